@@ -13,5 +13,46 @@ func LoadConfig(path string) (Config, error) {
 		return config, err
 	}
 	err = yaml.Unmarshal(file, &config)
+	applyDefaults(&config)
 	return config, err
 }
+
+// applyDefaults - заполняет незаданные параметры значениями по умолчанию
+func applyDefaults(config *Config) {
+	if config.Jobs.Workers <= 0 {
+		config.Jobs.Workers = 2
+	}
+	if config.Jobs.StateDir == "" {
+		config.Jobs.StateDir = "./data/jobs"
+	}
+	if config.Jobs.ResultTTLHours <= 0 {
+		config.Jobs.ResultTTLHours = 24
+	}
+	if config.Archives.MaxExtractSizeMB <= 0 {
+		config.Archives.MaxExtractSizeMB = 10240
+	}
+	if config.Archives.MaxExtractEntries <= 0 {
+		config.Archives.MaxExtractEntries = 100000
+	}
+	if config.Signatures.MaxBufferedSizeMB <= 0 {
+		config.Signatures.MaxBufferedSizeMB = 512
+	}
+	if config.MetadataIndex.Path == "" {
+		config.MetadataIndex.Path = "./data/metadata.db"
+	}
+	if config.MetadataHistory.Path == "" {
+		config.MetadataHistory.Path = "./data/history.db"
+	}
+	if config.MetadataHistory.MaxEntries <= 0 {
+		config.MetadataHistory.MaxEntries = 100
+	}
+	if config.MetadataStorage.Backend == "" {
+		config.MetadataStorage.Backend = "sidecar"
+	}
+	if config.Scrub.RateMBPerSec <= 0 {
+		config.Scrub.RateMBPerSec = 50
+	}
+	if config.Scrub.VerifyAfterHours <= 0 {
+		config.Scrub.VerifyAfterHours = config.Scrub.IntervalHours
+	}
+}
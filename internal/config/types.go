@@ -2,8 +2,22 @@ package config
 
 // Config - структура для конфигурации приложения
 type Config struct {
-	WebServer WebServer `yaml:"web-server"`
-	Logging   Logging   `yaml:"logging"`
+	WebServer       WebServer       `yaml:"web-server"`
+	Logging         Logging         `yaml:"logging"`
+	Jobs            Jobs            `yaml:"jobs"`
+	Archives        Archives        `yaml:"archives"`
+	MetadataIndex   MetadataIndex   `yaml:"metadata_index"`
+	MetadataStorage MetadataStorage `yaml:"metadata_storage"`
+	MetadataHistory MetadataHistory `yaml:"metadata_history"`
+	MetadataSchema  MetadataSchema  `yaml:"metadata_schema"`
+	Duplicates      Duplicates      `yaml:"duplicates"`
+	Scrub           Scrub           `yaml:"scrub"`
+	Reconcile       Reconcile       `yaml:"reconcile"`
+	Hashes          Hashes          `yaml:"hashes"`
+	Signatures      Signatures      `yaml:"signatures"`
+	Signing         Signing         `yaml:"signing"`
+	Reports         Reports         `yaml:"reports"`
+	ContentMetadata ContentMetadata `yaml:"content_metadata"`
 }
 
 // WebServer - конфигурация веб-сервера
@@ -23,4 +37,126 @@ type Logging struct {
 	LogMaxSize  int    `yaml:"log_max_size"`
 	LogMaxFiles int    `yaml:"log_max_files"`
 	LogMaxAge   int    `yaml:"log_max_age"`
-}
\ No newline at end of file
+}
+
+// Jobs - конфигурация фоновых задач
+type Jobs struct {
+	Workers        int    `yaml:"workers"`
+	StateDir       string `yaml:"state_dir"`
+	ResultTTLHours int    `yaml:"result_ttl_hours"`
+}
+
+// Archives - ограничения распаковки архивов на сервере
+type Archives struct {
+	MaxExtractSizeMB  int64 `yaml:"max_extract_size_mb"`
+	MaxExtractEntries int   `yaml:"max_extract_entries"`
+}
+
+// MetadataIndex - индекс метаданных для быстрых списков и поиска
+type MetadataIndex struct {
+	Disabled bool   `yaml:"disabled"`
+	Path     string `yaml:"path"`
+}
+
+// MetadataStorage - способ хранения метаданных файлов
+type MetadataStorage struct {
+	Backend string `yaml:"backend"` // sidecar (файлы .<name>.meta, по умолчанию) или xattr (атрибуты user.*)
+}
+
+// MetadataHistory - журнал изменений метаданных файлов
+type MetadataHistory struct {
+	Disabled   bool   `yaml:"disabled"`
+	Path       string `yaml:"path"`
+	MaxEntries int    `yaml:"max_entries"` // Записей на файл, старые удаляются
+}
+
+// MetadataSchema - схема пользовательских полей метаданных
+type MetadataSchema struct {
+	ReservedFields []string        `yaml:"reserved_fields"`
+	Fields         []MetadataField `yaml:"fields"`
+}
+
+// MetadataField - описание поля метаданных
+type MetadataField struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"` // string, semver, date, url, enum
+	Required bool     `yaml:"required"`
+	Pattern  string   `yaml:"pattern"`
+	Values   []string `yaml:"values"`
+}
+
+// Duplicates - настройки поиска дубликатов
+type Duplicates struct {
+	WarnOnUpload bool `yaml:"warn_on_upload"`
+}
+
+// Scrub - периодическая проверка целостности файлов
+type Scrub struct {
+	IntervalHours    int `yaml:"interval_hours"`     // 0 - проверка по расписанию отключена
+	RateMBPerSec     int `yaml:"rate_mb_per_sec"`    // Ограничение скорости чтения
+	VerifyAfterHours int `yaml:"verify_after_hours"` // Повторная проверка не чаще
+}
+
+// Reconcile - сверка метаданных с файлами, изменёнными в обход сервиса
+type Reconcile struct {
+	OnStartup     bool `yaml:"on_startup"`     // Сверка при запуске
+	IntervalHours int  `yaml:"interval_hours"` // 0 - сверка по расписанию отключена
+	DeleteOrphans bool `yaml:"delete_orphans"` // Удалять метаданные отсутствующих файлов
+	HashMissing   bool `yaml:"hash_missing"`   // Рассчитывать хеш-суммы файлов без метаданных
+	RefreshStale  bool `yaml:"refresh_stale"`  // Пересчитывать хеш-суммы изменённых файлов
+	SignMissing   bool `yaml:"sign_missing"`   // Подписывать файлы без метаданных после расчёта
+}
+
+// Hashes - вычисляемые хеш-суммы
+type Hashes struct {
+	Algorithms []string `yaml:"algorithms"` // CRC32, CRC64, MD5, SHA1, SHA256, SHA512, SHA3-256, BLAKE2sp, BLAKE3
+}
+
+// Signatures - доверенные ключи для проверки отсоединённых подписей
+type Signatures struct {
+	OpenPGPKeyrings []string `yaml:"openpgp_keyrings"` // Связки ключей OpenPGP (двоичные или armored)
+	CosignKeys      []string `yaml:"cosign_keys"`      // Открытые ключи cosign (PEM)
+	MinisignKeys    []string `yaml:"minisign_keys"`    // Открытые ключи minisign
+	// Наибольший размер файла для подписей, которые проверяются только
+	// чтением файла в память (cosign Ed25519, minisign старого формата)
+	MaxBufferedSizeMB int64 `yaml:"max_buffered_size_mb"`
+}
+
+// Signing - ключ, которым сервер подписывает опубликованные файлы
+type Signing struct {
+	MinisignKey    string `yaml:"minisign_key"`    // Секретный ключ minisign
+	OpenPGPKey     string `yaml:"openpgp_key"`     // Закрытый ключ OpenPGP (armored)
+	PassphraseFile string `yaml:"passphrase_file"` // Пароль зашифрованного ключа
+	Manifest       string `yaml:"manifest"`        // Имя подписанного файла контрольных сумм папки
+}
+
+// Reports - парсеры отчётов производителей для раздела RDS в README.md
+type Reports struct {
+	DisableBuiltin bool           `yaml:"disable_builtin"` // Не разбирать встроенный HTML-отчёт
+	Parsers        []ReportParser `yaml:"parsers"`
+}
+
+// ContentMetadata - извлечение типа содержимого и полей формата загруженных файлов
+type ContentMetadata struct {
+	Disabled   bool     `yaml:"disabled"`
+	Extractors []string `yaml:"extractors"` // Пустой список - все извлекатели
+}
+
+// ReportParser - описание формата отчёта
+type ReportParser struct {
+	Name   string        `yaml:"name"`
+	Format string        `yaml:"format"` // html, json, xml, pdf, text
+	Match  []string      `yaml:"match"`  // Шаблоны имён файлов
+	Detect string        `yaml:"detect"` // Строка, по которой отчёт узнаётся
+	Fields []ReportField `yaml:"fields"`
+	Hashes string        `yaml:"hashes"` // Селектор блока с хеш-суммами
+}
+
+// ReportField - правило извлечения поля из отчёта
+type ReportField struct {
+	Field    string `yaml:"field"`
+	Selector string `yaml:"selector"`
+	Label    string `yaml:"label"`
+	Pattern  string `yaml:"pattern"`
+	Required bool   `yaml:"required"`
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"errors"
+	"fileStation/internal/service"
+	"fileStation/pkg/logger"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ArchiveListHandler возвращает список элементов архива без его распаковки.
+func (h *FileHandler) ArchiveListHandler(w http.ResponseWriter, r *http.Request) {
+	fullPath, ok := h.archivePath(w, r)
+	if !ok {
+		return
+	}
+
+	listing, err := h.fileService.ListArchive(fullPath)
+	if err != nil {
+		logger.Errorf("Error listing archive %s: %v", fullPath, err)
+		http.Error(w, "Error reading archive", http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// ArchiveMemberHandler отдаёт один файл из архива.
+func (h *FileHandler) ArchiveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	fullPath, ok := h.archivePath(w, r)
+	if !ok {
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "Member name is required", http.StatusBadRequest)
+		return
+	}
+
+	member, entry, err := h.fileService.OpenArchiveMember(fullPath, name)
+	if errors.Is(err, service.ErrArchiveMemberNotFound) {
+		http.Error(w, "Archive member not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Errorf("Error opening member %s of %s: %v", name, fullPath, err)
+		http.Error(w, "Error reading archive", http.StatusUnprocessableEntity)
+		return
+	}
+	defer member.Close()
+
+	baseName := path.Base(strings.ReplaceAll(entry.Name, "\\", "/"))
+	contentType := mime.TypeByExtension(path.Ext(baseName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	setAttachmentHeaders(w, contentType, baseName)
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	if _, err := io.Copy(w, member); err != nil {
+		logger.Errorf("Error streaming member %s of %s: %v", name, fullPath, err)
+	}
+}
+
+// Helper: Проверка пути к архиву из параметра path
+func (h *FileHandler) archivePath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	archivePath := r.URL.Query().Get("path")
+	if archivePath == "" {
+		http.Error(w, "Archive path is required", http.StatusBadRequest)
+		return "", false
+	}
+
+	fullPath := h.fileService.GetFullPath(archivePath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return "", false
+	}
+	if !service.IsBrowsableArchive(fullPath) {
+		http.Error(w, "Unsupported archive type", http.StatusBadRequest)
+		return "", false
+	}
+	if info, err := os.Stat(fullPath); err != nil || info.IsDir() {
+		http.Error(w, "Archive not found", http.StatusNotFound)
+		return "", false
+	}
+	return fullPath, true
+}
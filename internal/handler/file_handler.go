@@ -1,16 +1,14 @@
 package handler
 
 import (
-	"crypto/sha1"
-	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fileStation/internal/service"
 	"fileStation/pkg/logger"
 	"fmt"
-	"hash/crc32"
-	"hash/crc64"
 	"html/template"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -19,7 +17,6 @@ import (
 	"time"
 
 	"github.com/yuin/goldmark"
-	"golang.org/x/crypto/blake2s"
 )
 
 // FileHandler отвечает за обработку запросов, связанных с файлами.
@@ -122,25 +119,29 @@ func (h *FileHandler) ServeFiles(w http.ResponseWriter, r *http.Request) {
 
 		pageTitle := "fileStation - " + reqPath
 
-		rdsStatuses := make(map[string]string)
+		rdsStatuses := make(map[string]*service.RDSVerification)
+		integrityStatuses := make(map[string]string)
+		signatureStatuses := make(map[string]*service.SignatureResult)
+		dirMetadata := h.fileService.DirectoryMetadata(fullPath, entries)
+		for name, metadata := range dirMetadata {
+			if status := service.IntegrityStatus(metadata); status != "" {
+				integrityStatuses[name] = status
+			}
+			if status := metadata[service.MetadataSignatureStatus]; status != "" {
+				signatureStatuses[name] = &service.SignatureResult{
+					File:   name,
+					Status: status,
+					Signer: metadata[service.MetadataSignatureSigner],
+					Source: metadata[service.MetadataSignatureFile],
+				}
+			}
+		}
 		for _, file := range entries {
 			if !file.IsDir() && !strings.HasSuffix(file.Name(), ".md") && !strings.HasSuffix(file.Name(), ".html") && !strings.HasSuffix(file.Name(), ".txt") {
-				metaFilePath := filepath.Join(fullPath, "."+file.Name()+".meta")
-				metadata, err := h.fileService.ReadMetadata(metaFilePath)
-				if err == nil {
-					if metadata["RDS CRC32"] == metadata["CRC32"] ||
-						metadata["RDS CRC64"] == metadata["CRC64"] ||
-						metadata["RDS SHA1"] == metadata["SHA1"] ||
-						metadata["RDS SHA256"] == metadata["SHA256"] ||
-						metadata["RDS BLAKE2sp"] == metadata["BLAKE2sp"] {
-						rdsStatuses[file.Name()] = "match"
-					} else if metadata["RDS CRC32"] != "" || metadata["RDS CRC64"] != "" || metadata["RDS SHA1"] != "" || metadata["RDS SHA256"] != "" || metadata["RDS BLAKE2sp"] != "" {
-						rdsStatuses[file.Name()] = "mismatch"
-					} else {
-						rdsStatuses[file.Name()] = "unknown"
-					}
-				} else if !os.IsNotExist(err) {
-					rdsStatuses[file.Name()] = "unknown"
+				metadata, ok := dirMetadata[file.Name()]
+				if ok {
+					verification := service.CompareRDS(metadata)
+					rdsStatuses[file.Name()] = &verification
 				}
 			}
 		}
@@ -157,7 +158,9 @@ func (h *FileHandler) ServeFiles(w http.ResponseWriter, r *http.Request) {
 			Username   string
 			ReadmeHTML template.HTML
 			Version    string
-			RDSStatuses map[string]string
+			RDSStatuses map[string]*service.RDSVerification
+			IntegrityStatuses map[string]string
+			SignatureStatuses map[string]*service.SignatureResult
 			ReadmeContent string
 		}{
 			Title:      pageTitle,
@@ -171,12 +174,15 @@ func (h *FileHandler) ServeFiles(w http.ResponseWriter, r *http.Request) {
 			ReadmeHTML: readmeHTML,
 			Version:    h.version,
 			RDSStatuses: rdsStatuses,
+			IntegrityStatuses: integrityStatuses,
+			SignatureStatuses: signatureStatuses,
 			ReadmeContent: readmeContent,
 		}
 
 		h.renderTemplate(w, "index.html", data)
 	} else {
 		// Serve the file
+		setStoredHashHeaders(w, h.fileService.StoredHashes(fullPath))
 		http.ServeFile(w, r, fullPath)
 	}
 }
@@ -190,14 +196,80 @@ func (h *FileHandler) DownloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	format, err := service.ParseArchiveFormat(r.FormValue("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := service.ArchiveOptions{
+		Format:      format,
+		ExcludeMeta: r.FormValue("exclude_meta") == "true",
+	}
+
+	// Store-mode zip рассчитывается заранее: известны размер и ETag,
+	// поддерживаются Range и условные запросы
+	if format == service.ArchiveZipStore {
+		archive, err := h.fileService.NewStoreZip(r.Context(), items, opts)
+		if err != nil {
+			logger.Errorf("Error creating archive: %v", err)
+			http.Error(w, "Error creating archive", http.StatusInternalServerError)
+			return
+		}
+		defer archive.Close()
+		setAttachmentHeaders(w, format.ContentType(), h.fileService.ArchiveName(items, format))
+		w.Header().Set("ETag", archive.ETag())
+		http.ServeContent(w, r, "", archive.ModTime(), archive)
+		return
+	}
+
 	// Архивирование и отправка файлов и папок
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=\"files.zip\"")
-	if err := h.fileService.CreateZipArchive(w, items); err != nil {
-		http.Error(w, "Error creating ZIP archive", http.StatusInternalServerError)
+	setAttachmentHeaders(w, format.ContentType(), h.fileService.ArchiveName(items, format))
+	if err := h.fileService.CreateArchive(r.Context(), w, items, opts, nil); err != nil {
+		logger.Errorf("Error creating archive: %v", err)
+		http.Error(w, "Error creating archive", http.StatusInternalServerError)
 	}
 }
 
+// Helper: Заголовки для отдачи файла как вложения
+func setAttachmentHeaders(w http.ResponseWriter, contentType, filename string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+// Helper: ETag и Digest/Repr-Digest по сохранённым в метаданных хеш-суммам
+func setStoredHashHeaders(w http.ResponseWriter, metadata map[string]string) {
+	if metadata == nil {
+		return
+	}
+	if etag := service.StrongETag(metadata); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	digest, reprDigest := service.DigestHeaders(metadata)
+	if digest != "" {
+		w.Header().Set("Digest", digest)
+	}
+	if reprDigest != "" {
+		w.Header().Set("Repr-Digest", reprDigest)
+	}
+}
+
+// setRevisionHeader передаёт ревизию метаданных в заголовке ETag.
+func setRevisionHeader(w http.ResponseWriter, revision string) {
+	if revision != "" {
+		w.Header().Set("ETag", `"`+revision+`"`)
+	}
+}
+
+// Helper: Ответ с ошибками проверки метаданных в JSON; false, если err не ошибка проверки
+func writeValidationError(w http.ResponseWriter, err error) bool {
+	var validationErr *service.ValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, validationErr)
+	return true
+}
+
 // Helper: Проверка, авторизован ли пользователь
 func (h *FileHandler) isLoggedIn(r *http.Request) bool {
 	cookie, err := r.Cookie("session_token")
@@ -374,6 +446,23 @@ func (h *FileHandler) UploadHandler(w http.ResponseWriter, r *http.Request) {
 
 	fullDestPath := h.fileService.GetFullPath(reqPath)
 	files := r.MultipartForm.File["uploadFiles"]
+
+	// Версии проверяются до сохранения файлов
+	for _, fileHeader := range files {
+		versionForFile := version
+		if !sameVersion {
+			versionForFile = fileVersionMap[fileHeader.Filename]
+		}
+		if writeValidationError(w, h.fileService.ValidateMetadata(map[string]string{"Version": versionForFile})) {
+			return
+		}
+	}
+
+	// Файлы, содержимое которых уже есть в хранилище
+	duplicates := []uploadDuplicate{}
+	uploaded := make([]string, 0, len(files))
+	reports := []*service.ReportResult{}
+
 	for _, fileHeader := range files {
 		file, err := fileHeader.Open()
 		if err != nil {
@@ -391,36 +480,23 @@ func (h *FileHandler) UploadHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Prepare hash writers
-		crc32Hash := crc32.NewIEEE()
-		crc64Hash := crc64.New(crc64.MakeTable(crc64.ECMA))
-		sha1Hash := sha1.New()
-		sha256Hash := sha256.New()
-		blake2spHash, err := blake2s.New256(nil)
+		// Compute the configured hashes while saving the file
+		hasher, err := h.fileService.NewHasher()
 		if err != nil {
-			http.Error(w, "Error creating BLAKE2sp hash", http.StatusInternalServerError)
+			dstFile.Close()
+			http.Error(w, "Error creating hashes", http.StatusInternalServerError)
 			return
 		}
 
-		// MultiWriter to write to dstFile and compute checksums
-		writer := io.MultiWriter(dstFile, crc32Hash, crc64Hash, sha1Hash, sha256Hash, blake2spHash)
-
-		// Copy data from uploaded file to dstFile and compute hashes
-		_, err = io.Copy(writer, file)
+		_, err = io.Copy(io.MultiWriter(dstFile, hasher), file)
 		if err != nil {
+			dstFile.Close()
 			http.Error(w, "Error saving file", http.StatusInternalServerError)
 			return
 		}
 
 		dstFile.Close() // Close the destination file
 
-		// Get the checksums
-		crc32Checksum := strings.ToUpper(fmt.Sprintf("%x", crc32Hash.Sum32()))
-		crc64Checksum := fmt.Sprintf("%x", crc64Hash.Sum(nil))
-		sha1Checksum := fmt.Sprintf("%x", sha1Hash.Sum(nil))
-		sha256Checksum := fmt.Sprintf("%x", sha256Hash.Sum(nil))
-		blake2spChecksum := fmt.Sprintf("%x", blake2spHash.Sum(nil))
-
 		// Determine version
 		var versionForFile string
 		if sameVersion {
@@ -430,37 +506,99 @@ func (h *FileHandler) UploadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Collect metadata
-		metadata := map[string]string{
-			"Version":  versionForFile,
-			"Uploader": username,
-			"CRC32":    crc32Checksum,
-			"CRC64":    crc64Checksum,
-			"SHA1":     sha1Checksum,
-			"SHA256":   sha256Checksum,
-			"BLAKE2sp": blake2spChecksum,
-		}
+		metadata := hasher.Sums()
+		metadata["Version"] = versionForFile
+		metadata["Uploader"] = username
 
 		// Save metadata
-		err = h.fileService.AddMetadata(dstPath, metadata)
-		if err != nil {
+		err = h.fileService.StoreHashes(dstPath, metadata, service.MetadataActor{User: username, Source: service.MetadataSourceUpload})
+		if writeValidationError(w, err) {
+			return
+		} else if err != nil {
 			http.Error(w, "Error saving metadata", http.StatusInternalServerError)
 			return
 		}
 
-		// Check if the file is an HTML file and extract metadata
-		if strings.HasSuffix(fileHeader.Filename, ".html") {
-			err = h.fileService.ExtractMetadataFromHTML(dstPath)
+		// Тип содержимого и поля формата файла
+		if _, err := h.fileService.ExtractContentMetadata(dstPath, username); err != nil {
+			logger.Warningf("Error extracting content metadata from %s: %v", fileHeader.Filename, err)
+		}
+
+		// Отчёт производителя: поля RDS для README.md
+		report, err := h.fileService.ExtractReportMetadata(dstPath)
+		if err != nil {
+			logger.Warningf("Error extracting metadata from report %s: %v", fileHeader.Filename, err)
+		}
+		if report != nil {
+			reports = append(reports, report)
+		}
+
+		if h.fileService.UploadDuplicateWarning() {
+			paths, err := h.fileService.DuplicatesOf(r.Context(), dstPath, metadata["SHA256"])
 			if err != nil {
-				logger.Warningf("Error extracting metadata from HTML file: %v", err)
+				logger.Warningf("Error searching duplicates of %s: %v", dstPath, err)
+			} else if len(paths) > 0 {
+				logger.Warningf("Uploaded file %s duplicates %s", fileHeader.Filename, strings.Join(paths, ", "))
+				duplicates = append(duplicates, uploadDuplicate{File: fileHeader.Filename, Paths: paths})
 			}
 		}
 
+		uploaded = append(uploaded, fileHeader.Filename)
 		logger.Infof("User %s uploaded file: %s", username, fileHeader.Filename)
 	}
 
+	// Отчёт мог быть загружен раньше описанного в нём файла
+	for _, report := range reports {
+		if err := h.fileService.LinkReport(report, username); err != nil {
+			logger.Warningf("Error linking report %s to %s: %v", report.File, report.Target, err)
+		}
+		for _, warning := range report.Warnings {
+			logger.Warningf("Report %s (%s): %s", report.File, report.Parser, warning)
+		}
+	}
+
+	// Ожидаемые суммы из SHA256SUMS, .sfv, .md5 и т.п. в папке
+	mismatches, err := h.fileService.ImportManifests(fullDestPath, uploaded)
+	if err != nil {
+		logger.Warningf("Error importing checksum files in %s: %v", reqPath, err)
+	}
+	for _, mismatch := range mismatches {
+		logger.Warningf("Checksum mismatch for %s in %s: %s expected %s, got %s",
+			mismatch.File, mismatch.Manifest, mismatch.Algorithm, mismatch.Expected, mismatch.Actual)
+	}
+
+	// Отсоединённые подписи загруженных файлов или к загруженным файлам
+	signatures, err := h.fileService.VerifyUploadedSignatures(fullDestPath, uploaded)
+	if err != nil {
+		logger.Warningf("Error verifying signatures in %s: %v", reqPath, err)
+	}
+	for _, signature := range signatures {
+		if signature.Status != service.SignatureVerified {
+			logger.Warningf("Signature %s of %s is %s: %s", signature.Source, signature.File, signature.Status, signature.Error)
+		}
+	}
+	if err := h.fileService.PublishSignatures(fullDestPath, uploaded); err != nil {
+		logger.Errorf("Error signing files in %s: %v", reqPath, err)
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"duplicates":          duplicates,
+			"checksum_mismatches": mismatches,
+			"signatures":          signatures,
+			"reports":             reports,
+		})
+		return
+	}
 	http.Redirect(w, r, reqPath, http.StatusSeeOther)
 }
 
+// uploadDuplicate - загруженный файл и файлы с тем же содержимым.
+type uploadDuplicate struct {
+	File  string   `json:"file"`
+	Paths []string `json:"paths"`
+}
+
 // DeleteHandler обрабатывает запросы на удаление файлов и папок.
 func (h *FileHandler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -607,6 +745,8 @@ func (h *FileHandler) MoveHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, destinationPath, http.StatusSeeOther)
 }
 
+// FileMetadataHandler возвращает метаданные файла вместе со значениями,
+// унаследованными от папок.
 func (h *FileHandler) FileMetadataHandler(w http.ResponseWriter, r *http.Request) {
 	filePath := r.URL.Query().Get("path")
 	if filePath == "" {
@@ -615,28 +755,150 @@ func (h *FileHandler) FileMetadataHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	fullPath := h.fileService.GetFullPath(filePath)
-	metaFilePath := filepath.Join(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".meta")
-	metadataFile, err := os.Open(metaFilePath)
-	if os.IsNotExist(err) {
-		// Если файл метаданных отсутствует, возвращаем пустой объект
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{})
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
-	} else if err != nil {
+	}
+	// С параметром rds=true ответ дополняется сверкой хеш-сумм с RDS, а с
+	// sources=true - источником каждого значения ("file" или путь папки):
+	// {"metadata": {...}, "sources": {...}, "revision": "...", "rds": {...}}.
+	// Ревизия метаданных файла передаётся и в заголовке ETag.
+	withRDS := r.URL.Query().Get("rds") == "true"
+	withSources := r.URL.Query().Get("sources") == "true"
+	// Ревизия читается до метаданных: если файл изменится между чтениями,
+	// следующее сохранение с этой ревизией получит конфликт
+	revision, err := h.fileService.MetadataRevision(fullPath)
+	if err != nil {
+		logger.Errorf("Error reading metadata of %s: %v", filePath, err)
 		http.Error(w, "Error reading metadata", http.StatusInternalServerError)
 		return
 	}
-	defer metadataFile.Close()
+	effective, err := h.fileService.EffectiveMetadata(fullPath)
+	if err != nil {
+		logger.Errorf("Error reading metadata of %s: %v", filePath, err)
+		http.Error(w, "Error reading metadata", http.StatusInternalServerError)
+		return
+	}
+	setRevisionHeader(w, revision)
 
-	var metadata map[string]string
-	decoder := json.NewDecoder(metadataFile)
-	if err := decoder.Decode(&metadata); err != nil {
-		http.Error(w, "Error decoding metadata", http.StatusInternalServerError)
+	if !withRDS && !withSources {
+		writeJSON(w, http.StatusOK, effective.Metadata)
 		return
 	}
+	response := map[string]interface{}{
+		"metadata": effective.Metadata,
+		"sources":  effective.Sources,
+		"revision": revision,
+	}
+	if withRDS {
+		response["rds"] = service.CompareRDS(effective.Metadata)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(metadata)
+// FolderMetadataHandler возвращает значения по умолчанию папки path, а также
+// унаследованные ею от родительских папок с их источниками.
+func (h *FileHandler) FolderMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	dirPath := r.URL.Query().Get("path")
+	fullPath := h.fileService.GetFullPath(dirPath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if isDir, err := h.fileService.IsDir(fullPath); err != nil || !isDir {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+
+	revision, err := h.fileService.MetadataRevision(fullPath)
+	if err != nil {
+		logger.Errorf("Error reading folder metadata of %s: %v", dirPath, err)
+		http.Error(w, "Error reading folder metadata", http.StatusInternalServerError)
+		return
+	}
+	defaults, err := h.fileService.FolderDefaults(fullPath)
+	if err != nil {
+		logger.Errorf("Error reading folder metadata of %s: %v", dirPath, err)
+		http.Error(w, "Error reading folder metadata", http.StatusInternalServerError)
+		return
+	}
+	inherited, sources, err := h.fileService.InheritedMetadata(filepath.Dir(fullPath))
+	if err != nil {
+		logger.Errorf("Error reading folder metadata of %s: %v", dirPath, err)
+		http.Error(w, "Error reading folder metadata", http.StatusInternalServerError)
+		return
+	}
+
+	setRevisionHeader(w, revision)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"path":      dirPath,
+		"defaults":  defaults,
+		"inherited": inherited,
+		"sources":   sources,
+		"revision":  revision,
+	})
+}
+
+// SaveFolderMetadataHandler изменяет значения по умолчанию папки. Тело
+// запроса - объект полей с путём папки в FilePath; пустое значение удаляет
+// поле.
+func (h *FileHandler) SaveFolderMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	username, err := h.authService.GetSessionUsername(cookie.Value)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var changes map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	dirPath, ok := changes[service.MetadataPathKey]
+	if !ok {
+		http.Error(w, "Folder path is required", http.StatusBadRequest)
+		return
+	}
+	delete(changes, service.MetadataPathKey)
+
+	fullPath := h.fileService.GetFullPath(dirPath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if isDir, err := h.fileService.IsDir(fullPath); err != nil || !isDir {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+
+	revision, err := h.fileService.EditFolderDefaults(fullPath, changes, username, r.Header.Get("If-Match"))
+	if errors.Is(err, service.ErrRootFolderDefaults) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if errors.Is(err, service.ErrMetadataConflict) {
+		http.Error(w, "Folder defaults were changed by another request, reload them and try again", http.StatusConflict)
+		return
+	} else if writeValidationError(w, err) {
+		return
+	} else if err != nil {
+		logger.Errorf("Error saving folder metadata of %s: %v", dirPath, err)
+		http.Error(w, "Error saving folder metadata", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Infof("User %s updated metadata defaults for folder: %s", username, dirPath)
+	setRevisionHeader(w, revision)
+	w.WriteHeader(http.StatusOK)
 }
 
 // RecalculateHashesHandler обрабатывает запросы на пересчет хеш-сумм.
@@ -654,12 +916,30 @@ func (h *FileHandler) RecalculateHashesHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// Пересчёт доступен и без входа; в истории тогда нет пользователя
+	username := ""
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		username, _ = h.authService.GetSessionUsername(cookie.Value)
+	}
+
 	// Update metadata with new hashes
-	err = h.fileService.AddMetadata(fullPath, hashes)
+	err = h.fileService.StoreHashes(fullPath, hashes, service.MetadataActor{User: username, Source: service.MetadataSourceRecalculate})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error updating metadata: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if _, err := h.fileService.ExtractContentMetadata(fullPath, username); err != nil {
+		logger.Warningf("Error extracting content metadata from %s: %v", filePath, err)
+	}
+
+	// Подпись и файл контрольных сумм папки должны описывать новые суммы.
+	// Без входа ключ сервера не подтверждает содержимое, изменённое в обход
+	// сервиса.
+	if username != "" {
+		if err := h.fileService.PublishSignatures(filepath.Dir(fullPath), []string{filepath.Base(fullPath)}); err != nil {
+			logger.Errorf("Error signing %s: %v", filePath, err)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(hashes)
@@ -690,20 +970,32 @@ func (h *FileHandler) SaveMetadataHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	filePath, ok := metadata["FilePath"]
+	filePath, ok := metadata[service.MetadataPathKey]
 	if !ok {
 		http.Error(w, "File path is required", http.StatusBadRequest)
 		return
 	}
+	delete(metadata, service.MetadataPathKey)
 
 	fullPath := h.fileService.GetFullPath(filePath)
-	err = h.fileService.AddMetadata(fullPath, metadata)
-	if err != nil {
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	// If-Match с ревизией из ETag защищает от перезаписи чужих изменений
+	revision, err := h.fileService.EditMetadata(fullPath, metadata, username, r.Header.Get("If-Match"))
+	if errors.Is(err, service.ErrMetadataConflict) {
+		http.Error(w, "Metadata was changed by another request, reload it and try again", http.StatusConflict)
+		return
+	} else if writeValidationError(w, err) {
+		return
+	} else if err != nil {
 		http.Error(w, "Error saving metadata", http.StatusInternalServerError)
 		return
 	}
 
 	logger.Infof("User %s updated metadata for file: %s", username, filePath)
+	setRevisionHeader(w, revision)
 	w.WriteHeader(http.StatusOK)
 }
 
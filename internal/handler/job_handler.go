@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fileStation/internal/service"
+	"fileStation/pkg/logger"
+	"net/http"
+	"strings"
+)
+
+// loginRequiredJobs - задачи, изменяющие содержимое хранилища, доступны только после входа.
+// Пересчёт хеш-сумм подписывает файл ключом сервера.
+var loginRequiredJobs = map[string]bool{
+	service.JobKindRecalculateHashes: true,
+	service.JobKindExtract:           true,
+	service.JobKindReindexMetadata:   true,
+	service.JobKindScrub:             true,
+	service.JobKindReconcile:         true,
+}
+
+// JobHandler обрабатывает запросы, связанные с фоновыми задачами.
+type JobHandler struct {
+	jobService  *service.JobService
+	fileService *service.FileService
+	authService *service.AuthService
+}
+
+// NewJobHandler создает новый экземпляр JobHandler.
+func NewJobHandler(jobService *service.JobService, fileService *service.FileService, authService *service.AuthService) *JobHandler {
+	return &JobHandler{
+		jobService:  jobService,
+		fileService: fileService,
+		authService: authService,
+	}
+}
+
+// JobsHandler возвращает список задач текущего пользователя (GET) или ставит
+// новую задачу в очередь (POST).
+func (h *JobHandler) JobsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		user := h.currentUser(r)
+		if user == "" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+			return
+		}
+		jobs := []service.Job{}
+		for _, job := range h.jobService.List() {
+			if job.User == user {
+				jobs = append(jobs, job)
+			}
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		h.submit(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *JobHandler) submit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	kind := r.FormValue("kind")
+	params := make(map[string][]string)
+	for key, values := range r.Form {
+		if key != "kind" {
+			params[key] = values
+		}
+	}
+
+	// Проверка на выход за пределы базовой директории
+	for _, key := range []string{"path", "items", "destination"} {
+		for _, p := range params[key] {
+			if !strings.HasPrefix(h.fileService.GetFullPath(p), h.fileService.GetFullPath("/")) {
+				http.Error(w, "Invalid path", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	user := h.currentUser(r)
+	if loginRequiredJobs[kind] && user == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	job, err := h.jobService.Submit(kind, user, params)
+	if errors.Is(err, service.ErrJobKindUnknown) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Error submitting job", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// JobStatusHandler возвращает состояние и прогресс задачи.
+func (h *JobHandler) JobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := h.visibleJob(r, r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// JobCancelHandler отменяет задачу.
+func (h *JobHandler) JobCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.FormValue("id")
+	// Задачу другого пользователя отменить нельзя; задачи без пользователя
+	// (например, по расписанию) может отменить любой вошедший
+	job, err := h.jobService.Get(id)
+	if err == nil && job.User != "" && job.User != h.currentUser(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err == nil {
+		err = h.jobService.Cancel(id)
+	}
+	switch {
+	case errors.Is(err, service.ErrJobNotFound):
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrJobFinished):
+		http.Error(w, "Job already finished", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Error canceling job", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Infof("User %s canceled job %s", r.Header.Get("X-User"), id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// JobResultHandler отдаёт результат завершённой задачи: файл или JSON.
+func (h *JobHandler) JobResultHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := h.visibleJob(r, r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != service.JobCompleted {
+		http.Error(w, "Job is not completed", http.StatusConflict)
+		return
+	}
+
+	if job.ResultFile != "" {
+		resultPath, err := h.jobService.ResultPath(job.ID)
+		if err != nil {
+			http.Error(w, "Job has no result", http.StatusNotFound)
+			return
+		}
+		if job.Kind == service.JobKindZipArchive {
+			format, _ := service.ParseArchiveFormat(firstParam(job.Params, "format"))
+			setAttachmentHeaders(w, format.ContentType(), h.fileService.ArchiveName(job.Params["items"], format))
+		}
+		http.ServeFile(w, r, resultPath)
+		return
+	}
+
+	if len(job.Result) == 0 {
+		writeJSON(w, http.StatusOK, map[string]string{})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(job.Result)
+}
+
+// Helper: Имя пользователя текущей сессии или пустая строка
+func (h *JobHandler) currentUser(r *http.Request) string {
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return ""
+	}
+	username, _ := h.authService.GetSessionUsername(cookie.Value)
+	return username
+}
+
+// Helper: Задача, доступная текущему пользователю. Задачи пользователя видны
+// только ему; задачи без пользователя (анонимные архивы, задачи по
+// расписанию) доступны по случайному идентификатору. Чужая задача не
+// отличается от отсутствующей.
+func (h *JobHandler) visibleJob(r *http.Request, id string) (service.Job, error) {
+	job, err := h.jobService.Get(id)
+	if err != nil {
+		return job, err
+	}
+	if job.User != "" && job.User != h.currentUser(r) {
+		return service.Job{}, service.ErrJobNotFound
+	}
+	return job, nil
+}
+
+// Helper: Первое значение параметра задачи
+func firstParam(params map[string][]string, key string) string {
+	if values := params[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Helper: Отправка ответа в формате JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
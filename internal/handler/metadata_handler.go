@@ -0,0 +1,680 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fileStation/internal/service"
+	"fileStation/pkg/logger"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultQueryLimit - число результатов запроса к метаданным по умолчанию.
+const defaultQueryLimit = 1000
+
+var errQueryLimit = errors.New("query limit reached")
+
+// MetadataQueryHandler ищет файлы по точному совпадению полей метаданных во
+// всём дереве: filter=<ключ>=<значение> (можно несколько), необязательные
+// prefix=<папка> и limit=<число>.
+func (h *FileHandler) MetadataQueryHandler(w http.ResponseWriter, r *http.Request) {
+	filters := make(map[string]string)
+	for _, filter := range r.URL.Query()["filter"] {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || key == "" {
+			http.Error(w, "Invalid filter, expected key=value", http.StatusBadRequest)
+			return
+		}
+		filters[key] = value
+	}
+
+	prefix := path.Clean("/" + r.URL.Query().Get("prefix"))
+	limit := defaultQueryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	results := []service.IndexedFile{}
+	truncated := false
+	err := h.fileService.WalkMetadata(r.Context(), func(file service.IndexedFile) error {
+		if prefix != "/" && file.Path != prefix && !strings.HasPrefix(file.Path, prefix+"/") {
+			return nil
+		}
+		for key, value := range filters {
+			if file.Metadata[key] != value {
+				return nil
+			}
+		}
+		if len(results) >= limit {
+			truncated = true
+			return errQueryLimit
+		}
+		results = append(results, file)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errQueryLimit) {
+		logger.Errorf("Error querying metadata: %v", err)
+		http.Error(w, "Error querying metadata", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results":   results,
+		"truncated": truncated,
+	})
+}
+
+// MetadataSchemaHandler возвращает схему метаданных: типизированные поля,
+// защищённые системные поля, поддерживаемые и вычисляемые хеш-суммы.
+func (h *FileHandler) MetadataSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	schema := h.fileService.MetadataSchema()
+	fields := schema.Fields()
+	if fields == nil {
+		fields = []service.MetadataField{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"fields":          fields,
+		"reserved":        schema.Reserved(),
+		"hashes":          service.HashFields,
+		"hash_algorithms": h.fileService.HashAlgorithms(),
+	})
+}
+
+// SearchHandler ищет файлы и папки по всему хранилищу. Параметры: q - имя
+// (glob или подстрока), filter=<ключ>=<значение> (можно несколько, значение
+// может быть glob-шаблоном), hash, min_size/max_size (с суффиксами K, M, G),
+// after/before (YYYY-MM-DD или RFC 3339), text - текст в README.md папки,
+// type (file или dir), prefix и limit.
+func (h *FileHandler) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := service.SearchQuery{
+		Name:    strings.TrimSpace(params.Get("q")),
+		Filters: make(map[string]string),
+		Hash:    strings.TrimSpace(params.Get("hash")),
+		Text:    strings.TrimSpace(params.Get("text")),
+		Type:    params.Get("type"),
+		Prefix:  params.Get("prefix"),
+		Limit:   defaultQueryLimit,
+	}
+	for _, filter := range params["filter"] {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || key == "" {
+			http.Error(w, "Invalid filter, expected key=value", http.StatusBadRequest)
+			return
+		}
+		query.Filters[key] = value
+	}
+	if query.Type != "" && query.Type != "file" && query.Type != "dir" {
+		http.Error(w, "Invalid type", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if query.MinSize, err = parseSize(params.Get("min_size")); err != nil {
+		http.Error(w, "Invalid min_size", http.StatusBadRequest)
+		return
+	}
+	if query.MaxSize, err = parseSize(params.Get("max_size")); err != nil {
+		http.Error(w, "Invalid max_size", http.StatusBadRequest)
+		return
+	}
+	if query.After, err = parseDate(params.Get("after"), false); err != nil {
+		http.Error(w, "Invalid after date", http.StatusBadRequest)
+		return
+	}
+	if query.Before, err = parseDate(params.Get("before"), true); err != nil {
+		http.Error(w, "Invalid before date", http.StatusBadRequest)
+		return
+	}
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		query.Limit = n
+	}
+
+	results, truncated, err := h.fileService.Search(r.Context(), query)
+	if err != nil {
+		logger.Errorf("Error searching files: %v", err)
+		http.Error(w, "Error searching files", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results":   results,
+		"truncated": truncated,
+	})
+}
+
+// parseSize разбирает размер в байтах с необязательным суффиксом K, M, G или T
+// (степени 1024). Пустая строка означает отсутствие ограничения (-1).
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return -1, nil
+	}
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		if i := strings.IndexByte("KMGT", value[n-1]); i >= 0 {
+			multiplier = int64(1) << (10 * (i + 1))
+			value = value[:n-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid size")
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// parseDate разбирает дату в формате YYYY-MM-DD или RFC 3339. Для endOfDay
+// дата без времени означает конец дня.
+func parseDate(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// FindByHashHandler возвращает все файлы с указанной контрольной суммой
+// (CRC32, CRC64, SHA1, SHA256 или BLAKE2sp): hash=<hex>.
+func (h *FileHandler) FindByHashHandler(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimSpace(r.URL.Query().Get("hash"))
+	if hash == "" {
+		http.Error(w, "Hash is required", http.StatusBadRequest)
+		return
+	}
+
+	matches, err := h.fileService.FindByHash(r.Context(), hash)
+	if err != nil {
+		logger.Errorf("Error searching files by hash: %v", err)
+		http.Error(w, "Error searching files by hash", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"hash":    hash,
+		"matches": matches,
+	})
+}
+
+// DuplicatesHandler возвращает отчёт о файлах с одинаковым SHA256 в папке path
+// (по умолчанию во всём хранилище).
+func (h *FileHandler) DuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	dirPath := r.URL.Query().Get("path")
+	fullPath := h.fileService.GetFullPath(dirPath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.fileService.FindDuplicates(r.Context(), dirPath)
+	if err != nil {
+		logger.Errorf("Error building duplicate report: %v", err)
+		http.Error(w, "Error building duplicate report", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// IntegrityReportHandler возвращает результаты последней проверки
+// целостности файлов папки path (по умолчанию всего хранилища).
+func (h *FileHandler) IntegrityReportHandler(w http.ResponseWriter, r *http.Request) {
+	dirPath := r.URL.Query().Get("path")
+	fullPath := h.fileService.GetFullPath(dirPath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.fileService.IntegrityReport(r.Context(), dirPath)
+	if err != nil {
+		logger.Errorf("Error building integrity report: %v", err)
+		http.Error(w, "Error building integrity report", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// ReconcileReportHandler сверяет метаданные папки path с файлами и
+// возвращает метаданные без файлов, файлы без хеш-сумм и изменённые после
+// расчёта файлы, ничего не изменяя. Удаление и расчёт хеш-сумм выполняет
+// задача reconcile.
+func (h *FileHandler) ReconcileReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	dirPath := r.URL.Query().Get("path")
+	fullPath := h.fileService.GetFullPath(dirPath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if isDir, err := h.fileService.IsDir(fullPath); err != nil || !isDir {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+
+	result, err := h.fileService.Reconcile(r.Context(), service.ReconcileOptions{Prefix: dirPath}, "", nil)
+	if err != nil {
+		logger.Errorf("Error reconciling metadata: %v", err)
+		http.Error(w, "Error reconciling metadata", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// ManifestHandler формирует файл контрольных сумм папки path по сохранённым
+// метаданным: format=sha256sums (по умолчанию), sha1sums, sha512sums,
+// md5sums, b3sums, md5 или sfv; recursive=true включает вложенные папки.
+// Число пропущенных файлов без актуальной суммы передаётся в заголовке
+// X-Checksum-Skipped.
+func (h *FileHandler) ManifestHandler(w http.ResponseWriter, r *http.Request) {
+	dirPath := r.URL.Query().Get("path")
+	fullPath := h.fileService.GetFullPath(dirPath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if isDir, err := h.fileService.IsDir(fullPath); err != nil || !isDir {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	algorithm, fileName, err := service.ManifestFormat(format, path.Base(path.Clean("/"+dirPath)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recursive := r.URL.Query().Get("recursive") == "true"
+
+	var buf bytes.Buffer
+	written, skipped, err := h.fileService.WriteManifest(&buf, fullPath, algorithm, strings.EqualFold(format, service.ManifestSFV), recursive)
+	if err != nil {
+		logger.Errorf("Error building checksum file for %s: %v", dirPath, err)
+		http.Error(w, "Error building checksum file", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("Generated %s for %s: %d files, %d skipped", fileName, dirPath, written, skipped)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	w.Header().Set("X-Checksum-Skipped", strconv.Itoa(skipped))
+	w.Write(buf.Bytes())
+}
+
+// VerifySignaturesHandler повторно проверяет отсоединённые подписи файла или
+// всех файлов папки path и возвращает результаты.
+func (h *FileHandler) VerifySignaturesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filePath := r.FormValue("path")
+	fullPath := h.fileService.GetFullPath(filePath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.fileService.VerifySignatures(fullPath)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Errorf("Error verifying signatures of %s: %v", filePath, err)
+		http.Error(w, "Error verifying signatures", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// SigningKeyHandler отдаёт открытый ключ, которым сервер подписывает файлы.
+func (h *FileHandler) SigningKeyHandler(w http.ResponseWriter, r *http.Request) {
+	signer := h.fileService.FileSigner()
+	if signer == nil {
+		http.Error(w, "Signing is not configured", http.StatusNotFound)
+		return
+	}
+	key, err := signer.PublicKey()
+	if err != nil {
+		logger.Errorf("Error exporting signing key: %v", err)
+		http.Error(w, "Error exporting signing key", http.StatusInternalServerError)
+		return
+	}
+	name := "fileStation.pub"
+	if signer.Extension() == ".asc" {
+		name = "fileStation.pub.asc"
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Write(key)
+}
+
+// maxMetadataImportSize - наибольший размер загружаемой выгрузки метаданных.
+const maxMetadataImportSize = 32 << 20
+
+// MetadataBulkHandler применяет одно изменение метаданных к нескольким
+// файлам: {"paths": [...], "set": {...}, "unset": [...], "dry_run": true}.
+// Необязательное поле revisions ({путь: ревизия}) - ревизии из
+// предварительного просмотра. Изменения применяются, только если ни один
+// файл не содержит ошибок; иначе возвращается статус 422 с ошибками по
+// файлам или 409, если метаданные файла изменились после просмотра.
+func (h *FileHandler) MetadataBulkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	username, ok := h.sessionUsername(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Paths     []string          `json:"paths"`
+		Set       map[string]string `json:"set"`
+		Unset     []string          `json:"unset"`
+		Revisions map[string]string `json:"revisions"`
+		DryRun    bool              `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(request.Paths) == 0 {
+		http.Error(w, "Paths are required", http.StatusBadRequest)
+		return
+	}
+	if len(request.Set) == 0 && len(request.Unset) == 0 {
+		http.Error(w, "Nothing to change", http.StatusBadRequest)
+		return
+	}
+
+	patches := make([]service.MetadataPatch, len(request.Paths))
+	for i, p := range request.Paths {
+		patches[i] = service.MetadataPatch{Path: p, Set: request.Set, Unset: request.Unset}
+	}
+	setPatchRevisions(patches, request.Revisions)
+	h.applyMetadataPatches(w, r, service.MetadataActor{User: username, Source: service.MetadataSourceBulk}, "bulk edit", patches, request.DryRun)
+}
+
+// MetadataExportHandler выгружает метаданные файлов папки path со всеми
+// вложенными папками: format=csv (по умолчанию) или jsonl.
+func (h *FileHandler) MetadataExportHandler(w http.ResponseWriter, r *http.Request) {
+	dirPath := r.URL.Query().Get("path")
+	fullPath := h.fileService.GetFullPath(dirPath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if isDir, err := h.fileService.IsDir(fullPath); err != nil || !isDir {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case "", service.MetadataCSV:
+		format = service.MetadataCSV
+	case service.MetadataJSONL:
+		contentType = "application/x-ndjson"
+	default:
+		http.Error(w, "Invalid format, expected csv or jsonl", http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	count, err := h.fileService.ExportMetadata(r.Context(), &buf, fullPath, format)
+	if err != nil {
+		logger.Errorf("Error exporting metadata of %s: %v", dirPath, err)
+		http.Error(w, "Error exporting metadata", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("Exported metadata of %d files from %s as %s", count, dirPath, format)
+
+	name := path.Base(path.Clean("/" + dirPath))
+	if name == "/" {
+		name = "fileStation"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "-metadata." + format}))
+	w.Write(buf.Bytes())
+}
+
+// MetadataImportHandler загружает отредактированную выгрузку метаданных
+// (поле формы file, CSV или JSON Lines) и обновляет файлы метаданных. С
+// dry_run=true возвращает только различия; поле revisions - JSON-объект
+// {путь: ревизия} из предварительного просмотра.
+func (h *FileHandler) MetadataImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	username, ok := h.sessionUsername(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMetadataImportSize)
+	if err := r.ParseMultipartForm(maxMetadataImportSize); err != nil {
+		http.Error(w, "Invalid import file", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Import file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	format := strings.ToLower(r.FormValue("format"))
+	if format == "" {
+		switch strings.ToLower(path.Ext(header.Filename)) {
+		case ".csv":
+			format = service.MetadataCSV
+		case ".jsonl", ".ndjson":
+			format = service.MetadataJSONL
+		}
+	}
+	patches, err := h.fileService.ParseMetadataImport(file, format)
+	if err != nil {
+		http.Error(w, "Invalid import file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if value := r.FormValue("revisions"); value != "" {
+		var revisions map[string]string
+		if err := json.Unmarshal([]byte(value), &revisions); err != nil {
+			http.Error(w, "Invalid revisions", http.StatusBadRequest)
+			return
+		}
+		setPatchRevisions(patches, revisions)
+	}
+	h.applyMetadataPatches(w, r, service.MetadataActor{User: username, Source: service.MetadataSourceImport}, "import of "+header.Filename, patches, r.FormValue("dry_run") == "true")
+}
+
+// applyMetadataPatches применяет изменения метаданных и отвечает
+// {"results": [...], "applied": bool, "dry_run": bool}.
+func (h *FileHandler) applyMetadataPatches(w http.ResponseWriter, r *http.Request, actor service.MetadataActor, operation string, patches []service.MetadataPatch, dryRun bool) {
+	diffs, applied, err := h.fileService.PatchMetadata(r.Context(), patches, dryRun, actor)
+	if err != nil {
+		logger.Errorf("Error applying metadata %s: %v", operation, err)
+		http.Error(w, "Error updating metadata", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	changed := 0
+	for _, diff := range diffs {
+		if diff.Conflict {
+			status = http.StatusConflict
+		} else if diff.Failed() {
+			if status != http.StatusConflict {
+				status = http.StatusUnprocessableEntity
+			}
+		} else if len(diff.Changes) > 0 {
+			changed++
+		}
+	}
+	if applied {
+		logger.Infof("User %s updated metadata of %d files by %s", actor.User, changed, operation)
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"results": diffs,
+		"applied": applied,
+		"dry_run": dryRun,
+	})
+}
+
+// setPatchRevisions задаёт изменениям ожидаемые ревизии по путям файлов.
+func setPatchRevisions(patches []service.MetadataPatch, revisions map[string]string) {
+	if len(revisions) == 0 {
+		return
+	}
+	normalized := make(map[string]string, len(revisions))
+	for p, revision := range revisions {
+		normalized[path.Clean("/"+p)] = revision
+	}
+	for i := range patches {
+		if revision, ok := normalized[path.Clean("/"+patches[i].Path)]; ok {
+			patches[i].Revision = revision
+		}
+	}
+}
+
+// MetadataHistoryHandler возвращает историю изменений метаданных файла path,
+// начиная с последнего изменения, и текущую ревизию (также в ETag):
+// {"path": "...", "revision": "...", "changes": [...]}.
+func (h *FileHandler) MetadataHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	filePath := r.URL.Query().Get("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+	fullPath := h.fileService.GetFullPath(filePath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := h.fileService.MetadataRevision(fullPath)
+	if err != nil {
+		logger.Errorf("Error reading metadata of %s: %v", filePath, err)
+		http.Error(w, "Error reading metadata", http.StatusInternalServerError)
+		return
+	}
+	changes, err := h.fileService.MetadataHistory(fullPath)
+	if errors.Is(err, service.ErrHistoryDisabled) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Errorf("Error reading metadata history of %s: %v", filePath, err)
+		http.Error(w, "Error reading metadata history", http.StatusInternalServerError)
+		return
+	}
+
+	setRevisionHeader(w, revision)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"path":     path.Clean("/" + filePath),
+		"revision": revision,
+		"changes":  changes,
+	})
+}
+
+// MetadataRevertHandler возвращает метаданные файла path к состоянию после
+// записи истории id. Заголовок If-Match защищает от отката поверх чужих
+// изменений; новая ревизия возвращается в ETag и {"revision": "..."}.
+func (h *FileHandler) MetadataRevertHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	username, ok := h.sessionUsername(w, r)
+	if !ok {
+		return
+	}
+
+	filePath := r.FormValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+	fullPath := h.fileService.GetFullPath(filePath)
+	if !strings.HasPrefix(fullPath, h.fileService.GetFullPath("/")) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if info, err := os.Stat(fullPath); err != nil || !info.Mode().IsRegular() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid history entry id", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := h.fileService.RevertMetadata(fullPath, id, username, r.Header.Get("If-Match"))
+	if errors.Is(err, service.ErrHistoryDisabled) || errors.Is(err, service.ErrHistoryNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if errors.Is(err, service.ErrMetadataConflict) {
+		http.Error(w, "Metadata was changed by another request, reload it and try again", http.StatusConflict)
+		return
+	} else if writeValidationError(w, err) {
+		return
+	} else if err != nil {
+		logger.Errorf("Error reverting metadata of %s: %v", filePath, err)
+		http.Error(w, "Error reverting metadata", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Infof("User %s reverted metadata of %s to history entry %d", username, filePath, id)
+	setRevisionHeader(w, revision)
+	writeJSON(w, http.StatusOK, map[string]string{"revision": revision})
+}
+
+// sessionUsername возвращает пользователя сессии запроса или отвечает 401.
+func (h *FileHandler) sessionUsername(w http.ResponseWriter, r *http.Request) (string, bool) {
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	username, err := h.authService.GetSessionUsername(cookie.Value)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	return username, true
+}
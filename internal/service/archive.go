@@ -0,0 +1,308 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveFormat - формат архива для скачивания.
+type ArchiveFormat string
+
+const (
+	ArchiveZip      ArchiveFormat = "zip"
+	ArchiveZipStore ArchiveFormat = "zip-store"
+	ArchiveTar      ArchiveFormat = "tar"
+	ArchiveTarGz    ArchiveFormat = "tar.gz"
+	ArchiveTarZst   ArchiveFormat = "tar.zst"
+)
+
+// ParseArchiveFormat разбирает формат архива; пустая строка означает zip.
+func ParseArchiveFormat(s string) (ArchiveFormat, error) {
+	switch f := ArchiveFormat(strings.ToLower(s)); f {
+	case "":
+		return ArchiveZip, nil
+	case ArchiveZip, ArchiveZipStore, ArchiveTar, ArchiveTarGz, ArchiveTarZst:
+		return f, nil
+	case "tgz":
+		return ArchiveTarGz, nil
+	case "tzst":
+		return ArchiveTarZst, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format: %s", s)
+	}
+}
+
+// Extension возвращает расширение файла архива.
+func (f ArchiveFormat) Extension() string {
+	switch f {
+	case ArchiveTar:
+		return ".tar"
+	case ArchiveTarGz:
+		return ".tar.gz"
+	case ArchiveTarZst:
+		return ".tar.zst"
+	default:
+		return ".zip"
+	}
+}
+
+// ContentType возвращает MIME-тип архива.
+func (f ArchiveFormat) ContentType() string {
+	switch f {
+	case ArchiveTar:
+		return "application/x-tar"
+	case ArchiveTarGz:
+		return "application/gzip"
+	case ArchiveTarZst:
+		return "application/zstd"
+	default:
+		return "application/zip"
+	}
+}
+
+// ArchiveOptions задаёт параметры создания архива.
+type ArchiveOptions struct {
+	Format      ArchiveFormat
+	ExcludeMeta bool // Не добавлять в архив файлы метаданных .<name>.meta
+}
+
+// archiveWriter записывает элементы в архив конкретного формата.
+type archiveWriter interface {
+	// add добавляет элемент; для обычных файлов содержимое читается из r.
+	add(relPath string, info os.FileInfo, link string, r io.Reader) error
+	// keepsLinks сообщает, хранит ли формат символические ссылки как есть.
+	keepsLinks() bool
+	Close() error
+}
+
+// CreateArchive создает архив из списка файлов и папок в указанном формате.
+func (fs *FileService) CreateArchive(ctx context.Context, w io.Writer, files []string, opts ArchiveOptions, progress ProgressFunc) error {
+	aw, err := newArchiveWriter(w, opts.Format)
+	if err != nil {
+		return err
+	}
+
+	var counter *progressCounter
+	if progress != nil {
+		var total int64
+		for _, file := range files {
+			size, err := fs.TreeSize(fs.GetFullPath(file))
+			if err != nil {
+				aw.Close()
+				return fmt.Errorf("error calculating archive size: %w", err)
+			}
+			total += size
+		}
+		counter = &progressCounter{total: total, report: progress}
+	}
+
+	for _, file := range files {
+		fullPath := fs.GetFullPath(file)
+		relPath := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(file)), "/")
+		if err := fs.addToArchive(ctx, aw, fullPath, relPath, opts, counter); err != nil {
+			aw.Close()
+			return fmt.Errorf("error adding file to archive: %w", err)
+		}
+	}
+
+	return aw.Close()
+}
+
+// ArchiveName возвращает имя архива по выбранным элементам: имя единственного
+// элемента либо имя папки, в которой они выбраны.
+func (fs *FileService) ArchiveName(files []string, format ArchiveFormat) string {
+	name := "files"
+	if len(files) == 1 {
+		name = path.Base(path.Clean("/" + filepath.ToSlash(files[0])))
+	} else if len(files) > 1 {
+		parent := path.Dir(path.Clean("/" + filepath.ToSlash(files[0])))
+		for _, file := range files[1:] {
+			if path.Dir(path.Clean("/"+filepath.ToSlash(file))) != parent {
+				parent = "/"
+				break
+			}
+		}
+		name = path.Base(parent)
+	}
+	if name == "/" || name == "." || name == "" {
+		name = "files"
+	}
+	return name + format.Extension()
+}
+
+func (fs *FileService) addToArchive(ctx context.Context, aw archiveWriter, fullPath, relPath string, opts ArchiveOptions, counter *progressCounter) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 && !aw.keepsLinks() {
+		// Формат не хранит ссылки, поэтому добавляем их цель
+		if info, err = os.Stat(fullPath); err != nil {
+			return err
+		}
+	}
+	if opts.ExcludeMeta && IsMetaFile(info.Name()) {
+		return nil
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(fullPath)
+		if err != nil {
+			return err
+		}
+		return aw.add(relPath, info, link, nil)
+
+	case info.IsDir():
+		if err := aw.add(relPath, info, "", nil); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(fullPath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			entryFullPath := filepath.Join(fullPath, entry.Name())
+			entryRelPath := path.Join(relPath, entry.Name())
+			if err := fs.addToArchive(ctx, aw, entryFullPath, entryRelPath, opts, counter); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case info.Mode().IsRegular():
+		file, err := os.Open(fullPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return aw.add(relPath, info, "", newProgressReader(ctx, file, counter))
+
+	default:
+		// Сокеты, устройства и каналы в архив не попадают
+		return nil
+	}
+}
+
+// IsMetaFile проверяет, является ли имя файлом метаданных .<name>.meta.
+func IsMetaFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".meta")
+}
+
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case ArchiveZip, "":
+		return &zipArchiveWriter{zw: zip.NewWriter(w), method: zip.Deflate}, nil
+	case ArchiveZipStore:
+		return &zipArchiveWriter{zw: zip.NewWriter(w), method: zip.Store}, nil
+	case ArchiveTar:
+		return &tarArchiveWriter{tw: tar.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchiveWriter{tw: tar.NewWriter(gz), compressor: gz}, nil
+	case ArchiveTarZst:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("error creating zstd writer: %w", err)
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// zipArchiveWriter пишет ZIP-архив; символические ссылки разыменовываются.
+type zipArchiveWriter struct {
+	zw     *zip.Writer
+	method uint16
+}
+
+func (a *zipArchiveWriter) add(relPath string, info os.FileInfo, link string, r io.Reader) error {
+	return addZipEntry(a.zw, relPath, info, a.method, r)
+}
+
+func (a *zipArchiveWriter) keepsLinks() bool {
+	return false
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+func addZipEntry(zw *zip.Writer, relPath string, info os.FileInfo, method uint16, r io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = relPath
+	if info.IsDir() {
+		header.Name += "/"
+		header.Method = zip.Store
+		_, err := zw.CreateHeader(header)
+		return err
+	}
+	header.Method = method
+
+	writer, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, r)
+	return err
+}
+
+// tarArchiveWriter пишет tar-архив с правами, временем изменения и ссылками,
+// при необходимости сжимая его.
+type tarArchiveWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (a *tarArchiveWriter) add(relPath string, info os.FileInfo, link string, r io.Reader) error {
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = relPath
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	// Имена владельцев не переносимы между системами, оставляем только uid/gid
+	header.Uname, header.Gname = "", ""
+
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if r != nil {
+		_, err = io.Copy(a.tw, r)
+	}
+	return err
+}
+
+func (a *tarArchiveWriter) keepsLinks() bool {
+	return true
+}
+
+func (a *tarArchiveWriter) Close() error {
+	err := a.tw.Close()
+	if a.compressor != nil {
+		if cerr := a.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,332 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrArchiveMemberNotFound возвращается, если в архиве нет запрошенного элемента.
+var ErrArchiveMemberNotFound = errors.New("archive member not found")
+
+// archive7z - формат 7z, поддерживаемый только для просмотра.
+const archive7z ArchiveFormat = "7z"
+
+// ArchiveEntry описывает элемент архива.
+type ArchiveEntry struct {
+	Name           string    `json:"name"`
+	Size           int64     `json:"size"`
+	CompressedSize int64     `json:"compressed_size,omitempty"` // Только для zip
+	ModTime        time.Time `json:"mod_time"`
+	CRC32          string    `json:"crc32,omitempty"` // Для zip и 7z
+	IsDir          bool      `json:"is_dir"`
+	Mode           string    `json:"mode"`
+	Link           string    `json:"link,omitempty"`
+}
+
+// ArchiveListing - содержимое архива.
+type ArchiveListing struct {
+	Format    ArchiveFormat  `json:"format"`
+	Entries   []ArchiveEntry `json:"entries"`
+	Truncated bool           `json:"truncated"` // Список обрезан по лимиту элементов
+}
+
+// IsBrowsableArchive проверяет, можно ли просмотреть содержимое архива с таким именем.
+func IsBrowsableArchive(name string) bool {
+	return browseKindByName(name) != ""
+}
+
+func browseKindByName(name string) ArchiveFormat {
+	if strings.HasSuffix(strings.ToLower(name), ".7z") {
+		return archive7z
+	}
+	return archiveKindByName(name)
+}
+
+// ListArchive возвращает список элементов архива без его распаковки. Число
+// элементов ограничено лимитом распаковки.
+func (fs *FileService) ListArchive(archivePath string) (ArchiveListing, error) {
+	kind := browseKindByName(archivePath)
+	if kind == "" {
+		return ArchiveListing{}, fmt.Errorf("unsupported archive type: %s", filepath.Base(archivePath))
+	}
+
+	listing := ArchiveListing{Format: kind, Entries: []ArchiveEntry{}}
+	limit := fs.extractLimits.MaxEntries
+	add := func(entry ArchiveEntry) bool {
+		if limit > 0 && len(listing.Entries) >= limit {
+			listing.Truncated = true
+			return false
+		}
+		listing.Entries = append(listing.Entries, entry)
+		return true
+	}
+
+	var err error
+	switch kind {
+	case ArchiveZip:
+		err = walkZip(archivePath, func(f *zip.File) bool {
+			return add(zipEntry(f))
+		})
+	case archive7z:
+		err = walk7z(archivePath, func(f *sevenzip.File) bool {
+			return add(sevenZipEntry(f))
+		})
+	default:
+		err = walkTar(archivePath, kind, func(header *tar.Header) bool {
+			return add(tarEntry(header))
+		})
+	}
+	if err != nil {
+		return ArchiveListing{}, err
+	}
+	return listing, nil
+}
+
+// OpenArchiveMember открывает на чтение обычный файл name внутри архива.
+func (fs *FileService) OpenArchiveMember(archivePath, name string) (io.ReadCloser, ArchiveEntry, error) {
+	kind := browseKindByName(archivePath)
+	if kind == "" {
+		return nil, ArchiveEntry{}, fmt.Errorf("unsupported archive type: %s", filepath.Base(archivePath))
+	}
+
+	switch kind {
+	case ArchiveZip:
+		return openZipMember(archivePath, name)
+	case archive7z:
+		return open7zMember(archivePath, name)
+	default:
+		return openTarMember(archivePath, kind, name)
+	}
+}
+
+func zipEntry(f *zip.File) ArchiveEntry {
+	info := f.FileInfo()
+	entry := ArchiveEntry{
+		Name:           f.Name,
+		Size:           int64(f.UncompressedSize64),
+		CompressedSize: int64(f.CompressedSize64),
+		ModTime:        f.Modified,
+		IsDir:          info.IsDir(),
+		Mode:           info.Mode().String(),
+	}
+	if !entry.IsDir {
+		entry.CRC32 = fmt.Sprintf("%08X", f.CRC32)
+	}
+	return entry
+}
+
+func tarEntry(header *tar.Header) ArchiveEntry {
+	info := header.FileInfo()
+	return ArchiveEntry{
+		Name:    header.Name,
+		Size:    header.Size,
+		ModTime: header.ModTime,
+		IsDir:   header.Typeflag == tar.TypeDir,
+		Mode:    info.Mode().String(),
+		Link:    header.Linkname,
+	}
+}
+
+func sevenZipEntry(f *sevenzip.File) ArchiveEntry {
+	info := f.FileInfo()
+	entry := ArchiveEntry{
+		Name:    f.Name,
+		Size:    int64(f.UncompressedSize),
+		ModTime: f.Modified,
+		IsDir:   info.IsDir(),
+		Mode:    info.Mode().String(),
+	}
+	if !entry.IsDir {
+		entry.CRC32 = fmt.Sprintf("%08X", f.CRC32)
+	}
+	return entry
+}
+
+// sameMemberName сравнивает имена элементов, игнорируя ведущие "./" и "/".
+func sameMemberName(a, b string) bool {
+	normalize := func(s string) string {
+		s = strings.ReplaceAll(s, "\\", "/")
+		return strings.TrimPrefix(path.Clean("/"+s), "/")
+	}
+	return normalize(a) == normalize(b)
+}
+
+func walkZip(archivePath string, fn func(f *zip.File) bool) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("error opening archive: %w", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !fn(f) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func walk7z(archivePath string, fn func(f *sevenzip.File) bool) error {
+	zr, err := sevenzip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("error opening archive: %w", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !fn(f) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// openTarStream открывает tar-поток с учётом сжатия; close закрывает все уровни.
+func openTarStream(archivePath string, kind ArchiveFormat) (tr *tar.Reader, close func(), err error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening archive: %w", err)
+	}
+
+	var r io.Reader = file
+	closers := []func(){func() { file.Close() }}
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+
+	switch kind {
+	case ArchiveTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("error opening gzip stream: %w", err)
+		}
+		closers = append(closers, func() { gz.Close() })
+		r = gz
+	case ArchiveTarZst:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("error opening zstd stream: %w", err)
+		}
+		closers = append(closers, zr.Close)
+		r = zr
+	}
+	return tar.NewReader(r), closeAll, nil
+}
+
+func walkTar(archivePath string, kind ArchiveFormat, fn func(header *tar.Header) bool) error {
+	tr, closeAll, err := openTarStream(archivePath, kind)
+	if err != nil {
+		return err
+	}
+	defer closeAll()
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading archive: %w", err)
+		}
+		if !fn(header) {
+			return nil
+		}
+	}
+}
+
+// memberReader закрывает вместе с элементом и сам архив.
+type memberReader struct {
+	io.Reader
+	close func() error
+}
+
+func (m *memberReader) Close() error {
+	return m.close()
+}
+
+func openZipMember(archivePath, name string) (io.ReadCloser, ArchiveEntry, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, ArchiveEntry{}, fmt.Errorf("error opening archive: %w", err)
+	}
+	for _, f := range zr.File {
+		if !sameMemberName(f.Name, name) || !f.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			zr.Close()
+			return nil, ArchiveEntry{}, fmt.Errorf("error reading %s: %w", f.Name, err)
+		}
+		return &memberReader{Reader: rc, close: func() error {
+			rc.Close()
+			return zr.Close()
+		}}, zipEntry(f), nil
+	}
+	zr.Close()
+	return nil, ArchiveEntry{}, ErrArchiveMemberNotFound
+}
+
+func open7zMember(archivePath, name string) (io.ReadCloser, ArchiveEntry, error) {
+	zr, err := sevenzip.OpenReader(archivePath)
+	if err != nil {
+		return nil, ArchiveEntry{}, fmt.Errorf("error opening archive: %w", err)
+	}
+	for _, f := range zr.File {
+		if !sameMemberName(f.Name, name) || !f.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			zr.Close()
+			return nil, ArchiveEntry{}, fmt.Errorf("error reading %s: %w", f.Name, err)
+		}
+		return &memberReader{Reader: rc, close: func() error {
+			rc.Close()
+			return zr.Close()
+		}}, sevenZipEntry(f), nil
+	}
+	zr.Close()
+	return nil, ArchiveEntry{}, ErrArchiveMemberNotFound
+}
+
+// openTarMember последовательно читает tar-поток до нужного элемента.
+func openTarMember(archivePath string, kind ArchiveFormat, name string) (io.ReadCloser, ArchiveEntry, error) {
+	tr, closeAll, err := openTarStream(archivePath, kind)
+	if err != nil {
+		return nil, ArchiveEntry{}, err
+	}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			closeAll()
+			return nil, ArchiveEntry{}, ErrArchiveMemberNotFound
+		}
+		if err != nil {
+			closeAll()
+			return nil, ArchiveEntry{}, fmt.Errorf("error reading archive: %w", err)
+		}
+		if header.Typeflag == tar.TypeReg && sameMemberName(header.Name, name) {
+			return &memberReader{Reader: tr, close: func() error {
+				closeAll()
+				return nil
+			}}, tarEntry(header), nil
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fileStation/pkg/logger"
+)
+
+// MetadataContentType - тип содержимого файла, определённый по его байтам.
+const MetadataContentType = "Content.Type"
+
+// contentSniffLen - число первых байт файла, по которым определяется тип.
+const contentSniffLen = 512
+
+// ContentFile - файл, из которого извлекаются метаданные формата.
+type ContentFile struct {
+	Path   string        // Полный путь к файлу
+	Name   string        // Имя файла
+	Size   int64         // Размер файла
+	MIME   string        // Тип, определённый по первым байтам
+	Header []byte        // Первые байты файла (не больше 512)
+	File   *os.File      // Открытый файл для чтения с произвольной позиции
+	Limits ExtractLimits // Лимиты обхода архива
+}
+
+// ContentExtractor извлекает метаданные одного формата. Поля записываются
+// с префиксом "<Namespace>." и при следующем извлечении заменяются целиком.
+type ContentExtractor struct {
+	Namespace string
+	MIME      string // Тип для Content.Type, если по первым байтам он не определился
+	Match     func(file *ContentFile) bool
+	Extract   func(file *ContentFile) (map[string]string, error)
+}
+
+var contentExtractorRegistry []ContentExtractor
+
+// RegisterContentExtractor добавляет извлекатель метаданных. Извлекатели
+// проверяются в порядке регистрации; пространство имён должно быть уникальным.
+func RegisterContentExtractor(extractor ContentExtractor) {
+	for _, registered := range contentExtractorRegistry {
+		if registered.Namespace == extractor.Namespace {
+			panic(fmt.Sprintf("content extractor %q already registered", extractor.Namespace))
+		}
+	}
+	contentExtractorRegistry = append(contentExtractorRegistry, extractor)
+}
+
+// ContentExtractorNames возвращает пространства имён всех извлекателей.
+func ContentExtractorNames() []string {
+	names := make([]string, 0, len(contentExtractorRegistry))
+	for _, extractor := range contentExtractorRegistry {
+		names = append(names, extractor.Namespace)
+	}
+	return names
+}
+
+// SetContentExtractors задаёт извлекатели, которые запускаются после
+// загрузки. Пустой список включает все, в том числе зарегистрированные
+// позже; с disable метаданные содержимого
+// не извлекаются.
+func (fs *FileService) SetContentExtractors(names []string, disable bool) error {
+	if disable {
+		fs.contentExtractors = nil
+		fs.contentDisabled = true
+		return nil
+	}
+	if len(names) == 0 {
+		fs.contentExtractors = nil
+		fs.contentDisabled = false
+		return nil
+	}
+	extractors := make([]ContentExtractor, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, extractor := range contentExtractorRegistry {
+			if strings.EqualFold(extractor.Namespace, name) {
+				extractors = append(extractors, extractor)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown content extractor %q (supported: %s)", name, strings.Join(ContentExtractorNames(), ", "))
+		}
+	}
+	fs.contentExtractors = extractors
+	fs.contentDisabled = false
+	return nil
+}
+
+// ExtractContentMetadata определяет тип содержимого файла, извлекает поля
+// его формата и записывает их в метаданные вместо извлечённых ранее.
+// Ошибка отдельного извлекателя только записывается в журнал. user -
+// пользователь, загрузивший или изменивший файл.
+func (fs *FileService) ExtractContentMetadata(filePath, user string) (map[string]string, error) {
+	if fs.contentDisabled {
+		return nil, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error reading file info: %w", err)
+	}
+	header := make([]byte, contentSniffLen)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	content := &ContentFile{
+		Path:   filePath,
+		Name:   filepath.Base(filePath),
+		Size:   info.Size(),
+		MIME:   http.DetectContentType(header[:n]),
+		Header: header[:n],
+		File:   file,
+		Limits: fs.extractLimits,
+	}
+
+	extractors := fs.contentExtractors
+	if extractors == nil {
+		extractors = contentExtractorRegistry
+	}
+	fields := map[string]string{MetadataContentType: content.MIME}
+	for _, extractor := range extractors {
+		if !extractor.Match(content) {
+			continue
+		}
+		values, err := extractor.Extract(content)
+		if err != nil {
+			logger.Warningf("Content extractor %s failed for %s: %v", extractor.Namespace, filePath, err)
+			continue
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if extractor.MIME != "" && content.MIME == "application/octet-stream" {
+			fields[MetadataContentType] = extractor.MIME
+		}
+		for key, value := range values {
+			if value != "" {
+				fields[extractor.Namespace+"."+key] = value
+			}
+		}
+	}
+
+	replace := make([]string, 0, len(contentExtractorRegistry))
+	for _, extractor := range contentExtractorRegistry {
+		replace = append(replace, extractor.Namespace+".")
+	}
+	if _, err := fs.updateMetadata(filePath, metadataUpdate{set: fields, replace: replace, actor: MetadataActor{User: user, Source: MetadataSourceContent}}); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
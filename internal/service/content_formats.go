@@ -0,0 +1,616 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Встроенные извлекатели метаданных содержимого.
+func init() {
+	RegisterContentExtractor(ContentExtractor{
+		Namespace: "ELF",
+		MIME:      "application/x-executable",
+		Match:     func(file *ContentFile) bool { return bytes.HasPrefix(file.Header, []byte(elf.ELFMAG)) },
+		Extract:   extractELF,
+	})
+	RegisterContentExtractor(ContentExtractor{
+		Namespace: "PE",
+		MIME:      "application/vnd.microsoft.portable-executable",
+		Match:     func(file *ContentFile) bool { return bytes.HasPrefix(file.Header, []byte("MZ")) },
+		Extract:   extractPE,
+	})
+	RegisterContentExtractor(ContentExtractor{
+		Namespace: "Deb",
+		MIME:      "application/vnd.debian.binary-package",
+		Match: func(file *ContentFile) bool {
+			return bytes.HasPrefix(file.Header, []byte("!<arch>\ndebian-binary"))
+		},
+		Extract: extractDeb,
+	})
+	RegisterContentExtractor(ContentExtractor{
+		Namespace: "RPM",
+		MIME:      "application/x-rpm",
+		Match:     func(file *ContentFile) bool { return bytes.HasPrefix(file.Header, rpmLeadMagic) },
+		Extract:   extractRPM,
+	})
+	RegisterContentExtractor(ContentExtractor{
+		Namespace: "Image",
+		Match:     func(file *ContentFile) bool { return strings.HasPrefix(file.MIME, "image/") },
+		Extract:   extractImage,
+	})
+	RegisterContentExtractor(ContentExtractor{
+		Namespace: "Archive",
+		Match:     func(file *ContentFile) bool { return contentArchiveKind(file) != "" },
+		Extract:   extractArchive,
+	})
+}
+
+// extractELF возвращает класс, архитектуру, тип и ABI исполняемого файла ELF,
+// интерпретатор динамически связанных программ и GNU build ID.
+func extractELF(file *ContentFile) (map[string]string, error) {
+	f, err := elf.NewFile(file.File)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{
+		"Class":   strings.TrimPrefix(f.Class.String(), "ELFCLASS") + "-bit",
+		"Machine": strings.TrimPrefix(f.Machine.String(), "EM_"),
+		"Type":    strings.TrimPrefix(f.Type.String(), "ET_"),
+		"OSABI":   strings.TrimPrefix(f.OSABI.String(), "ELFOSABI_"),
+	}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data := make([]byte, min(prog.Filesz, 4096))
+		if _, err := prog.ReadAt(data, 0); err == nil || err == io.EOF {
+			fields["Interpreter"] = string(bytes.TrimRight(data, "\x00"))
+		}
+	}
+	if section := f.Section(".note.gnu.build-id"); section != nil {
+		if data, err := section.Data(); err == nil && len(data) > 16 {
+			// Заголовок заметки: namesz, descsz, type; имя "GNU\0"
+			nameSize := f.ByteOrder.Uint32(data[0:4])
+			descSize := f.ByteOrder.Uint32(data[4:8])
+			start := 12 + (nameSize+3)&^3
+			if uint64(start)+uint64(descSize) <= uint64(len(data)) {
+				fields["BuildID"] = hex.EncodeToString(data[start : start+descSize])
+			}
+		}
+	}
+	return fields, nil
+}
+
+// peMachines - архитектуры PE по полю Machine.
+var peMachines = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386:  "i386",
+	pe.IMAGE_FILE_MACHINE_AMD64: "amd64",
+	pe.IMAGE_FILE_MACHINE_ARM:   "arm",
+	pe.IMAGE_FILE_MACHINE_ARMNT: "arm",
+	pe.IMAGE_FILE_MACHINE_ARM64: "arm64",
+	pe.IMAGE_FILE_MACHINE_IA64:  "ia64",
+}
+
+// peSubsystems - подсистемы Windows по полю Subsystem.
+var peSubsystems = map[uint16]string{
+	pe.IMAGE_SUBSYSTEM_NATIVE:                  "Native",
+	pe.IMAGE_SUBSYSTEM_WINDOWS_GUI:             "Windows GUI",
+	pe.IMAGE_SUBSYSTEM_WINDOWS_CUI:             "Windows Console",
+	pe.IMAGE_SUBSYSTEM_EFI_APPLICATION:         "EFI Application",
+	pe.IMAGE_SUBSYSTEM_EFI_BOOT_SERVICE_DRIVER: "EFI Boot Service Driver",
+	pe.IMAGE_SUBSYSTEM_EFI_RUNTIME_DRIVER:      "EFI Runtime Driver",
+}
+
+// peVersionStrings - поля StringFileInfo, которые попадают в метаданные.
+var peVersionStrings = []string{"FileVersion", "ProductVersion", "ProductName", "CompanyName", "FileDescription", "OriginalFilename"}
+
+// extractPE возвращает архитектуру, тип и подсистему исполняемого файла
+// Windows и поля его ресурса версии.
+func extractPE(file *ContentFile) (map[string]string, error) {
+	f, err := pe.NewFile(file.File)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{"Type": "EXE"}
+	if machine, ok := peMachines[f.Machine]; ok {
+		fields["Machine"] = machine
+	} else {
+		fields["Machine"] = fmt.Sprintf("0x%04x", f.Machine)
+	}
+	if f.Characteristics&pe.IMAGE_FILE_DLL != 0 {
+		fields["Type"] = "DLL"
+	}
+
+	var subsystem uint16
+	var resources pe.DataDirectory
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		subsystem = header.Subsystem
+		if header.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_RESOURCE {
+			resources = header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE]
+		}
+	case *pe.OptionalHeader64:
+		subsystem = header.Subsystem
+		if header.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_RESOURCE {
+			resources = header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE]
+		}
+	}
+	if name, ok := peSubsystems[subsystem]; ok {
+		fields["Subsystem"] = name
+	}
+
+	if resources.VirtualAddress != 0 {
+		version, err := peVersionInfo(f, resources.VirtualAddress)
+		if err != nil {
+			return nil, fmt.Errorf("error reading version resource: %w", err)
+		}
+		for key, value := range version {
+			fields[key] = value
+		}
+	}
+	return fields, nil
+}
+
+// peRTVersion - тип ресурса RT_VERSION.
+const peRTVersion = 16
+
+// peSectionData возвращает данные секции, в которую попадает RVA, и смещение
+// RVA в этих данных.
+func peSectionData(f *pe.File, rva uint32) ([]byte, uint32, error) {
+	for _, section := range f.Sections {
+		if rva < section.VirtualAddress || rva >= section.VirtualAddress+max(section.VirtualSize, section.Size) {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			return nil, 0, err
+		}
+		if rva-section.VirtualAddress >= uint32(len(data)) {
+			break
+		}
+		return data, rva - section.VirtualAddress, nil
+	}
+	return nil, 0, fmt.Errorf("RVA 0x%x is outside of sections", rva)
+}
+
+// peVersionInfo находит первый ресурс RT_VERSION и разбирает VS_VERSIONINFO.
+func peVersionInfo(f *pe.File, resourceRVA uint32) (map[string]string, error) {
+	data, base, err := peSectionData(f, resourceRVA)
+	if err != nil {
+		return nil, err
+	}
+	rsrc := data[base:]
+
+	// Три уровня каталога: тип, имя, язык. На втором и третьем берётся первая запись.
+	offset, err := peResourceEntry(rsrc, 0, peRTVersion)
+	if errors.Is(err, errResourceNotFound) {
+		return nil, nil
+	}
+	for level := 0; level < 2 && err == nil; level++ {
+		if offset&0x80000000 == 0 {
+			return nil, fmt.Errorf("malformed resource directory")
+		}
+		offset, err = peResourceEntry(rsrc, offset&0x7fffffff, -1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if offset&0x80000000 != 0 || int(offset)+8 > len(rsrc) {
+		return nil, fmt.Errorf("malformed resource data entry")
+	}
+	dataRVA := binary.LittleEndian.Uint32(rsrc[offset:])
+	size := binary.LittleEndian.Uint32(rsrc[offset+4:])
+	data, start, err := peSectionData(f, dataRVA)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(start)+uint64(size) > uint64(len(data)) {
+		return nil, fmt.Errorf("version resource is truncated")
+	}
+
+	root, ok := parseVersionNode(data[start:start+size], 0)
+	if !ok || root.key != "VS_VERSION_INFO" {
+		return nil, fmt.Errorf("malformed VS_VERSIONINFO")
+	}
+	fields := make(map[string]string)
+	// VS_FIXEDFILEINFO: сигнатура, версия структуры, затем версии файла и продукта
+	if value := root.value; len(value) >= 24 && binary.LittleEndian.Uint32(value) == 0xFEEF04BD {
+		version := func(ms, ls uint32) string {
+			return fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xffff, ls>>16, ls&0xffff)
+		}
+		fields["FileVersion"] = version(binary.LittleEndian.Uint32(value[8:]), binary.LittleEndian.Uint32(value[12:]))
+		fields["ProductVersion"] = version(binary.LittleEndian.Uint32(value[16:]), binary.LittleEndian.Uint32(value[20:]))
+	}
+	for _, child := range root.children {
+		if child.key != "StringFileInfo" || len(child.children) == 0 {
+			continue
+		}
+		strs := make(map[string]string)
+		for _, str := range child.children[0].children {
+			strs[str.key] = strings.TrimSpace(decodeUTF16(str.value))
+		}
+		for _, key := range peVersionStrings {
+			if strs[key] != "" {
+				fields[key] = strs[key]
+			}
+		}
+	}
+	return fields, nil
+}
+
+var errResourceNotFound = errors.New("resource not found")
+
+// peResourceEntry возвращает OffsetToData записи каталога ресурсов по
+// смещению dir с идентификатором id или первой записи, если id < 0.
+func peResourceEntry(rsrc []byte, dir uint32, id int) (uint32, error) {
+	if int(dir)+16 > len(rsrc) {
+		return 0, fmt.Errorf("malformed resource directory")
+	}
+	named := int(binary.LittleEndian.Uint16(rsrc[dir+12:]))
+	ids := int(binary.LittleEndian.Uint16(rsrc[dir+14:]))
+	for i := 0; i < named+ids; i++ {
+		entry := int(dir) + 16 + i*8
+		if entry+8 > len(rsrc) {
+			return 0, fmt.Errorf("malformed resource directory")
+		}
+		if id < 0 || (i >= named && binary.LittleEndian.Uint32(rsrc[entry:]) == uint32(id)) {
+			return binary.LittleEndian.Uint32(rsrc[entry+4:]), nil
+		}
+	}
+	return 0, errResourceNotFound
+}
+
+// versionNode - узел структуры VS_VERSIONINFO: ключ, значение и дочерние узлы.
+type versionNode struct {
+	key      string
+	value    []byte
+	children []versionNode
+}
+
+// parseVersionNode разбирает узел, который начинается со смещения offset.
+func parseVersionNode(data []byte, offset int) (versionNode, bool) {
+	if offset+6 > len(data) {
+		return versionNode{}, false
+	}
+	length := int(binary.LittleEndian.Uint16(data[offset:]))
+	valueLength := int(binary.LittleEndian.Uint16(data[offset+2:]))
+	text := binary.LittleEndian.Uint16(data[offset+4:]) == 1
+	end := offset + length
+	if length < 6 || end > len(data) {
+		return versionNode{}, false
+	}
+
+	var node versionNode
+	var key []uint16
+	p := offset + 6
+	for p+2 <= end {
+		c := binary.LittleEndian.Uint16(data[p:])
+		p += 2
+		if c == 0 {
+			break
+		}
+		key = append(key, c)
+	}
+	node.key = string(utf16.Decode(key))
+
+	p = align4(p)
+	if text {
+		valueLength *= 2
+	}
+	if p+valueLength > end {
+		valueLength = max(end-p, 0)
+	}
+	if p <= end {
+		node.value = data[p : p+valueLength]
+	}
+	for p = align4(p + valueLength); p < end; {
+		child, ok := parseVersionNode(data[:end], p)
+		if !ok {
+			break
+		}
+		node.children = append(node.children, child)
+		p = align4(p + int(binary.LittleEndian.Uint16(data[p:])))
+	}
+	return node, true
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// decodeUTF16 декодирует строку UTF-16LE до первого нулевого символа.
+func decodeUTF16(data []byte) string {
+	chars := make([]uint16, 0, len(data)/2)
+	for i := 0; i+2 <= len(data); i += 2 {
+		c := binary.LittleEndian.Uint16(data[i:])
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
+	}
+	return string(utf16.Decode(chars))
+}
+
+// contentControlLimit - наибольший размер управляющего файла пакета.
+const contentControlLimit = 1 << 20
+
+// extractDeb возвращает имя, версию и архитектуру пакета Debian из файла
+// control в архиве control.tar.
+func extractDeb(file *ContentFile) (map[string]string, error) {
+	offset := int64(len("!<arch>\n"))
+	header := make([]byte, 60)
+	for offset+60 <= file.Size {
+		if _, err := file.File.ReadAt(header, offset); err != nil {
+			return nil, fmt.Errorf("error reading ar header: %w", err)
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("malformed ar header for %q", name)
+		}
+		offset += 60
+		if strings.HasPrefix(name, "control.tar") {
+			control, err := readDebControl(io.NewSectionReader(file.File, offset, size), strings.TrimPrefix(name, "control.tar"))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{
+				"Package":      control["Package"],
+				"Version":      control["Version"],
+				"Architecture": control["Architecture"],
+			}, nil
+		}
+		offset += size + size%2
+	}
+	return nil, fmt.Errorf("control archive not found")
+}
+
+// readDebControl читает поля файла control из архива control.tar с
+// указанным расширением сжатия.
+func readDebControl(r io.Reader, ext string) (map[string]string, error) {
+	switch ext {
+	case "":
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("error opening gzip stream: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	case ".xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("error opening xz stream: %w", err)
+		}
+		r = xr
+	case ".zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("error opening zstd stream: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("unsupported control archive compression %q", ext)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("control file not found")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading control archive: %w", err)
+		}
+		if strings.TrimPrefix(header.Name, "./") != "control" {
+			continue
+		}
+		fields := make(map[string]string)
+		scanner := bufio.NewScanner(io.LimitReader(tr, contentControlLimit))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" || line[0] == ' ' || line[0] == '\t' {
+				continue
+			}
+			if key, value, ok := strings.Cut(line, ":"); ok {
+				fields[key] = strings.TrimSpace(value)
+			}
+		}
+		return fields, scanner.Err()
+	}
+}
+
+var (
+	rpmLeadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+// Теги заголовка RPM.
+const (
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagArch    = 1022
+)
+
+// Наибольшие число записей и размер данных заголовка RPM.
+const (
+	rpmHeaderEntries = 1 << 16
+	rpmHeaderSize    = 64 << 20
+)
+
+// extractRPM возвращает имя, версию, выпуск и архитектуру пакета RPM из его
+// основного заголовка, который следует за заголовком подписи.
+func extractRPM(file *ContentFile) (map[string]string, error) {
+	// Заголовок подписи идёт после 96 байт lead и выравнивается до 8 байт
+	_, _, next, err := readRPMHeader(file, 96)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signature header: %w", err)
+	}
+	index, store, _, err := readRPMHeader(file, (next+7)&^7)
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+
+	fields := make(map[string]string)
+	for i := 0; i+16 <= len(index); i += 16 {
+		tag := binary.BigEndian.Uint32(index[i:])
+		kind := binary.BigEndian.Uint32(index[i+4:])
+		offset := binary.BigEndian.Uint32(index[i+8:])
+		if uint64(offset) >= uint64(len(store)) {
+			continue
+		}
+		var value string
+		switch kind {
+		case 4: // INT32
+			if int(offset)+4 <= len(store) {
+				value = strconv.FormatUint(uint64(binary.BigEndian.Uint32(store[offset:])), 10)
+			}
+		case 6, 8, 9: // STRING, STRING_ARRAY, I18NSTRING
+			str := store[offset:]
+			if end := bytes.IndexByte(str, 0); end >= 0 {
+				str = str[:end]
+			}
+			value = string(str)
+		}
+		switch tag {
+		case rpmTagName:
+			fields["Name"] = value
+		case rpmTagVersion:
+			fields["Version"] = value
+		case rpmTagRelease:
+			fields["Release"] = value
+		case rpmTagEpoch:
+			fields["Epoch"] = value
+		case rpmTagArch:
+			fields["Architecture"] = value
+		}
+	}
+	if fields["Name"] == "" {
+		return nil, fmt.Errorf("package name not found")
+	}
+	return fields, nil
+}
+
+// readRPMHeader читает заголовок RPM по смещению offset и возвращает его
+// индекс, область данных и смещение за концом заголовка.
+func readRPMHeader(file *ContentFile, offset int64) (index, store []byte, next int64, err error) {
+	head := make([]byte, 16)
+	if _, err := file.File.ReadAt(head, offset); err != nil {
+		return nil, nil, 0, err
+	}
+	if !bytes.Equal(head[:4], rpmHeaderMagic) {
+		return nil, nil, 0, fmt.Errorf("bad header magic")
+	}
+	entries := binary.BigEndian.Uint32(head[8:])
+	size := binary.BigEndian.Uint32(head[12:])
+	if entries > rpmHeaderEntries || size > rpmHeaderSize {
+		return nil, nil, 0, fmt.Errorf("header is too large")
+	}
+	data := make([]byte, int64(entries)*16+int64(size))
+	if _, err := file.File.ReadAt(data, offset+16); err != nil {
+		return nil, nil, 0, err
+	}
+	return data[:entries*16], data[entries*16:], offset + 16 + int64(len(data)), nil
+}
+
+// extractImage возвращает формат и размеры изображения.
+func extractImage(file *ContentFile) (map[string]string, error) {
+	config, format, err := image.DecodeConfig(io.NewSectionReader(file.File, 0, file.Size))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"Format": format,
+		"Width":  strconv.Itoa(config.Width),
+		"Height": strconv.Itoa(config.Height),
+	}, nil
+}
+
+// contentArchiveKind возвращает формат архива по имени файла, а для ZIP с
+// другим расширением (jar, apk, docx) - по сигнатуре.
+func contentArchiveKind(file *ContentFile) ArchiveFormat {
+	if kind := browseKindByName(file.Name); kind != "" {
+		return kind
+	}
+	if file.MIME == "application/zip" {
+		return ArchiveZip
+	}
+	return ""
+}
+
+// extractArchive возвращает формат архива, число элементов, файлов и общий
+// размер файлов после распаковки. Обход останавливается на лимитах
+// распаковки, как у ListArchive: сжатый tar иначе пришлось бы распаковать
+// целиком. Тогда значения неполные и записывается Truncated.
+func extractArchive(file *ContentFile) (map[string]string, error) {
+	kind := contentArchiveKind(file)
+	var entries, files int
+	var size int64
+	truncated := false
+	count := func(dir bool, n int64) bool {
+		if file.Limits.MaxEntries > 0 && entries >= file.Limits.MaxEntries ||
+			file.Limits.MaxTotalSize > 0 && size >= file.Limits.MaxTotalSize {
+			truncated = true
+			return false
+		}
+		entries++
+		if !dir {
+			files++
+			size += n
+		}
+		return true
+	}
+
+	var err error
+	switch kind {
+	case ArchiveZip:
+		err = walkZip(file.Path, func(f *zip.File) bool {
+			return count(f.FileInfo().IsDir(), int64(f.UncompressedSize64))
+		})
+	case archive7z:
+		err = walk7z(file.Path, func(f *sevenzip.File) bool {
+			return count(f.FileInfo().IsDir(), int64(f.UncompressedSize))
+		})
+	default:
+		err = walkTar(file.Path, kind, func(header *tar.Header) bool {
+			return count(header.Typeflag == tar.TypeDir, header.Size)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{
+		"Format":  string(kind),
+		"Entries": strconv.Itoa(entries),
+		"Files":   strconv.Itoa(files),
+		"Size":    strconv.FormatInt(size, 10),
+	}
+	if truncated {
+		fields["Truncated"] = "true"
+	}
+	return fields, nil
+}
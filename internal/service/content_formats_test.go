@@ -0,0 +1,39 @@
+package service
+
+import (
+	"archive/tar"
+	"path/filepath"
+	"testing"
+)
+
+// Обход архива останавливается на лимитах распаковки.
+func TestExtractArchiveLimits(t *testing.T) {
+	base := t.TempDir()
+	path := filepath.Join(base, "a.tar")
+	var headers []*tar.Header
+	for _, name := range []string{"a", "b", "c", "d"} {
+		headers = append(headers, &tar.Header{Name: name, Typeflag: tar.TypeReg, Size: 100, Mode: 0644})
+	}
+	writeTestTar(t, path, headers)
+
+	fs := NewFileService(base, nil)
+	for _, tc := range []struct {
+		limits        ExtractLimits
+		entries, size string
+		truncated     string
+	}{
+		{ExtractLimits{}, "4", "400", ""},
+		{ExtractLimits{MaxEntries: 2}, "2", "200", "true"},
+		{ExtractLimits{MaxTotalSize: 150}, "2", "200", "true"},
+		{ExtractLimits{MaxEntries: 4, MaxTotalSize: 400}, "4", "400", ""},
+	} {
+		fs.SetExtractLimits(tc.limits)
+		fields, err := fs.ExtractContentMetadata(path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields["Archive.Entries"] != tc.entries || fields["Archive.Size"] != tc.size || fields["Archive.Truncated"] != tc.truncated {
+			t.Errorf("%+v: got %v", tc.limits, fields)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetaFilePath возвращает путь к файлу метаданных .<name>.meta для файла.
+func MetaFilePath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+".meta")
+}
+
+// StoredHashes возвращает метаданные файла, если им можно доверять как описанию
+// текущего содержимого: файл не менялся после расчёта хеш-сумм (см.
+// hashesStale), а проверка целостности не обнаружила расхождений. Иначе
+// возвращается nil.
+func (fs *FileService) StoredHashes(fullPath string) map[string]string {
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	written, err := fs.store.modTime(fullPath)
+	if err != nil {
+		return nil
+	}
+	metadata, err := fs.loadMetadata(fullPath)
+	if err != nil || VerifyFailed(metadata) || hashesStale(info, metadata, written) {
+		return nil
+	}
+	return metadata
+}
+
+// hashesStale сообщает, что файл изменился после расчёта хеш-сумм: его размер
+// или время изменения отличаются от сохранённых при расчёте. Для метаданных,
+// записанных до появления этих полей, время изменения файла сравнивается со
+// временем записи метаданных written.
+func hashesStale(info os.FileInfo, metadata map[string]string, written time.Time) bool {
+	if value := metadata[MetadataHashedSize]; value != "" {
+		if size, err := strconv.ParseInt(value, 10, 64); err != nil || size != info.Size() {
+			return true
+		}
+	}
+	if value := metadata[MetadataHashedModTime]; value != "" {
+		modTime, err := time.Parse(time.RFC3339Nano, value)
+		return err != nil || !modTime.Equal(info.ModTime())
+	}
+	return info.ModTime().After(written)
+}
+
+// digestAlgorithms сопоставляет ключи хеш-сумм в метаданных с именами
+// алгоритмов в заголовках Digest (RFC 3230) и Repr-Digest (RFC 9530).
+var digestAlgorithms = []struct {
+	key    string
+	digest string
+	repr   string
+}{
+	{key: "SHA256", digest: "SHA-256", repr: "sha-256"},
+	{key: "SHA1", digest: "SHA", repr: ""},
+}
+
+// StrongETag возвращает сильный ETag по сохранённой SHA256 или пустую строку.
+func StrongETag(metadata map[string]string) string {
+	sum := strings.ToLower(metadata["SHA256"])
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != 64 {
+		return ""
+	}
+	return `"sha256-` + sum + `"`
+}
+
+// DigestHeaders формирует значения заголовков Digest и Repr-Digest по
+// сохранённым хеш-суммам.
+func DigestHeaders(metadata map[string]string) (digest, reprDigest string) {
+	var digests, reprs []string
+	for _, alg := range digestAlgorithms {
+		sum, err := hex.DecodeString(metadata[alg.key])
+		if err != nil || len(sum) == 0 {
+			continue
+		}
+		encoded := base64.StdEncoding.EncodeToString(sum)
+		digests = append(digests, alg.digest+"="+encoded)
+		if alg.repr != "" {
+			reprs = append(reprs, alg.repr+"=:"+encoded+":")
+		}
+	}
+	return strings.Join(digests, ","), strings.Join(reprs, ", ")
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newHashedTestFile создаёт файл с сохранёнными хеш-суммами.
+func newHashedTestFile(t *testing.T, fs *FileService, name, content string) string {
+	t.Helper()
+	path := filepath.Join(fs.baseDir, name)
+	writeTestFile(t, path, []byte(content))
+	hashes, err := fs.RecalculateHashes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.StoreHashes(path, hashes, SystemActor(MetadataSourceUpload)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Запись метаданных после изменения файла не делает старые суммы актуальными.
+func TestStoredHashesAfterExternalChange(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	path := newHashedTestFile(t, fs, "a.txt", "hello")
+	if StrongETag(fs.StoredHashes(path)) == "" {
+		t.Fatal("fresh hashes must be trusted")
+	}
+
+	// Тот же размер, другое содержимое и время изменения
+	writeTestFile(t, path, []byte("HELLO"))
+	changed := time.Now().Add(-time.Hour)
+	os.Chtimes(path, changed, changed)
+	if _, err := fs.EditMetadata(path, map[string]string{"Notes": "edited"}, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if etag := StrongETag(fs.StoredHashes(path)); etag != "" {
+		t.Errorf("stale hashes trusted, ETag %s", etag)
+	}
+	z, err := fs.NewStoreZip(context.Background(), []string{"a.txt"}, ArchiveOptions{ExcludeMeta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if crc := binary.LittleEndian.Uint32(z.segments[0].data[14:18]); crc != crc32.ChecksumIEEE([]byte("HELLO")) {
+		t.Errorf("CRC32 in local header %08x, want CRC32 of the current content", crc)
+	}
+}
+
+// Метаданные прежних версий без времени изменения при расчёте сравниваются
+// со временем их записи.
+func TestHashesStaleLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	writeTestFile(t, path, []byte("hello"))
+	info, _ := os.Stat(path)
+
+	if hashesStale(info, map[string]string{}, info.ModTime()) {
+		t.Error("metadata written with the file must be current")
+	}
+	if !hashesStale(info, map[string]string{}, info.ModTime().Add(-time.Second)) {
+		t.Error("file modified after the metadata must be stale")
+	}
+	if !hashesStale(info, map[string]string{MetadataHashedSize: "4"}, info.ModTime()) {
+		t.Error("size change must be stale")
+	}
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HashMatch - файл с искомой контрольной суммой.
+type HashMatch struct {
+	Path   string   `json:"path"`
+	Size   int64    `json:"size"`
+	Fields []string `json:"fields"` // Поля метаданных, в которых найдена сумма
+}
+
+// DuplicateGroup - файлы с одинаковым содержимым (SHA256).
+type DuplicateGroup struct {
+	SHA256      string   `json:"sha256"`
+	Size        int64    `json:"size"`
+	Paths       []string `json:"paths"`
+	WastedBytes int64    `json:"wasted_bytes"` // Size * (число копий - 1)
+}
+
+// DuplicateReport - отчёт о дубликатах в папке.
+type DuplicateReport struct {
+	Path        string           `json:"path"`
+	Groups      []DuplicateGroup `json:"groups"`
+	Files       int              `json:"files"` // Число файлов с известным SHA256
+	WastedBytes int64            `json:"wasted_bytes"`
+}
+
+// SetUploadDuplicateWarning включает предупреждение о загрузке файла,
+// содержимое которого уже есть в хранилище.
+func (fs *FileService) SetUploadDuplicateWarning(enabled bool) {
+	fs.warnDuplicates = enabled
+}
+
+// UploadDuplicateWarning сообщает, включено ли предупреждение о дубликатах.
+func (fs *FileService) UploadDuplicateWarning() bool {
+	return fs.warnDuplicates
+}
+
+// FindByHash возвращает файлы, у которых одна из сохранённых контрольных
+// сумм (см. HashFields) равна hash. Файлы, изменённые после расчёта сумм, не
+// учитываются.
+func (fs *FileService) FindByHash(ctx context.Context, hash string) ([]HashMatch, error) {
+	hash = strings.TrimSpace(hash)
+	matches := []HashMatch{}
+	err := fs.WalkMetadata(ctx, func(file IndexedFile) error {
+		if !hasHash(file.Metadata, hash) {
+			return nil
+		}
+		fullPath := fs.GetFullPath(file.Path)
+		metadata := fs.StoredHashes(fullPath)
+		if metadata == nil {
+			return nil
+		}
+		var fields []string
+		for _, key := range HashFields {
+			if SameHash(key, metadata[key], hash) {
+				fields = append(fields, key)
+			}
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			return nil
+		}
+		matches = append(matches, HashMatch{Path: file.Path, Size: info.Size(), Fields: fields})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Path < matches[j].Path })
+	return matches, nil
+}
+
+// FindDuplicates группирует файлы папки dirPath (относительно base_dir) по
+// SHA256 и возвращает группы из нескольких файлов, начиная с занимающих
+// больше всего лишнего места.
+func (fs *FileService) FindDuplicates(ctx context.Context, dirPath string) (DuplicateReport, error) {
+	prefix := path.Clean("/" + dirPath)
+	report := DuplicateReport{Path: prefix, Groups: []DuplicateGroup{}}
+
+	candidates := make(map[string][]string)
+	err := fs.WalkMetadata(ctx, func(file IndexedFile) error {
+		if prefix != "/" && !strings.HasPrefix(file.Path, prefix+"/") {
+			return nil
+		}
+		if sum := strings.ToLower(file.Metadata["SHA256"]); sum != "" {
+			candidates[sum] = append(candidates[sum], file.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for sum, paths := range candidates {
+		report.Files += len(paths)
+		if len(paths) < 2 {
+			continue
+		}
+		// Сумма должна соответствовать текущему содержимому файла
+		group := DuplicateGroup{SHA256: sum}
+		for _, p := range paths {
+			fullPath := fs.GetFullPath(p)
+			if !strings.EqualFold(fs.StoredHashes(fullPath)["SHA256"], sum) {
+				continue
+			}
+			info, err := os.Stat(fullPath)
+			if err != nil {
+				continue
+			}
+			group.Size = info.Size()
+			group.Paths = append(group.Paths, p)
+		}
+		if len(group.Paths) < 2 {
+			continue
+		}
+		sort.Strings(group.Paths)
+		group.WastedBytes = group.Size * int64(len(group.Paths)-1)
+		report.WastedBytes += group.WastedBytes
+		report.Groups = append(report.Groups, group)
+	}
+
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].WastedBytes != report.Groups[j].WastedBytes {
+			return report.Groups[i].WastedBytes > report.Groups[j].WastedBytes
+		}
+		return report.Groups[i].SHA256 < report.Groups[j].SHA256
+	})
+	return report, nil
+}
+
+// DuplicatesOf возвращает другие файлы с тем же SHA256, что и у fullPath.
+func (fs *FileService) DuplicatesOf(ctx context.Context, fullPath, sha256 string) ([]string, error) {
+	matches, err := fs.FindByHash(ctx, sha256)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(filepath.Clean(fs.baseDir), fullPath)
+	if err != nil {
+		return nil, err
+	}
+	self := path.Join("/", filepath.ToSlash(rel))
+	var paths []string
+	for _, match := range matches {
+		if match.Path != self && containsString(match.Fields, "SHA256") {
+			paths = append(paths, match.Path)
+		}
+	}
+	return paths, nil
+}
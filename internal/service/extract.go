@@ -0,0 +1,466 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fileStation/pkg/logger"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrExtractLimit возвращается, если архив превышает допустимые размер или число элементов.
+var ErrExtractLimit = errors.New("archive exceeds extraction limits")
+
+// ExtractLimits ограничивает распаковку для защиты от zip-бомб.
+type ExtractLimits struct {
+	MaxTotalSize int64 // Суммарный размер распакованных файлов в байтах
+	MaxEntries   int   // Максимальное число элементов архива
+}
+
+// ExtractOptions задаёт параметры распаковки архива.
+type ExtractOptions struct {
+	User      string // Пользователь, записываемый в метаданные как Uploader
+	Overwrite bool   // Перезаписывать существующие файлы
+}
+
+// ExtractResult описывает итог распаковки.
+type ExtractResult struct {
+	Destination string   `json:"destination"`
+	Files       int      `json:"files"`
+	Dirs        int      `json:"dirs"`
+	Bytes       int64    `json:"bytes"`
+	Skipped     []string `json:"skipped,omitempty"`
+}
+
+// IsExtractableArchive проверяет, умеет ли сервер распаковывать архив с таким именем.
+func IsExtractableArchive(name string) bool {
+	return archiveKindByName(name) != ""
+}
+
+func archiveKindByName(name string) ArchiveFormat {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return ArchiveZip
+	case strings.HasSuffix(lower, ".tar"):
+		return ArchiveTar
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return ArchiveTarGz
+	case strings.HasSuffix(lower, ".tar.zst"), strings.HasSuffix(lower, ".tzst"):
+		return ArchiveTarZst
+	default:
+		return ""
+	}
+}
+
+// ArchiveBaseName возвращает имя архива без расширения архива.
+func ArchiveBaseName(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tgz", ".tzst", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// SetExtractLimits задаёт ограничения распаковки архивов.
+func (fs *FileService) SetExtractLimits(limits ExtractLimits) {
+	fs.extractLimits = limits
+}
+
+// ExtractArchive распаковывает zip/tar/tar.gz/tar.zst архив в destDir, защищаясь
+// от выхода за пределы папки и zip-бомб, и создаёт метаданные с хеш-суммами
+// для каждого распакованного файла. При ошибке созданные файлы удаляются.
+// Существующие файлы при перезаписи заменяются только после распаковки всего
+// архива, поэтому ошибка оставляет их нетронутыми.
+func (fs *FileService) ExtractArchive(ctx context.Context, archivePath, destDir string, opts ExtractOptions, progress ProgressFunc) (ExtractResult, error) {
+	kind := archiveKindByName(archivePath)
+	if kind == "" {
+		return ExtractResult{}, fmt.Errorf("unsupported archive type: %s", filepath.Base(archivePath))
+	}
+
+	ex := &extractor{
+		fs:          fs,
+		ctx:         ctx,
+		destDir:     filepath.Clean(destDir),
+		opts:        opts,
+		limits:      fs.extractLimits,
+		archiveName: filepath.Base(archivePath),
+	}
+	ex.result.Destination = destDir
+
+	err := ex.mkdirAll(ex.destDir)
+	if err == nil {
+		if kind == ArchiveZip {
+			err = ex.extractZip(archivePath, progress)
+		} else {
+			err = ex.extractTar(archivePath, kind, progress)
+		}
+	}
+	if err == nil {
+		err = ex.replaceFiles()
+	}
+	if err != nil {
+		ex.rollback()
+		return ExtractResult{}, err
+	}
+	return ex.result, nil
+}
+
+// extractEntry - элемент архива независимо от формата.
+type extractEntry struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+	isDir   bool
+	regular bool
+	r       io.Reader
+}
+
+type extractor struct {
+	fs          *FileService
+	ctx         context.Context
+	destDir     string
+	opts        ExtractOptions
+	limits      ExtractLimits
+	archiveName string
+	entries     int
+	result      ExtractResult
+	created     []string
+	replaced    []extractedFile // Файлы, заменяющие существующие после распаковки
+}
+
+// extractedFile - распакованный во временный файл элемент, который заменит
+// существующий файл target.
+type extractedFile struct {
+	name     string
+	tmp      string
+	target   string
+	modTime  time.Time
+	metadata map[string]string
+}
+
+func (ex *extractor) extractZip(archivePath string, progress ProgressFunc) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("error opening archive: %w", err)
+	}
+	defer zr.Close()
+
+	if ex.limits.MaxEntries > 0 && len(zr.File) > ex.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrExtractLimit, ex.limits.MaxEntries)
+	}
+
+	var counter *progressCounter
+	if progress != nil {
+		var total int64
+		for _, f := range zr.File {
+			total += int64(f.UncompressedSize64)
+		}
+		counter = &progressCounter{total: total, report: progress}
+	}
+
+	for _, f := range zr.File {
+		info := f.FileInfo()
+		entry := extractEntry{
+			name:    f.Name,
+			mode:    info.Mode(),
+			modTime: f.Modified,
+			isDir:   info.IsDir(),
+			regular: info.Mode().IsRegular(),
+		}
+		if !entry.regular {
+			if err := ex.handle(entry); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", f.Name, err)
+		}
+		entry.r = newProgressReader(ex.ctx, rc, counter)
+		err = ex.handle(entry)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ex *extractor) extractTar(archivePath string, kind ArchiveFormat, progress ProgressFunc) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("error opening archive: %w", err)
+	}
+	defer file.Close()
+
+	// Прогресс считается по прочитанным байтам самого архива
+	var counter *progressCounter
+	if progress != nil {
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("error reading archive info: %w", err)
+		}
+		counter = &progressCounter{total: info.Size(), report: progress}
+	}
+	var r io.Reader = newProgressReader(ex.ctx, file, counter)
+
+	switch kind {
+	case ArchiveTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("error opening gzip stream: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	case ArchiveTarZst:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("error opening zstd stream: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading archive: %w", err)
+		}
+		info := header.FileInfo()
+		entry := extractEntry{
+			name:    header.Name,
+			mode:    info.Mode(),
+			modTime: header.ModTime,
+			isDir:   header.Typeflag == tar.TypeDir,
+			regular: header.Typeflag == tar.TypeReg,
+			r:       tr,
+		}
+		if err := ex.handle(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// handle распаковывает один элемент архива.
+func (ex *extractor) handle(entry extractEntry) error {
+	if err := ex.ctx.Err(); err != nil {
+		return err
+	}
+
+	ex.entries++
+	if ex.limits.MaxEntries > 0 && ex.entries > ex.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrExtractLimit, ex.limits.MaxEntries)
+	}
+
+	target, err := safeExtractPath(ex.destDir, entry.name)
+	if err != nil {
+		return err
+	}
+	if target == ex.destDir {
+		return nil
+	}
+
+	switch {
+	case entry.isDir:
+		if err := ex.mkdirAll(target); err != nil {
+			return err
+		}
+		return nil
+	case !entry.regular:
+		// Ссылки и специальные файлы не распаковываются
+		ex.result.Skipped = append(ex.result.Skipped, entry.name)
+		return nil
+	case IsMetaFile(filepath.Base(target)):
+		// Метаданные из архива не доверяем: они создаются заново по содержимому
+		ex.result.Skipped = append(ex.result.Skipped, entry.name)
+		return nil
+	}
+
+	if err := ex.mkdirAll(filepath.Dir(target)); err != nil {
+		return err
+	}
+	return ex.writeFile(target, entry)
+}
+
+func (ex *extractor) writeFile(target string, entry extractEntry) error {
+	perm := entry.mode.Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+
+	// Существующий файл перезаписывается через временный файл рядом с ним
+	info, statErr := os.Lstat(target)
+	replace := statErr == nil
+	if replace && !ex.opts.Overwrite {
+		return fmt.Errorf("file already exists: %s", entry.name)
+	}
+	if replace && info.IsDir() {
+		return fmt.Errorf("not a file: %s", entry.name)
+	}
+	var file *os.File
+	var err error
+	if replace {
+		file, err = os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".tmp*")
+		if err == nil {
+			ex.replaced = append(ex.replaced, extractedFile{name: entry.name, tmp: file.Name(), target: target, modTime: entry.modTime})
+			err = file.Chmod(perm | 0600)
+		}
+	} else {
+		file, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm|0600)
+		if err == nil {
+			ex.created = append(ex.created, target)
+		}
+	}
+	if os.IsExist(err) {
+		if file != nil {
+			file.Close()
+		}
+		return fmt.Errorf("file already exists: %s", entry.name)
+	} else if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return fmt.Errorf("error creating %s: %w", entry.name, err)
+	}
+
+	hasher, err := ex.fs.NewHasher()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// Читаем не больше оставшегося лимита, чтобы обнаружить превышение
+	// независимо от размеров, заявленных в заголовках архива
+	var r io.Reader = entry.r
+	remaining := int64(-1)
+	if ex.limits.MaxTotalSize > 0 {
+		remaining = ex.limits.MaxTotalSize - ex.result.Bytes
+		r = io.LimitReader(r, remaining+1)
+	}
+	written, err := io.Copy(io.MultiWriter(file, hasher), r)
+	closeErr := file.Close()
+	if err != nil {
+		return fmt.Errorf("error extracting %s: %w", entry.name, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("error extracting %s: %w", entry.name, closeErr)
+	}
+	if remaining >= 0 && written > remaining {
+		return fmt.Errorf("%w: more than %d bytes", ErrExtractLimit, ex.limits.MaxTotalSize)
+	}
+
+	ex.result.Bytes += written
+	ex.result.Files++
+
+	metadata := hasher.Sums()
+	metadata["Uploader"] = ex.opts.User
+	metadata["Extracted From"] = ex.archiveName
+	if replace {
+		ex.replaced[len(ex.replaced)-1].metadata = metadata
+		return nil
+	}
+	return ex.storeMetadata(target, entry.name, entry.modTime, metadata)
+}
+
+// storeMetadata сохраняет метаданные распакованного файла target.
+func (ex *extractor) storeMetadata(target, name string, modTime time.Time, metadata map[string]string) error {
+	if !modTime.IsZero() {
+		os.Chtimes(target, modTime, modTime)
+	}
+	if err := ex.fs.StoreHashes(target, metadata, MetadataActor{User: ex.opts.User, Source: MetadataSourceExtract}); err != nil {
+		return fmt.Errorf("error saving metadata for %s: %w", name, err)
+	}
+	if _, err := ex.fs.ExtractContentMetadata(target, ex.opts.User); err != nil {
+		logger.Warningf("Error extracting content metadata from %s: %v", name, err)
+	}
+	return nil
+}
+
+// replaceFiles заменяет существующие файлы распакованными после того, как
+// весь архив распакован без ошибок.
+func (ex *extractor) replaceFiles() error {
+	for i, file := range ex.replaced {
+		if err := os.Rename(file.tmp, file.target); err != nil {
+			ex.replaced = ex.replaced[i:]
+			return fmt.Errorf("error replacing %s: %w", file.name, err)
+		}
+	}
+	replaced := ex.replaced
+	ex.replaced = nil
+	for _, file := range replaced {
+		if err := ex.storeMetadata(file.target, file.name, file.modTime, file.metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkdirAll создаёт директорию, запоминая созданные уровни для отката.
+func (ex *extractor) mkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("not a directory: %s", dir)
+		}
+		return nil
+	}
+	if err := ex.mkdirAll(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	ex.created = append(ex.created, dir)
+	ex.result.Dirs++
+	return nil
+}
+
+// rollback удаляет всё, что было создано при неудачной распаковке, и
+// временные файлы, не заменившие существующие.
+func (ex *extractor) rollback() {
+	for _, file := range ex.replaced {
+		os.Remove(file.tmp)
+	}
+	for i := len(ex.created) - 1; i >= 0; i-- {
+		path := ex.created[i]
+		os.Remove(path)
+		ex.fs.store.remove(path)
+		ex.fs.unindexPath(path)
+	}
+}
+
+// safeExtractPath возвращает путь элемента внутри destDir, отклоняя абсолютные
+// пути и выход за пределы папки через "..".
+func safeExtractPath(destDir, name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || filepath.VolumeName(filepath.FromSlash(name)) != "" {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	target := filepath.Join(destDir, clean)
+	if target != destDir && !strings.HasPrefix(target, destDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	return target, nil
+}
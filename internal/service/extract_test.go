@@ -0,0 +1,215 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testArchiveEntry struct {
+	name string
+	data string
+}
+
+func writeTestZip(t *testing.T, path string, entries []testArchiveEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, buf.Bytes())
+}
+
+func writeTestTar(t *testing.T, path string, headers []*tar.Header) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range headers {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(bytes.Repeat([]byte("x"), int(h.Size)))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, buf.Bytes())
+}
+
+// listTree возвращает пути всех файлов и папок внутри dir.
+func listTree(t *testing.T, dir string) []string {
+	t.Helper()
+	var paths []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && path != dir {
+			rel, _ := filepath.Rel(dir, path)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return paths
+}
+
+func TestSafeExtractPath(t *testing.T) {
+	dest := filepath.FromSlash("/data/dest")
+	for _, name := range []string{"../evil", "a/../../evil", "/etc/passwd", "..", `..\evil`, `a\..\..\evil`} {
+		if target, err := safeExtractPath(dest, name); err == nil {
+			t.Errorf("%q: accepted as %s", name, target)
+		}
+	}
+	for name, want := range map[string]string{
+		"a.txt":      "a.txt",
+		"dir/b.txt":  "dir/b.txt",
+		"dir/../c":   "c",
+		`win\d.txt`:  "win/d.txt",
+		"./e.txt":    "e.txt",
+		"..hidden/f": "..hidden/f",
+	} {
+		target, err := safeExtractPath(dest, name)
+		if err != nil {
+			t.Errorf("%q: %v", name, err)
+		} else if target != filepath.Join(dest, filepath.FromSlash(want)) {
+			t.Errorf("%q: got %s", name, target)
+		}
+	}
+}
+
+// Элемент с выходом за пределы папки прерывает распаковку, а уже
+// распакованные файлы и созданная папка удаляются.
+func TestExtractZipSlip(t *testing.T) {
+	base := t.TempDir()
+	archive := filepath.Join(base, "evil.zip")
+	writeTestZip(t, archive, []testArchiveEntry{
+		{"ok/a.txt", "a"},
+		{"../escaped.txt", "evil"},
+	})
+
+	fs := NewFileService(base, nil)
+	dest := filepath.Join(base, "dest")
+	if _, err := fs.ExtractArchive(context.Background(), archive, dest, ExtractOptions{}, nil); err == nil || !strings.Contains(err.Error(), "illegal path") {
+		t.Fatalf("got %v, want illegal path error", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "escaped.txt")); !os.IsNotExist(err) {
+		t.Error("file written outside the destination")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("destination must be rolled back, left %v", listTree(t, dest))
+	}
+}
+
+// В tar ссылки не распаковываются, поэтому через них нельзя записать файл
+// за пределами папки.
+func TestExtractTarSkipsLinks(t *testing.T) {
+	base := t.TempDir()
+	outside := t.TempDir()
+	archive := filepath.Join(base, "links.tar")
+	writeTestTar(t, archive, []*tar.Header{
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+		{Name: "link/evil.txt", Typeflag: tar.TypeReg, Size: 4, Mode: 0644},
+	})
+
+	fs := NewFileService(base, nil)
+	dest := filepath.Join(base, "dest")
+	result, err := fs.ExtractArchive(context.Background(), archive, dest, ExtractOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0] != "link" {
+		t.Errorf("skipped %v, want [link]", result.Skipped)
+	}
+	if files := listTree(t, outside); len(files) != 0 {
+		t.Errorf("files written outside the destination: %v", files)
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "link", "evil.txt")); err != nil || string(data) != "xxxx" {
+		t.Errorf("got %q, %v", data, err)
+	}
+}
+
+func TestExtractExistingFile(t *testing.T) {
+	base := t.TempDir()
+	archive := filepath.Join(base, "a.zip")
+	writeTestZip(t, archive, []testArchiveEntry{{"a.txt", "new"}})
+	dest := filepath.Join(base, "dest")
+	writeTestFile(t, filepath.Join(dest, "a.txt"), []byte("old"))
+
+	fs := NewFileService(base, nil)
+	if _, err := fs.ExtractArchive(context.Background(), archive, dest, ExtractOptions{}, nil); err == nil {
+		t.Fatal("existing file must not be overwritten without Overwrite")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "a.txt")); string(data) != "old" {
+		t.Errorf("got %q, want old", data)
+	}
+
+	result, err := fs.ExtractArchive(context.Background(), archive, dest, ExtractOptions{Overwrite: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Files != 1 {
+		t.Errorf("extracted %d files, want 1", result.Files)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "a.txt")); string(data) != "new" {
+		t.Errorf("got %q, want new", data)
+	}
+	if hashes := fs.StoredHashes(filepath.Join(dest, "a.txt")); len(hashes) == 0 {
+		t.Error("overwritten file has no stored hashes")
+	}
+	if files := listTree(t, dest); len(files) != 2 || files[0] != ".a.txt.meta" || files[1] != "a.txt" {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+// Ошибка распаковки с перезаписью оставляет существующие файлы нетронутыми
+// и не оставляет временных файлов.
+func TestExtractOverwriteFailureKeepsOriginals(t *testing.T) {
+	base := t.TempDir()
+	archive := filepath.Join(base, "a.zip")
+	writeTestZip(t, archive, []testArchiveEntry{
+		{"a.txt", "new"},
+		{"b.txt", "created"},
+		{"../evil.txt", "evil"},
+	})
+	dest := filepath.Join(base, "dest")
+	writeTestFile(t, filepath.Join(dest, "a.txt"), []byte("old"))
+
+	fs := NewFileService(base, nil)
+	if _, err := fs.ExtractArchive(context.Background(), archive, dest, ExtractOptions{Overwrite: true}, nil); err == nil {
+		t.Fatal("extraction must fail")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "a.txt")); string(data) != "old" {
+		t.Errorf("got %q, want old", data)
+	}
+	if files := listTree(t, dest); len(files) != 1 || files[0] != "a.txt" {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	base := t.TempDir()
+	archive := filepath.Join(base, "big.zip")
+	writeTestZip(t, archive, []testArchiveEntry{{"a.txt", strings.Repeat("a", 100)}, {"b.txt", "b"}})
+
+	fs := NewFileService(base, nil)
+	for _, limits := range []ExtractLimits{{MaxTotalSize: 50}, {MaxEntries: 1}} {
+		fs.SetExtractLimits(limits)
+		dest := filepath.Join(base, "dest")
+		if _, err := fs.ExtractArchive(context.Background(), archive, dest, ExtractOptions{}, nil); err == nil || !strings.Contains(err.Error(), ErrExtractLimit.Error()) {
+			t.Errorf("%+v: got %v, want limit error", limits, err)
+		}
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			t.Errorf("%+v: destination must be rolled back", limits)
+		}
+	}
+}
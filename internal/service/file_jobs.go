@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Типы фоновых задач, которые выполняет FileService.
+const (
+	JobKindRecalculateHashes = "recalculate-hashes"
+	JobKindZipArchive        = "zip"
+	JobKindExtract           = "extract"
+	JobKindReindexMetadata   = "reindex-metadata"
+)
+
+// RegisterJobs регистрирует исполнителей файловых задач в JobService.
+func (fs *FileService) RegisterJobs(jobs *JobService) {
+	jobs.Register(JobKindRecalculateHashes, fs.recalculateHashesJob)
+	jobs.Register(JobKindZipArchive, fs.zipArchiveJob)
+	jobs.Register(JobKindExtract, fs.extractJob)
+	jobs.Register(JobKindReindexMetadata, fs.reindexMetadataJob)
+	jobs.Register(JobKindScrub, fs.scrubJob)
+	jobs.Register(JobKindReconcile, fs.reconcileJob)
+}
+
+// recalculateHashesJob пересчитывает хеш-суммы файла и сохраняет их в метаданных.
+func (fs *FileService) recalculateHashesJob(ctx context.Context, jc *JobControl) error {
+	path := jc.Param("path")
+	if path == "" {
+		return errors.New("file path is required")
+	}
+
+	fullPath := fs.GetFullPath(path)
+	hashes, err := fs.RecalculateHashesContext(ctx, fullPath, jc.Progress)
+	if err != nil {
+		return err
+	}
+	if err := fs.StoreHashes(fullPath, hashes, MetadataActor{User: jc.User(), Source: MetadataSourceRecalculate}); err != nil {
+		return fmt.Errorf("error updating metadata: %w", err)
+	}
+	if err := fs.PublishSignatures(filepath.Dir(fullPath), []string{filepath.Base(fullPath)}); err != nil {
+		return fmt.Errorf("error signing file: %w", err)
+	}
+	return jc.SetResult(hashes)
+}
+
+// zipArchiveJob собирает архив из выбранных элементов в файл-результат задачи.
+// Формат задаётся параметром format (по умолчанию zip).
+func (fs *FileService) zipArchiveJob(ctx context.Context, jc *JobControl) error {
+	items := jc.Params("items")
+	if len(items) == 0 {
+		return errors.New("no files selected for download")
+	}
+	format, err := ParseArchiveFormat(jc.Param("format"))
+	if err != nil {
+		return err
+	}
+	opts := ArchiveOptions{
+		Format:      format,
+		ExcludeMeta: jc.Param("exclude_meta") == "true",
+	}
+
+	resultPath := jc.ResultFile(format.Extension())
+	file, err := os.Create(resultPath)
+	if err != nil {
+		return fmt.Errorf("error creating archive file: %w", err)
+	}
+	defer file.Close()
+
+	if err := fs.CreateArchive(ctx, file, items, opts, jc.Progress); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// extractJob распаковывает архив из base_dir в папку destination
+// (по умолчанию - папка с именем архива рядом с ним).
+func (fs *FileService) extractJob(ctx context.Context, jc *JobControl) error {
+	path := jc.Param("path")
+	if path == "" {
+		return errors.New("archive path is required")
+	}
+	archivePath := fs.GetFullPath(path)
+
+	destDir := filepath.Join(filepath.Dir(archivePath), ArchiveBaseName(filepath.Base(archivePath)))
+	if destination := jc.Param("destination"); destination != "" {
+		destDir = fs.GetFullPath(destination)
+	}
+
+	opts := ExtractOptions{
+		User:      jc.User(),
+		Overwrite: jc.Param("overwrite") == "true",
+	}
+	result, err := fs.ExtractArchive(ctx, archivePath, destDir, opts, jc.Progress)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(fs.baseDir, destDir); err == nil {
+		result.Destination = "/" + filepath.ToSlash(rel)
+	}
+	return jc.SetResult(result)
+}
+
+// reindexMetadataJob синхронизирует индекс метаданных с файлами .meta;
+// при full=true индекс перестраивается полностью.
+func (fs *FileService) reindexMetadataJob(ctx context.Context, jc *JobControl) error {
+	stats, err := fs.SyncMetadataIndex(ctx, jc.Param("full") == "true")
+	if err != nil {
+		return err
+	}
+	return jc.SetResult(stats)
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"fileStation/pkg/logger"
+)
+
+// MetadataPathKey - ключ с путём к файлу в запросе на сохранение метаданных;
+// в файл метаданных он не записывается.
+const MetadataPathKey = "FilePath"
+
+// SetMetadataSchema задаёт схему проверки метаданных.
+func (fs *FileService) SetMetadataSchema(schema *MetadataSchema) {
+	fs.schema = schema
+}
+
+// MetadataSchema возвращает действующую схему метаданных.
+func (fs *FileService) MetadataSchema() *MetadataSchema {
+	return fs.schema
+}
+
+// ValidateMetadata проверяет значения полей по схеме без сохранения.
+func (fs *FileService) ValidateMetadata(metadata map[string]string) error {
+	if errs := fs.schema.Validate(metadata); len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// EditMetadata сохраняет изменения метаданных, внесённые пользователем:
+// системные поля изменять нельзя, а после изменения должны быть заполнены
+// все обязательные поля схемы. Если задана ревизия revision (If-Match), а
+// метаданные с тех пор изменились, возвращается ErrMetadataConflict.
+// Изменение записывается в историю от имени user. Возвращает новую ревизию.
+func (fs *FileService) EditMetadata(filePath string, changes map[string]string, user, revision string) (string, error) {
+	var errs []FieldError
+	for key := range changes {
+		if fs.schema.IsReserved(key) {
+			errs = append(errs, FieldError{Field: key, Message: "reserved field cannot be edited"})
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return "", &ValidationError{Errors: errs}
+	}
+	result, err := fs.updateMetadata(filePath, metadataUpdate{set: changes, checkRequired: true, revision: revision, actor: MetadataActor{User: user, Source: MetadataSourceEdit}})
+	if err != nil {
+		return "", err
+	}
+	return result.revision, nil
+}
+
+// SetMetadataIndex подключает индекс метаданных; nil отключает его.
+func (fs *FileService) SetMetadataIndex(index *MetadataIndex) {
+	fs.index = index
+	if index != nil {
+		index.store = fs.store
+	}
+}
+
+// MetadataIndex возвращает подключённый индекс метаданных или nil.
+func (fs *FileService) MetadataIndex() *MetadataIndex {
+	return fs.index
+}
+
+// SyncMetadataIndex синхронизирует индекс с файлами метаданных.
+func (fs *FileService) SyncMetadataIndex(ctx context.Context, full bool) (IndexStats, error) {
+	if fs.index == nil {
+		return IndexStats{}, ErrIndexDisabled
+	}
+	return fs.index.Sync(ctx, full)
+}
+
+// indexMetadata обновляет запись индекса после записи файла метаданных.
+func (fs *FileService) indexMetadata(filePath string, metadata map[string]string) {
+	if fs.index == nil {
+		return
+	}
+	info, err := fs.store.state(filePath)
+	if err == nil {
+		err = fs.index.Put(filePath, metadata, info)
+	}
+	if err != nil {
+		logger.Errorf("Error updating metadata index for %s: %v", filePath, err)
+	}
+	fs.indexPath(filePath)
+}
+
+// unindexMetadata удаляет из индекса метаданные удалённого файла метаданных.
+func (fs *FileService) unindexMetadata(filePath string) {
+	if fs.index == nil {
+		return
+	}
+	if err := fs.index.DeleteMetadata(filePath); err != nil {
+		logger.Errorf("Error updating metadata index for %s: %v", filePath, err)
+	}
+}
+
+// indexPath обновляет в индексе запись созданного или изменённого файла
+// (папки) и его родительской папки.
+func (fs *FileService) indexPath(fullPath string) {
+	if fs.index == nil {
+		return
+	}
+	for _, p := range []string{fullPath, filepath.Dir(fullPath)} {
+		if err := fs.index.PutPath(p); err != nil {
+			logger.Errorf("Error updating metadata index for %s: %v", p, err)
+		}
+	}
+}
+
+// unindexPath удаляет из индекса файл или папку со всем содержимым.
+func (fs *FileService) unindexPath(fullPath string) {
+	if fs.index == nil {
+		return
+	}
+	if err := fs.index.Delete(fullPath); err != nil {
+		logger.Errorf("Error updating metadata index for %s: %v", fullPath, err)
+	}
+}
+
+// reindexMove переносит записи индекса при переименовании или перемещении.
+func (fs *FileService) reindexMove(oldPath, newPath string) {
+	if fs.index == nil {
+		return
+	}
+	if err := fs.index.Move(oldPath, newPath); err != nil {
+		logger.Errorf("Error updating metadata index for %s: %v", newPath, err)
+	}
+}
+
+// DirectoryMetadata возвращает метаданные файлов папки dirPath по именам.
+// entries - уже прочитанное содержимое папки. Если файл метаданных есть, но
+// не читается, значение равно nil. При подключённом индексе файлы метаданных
+// перечитываются только если изменились после индексации.
+func (fs *FileService) DirectoryMetadata(dirPath string, entries []os.DirEntry) map[string]map[string]string {
+	var records map[string]indexRecord
+	if fs.index != nil {
+		var err error
+		if records, err = fs.index.dir(metadataBucket, dirPath); err != nil {
+			logger.Errorf("Error reading metadata index for %s: %v", dirPath, err)
+		}
+		fs.refreshIndexedEntries(dirPath, entries)
+	}
+
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+
+	result := make(map[string]map[string]string)
+	for _, entry := range entries {
+		name, ok := fs.store.owner(entry)
+		if !ok || !names[name] {
+			// Метаданные без самого файла
+			continue
+		}
+		filePath := filepath.Join(dirPath, name)
+		info, err := fs.store.state(filePath)
+		if err != nil {
+			continue
+		}
+		if rec, ok := records[name]; ok && rec.matches(info) {
+			result[name] = rec.Metadata
+			continue
+		}
+
+		metadata, err := fs.loadMetadata(filePath)
+		if err != nil {
+			result[name] = nil
+			continue
+		}
+		result[name] = metadata
+		if fs.index != nil {
+			if err := fs.index.Put(filePath, metadata, info); err != nil {
+				logger.Errorf("Error updating metadata index for %s: %v", filePath, err)
+			}
+		}
+	}
+	return result
+}
+
+// refreshIndexedEntries обновляет записи индекса для элементов папки,
+// изменённых или удалённых в обход сервиса.
+func (fs *FileService) refreshIndexedEntries(dirPath string, entries []os.DirEntry) {
+	records, err := fs.index.dir(filesBucket, dirPath)
+	if err != nil {
+		logger.Errorf("Error reading metadata index for %s: %v", dirPath, err)
+		return
+	}
+	for _, entry := range entries {
+		if IsMetaFile(entry.Name()) && !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		rec, ok := records[entry.Name()]
+		delete(records, entry.Name())
+		if ok && rec.matches(info) {
+			continue
+		}
+		p := filepath.Join(dirPath, entry.Name())
+		if err := fs.index.PutPath(p); err != nil {
+			logger.Errorf("Error updating metadata index for %s: %v", p, err)
+		}
+	}
+	for name := range records {
+		fs.unindexPath(filepath.Join(dirPath, name))
+	}
+}
+
+// WalkMetadata перебирает метаданные всех файлов: из индекса, если он
+// подключён, иначе читая метаданные по всему дереву.
+func (fs *FileService) WalkMetadata(ctx context.Context, fn func(file IndexedFile) error) error {
+	if fs.index != nil {
+		return fs.index.Walk(func(file IndexedFile) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fn(file)
+		})
+	}
+
+	baseDir := filepath.Clean(fs.baseDir)
+	return filepath.WalkDir(baseDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name, ok := fs.store.owner(d)
+		if !ok || p == baseDir {
+			return nil
+		}
+		filePath := filepath.Join(filepath.Dir(p), name)
+		if _, err := os.Lstat(filePath); err != nil {
+			return nil
+		}
+		metadata, err := fs.loadMetadata(filePath)
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(baseDir, filepath.Dir(p))
+		if err != nil {
+			return nil
+		}
+		return fn(IndexedFile{Path: path.Join("/", filepath.ToSlash(rel), name), Metadata: metadata})
+	})
+}
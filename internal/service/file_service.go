@@ -2,35 +2,51 @@ package service
 
 import (
 	"archive/zip"
-	"bufio"
-	"crypto/sha1"
-	"crypto/sha256"
+	"context"
 	"encoding/json"
 	"fmt"
-	"hash/crc32"
-	"hash/crc64"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/blake2s"
-	"golang.org/x/net/html"
 )
 
 // FileService отвечает за операции с файлами и директориями.
 type FileService struct {
-	baseDir     string
-	authService *AuthService
+	baseDir        string
+	authService    *AuthService
+	extractLimits  ExtractLimits
+	index          *MetadataIndex
+	schema         *MetadataSchema
+	warnDuplicates bool
+	scrubOptions   ScrubOptions
+	hashAlgorithms []string
+	keyring        *SignatureKeyring
+	signer         *FileSigner
+	reportParsers  []*reportParser
+	locks          pathLocks     // Блокировки записи метаданных по путям
+	store          metadataStore // Файлы .<name>.meta или расширенные атрибуты
+
+	contentExtractors []ContentExtractor // Извлекатели метаданных содержимого, nil - все
+	contentDisabled   bool
+	history           *MetadataHistory // Журнал изменений метаданных, nil - не ведётся
+	reconcileOptions  ReconcileOptions // Действия сверки метаданных по умолчанию
 }
 
 // NewFileService создает новый экземпляр FileService.
 func NewFileService(baseDir string, authService *AuthService) *FileService {
-	return &FileService{
+	schema, _ := NewMetadataSchema(nil, nil)
+	fs := &FileService{
 		baseDir:     baseDir,
 		authService: authService,
+		schema:      schema,
+		store:       sidecarStore{},
 	}
+	fs.SetReportParsers(nil, false)
+	return fs
 }
 
 // Добавьте метод для получения `AuthService`, если требуется
@@ -49,10 +65,15 @@ func (fs *FileService) SaveFile(dstPath string, src io.Reader) error {
 	if err != nil {
 		return err
 	}
-	defer dstFile.Close()
-
-	_, err = io.Copy(dstFile, src)
-	return err
+	if _, err = io.Copy(dstFile, src); err != nil {
+		dstFile.Close()
+		return err
+	}
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+	fs.indexPath(dstPath)
+	return nil
 }
 
 // Delete удаляет файл или директорию (рекурсивно).
@@ -62,7 +83,11 @@ func (fs *FileService) Delete(path string) error {
 
 // CreateFolder создает директорию по указанному пути.
 func (fs *FileService) CreateFolder(path string) error {
-	return os.MkdirAll(path, os.ModePerm)
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		return err
+	}
+	fs.indexPath(path)
+	return nil
 }
 
 // Rename переименовывает файл или директорию.
@@ -100,11 +125,8 @@ func (fs *FileService) DeletePath(path string) error {
 	if err != nil {
 		return err
 	}
-	metaFilePath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".meta")
-	if _, err := os.Stat(metaFilePath); err == nil {
-		return os.Remove(metaFilePath)
-	}
-	return nil
+	fs.unindexPath(path)
+	return fs.store.remove(path)
 }
 
 // RenamePath переименовывает файл или директорию.
@@ -113,15 +135,9 @@ func (fs *FileService) RenamePath(oldPath, newPath string) error {
 	if err != nil {
 		return err
 	}
-	oldMetaFilePath := filepath.Join(filepath.Dir(oldPath), "."+filepath.Base(oldPath)+".meta")
-	newMetaFilePath := filepath.Join(filepath.Dir(newPath), "."+filepath.Base(newPath)+".meta")
-	if _, err := os.Stat(oldMetaFilePath); err == nil {
-		err = os.Rename(oldMetaFilePath, newMetaFilePath)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	fs.reindexMove(oldPath, newPath)
+	fs.moveHistory(oldPath, newPath)
+	return fs.store.move(oldPath, newPath)
 }
 
 // MovePath перемещает файл или директорию в новое место.
@@ -134,79 +150,40 @@ func (fs *FileService) MovePath(src, dest string) error {
 	if err != nil {
 		return err
 	}
-	srcMetaFilePath := filepath.Join(filepath.Dir(src), "."+filepath.Base(src)+".meta")
-	destMetaFilePath := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".meta")
-	if _, err := os.Stat(srcMetaFilePath); err == nil {
-		return os.Rename(srcMetaFilePath, destMetaFilePath)
-	}
-	return nil
+	fs.reindexMove(src, dest)
+	fs.moveHistory(src, dest)
+	return fs.store.move(src, dest)
 }
 
 // AddFileToZip добавляет файл в ZIP-архив.
 func (fs *FileService) AddFileToZip(zipWriter *zip.Writer, fullPath, relPath string) error {
-	file, err := os.Open(fullPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	aw := &zipArchiveWriter{zw: zipWriter, method: zip.Deflate}
+	return fs.addToArchive(context.Background(), aw, fullPath, filepath.ToSlash(relPath), ArchiveOptions{Format: ArchiveZip}, nil)
+}
 
-	info, err := file.Stat()
-	if err != nil {
-		return err
-	}
+// CreateZipArchive создает ZIP-архив из списка файлов.
+func (fs *FileService) CreateZipArchive(w io.Writer, files []string) error {
+	return fs.CreateArchive(context.Background(), w, files, ArchiveOptions{Format: ArchiveZip}, nil)
+}
 
-	if info.IsDir() {
-		// Add directory entry to zip
-		_, err := zipWriter.Create(relPath + "/")
+// TreeSize возвращает суммарный размер файла или всех файлов в директории.
+func (fs *FileService) TreeSize(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-
-		// Recursively add directory contents
-		entries, err := os.ReadDir(fullPath)
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
 		if err != nil {
 			return err
 		}
-		for _, entry := range entries {
-			entryFullPath := filepath.Join(fullPath, entry.Name())
-			entryRelPath := filepath.Join(relPath, entry.Name())
-			if err := fs.AddFileToZip(zipWriter, entryFullPath, entryRelPath); err != nil {
-				return err
-			}
-		}
+		total += info.Size()
 		return nil
-	}
-
-	header, err := zip.FileInfoHeader(info)
-	if err != nil {
-		return err
-	}
-	header.Name = relPath
-	header.Method = zip.Deflate
-
-	writer, err := zipWriter.CreateHeader(header)
-	if err != nil {
-		return err
-	}
-
-	_, err = io.Copy(writer, file)
-	return err
-}
-
-// CreateZipArchive создает ZIP-архив из списка файлов.
-func (fs *FileService) CreateZipArchive(w io.Writer, files []string) error {
-	zipWriter := zip.NewWriter(w)
-	defer zipWriter.Close()
-
-	for _, file := range files {
-		fullPath := fs.GetFullPath(file)
-		relPath := strings.TrimPrefix(file, "/")
-		if err := fs.AddFileToZip(zipWriter, fullPath, relPath); err != nil {
-			return fmt.Errorf("error adding file to ZIP: %w", err)
-		}
-	}
-
-	return nil
+	})
+	return total, err
 }
 
 // FormatReadableSize возвращает читаемый размер файла.
@@ -242,316 +219,236 @@ func (fs *FileService) GetModificationTimes(path string) (map[string]time.Time,
 	return modTimes, nil
 }
 
-func (fs *FileService) ExtractMetadataFromReadme(dirPath string) (map[string]string, error) {
-	readmePath := filepath.Join(dirPath, "README.md")
-	file, err := os.Open(readmePath)
-	if (err != nil) {
-		if os.IsNotExist(err) {
-			return nil, nil // README.md не существует
-		}
-		return nil, fmt.Errorf("ошибка при открытии README.md: %w", err)
-	}
-	defer file.Close()
+// AddMetadata добавляет поля к метаданным файла, проверяя их по схеме;
+// изменение записывается в историю от имени actor.
+func (fs *FileService) AddMetadata(filePath string, newMetadata map[string]string, actor MetadataActor) error {
+	_, err := fs.updateMetadata(filePath, metadataUpdate{set: newMetadata, actor: actor})
+	return err
+}
 
-	metadata := make(map[string]string)
-	scanner := bufio.NewScanner(file)
-	var inRDSSection bool
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "## RDS" {
-			inRDSSection = true
-			continue
-		}
-		if inRDSSection {
-			if strings.HasPrefix(line, "## ") && line != "## RDS" {
-				// Начался новый раздел, выходим из RDS секции
-				break
-			}
-			if strings.HasPrefix(line, "- **") && strings.Contains(line, "**: `") && strings.HasSuffix(line, "`") {
-				lineContent := strings.TrimPrefix(line, "- **")
-				parts := strings.SplitN(lineContent, "**: `", 2)
-				if len(parts) == 2 {
-					key := strings.TrimSpace(parts[0])
-					value := strings.TrimSuffix(parts[1], "`")
-					// Извлекаем 'Filename' и другие метаданные без префиксов
-					metadata[key] = strings.TrimSpace(value)
-				}
-			}
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("ошибка при чтении README.md: %w", err)
-	}
-	return metadata, nil
+// StoreHashes записывает метаданные с только что рассчитанными хеш-суммами
+// файла. Суммы алгоритмов, которых нет в metadata (например, исключённых из
+// hashes.algorithms), удаляются, чтобы рядом с новыми не оставались
+// устаревшие.
+func (fs *FileService) StoreHashes(filePath string, metadata map[string]string, actor MetadataActor) error {
+	_, err := fs.updateMetadata(filePath, metadataUpdate{set: metadata, replaceHashes: true, actor: actor})
+	return err
 }
 
-func (fs *FileService) AddMetadata(filePath string, newMetadata map[string]string) error {
-	metaFilePath := filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+".meta")
+// metadataUpdate - изменение метаданных файла.
+type metadataUpdate struct {
+	set           map[string]string // Поля, которые задаются
+	unset         []string          // Поля, которые удаляются
+	replace       []string          // Префиксы полей, которые заменяются значениями из set
+	checkRequired bool              // Проверить заполнение обязательных полей
+	dryRun        bool              // Только вычислить результат
+	revision      string            // Ожидаемая ревизия (If-Match), пустая - без проверки
+	actor         MetadataActor     // Кто изменяет метаданные (для истории)
+	revertTo      uint64            // Запись истории, к которой возвращаются поля
+	relabel       bool              // Хеш-суммы того же содержимого, например замена устаревшего BLAKE2sp
+	locked        bool              // Блокировку пути уже держит вызывающий
+	replaceHashes bool              // Хеш-суммы из set заменяют все сохранённые
+}
 
-	// Чтение существующих метаданных, если файл существует
-	existingMetadata := make(map[string]string)
-	if _, err := os.Stat(metaFilePath); err == nil {
-		file, err := os.Open(metaFilePath)
-		if err != nil {
-			return fmt.Errorf("ошибка при открытии файла метаданных: %w", err)
-		}
-		defer file.Close()
+// metadataResult - метаданные до и после изменения и новая ревизия. readme -
+// метаданные после переноса полей RDS из README.md, до самого изменения.
+type metadataResult struct {
+	before, after map[string]string
+	readme        map[string]string
+	revision      string
+}
 
-		decoder := json.NewDecoder(file)
-		if err := decoder.Decode(&existingMetadata); err != nil {
-			return fmt.Errorf("ошибка при декодировании файла метаданных: %w", err)
-		}
+// updateMetadata объединяет поля с метаданными файла и записывает результат
+// в хранилище метаданных. Чтение и запись метаданных одного файла выполняются
+// под блокировкой пути, поэтому одновременные изменения не теряются.
+func (fs *FileService) updateMetadata(filePath string, update metadataUpdate) (*metadataResult, error) {
+	newMetadata := update.set
+	if errs := fs.schema.Validate(newMetadata); len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
 	}
 
-	// Извлечение метаданных из README.md
-	readmeMetadata, err := fs.ExtractMetadataFromReadme(filepath.Dir(filePath))
-	if err != nil {
-		return fmt.Errorf("ошибка при извлечении метаданных из README.md: %w", err)
+	if !update.locked {
+		unlock := fs.locks.lock(filePath)
+		defer unlock()
 	}
 
-	// Получение фактического имени файла
-	actualFilename := filepath.Base(filePath)
-
-	// Проверка, совпадает ли имя файла в README с фактическим именем файла
-	if readmeFilename, ok := readmeMetadata["Filename"]; ok && readmeFilename == actualFilename {
-		// Объединение метаданных из README.md с префиксом "RDS "
-		for key, value := range readmeMetadata {
-			if key != "Filename" {
-				existingMetadata["RDS "+key] = value
-			} else {
-				existingMetadata["RDS Filename"] = value
-			}
+	// Чтение существующих метаданных, если они есть
+	existingMetadata := make(map[string]string)
+	revision := noMetadataRevision
+	data, err := fs.store.read(filePath)
+	if err == nil {
+		revision = metadataRevision(data)
+		if err := json.Unmarshal(data, &existingMetadata); err != nil {
+			return nil, fmt.Errorf("ошибка при декодировании файла метаданных: %w", err)
+		}
+		if existingMetadata == nil {
+			existingMetadata = make(map[string]string)
 		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("ошибка при открытии файла метаданных: %w", err)
 	}
-
-	// Объединение новых метаданных с существующими
-	for key, value := range newMetadata {
-		existingMetadata[key] = value
+	if err := checkRevision(revision, update.revision); err != nil {
+		return nil, err
 	}
 
-	// Удаление ключа "Filename" из метаданных
-	delete(existingMetadata, "Filename")
+	previous := make(map[string]string, len(existingMetadata))
+	for key, value := range existingMetadata {
+		previous[key] = value
+	}
 
-	// Запись обновленных метаданных обратно в файл
-	file, err := os.Create(metaFilePath)
+	// Запись RDS этого файла из README.md с префиксом "RDS "
+	records, err := fs.ExtractRDSRecords(filepath.Dir(filePath))
 	if err != nil {
-		return fmt.Errorf("ошибка при создании файла метаданных: %w", err)
+		return nil, fmt.Errorf("ошибка при извлечении метаданных из README.md: %w", err)
 	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", " ") // Для удобства чтения
-	if err := encoder.Encode(existingMetadata); err != nil {
-		return fmt.Errorf("ошибка при кодировании метаданных: %w", err)
+	if record, ok := records[filepath.Base(filePath)]; ok {
+		for key := range existingMetadata {
+			if strings.HasPrefix(key, "RDS ") {
+				delete(existingMetadata, key)
+			}
+		}
+		for key, value := range record {
+			existingMetadata["RDS "+key] = value
+		}
 	}
-
-	return nil
-}
-
-// RecalculateHashes пересчитывает хеш-суммы для файла.
-func (fs *FileService) RecalculateHashes(filePath string) (map[string]string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("error opening file: %w", err)
+	readme := make(map[string]string, len(existingMetadata))
+	for key, value := range existingMetadata {
+		readme[key] = value
 	}
-	defer file.Close()
 
-	crc32Hash := crc32.NewIEEE()
-	crc64Hash := crc64.New(crc64.MakeTable(crc64.ECMA))
-	sha1Hash := sha1.New()
-	sha256Hash := sha256.New()
-	blake2spHash, err := blake2s.New256(nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating BLAKE2sp hash: %w", err)
+	// Подпись относилась к прежнему содержимому файла; новое значение
+	// суммы того же содержимого подпись не отменяет
+	if !update.relabel && hashesChanged(existingMetadata, newMetadata) {
+		delete(existingMetadata, MetadataSignatureStatus)
+		delete(existingMetadata, MetadataSignatureSigner)
+		delete(existingMetadata, MetadataSignatureFile)
 	}
 
-	writer := io.MultiWriter(crc32Hash, crc64Hash, sha1Hash, sha256Hash, blake2spHash)
-
-	if _, err := io.Copy(writer, file); err != nil {
-		return nil, fmt.Errorf("error calculating hashes: %w", err)
+	// Поля с префиксами replace задаются заново
+	for key := range existingMetadata {
+		for _, prefix := range update.replace {
+			if strings.HasPrefix(key, prefix) {
+				delete(existingMetadata, key)
+				break
+			}
+		}
 	}
 
-	hashes := map[string]string{
-		"CRC32":    strings.ToUpper(fmt.Sprintf("%x", crc32Hash.Sum32())),
-		"CRC64":    strings.ToUpper(fmt.Sprintf("%x", crc64Hash.Sum(nil))),
-		"SHA1":     fmt.Sprintf("%x", sha1Hash.Sum(nil)),
-		"SHA256":   fmt.Sprintf("%x", sha256Hash.Sum(nil)),
-		"BLAKE2sp": fmt.Sprintf("%x", blake2spHash.Sum(nil)),
+	// Объединение новых метаданных с существующими
+	for key, value := range newMetadata {
+		existingMetadata[key] = value
 	}
 
-	return hashes, nil
-}
-
-func (fs *FileService) ExtractMetadataFromHTML(htmlFilePath string) error {
-	file, err := os.Open(htmlFilePath)
-	if err != nil {
-		return fmt.Errorf("error opening HTML file: %w", err)
+	for _, key := range update.unset {
+		delete(existingMetadata, key)
 	}
-	defer file.Close()
-
-	doc, err := html.Parse(file)
-	if err != nil {
-		return fmt.Errorf("error parsing HTML file: %w", err)
-	}
-
-	metadata := make(map[string]string)
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.ElementNode && n.Data == "p" {
-			for _, a := range n.Attr {
-				if a.Key == "class" {
-					switch a.Val {
-					case "report-date":
-						metadata["Дата проверки"] = extractText(n, "Дата проверки:")
-					case "report-rds_number":
-						metadata["RDS"] = extractText(n, "Основание:")
-					case "report-rds_link":
-						metadata["Ссылка на RDS"] = extractText(n, "Ссылка на RDS:")
-					}
-				}
-			}
-		}
-		if n.Type == html.ElementNode && n.Data == "div" {
-			for _, a := range n.Attr {
-				if a.Key == "id" && a.Val == "artifacts" {
-					content := extractText(n, "")
-					metadata["Filename"] = extractField(content, "Имя:")
-					metadata["CRC32"] = extractField(content, "CRC32:")
-					metadata["CRC64"] = extractField(content, "CRC64:")
-					metadata["SHA256"] = extractField(content, "SHA256:")
-					metadata["SHA1"] = extractField(content, "SHA1:")
-					metadata["BLAKE2sp"] = extractField(content, "BLAKE2sp:")
-				}
+	if update.replaceHashes {
+		for _, key := range HashFields {
+			if _, ok := newMetadata[key]; !ok {
+				delete(existingMetadata, key)
 			}
 		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
+	}
+
+	// Новые хеш-суммы отменяют результат прошлой проверки целостности
+	if _, verified := newMetadata[MetadataVerifyResult]; !verified && !update.relabel && hasStoredHashes(newMetadata) {
+		delete(existingMetadata, MetadataVerifiedAt)
+		delete(existingMetadata, MetadataVerifyResult)
+	}
+	// Размер и время изменения файла при расчёте хеш-сумм позволяют
+	// заметить его изменение независимо от последующих записей метаданных
+	if hasStoredHashes(newMetadata) {
+		if info, err := os.Stat(filePath); err == nil && info.Mode().IsRegular() {
+			existingMetadata[MetadataHashedSize] = strconv.FormatInt(info.Size(), 10)
+			existingMetadata[MetadataHashedModTime] = info.ModTime().UTC().Format(time.RFC3339Nano)
 		}
 	}
 
-	f(doc)
+	// Удаление ключа "Filename" из метаданных
+	delete(existingMetadata, "Filename")
+	// Путь к файлу определяется расположением метаданных и не хранится
+	delete(existingMetadata, MetadataPathKey)
 
-	// Check if all required fields are present
-	requiredFields := []string{"Дата проверки", "RDS", "Ссылка на RDS", "Filename", "CRC32", "CRC64", "SHA256", "SHA1", "BLAKE2sp"}
-	for _, field := range requiredFields {
-		if _, ok := metadata[field]; !ok {
-			return nil // Return nil if any required field is missing
+	if update.checkRequired {
+		// Обязательное поле может быть задано значением по умолчанию папки
+		effective, _, err := fs.InheritedMetadata(filepath.Dir(filePath))
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range existingMetadata {
+			effective[key] = value
+		}
+		if errs := fs.schema.CheckRequired(effective); len(errs) > 0 {
+			return nil, &ValidationError{Errors: errs}
 		}
 	}
 
-	readmePath := filepath.Join(filepath.Dir(htmlFilePath), "README.md")
-	if _, err := os.Stat(readmePath); os.IsNotExist(err) {
-		return createReadme(readmePath, metadata)
-	} else {
-		return updateReadme(readmePath, metadata)
+	result := &metadataResult{before: previous, after: existingMetadata, readme: readme, revision: revision}
+	if update.dryRun {
+		return result, nil
 	}
-}
 
-func createReadme(readmePath string, metadata map[string]string) error {
-	file, err := os.Create(readmePath)
+	data, err = fs.store.write(filePath, existingMetadata, time.Time{})
 	if err != nil {
-		return fmt.Errorf("error creating README.md: %w", err)
+		return nil, fmt.Errorf("ошибка при записи метаданных: %w", err)
 	}
-	defer file.Close()
-
-	// Удаляем ключ "File name" перед записью в README.md
-	delete(metadata, "File name")
+	result.revision = metadataRevision(data)
 
-	_, err = file.WriteString("## RDS\n")
-	if err != nil {
-		return fmt.Errorf("error writing to README.md: %w", err)
-	}
+	fs.indexMetadata(filePath, existingMetadata)
+	fs.recordHistory(filePath, update, result)
+	return result, nil
+}
 
-	for key, value := range metadata {
-		_, err := file.WriteString(fmt.Sprintf("- **%s**: `%s`\n", key, strings.TrimSpace(value)))
-		if err != nil {
-			return fmt.Errorf("error writing to README.md: %w", err)
-		}
-	}
-	return nil
+// RecalculateHashes пересчитывает хеш-суммы для файла.
+func (fs *FileService) RecalculateHashes(filePath string) (map[string]string, error) {
+	return fs.RecalculateHashesContext(context.Background(), filePath, nil)
 }
 
-func updateReadme(readmePath string, metadata map[string]string) error {
-	file, err := os.OpenFile(readmePath, os.O_RDWR, 0644)
+// RecalculateHashesContext пересчитывает хеш-суммы для файла с поддержкой
+// отмены через ctx и отчётом о прогрессе в байтах.
+func (fs *FileService) RecalculateHashesContext(ctx context.Context, filePath string, progress ProgressFunc) (map[string]string, error) {
+	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("error opening README.md: %w", err)
+		return nil, fmt.Errorf("error opening file: %w", err)
 	}
 	defer file.Close()
 
-	existingContent, err := io.ReadAll(file)
-	if err != nil {
-		return fmt.Errorf("error reading README.md: %w", err)
-	}
-
-	existingLines := strings.Split(string(existingContent), "\n")
-	updatedLines := make([]string, 0, len(existingLines))
-
-	// Remove existing metadata lines and "## RDS" header
-	for _, line := range existingLines {
-		updated := false
-		for key := range metadata {
-			if strings.HasPrefix(line, "- **"+key+"**:") {
-				updated = true
-				break
-			}
-		}
-		if line == "## RDS" {
-			updated = true
-		}
-		if !updated {
-			updatedLines = append(updatedLines, line)
+	var counter *progressCounter
+	if progress != nil {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("error reading file info: %w", err)
 		}
+		counter = &progressCounter{total: info.Size(), report: progress}
 	}
 
-	// Удаляем ключ "File name" перед обновлением README.md
-	delete(metadata, "File name")
-
-	// Add a section header for RDS
-	updatedLines = append(updatedLines, "## RDS")
-
-	// Append new metadata lines
-	for key, value := range metadata {
-		updatedLines = append(updatedLines, fmt.Sprintf("- **%s**: `%s`", key, strings.TrimSpace(value)))
+	hasher, err := fs.NewHasher()
+	if err != nil {
+		return nil, err
 	}
 
-	file.Truncate(0)
-	file.Seek(0, 0)
-	for _, line := range updatedLines {
-		_, err := file.WriteString(line + "\n")
-		if err != nil {
-			return fmt.Errorf("error writing to README.md: %w", err)
-		}
+	if _, err := io.Copy(hasher, newProgressReader(ctx, file, counter)); err != nil {
+		return nil, fmt.Errorf("error calculating hashes: %w", err)
 	}
-	return nil
-}
 
-func extractText(n *html.Node, label string) string {
-	if n.Type == html.TextNode {
-		return n.Data
-	}
-	var result string
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		if c.Type == html.ElementNode && c.Data == "br" {
-			result += "\n"
-		} else {
-			result += extractText(c, label)
-		}
-	}
-	result = strings.TrimSpace(result)
-	if label != "" {
-		result = strings.TrimPrefix(result, label)
-	}
-	return result
+	return hasher.Sums(), nil
 }
 
-func extractField(content, fieldName string) string {
-	lines := strings.Split(content, "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, fieldName) {
-			return strings.TrimSpace(strings.TrimPrefix(line, fieldName))
+// extractHashFields находит в тексте отчёта строки "<алгоритм>: <сумма>" для
+// всех поддерживаемых алгоритмов, в том числе в других написаниях (SHA-256).
+func extractHashFields(content string) map[string]string {
+	hashes := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		label, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		algorithm, ok := LookupHashAlgorithm(strings.TrimSpace(label))
+		if value = strings.TrimSpace(value); ok && value != "" {
+			hashes[algorithm.Name] = value
 		}
 	}
-	return ""
+	return hashes
 }
 
 func (fs *FileService) ReadMetadata(metaFilePath string) (map[string]string, error) {
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Значения по умолчанию папки хранятся в её собственных метаданных (файле
+// .<папка>.meta рядом с ней или атрибутах папки) и наследуются всеми файлами
+// папки и вложенных папок. Ближайшая папка переопределяет значения
+// родительских, а метаданные самого файла - значения папок. Унаследованные
+// значения не копируются в метаданные файлов.
+
+// MetadataSourceFile - источник значения из метаданных самого файла. Для
+// унаследованных значений источник - путь папки, например "/releases/1.0".
+const MetadataSourceFile = "file"
+
+// ErrRootFolderDefaults - у корневой папки нет файла метаданных.
+var ErrRootFolderDefaults = errors.New("root folder has no metadata defaults")
+
+// EffectiveMetadata - метаданные файла с учётом значений папок.
+type EffectiveMetadata struct {
+	Metadata map[string]string `json:"metadata"`
+	Sources  map[string]string `json:"sources"` // Ключ -> источник значения
+}
+
+// FolderDefaults возвращает значения по умолчанию папки dirPath без
+// унаследованных от родительских папок.
+func (fs *FileService) FolderDefaults(dirPath string) (map[string]string, error) {
+	if fs.isBaseDir(dirPath) {
+		return map[string]string{}, nil
+	}
+	defaults, err := fs.loadMetadata(dirPath)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading folder metadata: %w", err)
+	}
+	if defaults == nil {
+		defaults = map[string]string{}
+	}
+	return defaults, nil
+}
+
+// InheritedMetadata возвращает значения, которые наследуют файлы папки
+// dirPath, и их источники: значения самой папки и всех родительских.
+func (fs *FileService) InheritedMetadata(dirPath string) (map[string]string, map[string]string, error) {
+	metadata := make(map[string]string)
+	sources := make(map[string]string)
+	for _, dir := range fs.folderChain(dirPath) {
+		defaults, err := fs.FolderDefaults(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		source := fs.relativePath(dir)
+		for key, value := range defaults {
+			metadata[key] = value
+			sources[key] = source
+		}
+	}
+	return metadata, sources, nil
+}
+
+// EffectiveMetadata возвращает метаданные файла вместе с унаследованными
+// значениями папок и источник каждого значения.
+func (fs *FileService) EffectiveMetadata(filePath string) (*EffectiveMetadata, error) {
+	metadata, sources, err := fs.InheritedMetadata(filepath.Dir(filePath))
+	if err != nil {
+		return nil, err
+	}
+	own, err := fs.loadMetadata(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading metadata: %w", err)
+	}
+	for key, value := range own {
+		metadata[key] = value
+		sources[key] = MetadataSourceFile
+	}
+	return &EffectiveMetadata{Metadata: metadata, Sources: sources}, nil
+}
+
+// EditFolderDefaults изменяет значения по умолчанию папки dirPath: пустое
+// значение удаляет ключ. Системные поля и поля RDS задавать нельзя, значения
+// проверяются по схеме. Ревизия revision проверяется как в EditMetadata;
+// возвращается новая ревизия. Изменение записывается в историю папки.
+func (fs *FileService) EditFolderDefaults(dirPath string, changes map[string]string, user, revision string) (string, error) {
+	if fs.isBaseDir(dirPath) {
+		return "", ErrRootFolderDefaults
+	}
+	var errs []FieldError
+	values := make(map[string]string)
+	for key, value := range changes {
+		switch {
+		case strings.TrimSpace(key) == "" || key == MetadataPathKey || key == "Filename":
+			errs = append(errs, FieldError{Field: key, Message: "invalid field name"})
+		case fs.schema.IsReserved(key):
+			errs = append(errs, FieldError{Field: key, Message: "reserved field cannot be inherited"})
+		case strings.HasPrefix(key, "RDS "):
+			errs = append(errs, FieldError{Field: key, Message: "RDS fields are taken from README.md"})
+		case value != "":
+			values[key] = value
+		}
+	}
+	errs = append(errs, fs.schema.Validate(values)...)
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return "", &ValidationError{Errors: errs}
+	}
+
+	unlock := fs.locks.lock(dirPath)
+	defer unlock()
+
+	current, err := fs.MetadataRevision(dirPath)
+	if err != nil {
+		return "", err
+	}
+	if err := checkRevision(current, revision); err != nil {
+		return "", err
+	}
+	defaults, err := fs.FolderDefaults(dirPath)
+	if err != nil {
+		return "", err
+	}
+	result := &metadataResult{before: make(map[string]string, len(defaults)), after: defaults}
+	for key, value := range defaults {
+		result.before[key] = value
+	}
+	update := metadataUpdate{actor: MetadataActor{User: user, Source: MetadataSourceDefaults}}
+	for key, value := range changes {
+		if value == "" {
+			delete(defaults, key)
+		} else {
+			defaults[key] = value
+		}
+	}
+
+	if len(defaults) == 0 {
+		if err := fs.store.remove(dirPath); err != nil {
+			return "", fmt.Errorf("error removing folder metadata: %w", err)
+		}
+		fs.unindexMetadata(dirPath)
+		result.revision = noMetadataRevision
+		fs.recordHistory(dirPath, update, result)
+		return noMetadataRevision, nil
+	}
+	data, err := fs.store.write(dirPath, defaults, time.Time{})
+	if err != nil {
+		return "", fmt.Errorf("error writing folder metadata: %w", err)
+	}
+	fs.indexMetadata(dirPath, defaults)
+	result.revision = metadataRevision(data)
+	fs.recordHistory(dirPath, update, result)
+	return result.revision, nil
+}
+
+// folderChain возвращает папки от верхней под базовой до dirPath.
+func (fs *FileService) folderChain(dirPath string) []string {
+	var chain []string
+	for dir := filepath.Clean(dirPath); !fs.isBaseDir(dir); dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(filepath.Clean(fs.baseDir), dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			break
+		}
+		chain = append([]string{dir}, chain...)
+	}
+	return chain
+}
+
+func (fs *FileService) isBaseDir(dirPath string) bool {
+	return filepath.Clean(dirPath) == filepath.Clean(fs.baseDir)
+}
+
+// relativePath возвращает путь относительно базовой папки вида "/a/b".
+func (fs *FileService) relativePath(fullPath string) string {
+	rel, err := filepath.Rel(filepath.Clean(fs.baseDir), fullPath)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"strings"
+
+	"fileStation/pkg/blake2sp"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/sha3"
+	"lukechampine.com/blake3"
+)
+
+// HashAlgorithm описывает алгоритм хеширования, сумма которого хранится в
+// метаданных под именем Name.
+type HashAlgorithm struct {
+	Name    string
+	Aliases []string // Другие написания в отчётах производителей
+	New     func() hash.Hash
+	Format  func(sum []byte) string
+}
+
+// hashRegistry - поддерживаемые алгоритмы в порядке отображения.
+var hashRegistry = []HashAlgorithm{
+	{Name: "CRC32", New: func() hash.Hash { return crc32.NewIEEE() }, Format: formatCRC32},
+	{Name: "CRC64", New: func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ECMA)) }, Format: formatUpperHex},
+	{Name: "MD5", New: md5.New, Format: formatHex},
+	{Name: "SHA1", Aliases: []string{"SHA-1"}, New: sha1.New, Format: formatHex},
+	{Name: "SHA256", Aliases: []string{"SHA-256"}, New: sha256.New, Format: formatHex},
+	{Name: "SHA512", Aliases: []string{"SHA-512"}, New: sha512.New, Format: formatHex},
+	{Name: "SHA3-256", Aliases: []string{"SHA3_256"}, New: sha3.New256, Format: formatHex},
+	{Name: "BLAKE2sp", New: blake2sp.New, Format: formatHex},
+	{Name: "BLAKE3", New: func() hash.Hash { return blake3.New(32, nil) }, Format: formatHex},
+}
+
+// legacyBLAKE2sp - значение, которое раньше сохранялось под именем BLAKE2sp
+// (на самом деле BLAKE2s-256). Используется только при проверке целостности.
+var legacyBLAKE2sp = HashAlgorithm{
+	Name: "BLAKE2s",
+	New: func() hash.Hash {
+		h, _ := blake2s.New256(nil)
+		return h
+	},
+	Format: formatHex,
+}
+
+// DefaultHashAlgorithms - алгоритмы, вычисляемые по умолчанию.
+var DefaultHashAlgorithms = []string{"CRC32", "CRC64", "SHA1", "SHA256", "BLAKE2sp"}
+
+// HashFields - поля метаданных со всеми поддерживаемыми хеш-суммами.
+var HashFields = hashNames()
+
+func hashNames() []string {
+	names := make([]string, len(hashRegistry))
+	for i, algorithm := range hashRegistry {
+		names[i] = algorithm.Name
+	}
+	return names
+}
+
+// LookupHashAlgorithm ищет алгоритм по имени или его другому написанию без
+// учёта регистра.
+func LookupHashAlgorithm(name string) (HashAlgorithm, bool) {
+	for _, algorithm := range hashRegistry {
+		if strings.EqualFold(algorithm.Name, name) {
+			return algorithm, true
+		}
+		for _, alias := range algorithm.Aliases {
+			if strings.EqualFold(alias, name) {
+				return algorithm, true
+			}
+		}
+	}
+	return HashAlgorithm{}, false
+}
+
+// SameHash сравнивает две хеш-суммы алгоритма algorithm без учёта регистра.
+// CRC32 хранится без ведущих нулей, поэтому его значения перед сравнением
+// дополняются нулями до 8 знаков; остальные сравниваются как есть.
+func SameHash(algorithm, a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if a == "" || b == "" {
+		return false
+	}
+	if strings.EqualFold(algorithm, "CRC32") {
+		a, b = padHash(a, 8), padHash(b, 8)
+	}
+	return strings.EqualFold(a, b)
+}
+
+// padHash дополняет значение ведущими нулями до size знаков.
+func padHash(s string, size int) string {
+	if len(s) < size {
+		return strings.Repeat("0", size-len(s)) + s
+	}
+	return s
+}
+
+// SetHashAlgorithms задаёт алгоритмы, вычисляемые при загрузке, распаковке и
+// пересчёте хеш-сумм. Пустой список означает DefaultHashAlgorithms.
+func (fs *FileService) SetHashAlgorithms(names []string) error {
+	if len(names) == 0 {
+		names = DefaultHashAlgorithms
+	}
+	var algorithms []string
+	for _, name := range names {
+		algorithm, ok := LookupHashAlgorithm(name)
+		if !ok {
+			return fmt.Errorf("unknown hash algorithm: %s", name)
+		}
+		if !containsString(algorithms, algorithm.Name) {
+			algorithms = append(algorithms, algorithm.Name)
+		}
+	}
+	fs.hashAlgorithms = algorithms
+	return nil
+}
+
+// HashAlgorithms возвращает вычисляемые алгоритмы.
+func (fs *FileService) HashAlgorithms() []string {
+	if len(fs.hashAlgorithms) == 0 {
+		return DefaultHashAlgorithms
+	}
+	return fs.hashAlgorithms
+}
+
+// NewHasher создает FileHasher для настроенных алгоритмов.
+func (fs *FileService) NewHasher() (*FileHasher, error) {
+	return NewFileHasher(fs.HashAlgorithms()...)
+}
+
+// FileHasher одновременно вычисляет несколько хеш-сумм файла.
+type FileHasher struct {
+	io.Writer
+	algorithms []HashAlgorithm
+	hashes     []hash.Hash
+}
+
+// NewFileHasher создает новый экземпляр FileHasher для указанных алгоритмов
+// (по умолчанию DefaultHashAlgorithms).
+func NewFileHasher(names ...string) (*FileHasher, error) {
+	if len(names) == 0 {
+		names = DefaultHashAlgorithms
+	}
+	h := &FileHasher{}
+	for _, name := range names {
+		algorithm, ok := LookupHashAlgorithm(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown hash algorithm: %s", name)
+		}
+		h.add(algorithm)
+	}
+	return h, nil
+}
+
+func (h *FileHasher) add(algorithm HashAlgorithm) {
+	h.algorithms = append(h.algorithms, algorithm)
+	h.hashes = append(h.hashes, algorithm.New())
+	writers := make([]io.Writer, len(h.hashes))
+	for i, hh := range h.hashes {
+		writers[i] = hh
+	}
+	h.Writer = io.MultiWriter(writers...)
+}
+
+// Sums возвращает хеш-суммы в формате, в котором они хранятся в метаданных.
+func (h *FileHasher) Sums() map[string]string {
+	sums := make(map[string]string, len(h.hashes))
+	for i, algorithm := range h.algorithms {
+		sums[algorithm.Name] = algorithm.Format(h.hashes[i].Sum(nil))
+	}
+	return sums
+}
+
+func formatHex(sum []byte) string {
+	return fmt.Sprintf("%x", sum)
+}
+
+func formatUpperHex(sum []byte) string {
+	return fmt.Sprintf("%X", sum)
+}
+
+// formatCRC32 - CRC32 в верхнем регистре без ведущих нулей, как раньше
+// сохранялся в метаданных.
+func formatCRC32(sum []byte) string {
+	return fmt.Sprintf("%X", binary.BigEndian.Uint32(sum))
+}
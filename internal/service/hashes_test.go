@@ -0,0 +1,24 @@
+package service
+
+import "testing"
+
+func TestSameHash(t *testing.T) {
+	for _, tc := range []struct {
+		algorithm, a, b string
+		want            bool
+	}{
+		{"CRC32", "ABC", "00000abc", true},
+		{"crc32", " 0ABC ", "abc", true},
+		{"CRC32", "0", "00000000", true},
+		{"CRC32", "ABC", "ABD", false},
+		{"SHA256", "ABCDEF", "abcdef", true},
+		{"SHA256", "0abcdef", "abcdef", false},
+		{"CRC64", "00A1", "A1", false},
+		{"SHA1", "", "", false},
+		{"SHA1", "", "0", false},
+	} {
+		if got := SameHash(tc.algorithm, tc.a, tc.b); got != tc.want {
+			t.Errorf("SameHash(%s, %q, %q) = %v, want %v", tc.algorithm, tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"fileStation/pkg/logger"
+)
+
+// JobStatus описывает состояние фоновой задачи.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrJobKindUnknown = errors.New("unknown job kind")
+	ErrJobFinished    = errors.New("job already finished")
+	ErrJobNoResult    = errors.New("job has no result")
+)
+
+// Job представляет фоновую задачу и её текущее состояние.
+type Job struct {
+	ID         string              `json:"id"`
+	Kind       string              `json:"kind"`
+	Params     map[string][]string `json:"params,omitempty"`
+	User       string              `json:"user,omitempty"`
+	Status     JobStatus           `json:"status"`
+	Done       int64               `json:"done"`
+	Total      int64               `json:"total"`
+	Error      string              `json:"error,omitempty"`
+	Result     json.RawMessage     `json:"result,omitempty"`
+	ResultFile string              `json:"resultFile,omitempty"`
+	CreatedAt  time.Time           `json:"createdAt"`
+	StartedAt  *time.Time          `json:"startedAt,omitempty"`
+	FinishedAt *time.Time          `json:"finishedAt,omitempty"`
+}
+
+// Finished сообщает, завершена ли задача (успешно или нет).
+func (j *Job) Finished() bool {
+	return j.Status == JobCompleted || j.Status == JobFailed || j.Status == JobCanceled
+}
+
+// JobFunc выполняет задачу определённого типа.
+type JobFunc func(ctx context.Context, jc *JobControl) error
+
+// JobControl даёт исполнителю задачи доступ к параметрам, прогрессу и результату.
+type JobControl struct {
+	service *JobService
+	id      string
+	user    string
+	params  map[string][]string
+}
+
+// User возвращает имя пользователя, поставившего задачу.
+func (jc *JobControl) User() string {
+	return jc.user
+}
+
+// Param возвращает первое значение параметра задачи.
+func (jc *JobControl) Param(key string) string {
+	if values := jc.params[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Params возвращает все значения параметра задачи.
+func (jc *JobControl) Params(key string) []string {
+	return jc.params[key]
+}
+
+// Progress обновляет прогресс выполнения задачи.
+func (jc *JobControl) Progress(done, total int64) {
+	jc.service.update(jc.id, false, func(j *Job) {
+		j.Done = done
+		j.Total = total
+	})
+}
+
+// ResultFile возвращает путь, по которому задача должна записать файл-результат.
+func (jc *JobControl) ResultFile(ext string) string {
+	name := jc.id + ext
+	jc.service.update(jc.id, true, func(j *Job) {
+		j.ResultFile = name
+	})
+	return jc.service.resultPath(name)
+}
+
+// SetResult сохраняет структурированный результат задачи.
+func (jc *JobControl) SetResult(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding job result: %w", err)
+	}
+	jc.service.update(jc.id, true, func(j *Job) {
+		j.Result = data
+	})
+	return nil
+}
+
+// JobService управляет очередью фоновых задач с ограниченным числом исполнителей.
+// Состояние задач сохраняется в stateDir, поэтому незавершённые задачи
+// переживают перезапуск сервера.
+type JobService struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	jobs      map[string]*Job
+	cancels   map[string]context.CancelFunc
+	kinds     map[string]JobFunc
+	queue     []string
+	stateDir  string
+	workers   int
+	resultTTL time.Duration
+}
+
+// NewJobService создает новый экземпляр JobService.
+func NewJobService(stateDir string, workers int, resultTTL time.Duration) (*JobService, error) {
+	if err := os.MkdirAll(filepath.Join(stateDir, "results"), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating job state directory: %w", err)
+	}
+	s := &JobService{
+		jobs:      make(map[string]*Job),
+		cancels:   make(map[string]context.CancelFunc),
+		kinds:     make(map[string]JobFunc),
+		stateDir:  stateDir,
+		workers:   workers,
+		resultTTL: resultTTL,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// Register регистрирует исполнителя для задач указанного типа.
+func (s *JobService) Register(kind string, fn JobFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kinds[kind] = fn
+}
+
+// Start загружает сохранённые задачи, возвращает незавершённые в очередь
+// и запускает исполнителей.
+func (s *JobService) Start() error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	for i := 0; i < s.workers; i++ {
+		go s.worker()
+	}
+	go s.cleanupLoop()
+	return nil
+}
+
+// Submit ставит новую задачу в очередь.
+func (s *JobService) Submit(kind, user string, params map[string][]string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.kinds[kind]; !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrJobKindUnknown, kind)
+	}
+
+	job := &Job{
+		ID:        newJobID(),
+		Kind:      kind,
+		Params:    params,
+		User:      user,
+		Status:    JobQueued,
+		CreatedAt: time.Now(),
+	}
+	s.jobs[job.ID] = job
+	s.persist(job)
+	s.queue = append(s.queue, job.ID)
+	s.cond.Signal()
+
+	logger.Infof("Job %s (%s) queued by %q", job.ID, kind, user)
+	return *job, nil
+}
+
+// Get возвращает копию задачи по идентификатору.
+func (s *JobService) Get(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+// List возвращает все известные задачи, новые первыми.
+func (s *JobService) List() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// Cancel отменяет задачу, ожидающую в очереди или выполняющуюся.
+func (s *JobService) Cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if job.Finished() {
+		return ErrJobFinished
+	}
+	if cancel, ok := s.cancels[id]; ok {
+		// Выполняющаяся задача завершится сама, получив отмену контекста
+		cancel()
+		return nil
+	}
+	s.finish(job, JobCanceled, "")
+	return nil
+}
+
+// ResultPath возвращает путь к файлу-результату завершённой задачи.
+func (s *JobService) ResultPath(id string) (string, error) {
+	job, err := s.Get(id)
+	if err != nil {
+		return "", err
+	}
+	if job.Status != JobCompleted || job.ResultFile == "" {
+		return "", ErrJobNoResult
+	}
+	return s.resultPath(job.ResultFile), nil
+}
+
+func (s *JobService) resultPath(name string) string {
+	return filepath.Join(s.stateDir, "results", filepath.Base(name))
+}
+
+func (s *JobService) worker() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 {
+			s.cond.Wait()
+		}
+		id := s.queue[0]
+		s.queue = s.queue[1:]
+
+		job, ok := s.jobs[id]
+		if !ok || job.Status != JobQueued {
+			s.mu.Unlock()
+			continue
+		}
+		fn := s.kinds[job.Kind]
+		ctx, cancel := context.WithCancel(context.Background())
+		s.cancels[id] = cancel
+		now := time.Now()
+		job.Status = JobRunning
+		job.StartedAt = &now
+		job.Done, job.Total = 0, 0
+		s.persist(job)
+		jc := &JobControl{service: s, id: id, user: job.User, params: job.Params}
+		s.mu.Unlock()
+
+		logger.Infof("Job %s (%s) started", id, job.Kind)
+		err := runJob(ctx, fn, jc)
+
+		s.mu.Lock()
+		delete(s.cancels, id)
+		switch {
+		case ctx.Err() != nil:
+			s.finish(job, JobCanceled, "")
+			logger.Infof("Job %s canceled", id)
+		case err != nil:
+			s.finish(job, JobFailed, err.Error())
+			logger.Errorf("Job %s failed: %v", id, err)
+		default:
+			s.finish(job, JobCompleted, "")
+			logger.Infof("Job %s completed", id)
+		}
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// runJob выполняет задачу, превращая панику исполнителя в ошибку.
+func runJob(ctx context.Context, fn JobFunc, jc *JobControl) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return fn(ctx, jc)
+}
+
+// finish переводит задачу в конечное состояние. Вызывается под s.mu.
+func (s *JobService) finish(job *Job, status JobStatus, errMsg string) {
+	now := time.Now()
+	job.Status = status
+	job.Error = errMsg
+	job.FinishedAt = &now
+	if status != JobCompleted && job.ResultFile != "" {
+		os.Remove(s.resultPath(job.ResultFile))
+		job.ResultFile = ""
+	}
+	s.persist(job)
+}
+
+// update изменяет задачу под блокировкой и при необходимости сохраняет её.
+func (s *JobService) update(id string, save bool, fn func(j *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	fn(job)
+	if save {
+		s.persist(job)
+	}
+}
+
+// persist сохраняет состояние задачи на диск. Вызывается под s.mu.
+func (s *JobService) persist(job *Job) {
+	data, err := json.MarshalIndent(job, "", " ")
+	if err != nil {
+		logger.Errorf("Error encoding job %s: %v", job.ID, err)
+		return
+	}
+	path := filepath.Join(s.stateDir, job.ID+".json")
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		logger.Errorf("Error saving job %s: %v", job.ID, err)
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		logger.Errorf("Error saving job %s: %v", job.ID, err)
+	}
+}
+
+// load восстанавливает задачи из stateDir.
+func (s *JobService) load() error {
+	entries, err := os.ReadDir(s.stateDir)
+	if err != nil {
+		return fmt.Errorf("error reading job state directory: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []*Job
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.stateDir, entry.Name()))
+		if err != nil {
+			logger.Warningf("Error reading job state %s: %v", entry.Name(), err)
+			continue
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			logger.Warningf("Error decoding job state %s: %v", entry.Name(), err)
+			continue
+		}
+		s.jobs[job.ID] = &job
+		if job.Finished() {
+			continue
+		}
+		if _, ok := s.kinds[job.Kind]; !ok {
+			s.finish(&job, JobFailed, ErrJobKindUnknown.Error())
+			continue
+		}
+		// Прерванные перезапуском задачи выполняются заново
+		job.Status = JobQueued
+		job.StartedAt = nil
+		job.Done, job.Total = 0, 0
+		s.persist(&job)
+		pending = append(pending, &job)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	for _, job := range pending {
+		s.queue = append(s.queue, job.ID)
+	}
+	if len(pending) > 0 {
+		logger.Infof("Restored %d unfinished jobs", len(pending))
+	}
+	return nil
+}
+
+// cleanupLoop периодически удаляет завершённые задачи старше resultTTL.
+func (s *JobService) cleanupLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		s.cleanup()
+		<-ticker.C
+	}
+}
+
+func (s *JobService) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-s.resultTTL)
+	for id, job := range s.jobs {
+		if !job.Finished() || job.FinishedAt == nil || job.FinishedAt.After(cutoff) {
+			continue
+		}
+		if job.ResultFile != "" {
+			os.Remove(s.resultPath(job.ResultFile))
+		}
+		os.Remove(filepath.Join(s.stateDir, id+".json"))
+		delete(s.jobs, id)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Поля метаданных с ожидаемыми хеш-суммами из файла контрольных сумм
+// (SHA256SUMS, .sfv, .md5), загруженного в ту же папку.
+const (
+	// ManifestFieldPrefix - префикс ожидаемой суммы: "Expected SHA256".
+	ManifestFieldPrefix = "Expected "
+	// MetadataManifestSource - имя файла контрольных сумм.
+	MetadataManifestSource = "Expected Source"
+)
+
+// Форматы файлов контрольных сумм.
+const (
+	ManifestSums = "sums" // <сумма>  <имя> (sha256sum, md5sum, b3sum)
+	ManifestSFV  = "sfv"  // <имя> <CRC32>
+	ManifestMD5  = "md5"  // md5sum
+)
+
+// ManifestEntry - ожидаемая сумма файла из файла контрольных сумм.
+type ManifestEntry struct {
+	Name      string // Путь относительно папки с файлом контрольных сумм
+	Algorithm string
+	Hash      string
+}
+
+// ManifestMismatch - файл, сумма которого не совпала с ожидаемой.
+type ManifestMismatch struct {
+	Manifest  string `json:"manifest"`
+	File      string `json:"file"`
+	Algorithm string `json:"algorithm"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual"`
+}
+
+// manifestNames - имена файлов контрольных сумм и алгоритм по умолчанию.
+var manifestNames = map[string]string{
+	"md5sums":    "MD5",
+	"sha1sums":   "SHA1",
+	"sha256sums": "SHA256",
+	"sha512sums": "SHA512",
+	"b3sums":     "BLAKE3",
+	"checksums":  "",
+}
+
+// manifestExtensions - расширения файлов контрольных сумм и алгоритм.
+var manifestExtensions = map[string]string{
+	".sfv":       "CRC32",
+	".md5":       "MD5",
+	".md5sum":    "MD5",
+	".sha1":      "SHA1",
+	".sha1sum":   "SHA1",
+	".sha256":    "SHA256",
+	".sha256sum": "SHA256",
+	".sha512":    "SHA512",
+	".sha512sum": "SHA512",
+	".b3":        "BLAKE3",
+}
+
+// DetectManifest определяет по имени файла, является ли он файлом
+// контрольных сумм, и возвращает алгоритм по умолчанию. Пустой алгоритм
+// означает, что он определяется по длине суммы.
+func DetectManifest(name string) (string, bool) {
+	lower := strings.ToLower(name)
+	if algorithm, ok := manifestNames[strings.TrimSuffix(lower, ".txt")]; ok {
+		return algorithm, true
+	}
+	algorithm, ok := manifestExtensions[filepath.Ext(lower)]
+	return algorithm, ok
+}
+
+// ParseManifest читает файл контрольных сумм: строки вида "<сумма>  <имя>"
+// или "<сумма> *<имя>" (coreutils), "SHA256 (<имя>) = <сумма>" (BSD) и, для
+// CRC32, "<имя> <сумма>" (SFV). Комментарии (#, ;) и пустые строки
+// пропускаются.
+func ParseManifest(r io.Reader, algorithm string) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		entry, ok := parseManifestLine(line, algorithm)
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading checksum file: %w", err)
+	}
+	return entries, nil
+}
+
+func parseManifestLine(line, algorithm string) (ManifestEntry, bool) {
+	// BSD: ALGORITHM (name) = hash
+	if open := strings.Index(line, " ("); open > 0 {
+		if close := strings.LastIndex(line, ") = "); close > open {
+			if a, ok := LookupHashAlgorithm(line[:open]); ok {
+				return newManifestEntry(line[open+2:close], a.Name, line[close+4:])
+			}
+		}
+	}
+
+	if algorithm == "CRC32" {
+		// SFV: name hash
+		i := strings.LastIndexAny(line, " \t")
+		if i < 0 {
+			return ManifestEntry{}, false
+		}
+		return newManifestEntry(strings.TrimSpace(line[:i]), algorithm, line[i+1:])
+	}
+
+	// coreutils: hash  name, hash *name; "\" в начале означает экранированное имя
+	escaped := strings.HasPrefix(line, "\\")
+	line = strings.TrimPrefix(line, "\\")
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return ManifestEntry{}, false
+	}
+	hash, name := line[:i], strings.TrimLeft(line[i:], " \t")
+	name = strings.TrimPrefix(name, "*")
+	if escaped {
+		name = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r").Replace(name)
+	}
+	if algorithm == "" {
+		algorithm = algorithmByLength(hash)
+	}
+	return newManifestEntry(name, algorithm, hash)
+}
+
+func newManifestEntry(name, algorithm, hash string) (ManifestEntry, bool) {
+	name = strings.TrimSpace(name)
+	hash = strings.TrimSpace(hash)
+	if name == "" || algorithm == "" || !isHex(hash) {
+		return ManifestEntry{}, false
+	}
+	return ManifestEntry{Name: name, Algorithm: algorithm, Hash: hash}, true
+}
+
+// algorithmByLength угадывает алгоритм по длине суммы в шестнадцатеричном виде.
+func algorithmByLength(hash string) string {
+	switch len(hash) {
+	case 8:
+		return "CRC32"
+	case 16:
+		return "CRC64"
+	case 32:
+		return "MD5"
+	case 40:
+		return "SHA1"
+	case 64:
+		return "SHA256"
+	case 128:
+		return "SHA512"
+	}
+	return ""
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportManifest добавляет ожидаемые суммы из файла контрольных сумм
+// manifestPath в метаданные перечисленных в нём файлов той же папки. Если
+// names не пуст, обрабатываются только файлы с этими путями (относительно
+// папки). Возвращает файлы, сохранённые суммы которых не совпали с
+// ожидаемыми.
+func (fs *FileService) ImportManifest(manifestPath string, names ...string) ([]ManifestMismatch, error) {
+	algorithm, ok := DetectManifest(filepath.Base(manifestPath))
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum file: %s", filepath.Base(manifestPath))
+	}
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening checksum file: %w", err)
+	}
+	defer file.Close()
+
+	entries, err := ParseManifest(file, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(manifestPath)
+	manifestName := filepath.Base(manifestPath)
+	expected := make(map[string]map[string]string)
+	var order []string
+	for _, entry := range entries {
+		name := path.Clean(strings.TrimPrefix(filepath.ToSlash(entry.Name), "./"))
+		if name == "." || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			continue
+		}
+		if len(names) > 0 && !containsString(names, name) {
+			continue
+		}
+		if expected[name] == nil {
+			expected[name] = map[string]string{MetadataManifestSource: manifestName}
+			order = append(order, name)
+		}
+		expected[name][ManifestFieldPrefix+entry.Algorithm] = entry.Hash
+	}
+
+	mismatches := []ManifestMismatch{}
+	for _, name := range order {
+		fullPath := filepath.Join(dir, filepath.FromSlash(name))
+		if info, err := os.Stat(fullPath); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		// Суммы могут прийти из нескольких файлов контрольных сумм
+		if existing, err := fs.loadMetadata(fullPath); err == nil {
+			if source := existing[MetadataManifestSource]; source != "" && !containsString(strings.Split(source, ", "), manifestName) {
+				expected[name][MetadataManifestSource] = source + ", " + manifestName
+			}
+		}
+		if err := fs.AddMetadata(fullPath, expected[name], SystemActor(MetadataSourceManifest)); err != nil {
+			return mismatches, fmt.Errorf("error saving expected hashes of %s: %w", name, err)
+		}
+		stored := fs.StoredHashes(fullPath)
+		for key, hash := range expected[name] {
+			algorithm := strings.TrimPrefix(key, ManifestFieldPrefix)
+			if key == MetadataManifestSource || stored[algorithm] == "" || SameHash(algorithm, stored[algorithm], hash) {
+				continue
+			}
+			mismatches = append(mismatches, ManifestMismatch{
+				Manifest:  manifestName,
+				File:      name,
+				Algorithm: algorithm,
+				Expected:  hash,
+				Actual:    stored[algorithm],
+			})
+		}
+	}
+	return mismatches, nil
+}
+
+// ImportManifests применяет файлы контрольных сумм папки dirPath после
+// загрузки файлов uploaded (имена в папке): загруженные файлы контрольных
+// сумм применяются ко всем перечисленным в них файлам, остальные - только к
+// загруженным файлам.
+func (fs *FileService) ImportManifests(dirPath string, uploaded []string) ([]ManifestMismatch, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+	mismatches := []ManifestMismatch{}
+	for _, entry := range entries {
+		if entry.IsDir() || IsMetaFile(entry.Name()) {
+			continue
+		}
+		if _, ok := DetectManifest(entry.Name()); !ok {
+			continue
+		}
+		// Файл контрольных сумм, созданный сервером, описывает сохранённые суммы
+		if fs.signer != nil && entry.Name() == fs.signer.manifest {
+			continue
+		}
+		var names []string
+		if !containsString(uploaded, entry.Name()) {
+			names = uploaded
+		}
+		found, err := fs.ImportManifest(filepath.Join(dirPath, entry.Name()), names...)
+		if err != nil {
+			return mismatches, err
+		}
+		mismatches = append(mismatches, found...)
+	}
+	return mismatches, nil
+}
+
+// ManifestFormat возвращает алгоритм и имя файла контрольных сумм для
+// формата: "sfv" (CRC32), "md5" или "<алгоритм>sums", например "sha256sums".
+func ManifestFormat(format, folder string) (algorithm, fileName string, err error) {
+	format = strings.ToLower(format)
+	if folder == "" || folder == "/" || folder == "." {
+		folder = "checksums"
+	}
+	switch {
+	case format == ManifestSFV:
+		return "CRC32", folder + ".sfv", nil
+	case format == ManifestMD5:
+		return "MD5", folder + ".md5", nil
+	case format == "" || format == ManifestSums:
+		return "SHA256", "SHA256SUMS", nil
+	case strings.HasSuffix(format, "sums"):
+		name := strings.TrimSuffix(format, "sums")
+		if name == "b3" {
+			name = "BLAKE3"
+		}
+		if a, ok := LookupHashAlgorithm(name); ok {
+			return a.Name, strings.ToUpper(format), nil
+		}
+	}
+	return "", "", fmt.Errorf("unsupported checksum format: %s", format)
+}
+
+// WriteManifest записывает в w файл контрольных сумм папки dirPath (полный
+// путь) по сохранённым метаданным. Файлы без актуальной суммы алгоритма
+// algorithm пропускаются; возвращается число записанных и пропущенных файлов.
+func (fs *FileService) WriteManifest(w io.Writer, dirPath, algorithm string, sfv, recursive bool) (written, skipped int, err error) {
+	var names []string
+	err = filepath.WalkDir(dirPath, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dirPath && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if IsMetaFile(d.Name()) || !d.Type().IsRegular() {
+			return nil
+		}
+		if _, ok := DetectManifest(d.Name()); ok {
+			return nil
+		}
+		rel, err := filepath.Rel(dirPath, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading directory: %w", err)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	if sfv {
+		fmt.Fprintf(bw, "; Generated by FileStation\n")
+	}
+	for _, name := range names {
+		hash := fs.StoredHashes(filepath.Join(dirPath, filepath.FromSlash(name)))[algorithm]
+		if hash == "" {
+			skipped++
+			continue
+		}
+		if algorithm == "CRC32" {
+			hash = fmt.Sprintf("%08s", strings.ToUpper(hash))
+		}
+		if sfv {
+			fmt.Fprintf(bw, "%s %s\n", name, hash)
+		} else if strings.ContainsAny(name, "\\\n\r") {
+			fmt.Fprintf(bw, "\\%s  %s\n", hash, strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(name))
+		} else {
+			fmt.Fprintf(bw, "%s  %s\n", hash, name)
+		}
+		written++
+	}
+	if err := bw.Flush(); err != nil {
+		return written, skipped, fmt.Errorf("error writing checksum file: %w", err)
+	}
+	return written, skipped, nil
+}
@@ -0,0 +1,419 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Форматы выгрузки и загрузки метаданных.
+const (
+	MetadataCSV   = "csv"
+	MetadataJSONL = "jsonl"
+)
+
+// metadataPathColumn - столбец CSV (поле JSON) с путём файла.
+const metadataPathColumn = "path"
+
+// Действия над полем в результате изменения метаданных.
+const (
+	FieldAdded   = "add"
+	FieldChanged = "change"
+	FieldRemoved = "remove"
+)
+
+// MetadataPatch - изменение метаданных одного файла: поля Set задаются,
+// поля Unset удаляются. Path - путь файла относительно базовой папки. Если
+// задана ревизия Revision, изменение применяется только к ней.
+type MetadataPatch struct {
+	Path     string            `json:"path"`
+	Set      map[string]string `json:"set,omitempty"`
+	Unset    []string          `json:"unset,omitempty"`
+	Revision string            `json:"revision,omitempty"`
+}
+
+// FieldChange - изменение одного поля.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Action string `json:"action"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// MetadataDiff - результат изменения метаданных одного файла. Revision -
+// ревизия метаданных, к которой относятся изменения (после применения -
+// новая).
+type MetadataDiff struct {
+	Path     string        `json:"path"`
+	Revision string        `json:"revision,omitempty"`
+	Changes  []FieldChange `json:"changes"`
+	Errors   []FieldError  `json:"errors,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Conflict bool          `json:"conflict,omitempty"` // Ревизия не совпала
+}
+
+// Failed сообщает, что изменение файла не может быть применено.
+func (d MetadataDiff) Failed() bool {
+	return len(d.Errors) > 0 || d.Error != "" || d.Conflict
+}
+
+// PatchMetadata проверяет изменения всех файлов и, если ни одно не
+// содержит ошибок и не задан dryRun, применяет их. Системные поля изменять
+// нельзя, каждый файл может встречаться только один раз. Перед записью
+// блокируются все файлы и сверяются их ревизии: если метаданные хотя бы
+// одного изменились после проверки или не совпали с ревизией изменения, он
+// отмечается конфликтом и не записывается ни один файл. Только ошибка
+// записи на диск может оставить часть изменений применённой. Возвращает
+// различия по каждому файлу и признак применения. Изменения записываются в
+// историю от имени actor.
+func (fs *FileService) PatchMetadata(ctx context.Context, patches []MetadataPatch, dryRun bool, actor MetadataActor) ([]MetadataDiff, bool, error) {
+	diffs := make([]MetadataDiff, len(patches))
+	failed := false
+	seen := make(map[string]bool, len(patches))
+	for i, patch := range patches {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		diffs[i] = fs.patchMetadata(patch, true, false, actor)
+		if seen[diffs[i].Path] {
+			diffs[i].Error = "duplicate path"
+		}
+		seen[diffs[i].Path] = true
+		failed = failed || diffs[i].Failed()
+	}
+	if failed || dryRun {
+		return diffs, false, nil
+	}
+
+	// Блокировки берутся в порядке путей, чтобы одновременные запросы не
+	// ждали друг друга бесконечно
+	var paths []string
+	for i := range patches {
+		if len(diffs[i].Changes) > 0 {
+			paths = append(paths, fs.GetFullPath(diffs[i].Path))
+		}
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		unlock := fs.locks.lock(p)
+		defer unlock()
+	}
+
+	conflict := false
+	for i := range patches {
+		if len(diffs[i].Changes) == 0 {
+			continue
+		}
+		// Файл не должен измениться между проверкой и записью
+		current, err := fs.MetadataRevision(fs.GetFullPath(diffs[i].Path))
+		if err != nil {
+			return diffs, false, fmt.Errorf("error reading metadata of %s: %w", diffs[i].Path, err)
+		}
+		if checkRevision(current, diffs[i].Revision) != nil {
+			diffs[i].Conflict = true
+			diffs[i].Error = ErrMetadataConflict.Error()
+			diffs[i].Revision = current
+			conflict = true
+		}
+	}
+	if conflict {
+		return diffs, false, nil
+	}
+
+	for i, patch := range patches {
+		if len(diffs[i].Changes) == 0 {
+			continue
+		}
+		patch.Revision = diffs[i].Revision
+		diffs[i] = fs.patchMetadata(patch, false, true, actor)
+		if diffs[i].Failed() {
+			return diffs, false, fmt.Errorf("error updating metadata of %s: %s", diffs[i].Path, diffs[i].Error)
+		}
+	}
+	return diffs, true, nil
+}
+
+// patchMetadata применяет изменение к одному файлу или только вычисляет его.
+// locked - блокировку файла уже держит вызывающий.
+func (fs *FileService) patchMetadata(patch MetadataPatch, dryRun, locked bool, actor MetadataActor) MetadataDiff {
+	diff := MetadataDiff{Path: path.Clean("/" + patch.Path), Changes: []FieldChange{}}
+	fullPath := fs.GetFullPath(diff.Path)
+	if info, err := os.Stat(fullPath); err != nil {
+		diff.Error = "file not found"
+		return diff
+	} else if !info.Mode().IsRegular() || IsMetaFile(info.Name()) {
+		diff.Error = "not a regular file"
+		return diff
+	}
+
+	if len(patch.Set) == 0 && len(patch.Unset) == 0 {
+		diff.Revision, _ = fs.MetadataRevision(fullPath)
+		return diff
+	}
+	for key := range patch.Set {
+		if fs.schema.IsReserved(key) {
+			diff.Errors = append(diff.Errors, FieldError{Field: key, Message: "reserved field cannot be edited"})
+		}
+	}
+	for _, key := range patch.Unset {
+		if fs.schema.IsReserved(key) {
+			diff.Errors = append(diff.Errors, FieldError{Field: key, Message: "reserved field cannot be edited"})
+		}
+	}
+	if len(diff.Errors) > 0 {
+		sort.Slice(diff.Errors, func(i, j int) bool { return diff.Errors[i].Field < diff.Errors[j].Field })
+		return diff
+	}
+
+	result, err := fs.updateMetadata(fullPath, metadataUpdate{
+		set:           patch.Set,
+		unset:         patch.Unset,
+		checkRequired: true,
+		dryRun:        dryRun,
+		revision:      patch.Revision,
+		actor:         actor,
+		locked:        locked,
+	})
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		diff.Errors = validationErr.Errors
+		return diff
+	} else if errors.Is(err, ErrMetadataConflict) {
+		diff.Conflict = true
+		diff.Error = err.Error()
+		diff.Revision, _ = fs.MetadataRevision(fullPath)
+		return diff
+	} else if err != nil {
+		diff.Error = err.Error()
+		return diff
+	}
+	diff.Revision = result.revision
+	diff.Changes = diffMetadata(result.before, result.after)
+	return diff
+}
+
+// diffMetadata возвращает изменения полей, отсортированные по имени.
+func diffMetadata(before, after map[string]string) []FieldChange {
+	changes := []FieldChange{}
+	for key, value := range after {
+		old, ok := before[key]
+		switch {
+		case !ok:
+			changes = append(changes, FieldChange{Field: key, Action: FieldAdded, New: value})
+		case old != value:
+			changes = append(changes, FieldChange{Field: key, Action: FieldChanged, Old: old, New: value})
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			changes = append(changes, FieldChange{Field: key, Action: FieldRemoved, Old: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// ExportedMetadata - метаданные одного файла в выгрузке.
+type ExportedMetadata struct {
+	Path     string            `json:"path"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// collectMetadata возвращает метаданные всех файлов папки dirPath и
+// вложенных папок, отсортированные по пути. Файлы без метаданных
+// выгружаются с пустым набором полей, чтобы их можно было заполнить.
+func (fs *FileService) collectMetadata(ctx context.Context, dirPath string) ([]ExportedMetadata, error) {
+	var files []ExportedMetadata
+	err := filepath.WalkDir(dirPath, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() || IsMetaFile(d.Name()) {
+			return nil
+		}
+		metadata, err := fs.loadMetadata(p)
+		if err != nil && !os.IsNotExist(err) {
+			return nil
+		}
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		files = append(files, ExportedMetadata{Path: fs.relativePath(p), Metadata: metadata})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// ExportMetadata выгружает метаданные файлов папки dirPath со всеми
+// вложенными папками в формате CSV (столбец path и по столбцу на поле) или
+// JSON Lines ({"path": ..., "metadata": {...}} в каждой строке). Возвращает
+// число выгруженных файлов.
+func (fs *FileService) ExportMetadata(ctx context.Context, w io.Writer, dirPath, format string) (int, error) {
+	files, err := fs.collectMetadata(ctx, dirPath)
+	if err != nil {
+		return 0, err
+	}
+
+	switch format {
+	case MetadataJSONL:
+		encoder := json.NewEncoder(w)
+		for _, file := range files {
+			if err := encoder.Encode(file); err != nil {
+				return 0, err
+			}
+		}
+	case MetadataCSV:
+		seen := make(map[string]bool)
+		var columns []string
+		for _, file := range files {
+			for key := range file.Metadata {
+				if !seen[key] {
+					seen[key] = true
+					columns = append(columns, key)
+				}
+			}
+		}
+		sort.Strings(columns)
+
+		writer := csv.NewWriter(w)
+		if err := writer.Write(append([]string{metadataPathColumn}, columns...)); err != nil {
+			return 0, err
+		}
+		for _, file := range files {
+			row := []string{file.Path}
+			for _, column := range columns {
+				row = append(row, file.Metadata[column])
+			}
+			if err := writer.Write(row); err != nil {
+				return 0, err
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unsupported metadata format %q", format)
+	}
+	return len(files), nil
+}
+
+// ParseMetadataImport читает выгрузку CSV или JSON Lines и возвращает
+// изменения по файлам. Формат определяется по первому символу, если не
+// задан. Заданные поля записываются, поля с пустым значением (null в JSON)
+// удаляются, отсутствующие не меняются. Системные поля пропускаются: их
+// значения в выгрузке могут устареть (например, после пересчёта хеш-сумм или
+// проверки целостности), и выгрузку всё равно можно загрузить обратно.
+func (fs *FileService) ParseMetadataImport(r io.Reader, format string) ([]MetadataPatch, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading import: %w", err)
+	}
+	data = []byte(strings.TrimPrefix(string(data), "\ufeff"))
+	if format == "" {
+		format = MetadataCSV
+		if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+			format = MetadataJSONL
+		}
+	}
+
+	var records []map[string]*string
+	switch format {
+	case MetadataJSONL:
+		for n, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var record struct {
+				Path     string             `json:"path"`
+				Metadata map[string]*string `json:"metadata"`
+			}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			if record.Path == "" {
+				return nil, fmt.Errorf("line %d: path is required", n+1)
+			}
+			fields := map[string]*string{metadataPathColumn: &record.Path}
+			for key, value := range record.Metadata {
+				fields[key] = value
+			}
+			records = append(records, fields)
+		}
+	case MetadataCSV:
+		reader := csv.NewReader(strings.NewReader(string(data)))
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CSV: %w", err)
+		}
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		header := rows[0]
+		if !containsString(header, metadataPathColumn) {
+			return nil, fmt.Errorf("CSV has no %q column", metadataPathColumn)
+		}
+		for _, row := range rows[1:] {
+			fields := make(map[string]*string, len(header))
+			for i, column := range header {
+				if column == "" || i >= len(row) {
+					continue
+				}
+				value := row[i]
+				if value == "" && column != metadataPathColumn {
+					fields[column] = nil
+				} else {
+					fields[column] = &value
+				}
+			}
+			records = append(records, fields)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported metadata format %q", format)
+	}
+
+	var patches []MetadataPatch
+	for n, fields := range records {
+		filePath := fields[metadataPathColumn]
+		if filePath == nil || strings.TrimSpace(*filePath) == "" {
+			return nil, fmt.Errorf("record %d: path is required", n+1)
+		}
+		delete(fields, metadataPathColumn)
+		patch := MetadataPatch{Path: path.Clean("/" + strings.TrimSpace(*filePath)), Set: map[string]string{}}
+		stored, _ := fs.loadMetadata(fs.GetFullPath(patch.Path))
+		for key, value := range fields {
+			if fs.schema.IsReserved(key) {
+				continue
+			}
+			current, exists := stored[key]
+			switch {
+			case value == nil || *value == "":
+				if exists {
+					patch.Unset = append(patch.Unset, key)
+				}
+			case exists && current == *value:
+				// Значение не изменилось
+			default:
+				patch.Set[key] = *value
+			}
+		}
+		sort.Strings(patch.Unset)
+		patches = append(patches, patch)
+	}
+	return patches, nil
+}
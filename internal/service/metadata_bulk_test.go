@@ -0,0 +1,53 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// Выгрузка загружается обратно после пересчёта хеш-сумм: устаревшие значения
+// системных полей пропускаются, а изменённые пользователем поля записываются.
+func TestImportIgnoresReservedColumns(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	path := newHashedTestFile(t, fs, "a.txt", "hello")
+	if _, err := fs.EditMetadata(path, map[string]string{"Notes": "old"}, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+	var export bytes.Buffer
+	if _, err := fs.ExportMetadata(context.Background(), &export, fs.baseDir, MetadataCSV); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, path, []byte("changed"))
+	hashes, err := fs.RecalculateHashes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.StoreHashes(path, hashes, SystemActor(MetadataSourceRecalculate)); err != nil {
+		t.Fatal(err)
+	}
+	stored := fs.StoredHashes(path)
+
+	edited := bytes.Replace(export.Bytes(), []byte(",old"), []byte(",new"), 1)
+	patches, err := fs.ParseMetadataImport(bytes.NewReader(edited), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 || len(patches[0].Set) != 1 || patches[0].Set["Notes"] != "new" || len(patches[0].Unset) != 0 {
+		t.Fatalf("unexpected patches %+v", patches)
+	}
+	diffs, applied, err := fs.PatchMetadata(context.Background(), patches, false, MetadataActor{User: "tester", Source: MetadataSourceImport})
+	if err != nil || !applied {
+		t.Fatalf("import failed: %v, %+v", err, diffs)
+	}
+	metadata, _ := fs.loadMetadata(path)
+	if metadata["Notes"] != "new" {
+		t.Errorf("Notes = %q, want new", metadata["Notes"])
+	}
+	for _, key := range HashFields {
+		if metadata[key] != stored[key] {
+			t.Errorf("%s = %q, want recalculated %q", key, metadata[key], stored[key])
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fileStation/pkg/logger"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrHistoryDisabled возвращается, если история метаданных не ведётся.
+var ErrHistoryDisabled = errors.New("metadata history is disabled")
+
+// ErrHistoryNotFound возвращается, если в истории файла нет такой записи.
+var ErrHistoryNotFound = errors.New("metadata history entry not found")
+
+// Действия, которыми изменяются метаданные.
+const (
+	MetadataSourceUpload      = "upload"
+	MetadataSourceEdit        = "edit"
+	MetadataSourceBulk        = "bulk"
+	MetadataSourceImport      = "import"
+	MetadataSourceRecalculate = "recalculate"
+	MetadataSourceExtract     = "extract"
+	MetadataSourceContent     = "content"
+	MetadataSourceReport      = "report"
+	MetadataSourceScrub       = "scrub"
+	MetadataSourceSignature   = "signature"
+	MetadataSourceSigning     = "signing"
+	MetadataSourceManifest    = "manifest"
+	MetadataSourceRevert      = "revert"
+	MetadataSourceReconcile   = "reconcile"
+	MetadataSourceReadme      = "readme"   // Поля RDS из изменённого вручную README.md
+	MetadataSourceDefaults    = "defaults" // Значения по умолчанию папки
+)
+
+// DefaultHistoryLimit - число записей истории одного файла по умолчанию.
+const DefaultHistoryLimit = 100
+
+var historyBucket = []byte("history")
+
+// MetadataActor - кто и каким действием изменяет метаданные. Пустой User -
+// изменение, которое сервер вносит сам.
+type MetadataActor struct {
+	User   string
+	Source string
+}
+
+// SystemActor возвращает исполнителя для изменений, которые вносит сервер.
+func SystemActor(source string) MetadataActor {
+	return MetadataActor{Source: source}
+}
+
+// MetadataChange - запись истории: изменённые поля метаданных файла.
+type MetadataChange struct {
+	ID       uint64        `json:"id"`
+	Time     time.Time     `json:"time"`
+	User     string        `json:"user,omitempty"`
+	Source   string        `json:"source"`
+	Changes  []FieldChange `json:"changes"`
+	Revision string        `json:"revision"`            // Ревизия после изменения
+	RevertTo uint64        `json:"revert_to,omitempty"` // Запись, к состоянию после которой вернулись
+}
+
+// MetadataHistory - журнал изменений метаданных (bbolt). Записи хранятся по
+// пути файла относительно base_dir и переносятся при его переименовании; при
+// удалении файла история сохраняется и продолжается, если файл с тем же
+// именем появится снова.
+type MetadataHistory struct {
+	db      *bolt.DB
+	baseDir string
+	limit   int
+}
+
+// OpenMetadataHistory открывает (создаёт) журнал изменений метаданных. limit -
+// число хранимых записей одного файла, 0 - DefaultHistoryLimit.
+func OpenMetadataHistory(dbPath, baseDir string, limit int) (*MetadataHistory, error) {
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, fmt.Errorf("error creating history directory: %w", err)
+	}
+	db, err := bolt.Open(dbPath, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening metadata history: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing metadata history: %w", err)
+	}
+	return &MetadataHistory{db: db, baseDir: filepath.Clean(baseDir), limit: limit}, nil
+}
+
+// Close закрывает журнал.
+func (h *MetadataHistory) Close() error {
+	return h.db.Close()
+}
+
+func (h *MetadataHistory) relPath(fullPath string) (string, bool) {
+	rel, err := filepath.Rel(h.baseDir, filepath.Clean(fullPath))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path.Clean("/" + filepath.ToSlash(rel)), true
+}
+
+// historyKey - ключ записи "<путь>\x00<номер>"; записи файла идут подряд по
+// возрастанию номера.
+func historyKey(relPath string, id uint64) []byte {
+	key := make([]byte, len(relPath)+9)
+	copy(key, relPath)
+	binary.BigEndian.PutUint64(key[len(relPath)+1:], id)
+	return key
+}
+
+// Record добавляет запись в историю файла fullPath, присваивая ей номер, и
+// удаляет самые старые записи сверх лимита.
+func (h *MetadataHistory) Record(fullPath string, change MetadataChange) error {
+	rel, ok := h.relPath(fullPath)
+	if !ok {
+		return nil
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		change.ID = id
+		data, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(historyKey(rel, id), data); err != nil {
+			return err
+		}
+
+		prefix := []byte(rel + "\x00")
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for len(keys) > h.limit {
+			if err := b.Delete(keys[0]); err != nil {
+				return err
+			}
+			keys = keys[1:]
+		}
+		return nil
+	})
+}
+
+// List возвращает историю файла fullPath, начиная с последнего изменения.
+func (h *MetadataHistory) List(fullPath string) ([]MetadataChange, error) {
+	changes := []MetadataChange{}
+	rel, ok := h.relPath(fullPath)
+	if !ok {
+		return changes, nil
+	}
+	prefix := []byte(rel + "\x00")
+	err := h.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(historyBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var change MetadataChange
+			if err := json.Unmarshal(v, &change); err != nil {
+				continue
+			}
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	return changes, err
+}
+
+// Move переносит историю файла или папки со всем содержимым на новый путь.
+func (h *MetadataHistory) Move(oldFullPath, newFullPath string) error {
+	oldRel, ok := h.relPath(oldFullPath)
+	if !ok {
+		return nil
+	}
+	newRel, ok := h.relPath(newFullPath)
+	if !ok {
+		return nil
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		var keys, values [][]byte
+		c := b.Cursor()
+		for _, prefix := range [][]byte{[]byte(oldRel + "\x00"), []byte(oldRel + "/")} {
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, append([]byte(nil), v...))
+			}
+		}
+		for i, key := range keys {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+			moved := append([]byte(newRel), key[len(oldRel):]...)
+			if err := b.Put(moved, values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetMetadataHistory подключает журнал изменений метаданных; nil отключает его.
+func (fs *FileService) SetMetadataHistory(history *MetadataHistory) {
+	fs.history = history
+}
+
+// MetadataHistory возвращает историю изменений метаданных файла, начиная с
+// последнего.
+func (fs *FileService) MetadataHistory(filePath string) ([]MetadataChange, error) {
+	if fs.history == nil {
+		return nil, ErrHistoryDisabled
+	}
+	return fs.history.List(filePath)
+}
+
+// historyBookkeeping - служебные поля, которые меняются при каждой проверке
+// целостности. Изменение только этих полей в историю не записывается, иначе
+// плановые проверки вытесняли бы из неё изменения пользователей.
+var historyBookkeeping = []string{MetadataVerifiedAt, MetadataHashedSize, MetadataHashedModTime}
+
+// recordHistory записывает в историю изменение метаданных файла, если поля
+// изменились не только служебные (см. historyBookkeeping). Поля RDS из
+// README.md, изменённого с прошлой записи, записываются отдельно с действием
+// readme, а не с действием этого изменения.
+func (fs *FileService) recordHistory(filePath string, update metadataUpdate, result *metadataResult) {
+	if fs.history == nil {
+		return
+	}
+	before := result.before
+	if result.readme != nil && update.actor.Source != MetadataSourceReport {
+		if changes := diffMetadata(before, result.readme); len(changes) > 0 {
+			change := MetadataChange{
+				Time:     time.Now().UTC(),
+				Source:   MetadataSourceReadme,
+				Changes:  changes,
+				Revision: result.revision,
+			}
+			if err := fs.history.Record(filePath, change); err != nil {
+				logger.Errorf("Error recording metadata history for %s: %v", filePath, err)
+			}
+		}
+		before = result.readme
+	}
+	changes := diffMetadata(before, result.after)
+	bookkeeping := true
+	for _, change := range changes {
+		if !containsString(historyBookkeeping, change.Field) {
+			bookkeeping = false
+			break
+		}
+	}
+	if bookkeeping {
+		return
+	}
+	change := MetadataChange{
+		Time:     time.Now().UTC(),
+		User:     update.actor.User,
+		Source:   update.actor.Source,
+		Changes:  changes,
+		Revision: result.revision,
+		RevertTo: update.revertTo,
+	}
+	if err := fs.history.Record(filePath, change); err != nil {
+		logger.Errorf("Error recording metadata history for %s: %v", filePath, err)
+	}
+}
+
+// moveHistory переносит историю переименованного или перемещённого файла.
+func (fs *FileService) moveHistory(oldPath, newPath string) {
+	if fs.history == nil {
+		return
+	}
+	if err := fs.history.Move(oldPath, newPath); err != nil {
+		logger.Errorf("Error moving metadata history of %s: %v", oldPath, err)
+	}
+}
+
+// historyRevertible сообщает, возвращается ли поле к прежнему значению при
+// откате. Системные поля, поля RDS из README.md и поля содержимого
+// описывают текущий файл и не откатываются.
+func (fs *FileService) historyRevertible(field string) bool {
+	if fs.schema.IsReserved(field) || strings.HasPrefix(field, "RDS ") {
+		return false
+	}
+	for _, extractor := range contentExtractorRegistry {
+		if strings.HasPrefix(field, extractor.Namespace+".") {
+			return false
+		}
+	}
+	return true
+}
+
+// RevertMetadata возвращает пользовательские поля метаданных файла к
+// состоянию после записи истории id, отменяя все последующие изменения.
+// Ревизия revision (If-Match) проверяется как в EditMetadata. Возвращает
+// новую ревизию.
+func (fs *FileService) RevertMetadata(filePath string, id uint64, user, revision string) (string, error) {
+	history, err := fs.MetadataHistory(filePath)
+	if err != nil {
+		return "", err
+	}
+	found := false
+	set := make(map[string]string)
+	unsetFields := make(map[string]bool)
+	// Записи идут от последней; более ранние перекрывают значения более поздних
+	for _, change := range history {
+		if change.ID == id {
+			found = true
+			break
+		}
+		for _, field := range change.Changes {
+			if !fs.historyRevertible(field.Field) {
+				continue
+			}
+			if field.Action == FieldAdded {
+				delete(set, field.Field)
+				unsetFields[field.Field] = true
+			} else {
+				delete(unsetFields, field.Field)
+				set[field.Field] = field.Old
+			}
+		}
+	}
+	if !found {
+		return "", ErrHistoryNotFound
+	}
+	unset := make([]string, 0, len(unsetFields))
+	for field := range unsetFields {
+		unset = append(unset, field)
+	}
+
+	result, err := fs.updateMetadata(filePath, metadataUpdate{
+		set:           set,
+		unset:         unset,
+		checkRequired: true,
+		revision:      revision,
+		actor:         MetadataActor{User: user, Source: MetadataSourceRevert},
+		revertTo:      id,
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.revision, nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// newHistoryTestService возвращает сервис с журналом истории на limit записей.
+func newHistoryTestService(t *testing.T, limit int) *FileService {
+	t.Helper()
+	fs := NewFileService(t.TempDir(), nil)
+	history, err := OpenMetadataHistory(filepath.Join(t.TempDir(), "history.db"), fs.baseDir, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { history.Close() })
+	fs.SetMetadataHistory(history)
+	return fs
+}
+
+func historySources(t *testing.T, fs *FileService, path string) []string {
+	t.Helper()
+	changes, err := fs.MetadataHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sources []string
+	for _, change := range changes {
+		sources = append(sources, change.Source)
+	}
+	return sources
+}
+
+// Плановые проверки целостности не вытесняют изменения пользователей.
+func TestHistorySkipsVerificationBookkeeping(t *testing.T) {
+	fs := newHistoryTestService(t, 5)
+	path := newHashedTestFile(t, fs, "a.txt", "hello")
+	if _, err := fs.EditMetadata(path, map[string]string{"Notes": "keep"}, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if _, err := fs.VerifyFile(context.Background(), path, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+		// Следующая проверка запишет другое время, как при плановом запуске
+		old := metadataUpdate{set: map[string]string{MetadataVerifiedAt: fmt.Sprintf("2000-01-0%dT00:00:00Z", i+1)}, relabel: true}
+		if _, err := fs.updateMetadata(path, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Первая проверка добавляет Verify Result, следующие меняют только Verified At
+	sources := historySources(t, fs, path)
+	want := []string{MetadataSourceScrub, MetadataSourceEdit, MetadataSourceUpload}
+	if len(sources) != len(want) {
+		t.Fatalf("history %v, want %v", sources, want)
+	}
+	for i := range want {
+		if sources[i] != want[i] {
+			t.Fatalf("history %v, want %v", sources, want)
+		}
+	}
+}
+
+func TestHistoryFolderDefaults(t *testing.T) {
+	fs := newHistoryTestService(t, 0)
+	dir := filepath.Join(fs.baseDir, "releases")
+	writeTestFile(t, filepath.Join(dir, "a.txt"), []byte("a"))
+
+	if _, err := fs.EditFolderDefaults(dir, map[string]string{"Channel": "beta"}, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.EditFolderDefaults(dir, map[string]string{"Channel": ""}, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := fs.MetadataHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Changes[0].Action != FieldRemoved || changes[1].Changes[0].New != "beta" {
+		t.Fatalf("unexpected history %+v", changes)
+	}
+	if changes[0].User != "tester" || changes[0].Source != MetadataSourceDefaults {
+		t.Errorf("recorded as %s/%s", changes[0].User, changes[0].Source)
+	}
+}
+
+// Поля RDS из изменённого README.md записываются отдельно от изменения,
+// которое их подхватило.
+func TestHistoryReadmeChanges(t *testing.T) {
+	fs := newHistoryTestService(t, 0)
+	path := newHashedTestFile(t, fs, "a.txt", "hello")
+	writeTestFile(t, filepath.Join(fs.baseDir, "README.md"), []byte("## RDS\n### a.txt\n- **RDS**: `100`\n"))
+	if _, err := fs.EditMetadata(path, map[string]string{"Notes": "edited"}, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := fs.MetadataHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("unexpected history %+v", changes)
+	}
+	edit, readme := changes[0], changes[1]
+	if edit.Source != MetadataSourceEdit || len(edit.Changes) != 1 || edit.Changes[0].Field != "Notes" {
+		t.Errorf("edit entry %+v", edit)
+	}
+	if readme.Source != MetadataSourceReadme || readme.User != "" || len(readme.Changes) != 2 || readme.Changes[1].Field != "RDS RDS" {
+		t.Errorf("readme entry %+v", readme)
+	}
+}
+
+// Откат возвращает пользовательские поля к состоянию после выбранной записи,
+// а системные поля оставляет.
+func TestRevertMetadata(t *testing.T) {
+	fs := newHistoryTestService(t, 0)
+	path := newHashedTestFile(t, fs, "a.txt", "hello")
+	if _, err := fs.EditMetadata(path, map[string]string{"Notes": "one"}, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+	history, err := fs.MetadataHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := history[0].ID
+	if _, err := fs.EditMetadata(path, map[string]string{"Notes": "two", "Owner": "someone"}, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, []byte("changed"))
+	recalculated, err := fs.RecalculateHashes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.StoreHashes(path, recalculated, SystemActor(MetadataSourceRecalculate)); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := fs.EditMetadata(path, map[string]string{"Notes": "three"}, "tester", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.EditMetadata(path, map[string]string{"Owner": "other"}, "other", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.RevertMetadata(path, target, "tester", stale); !errors.Is(err, ErrMetadataConflict) {
+		t.Fatalf("stale revision: got %v, want ErrMetadataConflict", err)
+	}
+	if _, err := fs.RevertMetadata(path, target+100, "tester", ""); !errors.Is(err, ErrHistoryNotFound) {
+		t.Fatalf("unknown id: got %v, want ErrHistoryNotFound", err)
+	}
+	current, _ := fs.MetadataRevision(path)
+	revision, err := fs.RevertMetadata(path, target, "tester", current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, _ := fs.loadMetadata(path)
+	if metadata["Notes"] != "one" {
+		t.Errorf("Notes = %q, want one", metadata["Notes"])
+	}
+	if _, ok := metadata["Owner"]; ok {
+		t.Errorf("Owner = %q, want removed", metadata["Owner"])
+	}
+	for _, key := range HashFields {
+		if metadata[key] != recalculated[key] {
+			t.Errorf("%s = %q, want recalculated %q", key, metadata[key], recalculated[key])
+		}
+	}
+	history, _ = fs.MetadataHistory(path)
+	if last := history[0]; last.Source != MetadataSourceRevert || last.User != "tester" || last.RevertTo != target || last.Revision != revision {
+		t.Errorf("unexpected history entry %+v", last)
+	}
+}
@@ -0,0 +1,554 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrIndexDisabled возвращается, если индекс метаданных не настроен.
+var ErrIndexDisabled = errors.New("metadata index is disabled")
+
+var (
+	metadataBucket = []byte("metadata") // Содержимое файлов .<name>.meta
+	filesBucket    = []byte("files")    // Файлы и папки: размер и время изменения
+	readmeBucket   = []byte("readme")   // Текст README.md по папкам
+	indexBuckets   = [][]byte{metadataBucket, filesBucket, readmeBucket}
+)
+
+const (
+	// indexBatchSize - число записей в одной транзакции при синхронизации.
+	indexBatchSize = 1000
+	// maxIndexedReadme - максимальный размер индексируемого текста README.md.
+	maxIndexedReadme = 1 << 20
+	readmeName       = "README.md"
+)
+
+// MetadataIndex - встроенный индекс (bbolt) содержимого файлов .<name>.meta,
+// списка файлов и текста README.md. Источником истины остаётся файловая
+// система: индекс хранит размер и время изменения исходных файлов и
+// перечитывает их при расхождении.
+type MetadataIndex struct {
+	db      *bolt.DB
+	baseDir string
+	store   metadataStore // Хранилище метаданных, из которого читает Sync
+}
+
+// IndexedFile - метаданные файла из индекса.
+type IndexedFile struct {
+	Path     string            `json:"path"` // Путь относительно base_dir, начинается с "/"
+	Metadata map[string]string `json:"metadata"`
+}
+
+// IndexedEntry - файл или папка из индекса вместе с метаданными.
+type IndexedEntry struct {
+	Path     string
+	IsDir    bool
+	Size     int64
+	ModTime  time.Time
+	Metadata map[string]string
+}
+
+// IndexStats - итог синхронизации индекса.
+type IndexStats struct {
+	Scanned int `json:"scanned"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+}
+
+// indexRecord - запись индекса: состояние исходного файла и извлечённые данные.
+type indexRecord struct {
+	ModTime  int64             `json:"mtime"`
+	Size     int64             `json:"size"`
+	IsDir    bool              `json:"dir,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Text     string            `json:"text,omitempty"`
+}
+
+func newIndexRecord(info os.FileInfo) indexRecord {
+	return indexRecord{ModTime: info.ModTime().UnixNano(), Size: info.Size(), IsDir: info.IsDir()}
+}
+
+func (rec *indexRecord) matches(info os.FileInfo) bool {
+	return rec.ModTime == info.ModTime().UnixNano() && rec.Size == info.Size() && rec.IsDir == info.IsDir()
+}
+
+// OpenMetadataIndex открывает (создаёт) индекс метаданных для файлов в baseDir.
+func OpenMetadataIndex(dbPath, baseDir string) (*MetadataIndex, error) {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, fmt.Errorf("error creating index directory: %w", err)
+	}
+	db, err := bolt.Open(dbPath, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening metadata index: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range indexBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing metadata index: %w", err)
+	}
+	return &MetadataIndex{db: db, baseDir: filepath.Clean(baseDir), store: sidecarStore{}}, nil
+}
+
+// Close закрывает индекс.
+func (idx *MetadataIndex) Close() error {
+	return idx.db.Close()
+}
+
+// relPath возвращает путь относительно base_dir в виде "/dir/name".
+func (idx *MetadataIndex) relPath(fullPath string) (string, bool) {
+	rel, err := filepath.Rel(idx.baseDir, filepath.Clean(fullPath))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path.Clean("/" + filepath.ToSlash(rel)), true
+}
+
+// indexKey - ключ записи "<папка>\x00<имя>", чтобы записи одной папки шли подряд.
+func indexKey(relPath string) []byte {
+	return []byte(path.Dir(relPath) + "\x00" + path.Base(relPath))
+}
+
+func keyPath(key []byte) string {
+	dir, name, _ := strings.Cut(string(key), "\x00")
+	return path.Join(dir, name)
+}
+
+func (idx *MetadataIndex) put(bucket []byte, fullPath string, rec indexRecord) error {
+	rel, ok := idx.relPath(fullPath)
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(indexKey(rel), data)
+	})
+}
+
+// Put сохраняет метаданные файла fullPath; metaInfo - состояние его метаданных.
+func (idx *MetadataIndex) Put(fullPath string, metadata map[string]string, metaInfo os.FileInfo) error {
+	rec := newIndexRecord(metaInfo)
+	rec.Metadata = metadata
+	return idx.put(metadataBucket, fullPath, rec)
+}
+
+// DeleteMetadata удаляет из индекса метаданные файла fullPath, не трогая
+// запись самого файла.
+func (idx *MetadataIndex) DeleteMetadata(fullPath string) error {
+	rel, ok := idx.relPath(fullPath)
+	if !ok {
+		return nil
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataBucket).Delete(indexKey(rel))
+	})
+}
+
+// PutPath обновляет запись файла или папки, а для README.md - и его текст.
+func (idx *MetadataIndex) PutPath(fullPath string) error {
+	rel, ok := idx.relPath(fullPath)
+	if !ok || rel == "/" {
+		return nil
+	}
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return err
+	}
+	if err := idx.put(filesBucket, fullPath, newIndexRecord(info)); err != nil {
+		return err
+	}
+	if info.Name() == readmeName && info.Mode().IsRegular() {
+		rec, err := readReadmeRecord(fullPath, info)
+		if err != nil {
+			return err
+		}
+		return idx.put(readmeBucket, filepath.Dir(fullPath), rec)
+	}
+	return nil
+}
+
+// Delete удаляет из индекса файл или папку со всем содержимым.
+func (idx *MetadataIndex) Delete(fullPath string) error {
+	rel, ok := idx.relPath(fullPath)
+	if !ok {
+		return nil
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range indexBuckets {
+			b := tx.Bucket(name)
+			for _, key := range treeKeys(b, rel) {
+				if err := b.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		if path.Base(rel) == readmeName {
+			return tx.Bucket(readmeBucket).Delete(indexKey(path.Dir(rel)))
+		}
+		return nil
+	})
+}
+
+// Move переносит записи файла или папки со всем содержимым на новый путь.
+func (idx *MetadataIndex) Move(oldFullPath, newFullPath string) error {
+	oldRel, ok := idx.relPath(oldFullPath)
+	if !ok {
+		return nil
+	}
+	newRel, ok := idx.relPath(newFullPath)
+	if !ok {
+		return idx.Delete(oldFullPath)
+	}
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range indexBuckets {
+			b := tx.Bucket(name)
+			keys := treeKeys(b, oldRel)
+			values := make([][]byte, len(keys))
+			for i, key := range keys {
+				values[i] = append([]byte(nil), b.Get(key)...)
+			}
+			for i, key := range keys {
+				if err := b.Delete(key); err != nil {
+					return err
+				}
+				moved := newRel + strings.TrimPrefix(keyPath(key), oldRel)
+				if err := b.Put(indexKey(moved), values[i]); err != nil {
+					return err
+				}
+			}
+		}
+		if path.Base(oldRel) == readmeName {
+			return tx.Bucket(readmeBucket).Delete(indexKey(path.Dir(oldRel)))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Файл мог стать README.md новой папки
+	if path.Base(newRel) == readmeName {
+		return idx.PutPath(newFullPath)
+	}
+	return nil
+}
+
+// treeKeys возвращает ключи записи rel и всех записей внутри папки rel.
+func treeKeys(b *bolt.Bucket, rel string) [][]byte {
+	var keys [][]byte
+	if b.Get(indexKey(rel)) != nil {
+		keys = append(keys, indexKey(rel))
+	}
+	c := b.Cursor()
+	for _, prefix := range [][]byte{[]byte(rel + "\x00"), []byte(strings.TrimSuffix(rel, "/") + "/")} {
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+	}
+	return keys
+}
+
+// dir возвращает записи индекса из bucket для элементов папки dirFullPath по именам.
+func (idx *MetadataIndex) dir(bucket []byte, dirFullPath string) (map[string]indexRecord, error) {
+	rel, ok := idx.relPath(dirFullPath)
+	if !ok {
+		return nil, nil
+	}
+	records := make(map[string]indexRecord)
+	prefix := []byte(rel + "\x00")
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var rec indexRecord
+			if json.Unmarshal(v, &rec) == nil {
+				records[string(k[len(prefix):])] = rec
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// Walk перебирает все записи метаданных.
+func (idx *MetadataIndex) Walk(fn func(file IndexedFile) error) error {
+	return idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataBucket).ForEach(func(k, v []byte) error {
+			var rec indexRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return nil
+			}
+			return fn(IndexedFile{Path: keyPath(k), Metadata: rec.Metadata})
+		})
+	})
+}
+
+// WalkEntries перебирает все файлы и папки вместе с их метаданными.
+func (idx *MetadataIndex) WalkEntries(fn func(entry IndexedEntry) error) error {
+	return idx.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metadataBucket)
+		return tx.Bucket(filesBucket).ForEach(func(k, v []byte) error {
+			var rec indexRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return nil
+			}
+			entry := IndexedEntry{
+				Path:    keyPath(k),
+				IsDir:   rec.IsDir,
+				Size:    rec.Size,
+				ModTime: time.Unix(0, rec.ModTime),
+			}
+			if data := meta.Get(k); data != nil {
+				var metaRec indexRecord
+				if json.Unmarshal(data, &metaRec) == nil {
+					entry.Metadata = metaRec.Metadata
+				}
+			}
+			return fn(entry)
+		})
+	})
+}
+
+// WalkReadmes перебирает тексты README.md; dir - путь папки.
+func (idx *MetadataIndex) WalkReadmes(fn func(dir, text string) error) error {
+	return idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(readmeBucket).ForEach(func(k, v []byte) error {
+			var rec indexRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return nil
+			}
+			return fn(keyPath(k), rec.Text)
+		})
+	})
+}
+
+// syncBatch накапливает изменения одного bucket при синхронизации.
+type syncBatch struct {
+	idx     *MetadataIndex
+	bucket  []byte
+	known   map[string]indexRecord
+	pending map[string][]byte
+	stats   *IndexStats
+}
+
+func (idx *MetadataIndex) newSyncBatch(bucket []byte, full bool, stats *IndexStats) (*syncBatch, error) {
+	sb := &syncBatch{
+		idx:     idx,
+		bucket:  bucket,
+		known:   make(map[string]indexRecord),
+		pending: make(map[string][]byte),
+		stats:   stats,
+	}
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			var rec indexRecord
+			if !full {
+				// Для сравнения достаточно размера и времени изменения
+				json.Unmarshal(v, &rec)
+				rec.Metadata, rec.Text = nil, ""
+			}
+			sb.known[string(k)] = rec
+			return nil
+		})
+	})
+	return sb, err
+}
+
+// visit отмечает ключ как существующий и сообщает, нужно ли его перечитать.
+func (sb *syncBatch) visit(key string, info os.FileInfo, full bool) bool {
+	rec, exists := sb.known[key]
+	delete(sb.known, key)
+	return full || !exists || !rec.matches(info)
+}
+
+func (sb *syncBatch) put(key string, rec indexRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	sb.pending[key] = data
+	sb.stats.Updated++
+	if len(sb.pending) >= indexBatchSize {
+		return sb.flush()
+	}
+	return nil
+}
+
+// finish удаляет записи, исходных файлов которых больше нет.
+func (sb *syncBatch) finish() error {
+	for key := range sb.known {
+		sb.pending[key] = nil
+		sb.stats.Removed++
+		if len(sb.pending) >= indexBatchSize {
+			if err := sb.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return sb.flush()
+}
+
+func (sb *syncBatch) flush() error {
+	if len(sb.pending) == 0 {
+		return nil
+	}
+	err := sb.idx.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sb.bucket)
+		for k, v := range sb.pending {
+			var err error
+			if v == nil {
+				err = b.Delete([]byte(k))
+			} else {
+				err = b.Put([]byte(k), v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	sb.pending = make(map[string][]byte)
+	return err
+}
+
+// Sync приводит индекс в соответствие с файловой системой: перечитывает
+// изменившиеся файлы метаданных и README.md и удаляет записи исчезнувших
+// файлов. При full индекс перестраивается заново.
+func (idx *MetadataIndex) Sync(ctx context.Context, full bool) (IndexStats, error) {
+	var stats IndexStats
+	batches := make(map[string]*syncBatch)
+	for _, name := range indexBuckets {
+		sb, err := idx.newSyncBatch(name, full, &stats)
+		if err != nil {
+			return stats, fmt.Errorf("error reading metadata index: %w", err)
+		}
+		batches[string(name)] = sb
+	}
+	metaBatch := batches[string(metadataBucket)]
+	filesBatch := batches[string(filesBucket)]
+	readmeBatch := batches[string(readmeBucket)]
+
+	err := filepath.WalkDir(idx.baseDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Недоступные папки пропускаем, их записи будут удалены
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == idx.baseDir {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		stats.Scanned++
+
+		if name, ok := idx.store.owner(d); ok {
+			if err := idx.syncMetadata(metaBatch, filepath.Join(filepath.Dir(p), name), full); err != nil {
+				return err
+			}
+		}
+		if IsMetaFile(d.Name()) && !d.IsDir() {
+			return nil
+		}
+
+		rel, ok := idx.relPath(p)
+		if !ok {
+			return nil
+		}
+		key := string(indexKey(rel))
+		if filesBatch.visit(key, info, full) {
+			if err := filesBatch.put(key, newIndexRecord(info)); err != nil {
+				return err
+			}
+		}
+
+		if d.Name() == readmeName && info.Mode().IsRegular() {
+			dirKey := string(indexKey(path.Dir(rel)))
+			if readmeBatch.visit(dirKey, info, full) {
+				rec, err := readReadmeRecord(p, info)
+				if err != nil {
+					return nil
+				}
+				return readmeBatch.put(dirKey, rec)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("error scanning files: %w", err)
+	}
+
+	for _, name := range indexBuckets {
+		if err := batches[string(name)].finish(); err != nil {
+			return stats, fmt.Errorf("error updating metadata index: %w", err)
+		}
+	}
+	return stats, nil
+}
+
+// syncMetadata перечитывает метаданные файла target, если они изменились.
+func (idx *MetadataIndex) syncMetadata(sb *syncBatch, target string, full bool) error {
+	if _, err := os.Lstat(target); err != nil {
+		return nil
+	}
+	rel, ok := idx.relPath(target)
+	if !ok {
+		return nil
+	}
+	info, err := idx.store.state(target)
+	if err != nil {
+		return nil
+	}
+	key := string(indexKey(rel))
+	if !sb.visit(key, info, full) {
+		return nil
+	}
+	data, err := idx.store.read(target)
+	if err != nil {
+		return nil
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil
+	}
+	rec := newIndexRecord(info)
+	rec.Metadata = metadata
+	return sb.put(key, rec)
+}
+
+func readReadmeRecord(readmePath string, info os.FileInfo) (indexRecord, error) {
+	file, err := os.Open(readmePath)
+	if err != nil {
+		return indexRecord{}, err
+	}
+	defer file.Close()
+	text, err := io.ReadAll(io.LimitReader(file, maxIndexedReadme))
+	if err != nil {
+		return indexRecord{}, err
+	}
+	rec := newIndexRecord(info)
+	rec.Text = string(text)
+	return rec, nil
+}
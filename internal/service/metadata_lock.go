@@ -0,0 +1,92 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrMetadataConflict - метаданные изменились после того, как клиент их
+// прочитал (ревизия не совпала с If-Match).
+var ErrMetadataConflict = errors.New("metadata was changed by another request")
+
+// noMetadataRevision - ревизия отсутствующего файла метаданных.
+const noMetadataRevision = "0"
+
+// pathLocks - блокировки по путям файлов: чтение, объединение и запись
+// метаданных одного файла выполняются по очереди. Нулевое значение готово к
+// использованию.
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock блокирует путь и возвращает функцию снятия блокировки.
+func (l *pathLocks) lock(path string) func() {
+	path = filepath.Clean(path)
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*pathLock)
+	}
+	entry := l.locks[path]
+	if entry == nil {
+		entry = &pathLock{}
+		l.locks[path] = entry
+	}
+	entry.refs++
+	l.mu.Unlock()
+
+	entry.mu.Lock()
+	return func() {
+		entry.mu.Unlock()
+		l.mu.Lock()
+		if entry.refs--; entry.refs == 0 {
+			delete(l.locks, path)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// metadataRevision возвращает ревизию содержимого файла метаданных.
+func metadataRevision(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// MetadataRevision возвращает ревизию метаданных файла или папки filePath
+// для заголовков ETag и If-Match.
+func (fs *FileService) MetadataRevision(filePath string) (string, error) {
+	data, err := fs.store.read(filePath)
+	if os.IsNotExist(err) {
+		return noMetadataRevision, nil
+	} else if err != nil {
+		return "", err
+	}
+	return metadataRevision(data), nil
+}
+
+// checkRevision сравнивает ревизию с ожидаемой клиентом. Пустое значение не
+// проверяется, "*" означает любые существующие метаданные. Значение может
+// быть в кавычках, как в заголовке If-Match.
+func checkRevision(current, expected string) error {
+	expected = strings.TrimPrefix(strings.TrimSpace(expected), "W/")
+	expected = strings.Trim(expected, `"`)
+	switch {
+	case expected == "":
+		return nil
+	case expected == "*" && current != noMetadataRevision:
+		return nil
+	case expected == current:
+		return nil
+	}
+	return ErrMetadataConflict
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCheckRevision(t *testing.T) {
+	for _, tc := range []struct {
+		current, expected string
+		ok                bool
+	}{
+		{"abc", "", true},
+		{"abc", "abc", true},
+		{"abc", `"abc"`, true},
+		{"abc", `W/"abc"`, true},
+		{"abc", "*", true},
+		{noMetadataRevision, "*", false},
+		{"abc", "abd", false},
+		{noMetadataRevision, "abc", false},
+	} {
+		if err := checkRevision(tc.current, tc.expected); (err == nil) != tc.ok {
+			t.Errorf("checkRevision(%q, %q) = %v", tc.current, tc.expected, err)
+		}
+	}
+}
+
+// Изменение по устаревшей ревизии отклоняется и ничего не записывает.
+func TestEditMetadataStaleRevision(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	path := filepath.Join(fs.baseDir, "a.txt")
+	writeTestFile(t, path, []byte("a"))
+
+	if _, err := fs.EditMetadata(path, map[string]string{"Notes": "first"}, "tester", "*"); !errors.Is(err, ErrMetadataConflict) {
+		t.Fatalf("If-Match * without metadata: got %v, want ErrMetadataConflict", err)
+	}
+	stale, err := fs.EditMetadata(path, map[string]string{"Notes": "first"}, "tester", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := fs.EditMetadata(path, map[string]string{"Notes": "second"}, "tester", `"`+stale+`"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.EditMetadata(path, map[string]string{"Notes": "lost"}, "tester", stale); !errors.Is(err, ErrMetadataConflict) {
+		t.Fatalf("got %v, want ErrMetadataConflict", err)
+	}
+	if revision, _ := fs.MetadataRevision(path); revision != current {
+		t.Errorf("revision changed to %s, want %s", revision, current)
+	}
+	if metadata, _ := fs.loadMetadata(path); metadata["Notes"] != "second" {
+		t.Errorf("Notes = %q, want second", metadata["Notes"])
+	}
+}
+
+// Одновременные изменения одного файла не теряют поля друг друга.
+func TestConcurrentMetadataUpdates(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	path := filepath.Join(fs.baseDir, "a.txt")
+	writeTestFile(t, path, []byte("a"))
+
+	const writers = 32
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			field := map[string]string{fmt.Sprintf("Field %02d", i): "value"}
+			if i%2 == 0 {
+				errs <- fs.AddMetadata(path, field, SystemActor(MetadataSourceUpload))
+			} else {
+				_, err := fs.EditMetadata(path, field, "tester", "")
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metadata, err := fs.loadMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < writers; i++ {
+		if key := fmt.Sprintf("Field %02d", i); metadata[key] != "value" {
+			t.Errorf("%s lost", key)
+		}
+	}
+}
+
+// Конфликт одного файла в пакетном изменении не меняет ни один файл.
+func TestPatchMetadataConflict(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		writeTestFile(t, filepath.Join(fs.baseDir, name), []byte(name))
+	}
+	b := filepath.Join(fs.baseDir, "b.txt")
+	stale, err := fs.EditMetadata(b, map[string]string{"Notes": "old"}, "tester", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := fs.EditMetadata(b, map[string]string{"Notes": "changed"}, "other", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patches := []MetadataPatch{
+		{Path: "/a.txt", Set: map[string]string{"Notes": "batch"}},
+		{Path: "/b.txt", Set: map[string]string{"Notes": "batch"}, Revision: stale},
+	}
+	diffs, applied, err := fs.PatchMetadata(context.Background(), patches, false, MetadataActor{User: "tester", Source: MetadataSourceBulk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied {
+		t.Fatal("batch with a conflict must not be applied")
+	}
+	if diffs[0].Failed() || !diffs[1].Conflict || diffs[1].Revision != current {
+		t.Errorf("unexpected diffs %+v", diffs)
+	}
+	if metadata, _ := fs.loadMetadata(filepath.Join(fs.baseDir, "a.txt")); metadata["Notes"] != "" {
+		t.Errorf("a.txt changed: %v", metadata)
+	}
+	if metadata, _ := fs.loadMetadata(b); metadata["Notes"] != "changed" {
+		t.Errorf("b.txt changed: %v", metadata)
+	}
+
+	// С текущей ревизией изменение применяется
+	patches[1].Revision = current
+	if diffs, applied, err := fs.PatchMetadata(context.Background(), patches, false, MetadataActor{User: "tester", Source: MetadataSourceBulk}); err != nil || !applied {
+		t.Fatalf("got %v, %+v", err, diffs)
+	}
+	if metadata, _ := fs.loadMetadata(b); metadata["Notes"] != "batch" {
+		t.Errorf("Notes = %q, want batch", metadata["Notes"])
+	}
+}
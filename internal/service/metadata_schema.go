@@ -0,0 +1,201 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Типы полей схемы метаданных.
+const (
+	FieldString = "string"
+	FieldSemver = "semver"
+	FieldDate   = "date"
+	FieldURL    = "url"
+	FieldEnum   = "enum"
+)
+
+// DefaultReservedFields - системные поля, которые заполняет только сервер:
+// все хеш-суммы, загрузивший пользователь, версия, результаты проверки
+// целостности и подписи, тип содержимого, размер и время изменения файла при
+// расчёте хеш-сумм.
+var DefaultReservedFields = append(append([]string{}, HashFields...), "Uploader", "Version", MetadataVerifiedAt, MetadataVerifyResult,
+	MetadataSignatureStatus, MetadataSignatureSigner, MetadataSignatureFile, MetadataServerSignature, MetadataContentType, MetadataHashedSize,
+	MetadataHashedModTime)
+
+// semverPattern - семантическая версия (semver.org), допускается префикс "v".
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// MetadataField описывает поле схемы метаданных.
+type MetadataField struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Pattern  string   `json:"pattern,omitempty"`
+	Values   []string `json:"values,omitempty"` // Допустимые значения для enum
+}
+
+// MetadataSchema проверяет значения полей метаданных.
+type MetadataSchema struct {
+	fields       []MetadataField
+	patterns     map[string]*regexp.Regexp
+	reserved     map[string]bool
+	reservedList []string
+}
+
+// FieldError - ошибка проверки одного поля.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError содержит все ошибки проверки метаданных.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		messages[i] = fe.Field + ": " + fe.Message
+	}
+	return "invalid metadata: " + strings.Join(messages, "; ")
+}
+
+// NewMetadataSchema создает схему из описаний полей. Если reserved пуст,
+// защищаются DefaultReservedFields.
+func NewMetadataSchema(fields []MetadataField, reserved []string) (*MetadataSchema, error) {
+	s := &MetadataSchema{
+		patterns: make(map[string]*regexp.Regexp),
+		reserved: make(map[string]bool),
+	}
+	if len(reserved) == 0 {
+		reserved = DefaultReservedFields
+	}
+	for _, name := range reserved {
+		if !s.reserved[name] {
+			s.reserved[name] = true
+			s.reservedList = append(s.reservedList, name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		if field.Name == "" {
+			return nil, fmt.Errorf("metadata field without name")
+		}
+		if seen[field.Name] {
+			return nil, fmt.Errorf("duplicate metadata field: %s", field.Name)
+		}
+		seen[field.Name] = true
+
+		if field.Type == "" {
+			field.Type = FieldString
+		}
+		switch field.Type {
+		case FieldString, FieldSemver, FieldDate, FieldURL:
+		case FieldEnum:
+			if len(field.Values) == 0 {
+				return nil, fmt.Errorf("enum field %s has no values", field.Name)
+			}
+		default:
+			return nil, fmt.Errorf("unknown type %q of metadata field %s", field.Type, field.Name)
+		}
+		if field.Pattern != "" {
+			re, err := regexp.Compile(field.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern of metadata field %s: %w", field.Name, err)
+			}
+			s.patterns[field.Name] = re
+		}
+		s.fields = append(s.fields, field)
+	}
+	return s, nil
+}
+
+// Fields возвращает описания полей схемы.
+func (s *MetadataSchema) Fields() []MetadataField {
+	return s.fields
+}
+
+// Reserved возвращает список защищённых полей.
+func (s *MetadataSchema) Reserved() []string {
+	return s.reservedList
+}
+
+// IsReserved проверяет, является ли поле системным.
+func (s *MetadataSchema) IsReserved(name string) bool {
+	return s.reserved[name]
+}
+
+// Validate проверяет значения переданных полей по их типам. Пустое значение
+// допустимо для необязательных полей.
+func (s *MetadataSchema) Validate(metadata map[string]string) []FieldError {
+	var errs []FieldError
+	for _, field := range s.fields {
+		value, ok := metadata[field.Name]
+		if !ok {
+			continue
+		}
+		if value == "" {
+			if field.Required {
+				errs = append(errs, FieldError{Field: field.Name, Message: "value is required"})
+			}
+			continue
+		}
+		if msg := s.checkValue(field, value); msg != "" {
+			errs = append(errs, FieldError{Field: field.Name, Message: msg})
+		}
+	}
+	return errs
+}
+
+// CheckRequired проверяет, что заполнены все обязательные поля.
+func (s *MetadataSchema) CheckRequired(metadata map[string]string) []FieldError {
+	var errs []FieldError
+	for _, field := range s.fields {
+		if field.Required && metadata[field.Name] == "" {
+			errs = append(errs, FieldError{Field: field.Name, Message: "value is required"})
+		}
+	}
+	return errs
+}
+
+func (s *MetadataSchema) checkValue(field MetadataField, value string) string {
+	switch field.Type {
+	case FieldSemver:
+		if !semverPattern.MatchString(value) {
+			return "must be a semantic version like 1.2.3"
+		}
+	case FieldDate:
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				return "must be a date in YYYY-MM-DD or RFC 3339 format"
+			}
+		}
+	case FieldURL:
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be an absolute URL"
+		}
+	case FieldEnum:
+		if !containsString(field.Values, value) {
+			return "must be one of: " + strings.Join(field.Values, ", ")
+		}
+	}
+	if re := s.patterns[field.Name]; re != nil && !re.MatchString(value) {
+		return "does not match pattern " + field.Pattern
+	}
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
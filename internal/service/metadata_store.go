@@ -0,0 +1,432 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fileStation/pkg/logger"
+)
+
+// Способы хранения метаданных.
+const (
+	MetadataStorageSidecar = "sidecar" // Файлы .<name>.meta рядом с файлами
+	MetadataStorageXattr   = "xattr"   // Расширенные атрибуты user.* самих файлов
+)
+
+const (
+	// xattrPrefix - префикс атрибутов с полями метаданных: user.fileStation.<поле>.
+	xattrPrefix = "user.fileStation."
+	// xattrModTime - атрибут со временем последней записи метаданных.
+	xattrModTime = "user.fileStation"
+)
+
+// ErrXattrUnsupported возвращается, если расширенные атрибуты недоступны.
+var ErrXattrUnsupported = errors.New("extended attributes are not supported")
+
+// metadataStore хранит метаданные файлов и папок.
+type metadataStore interface {
+	// read возвращает метаданные filePath в JSON или ошибку os.ErrNotExist.
+	read(filePath string) ([]byte, error)
+	// write сохраняет метаданные и возвращает записанное представление, по
+	// которому считается ревизия. Нулевое modTime - текущее время.
+	write(filePath string, metadata map[string]string, modTime time.Time) ([]byte, error)
+	// remove удаляет метаданные; их отсутствие ошибкой не считается.
+	remove(filePath string) error
+	// move переносит метаданные вслед за переименованным файлом.
+	move(oldPath, newPath string) error
+	// state возвращает состояние метаданных, по которому индекс узнаёт об
+	// изменениях в обход сервиса.
+	state(filePath string) (os.FileInfo, error)
+	// modTime возвращает время последней записи метаданных.
+	modTime(filePath string) (time.Time, error)
+	// owner возвращает имя элемента папки, метаданные которого хранит entry.
+	owner(entry os.DirEntry) (string, bool)
+}
+
+// newMetadataStore возвращает хранилище метаданных по названию способа.
+func newMetadataStore(backend string) (metadataStore, error) {
+	switch strings.ToLower(backend) {
+	case "", MetadataStorageSidecar:
+		return sidecarStore{}, nil
+	case MetadataStorageXattr:
+		return xattrStore{}, nil
+	}
+	return nil, fmt.Errorf("unknown metadata storage %q, expected %s or %s", backend, MetadataStorageSidecar, MetadataStorageXattr)
+}
+
+// SetMetadataStorage выбирает способ хранения метаданных: sidecar (по
+// умолчанию) или xattr. Для xattr проверяется, что файловая система базовой
+// папки поддерживает атрибуты user.*.
+func (fs *FileService) SetMetadataStorage(backend string) error {
+	store, err := newMetadataStore(backend)
+	if err != nil {
+		return err
+	}
+	if _, ok := store.(xattrStore); ok {
+		if err := probeXattr(fs.baseDir); err != nil {
+			return fmt.Errorf("base_dir does not support user extended attributes: %w", err)
+		}
+	}
+	fs.store = store
+	if fs.index != nil {
+		fs.index.store = store
+	}
+	return nil
+}
+
+// loadMetadata читает метаданные файла или папки filePath.
+func (fs *FileService) loadMetadata(filePath string) (map[string]string, error) {
+	data, err := fs.store.read(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// sidecarStore хранит метаданные в файлах .<name>.meta рядом с файлами.
+type sidecarStore struct{}
+
+func (sidecarStore) read(filePath string) ([]byte, error) {
+	return os.ReadFile(MetaFilePath(filePath))
+}
+
+func (sidecarStore) write(filePath string, metadata map[string]string, modTime time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", " ") // Для удобства чтения
+	if err := encoder.Encode(metadata); err != nil {
+		return nil, err
+	}
+	// Запись через временный файл: читатели видят либо прежнюю, либо новую
+	// версию целиком
+	metaFilePath := MetaFilePath(filePath)
+	if err := writeFileAtomic(metaFilePath, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(metaFilePath, modTime, modTime); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (sidecarStore) remove(filePath string) error {
+	if err := os.Remove(MetaFilePath(filePath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (sidecarStore) move(oldPath, newPath string) error {
+	oldMetaFilePath := MetaFilePath(oldPath)
+	if _, err := os.Stat(oldMetaFilePath); err != nil {
+		return nil
+	}
+	return os.Rename(oldMetaFilePath, MetaFilePath(newPath))
+}
+
+func (sidecarStore) state(filePath string) (os.FileInfo, error) {
+	return os.Stat(MetaFilePath(filePath))
+}
+
+func (sidecarStore) modTime(filePath string) (time.Time, error) {
+	info, err := os.Stat(MetaFilePath(filePath))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (sidecarStore) owner(entry os.DirEntry) (string, bool) {
+	if entry.IsDir() || !IsMetaFile(entry.Name()) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(entry.Name(), "."), ".meta"), true
+}
+
+// xattrStore хранит каждое поле метаданных в расширенном атрибуте
+// user.fileStation.<поле> самого файла или папки, а время записи - в
+// атрибуте user.fileStation. Атрибуты переносятся вместе с файлом при
+// переименовании и удаляются вместе с ним.
+type xattrStore struct{}
+
+// attributes возвращает имена атрибутов fileStation файла.
+func (xattrStore) attributes(filePath string) ([]string, error) {
+	names, err := listXattr(filePath)
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: filePath, Err: err}
+	}
+	var own []string
+	for _, name := range names {
+		if name == xattrModTime || strings.HasPrefix(name, xattrPrefix) {
+			own = append(own, name)
+		}
+	}
+	return own, nil
+}
+
+func (s xattrStore) read(filePath string) ([]byte, error) {
+	names, err := s.attributes(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, &os.PathError{Op: "getxattr", Path: filePath, Err: os.ErrNotExist}
+	}
+	metadata := make(map[string]string)
+	for _, name := range names {
+		if name == xattrModTime {
+			continue
+		}
+		value, err := getXattr(filePath, name)
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: filePath, Err: err}
+		}
+		metadata[strings.TrimPrefix(name, xattrPrefix)] = string(value)
+	}
+	// Ключи кодируются по порядку, поэтому ревизия не зависит от порядка атрибутов
+	return json.Marshal(metadata)
+}
+
+// write записывает метаданные как единое целое. ext4 по умолчанию хранит все
+// атрибуты файла в одном блоке, поэтому запись может прерваться на середине
+// (E2BIG, ENOSPC); тогда атрибуты возвращаются к прежним значениям.
+func (s xattrStore) write(filePath string, metadata map[string]string, modTime time.Time) ([]byte, error) {
+	existing, err := s.attributes(filePath)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string][]byte, len(existing))
+	for _, name := range existing {
+		value, err := getXattr(filePath, name)
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: filePath, Err: err}
+		}
+		snapshot[name] = value
+	}
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	if err := s.apply(filePath, snapshot, metadata, modTime); err != nil {
+		s.restore(filePath, snapshot)
+		return nil, err
+	}
+	return json.Marshal(metadata)
+}
+
+// apply заменяет атрибуты snapshot на metadata. Лишние атрибуты удаляются
+// первыми, чтобы освободить место, неизменённые значения не перезаписываются.
+func (xattrStore) apply(filePath string, snapshot map[string][]byte, metadata map[string]string, modTime time.Time) error {
+	for name := range snapshot {
+		if _, ok := metadata[strings.TrimPrefix(name, xattrPrefix)]; ok || name == xattrModTime {
+			continue
+		}
+		if err := removeXattr(filePath, name); err != nil {
+			return &os.PathError{Op: "removexattr", Path: filePath, Err: err}
+		}
+	}
+	for key, value := range metadata {
+		name := xattrPrefix + key
+		if old, ok := snapshot[name]; ok && string(old) == value {
+			continue
+		}
+		if err := setXattr(filePath, name, []byte(value)); err != nil {
+			return &os.PathError{Op: "setxattr", Path: filePath, Err: err}
+		}
+	}
+	if err := setXattr(filePath, xattrModTime, []byte(modTime.UTC().Format(time.RFC3339Nano))); err != nil {
+		return &os.PathError{Op: "setxattr", Path: filePath, Err: err}
+	}
+	return nil
+}
+
+// restore возвращает атрибуты fileStation файла к снимку snapshot после
+// неудачной записи. Сначала удаляются новые атрибуты, чтобы прежним хватило
+// места.
+func (s xattrStore) restore(filePath string, snapshot map[string][]byte) {
+	names, err := s.attributes(filePath)
+	if err != nil {
+		logger.Errorf("Error restoring metadata attributes of %s: %v", filePath, err)
+		return
+	}
+	for _, name := range names {
+		if _, ok := snapshot[name]; !ok {
+			removeXattr(filePath, name)
+		}
+	}
+	for name, value := range snapshot {
+		if current, err := getXattr(filePath, name); err == nil && bytes.Equal(current, value) {
+			continue
+		}
+		if err := setXattr(filePath, name, value); err != nil {
+			logger.Errorf("Error restoring metadata attribute %s of %s: %v", name, filePath, err)
+		}
+	}
+}
+
+func (s xattrStore) remove(filePath string) error {
+	names, err := s.attributes(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := removeXattr(filePath, name); err != nil {
+			return &os.PathError{Op: "removexattr", Path: filePath, Err: err}
+		}
+	}
+	return nil
+}
+
+func (xattrStore) move(oldPath, newPath string) error {
+	return nil
+}
+
+// state возвращает время изменения индексного дескриптора (ctime): оно
+// меняется при любом изменении атрибутов, в том числе сторонними программами.
+func (s xattrStore) state(filePath string) (os.FileInfo, error) {
+	names, err := s.attributes(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, &os.PathError{Op: "getxattr", Path: filePath, Err: os.ErrNotExist}
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return xattrInfo{name: filepath.Base(filePath), modTime: changeTime(info)}, nil
+}
+
+func (xattrStore) modTime(filePath string) (time.Time, error) {
+	value, err := getXattr(filePath, xattrModTime)
+	if err != nil {
+		return time.Time{}, &os.PathError{Op: "getxattr", Path: filePath, Err: err}
+	}
+	return time.Parse(time.RFC3339Nano, string(value))
+}
+
+func (xattrStore) owner(entry os.DirEntry) (string, bool) {
+	if !entry.IsDir() && IsMetaFile(entry.Name()) {
+		return "", false
+	}
+	return entry.Name(), true
+}
+
+// xattrInfo - состояние атрибутов файла для индекса.
+type xattrInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (i xattrInfo) Name() string       { return i.name }
+func (i xattrInfo) Size() int64        { return 0 }
+func (i xattrInfo) Mode() os.FileMode  { return 0 }
+func (i xattrInfo) ModTime() time.Time { return i.modTime }
+func (i xattrInfo) IsDir() bool        { return false }
+func (i xattrInfo) Sys() interface{}   { return nil }
+
+// probeXattr проверяет, что в папке dir можно записывать атрибуты user.*.
+// У базовой папки метаданных нет, поэтому пробный атрибут ничего не затирает.
+func probeXattr(dir string) error {
+	if err := setXattr(dir, xattrModTime, []byte("probe")); err != nil {
+		return err
+	}
+	return removeXattr(dir, xattrModTime)
+}
+
+// MetadataMigration - итог переноса метаданных между хранилищами.
+type MetadataMigration struct {
+	Migrated int      `json:"migrated"`
+	Failed   []string `json:"failed"` // "<путь>: <ошибка>"
+}
+
+// MigrateMetadataStorage переносит метаданные всех файлов и папок из
+// хранилища from в хранилище to (sidecar или xattr) и удаляет их из прежнего.
+// Время записи метаданных сохраняется, поэтому сохранённым хеш-суммам по-
+// прежнему можно доверять. Метаданные символических ссылок и файлов
+// метаданных без самого файла не переносятся. Сервер на время переноса
+// должен быть остановлен.
+func (fs *FileService) MigrateMetadataStorage(ctx context.Context, from, to string) (MetadataMigration, error) {
+	var result MetadataMigration
+	source, err := newMetadataStore(from)
+	if err != nil {
+		return result, err
+	}
+	target, err := newMetadataStore(to)
+	if err != nil {
+		return result, err
+	}
+	if source == target {
+		return result, fmt.Errorf("metadata is already stored as %s", to)
+	}
+	for _, store := range []metadataStore{source, target} {
+		if _, ok := store.(xattrStore); ok {
+			if err := probeXattr(fs.baseDir); err != nil {
+				return result, fmt.Errorf("base_dir does not support user extended attributes: %w", err)
+			}
+		}
+	}
+
+	baseDir := filepath.Clean(fs.baseDir)
+	err = filepath.WalkDir(baseDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name, ok := source.owner(d)
+		if !ok || p == baseDir {
+			return nil
+		}
+		filePath := filepath.Join(filepath.Dir(p), name)
+		info, err := os.Lstat(filePath)
+		if err != nil {
+			return nil
+		}
+		data, err := source.read(filePath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		fail := func(err error) error {
+			result.Failed = append(result.Failed, fs.relativePath(filePath)+": "+err.Error())
+			return nil
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fail(errors.New("metadata of symbolic links is not migrated"))
+		}
+		var metadata map[string]string
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return fail(err)
+		}
+		// Без времени записи метаданные получают текущее время
+		modTime, _ := source.modTime(filePath)
+		if _, err := target.write(filePath, metadata, modTime); err != nil {
+			return fail(err)
+		}
+		if err := source.remove(filePath); err != nil {
+			return fail(err)
+		}
+		result.Migrated++
+		return nil
+	})
+	return result, err
+}
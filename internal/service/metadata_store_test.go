@@ -0,0 +1,51 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Ошибка посреди записи атрибутов (E2BIG, ENOSPC) оставляет прежние метаданные.
+func TestXattrStoreWriteRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	writeTestFile(t, path, []byte("a"))
+	if err := setXattr(path, xattrPrefix+"probe", []byte("1")); err != nil {
+		t.Skipf("extended attributes are not supported: %v", err)
+	}
+	removeXattr(path, xattrPrefix+"probe")
+
+	store := xattrStore{}
+	written := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	before, err := store.write(path, map[string]string{"A": "1", "B": "2"}, written)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Атрибуты вместе не помещаются в блок ext4, но первые успевают записаться
+	update := map[string]string{"A": "changed"}
+	for _, key := range []string{"C", "D", "E", "F", "G", "H", "I", "J"} {
+		update[key] = strings.Repeat(key, 1000)
+	}
+	if _, err := store.write(path, update, time.Time{}); err == nil {
+		t.Skip("file system accepted all attributes")
+	}
+	after, err := store.read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("got %s, want %s", after, before)
+	}
+	if modTime, err := store.modTime(path); err != nil || !modTime.Equal(written) {
+		t.Errorf("write time %v, %v, want %v", modTime, err, written)
+	}
+
+	if _, err := store.write(path, map[string]string{"A": "1"}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := store.read(path); string(data) != `{"A":"1"}` {
+		t.Errorf("got %s", data)
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ProgressFunc получает количество обработанных байт и общий объём работы.
+type ProgressFunc func(done, total int64)
+
+// progressCounter накапливает обработанные байты и периодически сообщает о них.
+type progressCounter struct {
+	done       int64
+	total      int64
+	report     ProgressFunc
+	lastReport time.Time
+}
+
+func (c *progressCounter) add(n int64) {
+	c.done += n
+	if time.Since(c.lastReport) < 500*time.Millisecond && c.done < c.total {
+		return
+	}
+	c.lastReport = time.Now()
+	c.report(c.done, c.total)
+}
+
+// progressReader считает прочитанные байты и прерывает чтение при отмене ctx.
+type progressReader struct {
+	ctx     context.Context
+	r       io.Reader
+	counter *progressCounter
+}
+
+func newProgressReader(ctx context.Context, r io.Reader, counter *progressCounter) io.Reader {
+	if ctx == context.Background() && counter == nil {
+		return r
+	}
+	return &progressReader{ctx: ctx, r: r, counter: counter}
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.r.Read(buf)
+	if n > 0 && p.counter != nil {
+		p.counter.add(int64(n))
+	}
+	return n, err
+}
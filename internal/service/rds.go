@@ -0,0 +1,123 @@
+package service
+
+import "strings"
+
+// Итоговый статус сверки хеш-сумм файла с данными RDS из README.md.
+const (
+	RDSMatch    = "match"    // Совпали все сравнимые суммы, среди них есть криптографическая
+	RDSWeak     = "weak"     // Совпали только CRC, которые не защищают от подмены
+	RDSMismatch = "mismatch" // Хотя бы одна сумма не совпала
+	RDSUnknown  = "unknown"  // Нет ни одной пары сумм для сравнения
+)
+
+// Статус сверки отдельной хеш-суммы.
+const (
+	RDSHashMatch    = "match"
+	RDSHashMismatch = "mismatch"
+	RDSHashMissing  = "missing" // Сумма есть только с одной стороны
+	// BLAKE2sp не совпал, а другая криптографическая сумма совпала: значение
+	// сохранено до появления настоящего BLAKE2sp (это BLAKE2s) и устарело
+	RDSHashStale = "stale"
+)
+
+// weakRDSAlgorithms - контрольные суммы, совпадение только по которым не
+// подтверждает подлинность файла.
+var weakRDSAlgorithms = []string{"CRC32", "CRC64"}
+
+// RDSHashStatus - сверка одной хеш-суммы файла с RDS.
+type RDSHashStatus struct {
+	Algorithm string `json:"algorithm"`
+	Expected  string `json:"expected,omitempty"` // Значение "RDS <алгоритм>"
+	Actual    string `json:"actual,omitempty"`   // Сохранённая сумма файла
+	Status    string `json:"status"`
+	Weak      bool   `json:"weak,omitempty"`
+}
+
+// RDSVerification - результат сверки всех хеш-сумм файла с RDS.
+type RDSVerification struct {
+	Status string          `json:"status"`
+	Hashes []RDSHashStatus `json:"hashes"`
+}
+
+// CompareRDS сравнивает каждую хеш-сумму файла с её значением "RDS " из
+// README.md. Пустые значения не считаются совпадающими; алгоритмы, которых
+// нет ни в файле, ни в RDS, не попадают в результат. Несовпавший BLAKE2sp
+// при совпавшей другой криптографической сумме считается устаревшим
+// значением, а не расхождением.
+func CompareRDS(metadata map[string]string) RDSVerification {
+	result := RDSVerification{Status: RDSUnknown, Hashes: []RDSHashStatus{}}
+	strong, weak, mismatch := false, false, false
+	legacy := -1 // Индекс несовпавшего BLAKE2sp в result.Hashes
+	for _, algorithm := range HashFields {
+		expected := strings.TrimSpace(metadata["RDS "+algorithm])
+		actual := strings.TrimSpace(metadata[algorithm])
+		if expected == "" && actual == "" {
+			continue
+		}
+		hash := RDSHashStatus{
+			Algorithm: algorithm,
+			Expected:  expected,
+			Actual:    actual,
+			Weak:      containsString(weakRDSAlgorithms, algorithm),
+		}
+		switch {
+		case expected == "" || actual == "":
+			hash.Status = RDSHashMissing
+		case SameHash(algorithm, expected, actual):
+			hash.Status = RDSHashMatch
+			if hash.Weak {
+				weak = true
+			} else {
+				strong = true
+			}
+		case algorithm == "BLAKE2sp":
+			hash.Status = RDSHashMismatch
+			legacy = len(result.Hashes)
+		default:
+			hash.Status = RDSHashMismatch
+			mismatch = true
+		}
+		result.Hashes = append(result.Hashes, hash)
+	}
+	if legacy >= 0 {
+		if strong {
+			result.Hashes[legacy].Status = RDSHashStale
+		} else {
+			mismatch = true
+		}
+	}
+
+	switch {
+	case mismatch:
+		result.Status = RDSMismatch
+	case strong:
+		result.Status = RDSMatch
+	case weak:
+		result.Status = RDSWeak
+	}
+	return result
+}
+
+// Summary возвращает описание сверки для подсказки в списке файлов,
+// например "SHA256: match, CRC32: match, SHA1: not calculated". Алгоритмы,
+// которых нет в RDS, не перечисляются.
+func (v RDSVerification) Summary() string {
+	var parts []string
+	for _, hash := range v.Hashes {
+		if hash.Expected == "" {
+			continue
+		}
+		status := hash.Status
+		switch status {
+		case RDSHashMissing:
+			status = "not calculated"
+		case RDSHashStale:
+			status = "outdated, recalculate hashes"
+		}
+		parts = append(parts, hash.Algorithm+": "+status)
+	}
+	if len(parts) == 0 {
+		return "No RDS hashes"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,611 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// rdsHeading - текст заголовка второго уровня раздела README.md с данными RDS.
+const rdsHeading = "RDS"
+
+// Раздел RDS описывает файлы папки одним из способов:
+//
+//	## RDS
+//	### app.bin
+//	- **RDS**: `123`
+//	- **SHA256**: `...`
+//
+// или таблицей со столбцом Filename:
+//
+//	## RDS
+//	| Filename | RDS | SHA256 |
+//	|---|---|---|
+//	| app.bin | 123 | ... |
+//
+// Поддерживается и прежний формат - один список с полем Filename без
+// подразделов.
+//
+// Границы раздела и подразделов определяются по дереву Markdown, поэтому
+// строки, похожие на заголовки, внутри блоков кода их не меняют. При записи
+// меняется только содержимое раздела RDS, остальной текст сохраняется
+// побайтно.
+
+// rdsLeadingKeys - поля, которые идут в записи перед хеш-суммами, если их
+// порядок не задан парсером отчёта.
+var rdsLeadingKeys = []string{"RDS", "Ссылка на RDS", "Дата проверки"}
+
+// readmeSection - содержимое раздела RDS файла README.md.
+type readmeSection struct {
+	found  bool
+	last   bool           // Раздел идёт до конца документа
+	start  int            // Начало содержимого раздела (после заголовка) в байтах
+	end    int            // Конец раздела в байтах
+	lines  []string       // Строки содержимого с исходными переводами строк
+	levels map[int]int    // Номер строки заголовка внутри раздела -> уровень
+	names  map[int]string // Номер строки заголовка третьего уровня -> имя файла
+	eol    string         // Перевод строки документа
+}
+
+// parseReadme находит раздел RDS в README.md.
+func parseReadme(source []byte) *readmeSection {
+	section := &readmeSection{levels: map[int]int{}, names: map[int]string{}, eol: "\n"}
+	if bytes.Contains(source, []byte("\r\n")) {
+		section.eol = "\r\n"
+	}
+
+	type heading struct {
+		level      int
+		start, end int // Строки заголовка в байтах
+		text       string
+	}
+	var headings []heading
+	doc := goldmark.DefaultParser().Parse(text.NewReader(source))
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		h, ok := n.(*ast.Heading)
+		if !ok || h.Lines().Len() == 0 {
+			continue
+		}
+		lines := h.Lines()
+		start := lineStart(source, lines.At(0).Start)
+		end := lineEnd(source, lines.At(lines.Len()-1).Stop-1)
+		if !strings.HasPrefix(strings.TrimLeft(string(source[start:end]), " "), "#") {
+			end = lineEnd(source, end) // Подчёркивание заголовка Setext
+		}
+		headings = append(headings, heading{
+			level: h.Level,
+			start: start,
+			end:   end,
+			text:  strings.TrimSpace(string(lines.Value(source))),
+		})
+	}
+
+	for i, h := range headings {
+		if h.level != 2 || h.text != rdsHeading {
+			continue
+		}
+		section.found, section.last = true, true
+		section.start, section.end = h.end, len(source)
+		var inner []heading
+		for _, next := range headings[i+1:] {
+			if next.level <= 2 {
+				section.end, section.last = next.start, false
+				break
+			}
+			inner = append(inner, next)
+		}
+
+		offset := section.start
+		for _, line := range strings.SplitAfter(string(source[section.start:section.end]), "\n") {
+			if line == "" {
+				continue
+			}
+			for _, h := range inner {
+				if h.start == offset {
+					section.levels[len(section.lines)] = h.level
+					if h.level == 3 {
+						section.names[len(section.lines)] = strings.Trim(h.text, "`")
+					}
+				}
+			}
+			section.lines = append(section.lines, line)
+			offset += len(line)
+		}
+		break
+	}
+	return section
+}
+
+// lineStart возвращает начало строки, содержащей байт offset.
+func lineStart(source []byte, offset int) int {
+	return bytes.LastIndexByte(source[:offset], '\n') + 1
+}
+
+// lineEnd возвращает начало строки, следующей за байтом offset.
+func lineEnd(source []byte, offset int) int {
+	if offset < 0 {
+		offset = 0
+	}
+	if i := bytes.IndexByte(source[offset:], '\n'); i >= 0 {
+		return offset + i + 1
+	}
+	return len(source)
+}
+
+// firstRecord возвращает номер строки первого заголовка раздела, то есть
+// конец общих строк раздела.
+func (s *readmeSection) firstRecord() int {
+	first := len(s.lines)
+	for i := range s.levels {
+		if i < first {
+			first = i
+		}
+	}
+	return first
+}
+
+// recordEnd возвращает конец подраздела, заголовок которого на строке i.
+func (s *readmeSection) recordEnd(i int) int {
+	for j := i + 1; j < len(s.lines); j++ {
+		if level, ok := s.levels[j]; ok && level <= 3 {
+			return j
+		}
+	}
+	return len(s.lines)
+}
+
+// ExtractRDSRecords читает раздел RDS файла README.md папки dirPath и
+// возвращает записи по именам файлов. В каждой записи есть поле Filename.
+func (fs *FileService) ExtractRDSRecords(dirPath string) (map[string]map[string]string, error) {
+	content, err := os.ReadFile(filepath.Join(dirPath, "README.md"))
+	if os.IsNotExist(err) {
+		return nil, nil // README.md не существует
+	} else if err != nil {
+		return nil, fmt.Errorf("ошибка при чтении README.md: %w", err)
+	}
+	section := parseReadme(content)
+	if !section.found {
+		return nil, nil
+	}
+	return section.records(), nil
+}
+
+// records разбирает записи раздела.
+func (s *readmeSection) records() map[string]map[string]string {
+	records := make(map[string]map[string]string)
+	legacy := make(map[string]string)
+	first := s.firstRecord()
+	for i := 0; i < first; i++ {
+		line := strings.TrimSpace(s.lines[i])
+		if strings.HasPrefix(line, "|") {
+			i += parseRDSTable(s.lines[i:first], records) - 1
+		} else if key, value, ok := parseRDSField(line); ok {
+			legacy[key] = value
+		}
+	}
+	for i := first; i < len(s.lines); i++ {
+		name, ok := s.names[i]
+		if !ok {
+			continue
+		}
+		record := records[name]
+		if record == nil {
+			record = map[string]string{"Filename": name}
+			records[name] = record
+		}
+		for _, line := range s.lines[i+1 : s.recordEnd(i)] {
+			if key, value, ok := parseRDSField(strings.TrimSpace(line)); ok && key != "Filename" {
+				record[key] = value
+			}
+		}
+	}
+	if name := legacy["Filename"]; name != "" {
+		if _, ok := records[name]; !ok {
+			records[name] = legacy
+		}
+	}
+	return records
+}
+
+// parseRDSField разбирает строку списка "- **Ключ**: `значение`".
+func parseRDSField(line string) (string, string, bool) {
+	if !strings.HasPrefix(line, "- **") || !strings.Contains(line, "**: `") || !strings.HasSuffix(line, "`") {
+		return "", "", false
+	}
+	key, value, _ := strings.Cut(strings.TrimPrefix(line, "- **"), "**: `")
+	return strings.TrimSpace(key), strings.TrimSpace(strings.TrimSuffix(value, "`")), true
+}
+
+// formatRDSField возвращает строку списка для поля записи.
+func formatRDSField(key, value, eol string) string {
+	return fmt.Sprintf("- **%s**: `%s`", key, value) + eol
+}
+
+// orderRDSKeys возвращает ключи fields в детерминированном порядке: сначала
+// в порядке order, затем rdsLeadingKeys, хеш-суммы в порядке HashFields и
+// остальные по алфавиту.
+func orderRDSKeys(fields map[string]string, order []string) []string {
+	keys := make([]string, 0, len(fields))
+	seen := make(map[string]bool, len(fields))
+	add := func(key string) {
+		if _, ok := fields[key]; ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range order {
+		add(key)
+	}
+	for _, key := range rdsLeadingKeys {
+		add(key)
+	}
+	for _, key := range HashFields {
+		add(key)
+	}
+	var rest []string
+	for key := range fields {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// writeRDSRecord записывает поля fields файла name в раздел RDS файла
+// readmePath. order задаёт порядок новых полей (см. orderRDSKeys). Записи
+// других файлов и текст вне раздела не меняются; запись в прежнем формате
+// без подраздела переносится в подраздел.
+func writeRDSRecord(readmePath, name string, fields map[string]string, order []string) error {
+	if name == "" {
+		return fmt.Errorf("RDS record has no Filename")
+	}
+	source, err := os.ReadFile(readmePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading README.md: %w", err)
+	}
+	record := make(map[string]string, len(fields))
+	for key, value := range fields {
+		if key != "Filename" && key != "File name" {
+			record[key] = strings.TrimSpace(value)
+		}
+	}
+	keys := orderRDSKeys(record, order)
+
+	section := parseReadme(source)
+	var out bytes.Buffer
+	if !section.found {
+		out.Write(source)
+		if len(source) > 0 {
+			if !bytes.HasSuffix(source, []byte("\n")) {
+				out.WriteString(section.eol)
+			}
+			out.WriteString(section.eol)
+		}
+		out.WriteString("## " + rdsHeading + section.eol)
+		out.WriteString(strings.Join(rdsBlock(name, record, keys, section.eol), ""))
+	} else {
+		out.Write(source[:section.start])
+		if section.start > 0 && source[section.start-1] != '\n' {
+			out.WriteString(section.eol)
+		}
+		out.WriteString(strings.Join(section.update(name, record, keys), ""))
+		out.Write(source[section.end:])
+	}
+	if bytes.Equal(out.Bytes(), source) {
+		return nil
+	}
+	if err := writeFileAtomic(readmePath, out.Bytes()); err != nil {
+		return fmt.Errorf("error writing README.md: %w", err)
+	}
+	return nil
+}
+
+// rdsBlock возвращает подраздел файла name.
+func rdsBlock(name string, fields map[string]string, keys []string, eol string) []string {
+	block := []string{"### " + name + eol}
+	for _, key := range keys {
+		block = append(block, formatRDSField(key, fields[key], eol))
+	}
+	return block
+}
+
+// update возвращает строки раздела с записью файла name. Меняются только
+// строки полей этой записи; прочий текст раздела сохраняется.
+func (s *readmeSection) update(name string, fields map[string]string, keys []string) []string {
+	lines := append([]string{}, s.lines...)
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines[n-1] += s.eol
+	}
+	first := s.firstRecord()
+
+	// Таблица записей
+	for i := 0; i < first; i++ {
+		if !strings.HasPrefix(strings.TrimSpace(lines[i]), "|") {
+			continue
+		}
+		table, n := readRDSTable(lines[i:first])
+		if table.filenameColumn() < 0 {
+			i += n - 1
+			continue
+		}
+		updated := append([]string{}, lines[:i]...)
+		updated = append(updated, table.set(name, fields, keys).lines(s.eol)...)
+		return append(updated, lines[i+n:]...)
+	}
+
+	// Прежний формат: список полей без подраздела
+	legacyName := ""
+	var legacy []int
+	for i := 0; i < first; i++ {
+		if key, value, ok := parseRDSField(strings.TrimSpace(lines[i])); ok {
+			legacy = append(legacy, i)
+			if key == "Filename" {
+				legacyName = value
+			}
+		}
+	}
+	if legacyName == name {
+		return updateRDSFields(lines, 0, first, fields, keys, s.eol)
+	}
+
+	// Подраздел файла name
+	for i := first; i < len(lines); i++ {
+		if s.names[i] == name {
+			return updateRDSFields(lines, i+1, s.recordEnd(i), fields, keys, s.eol)
+		}
+	}
+
+	// Новый подраздел в конце раздела. Запись в прежнем формате переносится
+	// в свой подраздел перед ним.
+	var blocks []string
+	if legacyName != "" {
+		blocks = append(blocks, "### "+legacyName+s.eol)
+		for _, i := range legacy {
+			if key, _, _ := parseRDSField(strings.TrimSpace(lines[i])); key != "Filename" {
+				blocks = append(blocks, lines[i])
+			}
+		}
+		for j := len(legacy) - 1; j >= 0; j-- {
+			lines = append(lines[:legacy[j]], lines[legacy[j]+1:]...)
+		}
+		blocks = append(blocks, s.eol)
+	}
+	blocks = append(blocks, rdsBlock(name, fields, keys, s.eol)...)
+
+	last := len(lines)
+	for last > 0 && strings.TrimSpace(lines[last-1]) == "" {
+		last--
+	}
+	updated := append([]string{}, lines[:last]...)
+	if last > 0 {
+		updated = append(updated, s.eol)
+	}
+	updated = append(updated, blocks...)
+	trailing := lines[last:]
+	if len(trailing) == 0 && !s.last {
+		trailing = []string{s.eol} // Пустая строка перед следующим разделом
+	}
+	return append(updated, trailing...)
+}
+
+// updateRDSFields обновляет строки полей записи в lines[from:to]: значения
+// полей меняются на месте, поля, которых больше нет, удаляются, новые
+// добавляются после последнего поля в порядке keys.
+func updateRDSFields(lines []string, from, to int, fields map[string]string, keys []string, eol string) []string {
+	written := make(map[string]bool, len(fields))
+	updated := append([]string{}, lines[:from]...)
+	insert := len(updated)
+	for i := from; i < to; i++ {
+		key, _, ok := parseRDSField(strings.TrimSpace(lines[i]))
+		switch {
+		case !ok:
+			updated = append(updated, lines[i])
+			continue
+		case key == "Filename":
+			updated = append(updated, lines[i])
+		case fields[key] != "" && !written[key]:
+			updated = append(updated, formatRDSField(key, fields[key], lineEOL(lines[i], eol)))
+			written[key] = true
+		default:
+			continue // Поля нет в новой записи
+		}
+		insert = len(updated)
+	}
+	var added []string
+	for _, key := range keys {
+		if !written[key] && fields[key] != "" {
+			added = append(added, formatRDSField(key, fields[key], eol))
+		}
+	}
+	tail := append(added, updated[insert:]...)
+	updated = append(updated[:insert], tail...)
+	return append(updated, lines[to:]...)
+}
+
+// lineEOL возвращает перевод строки line или eol, если его нет.
+func lineEOL(line, eol string) string {
+	if strings.HasSuffix(line, "\r\n") {
+		return "\r\n"
+	} else if strings.HasSuffix(line, "\n") {
+		return "\n"
+	}
+	return eol
+}
+
+// rdsTable - таблица записей RDS.
+type rdsTable struct {
+	columns []string
+	rows    [][]string
+	raw     []string // Исходные строки: заголовок, разделитель и строки таблицы
+}
+
+// readRDSTable читает таблицу, начинающуюся с первой строки lines, и
+// возвращает её и число занятых ею строк.
+func readRDSTable(lines []string) (rdsTable, int) {
+	var table rdsTable
+	n := 0
+	for ; n < len(lines); n++ {
+		line := strings.TrimSpace(lines[n])
+		if !strings.HasPrefix(line, "|") {
+			break
+		}
+		table.raw = append(table.raw, lines[n])
+		cells := splitTableRow(line)
+		switch {
+		case n == 0:
+			table.columns = cells
+		case n == 1 && isTableSeparator(cells):
+		default:
+			table.rows = append(table.rows, cells)
+		}
+	}
+	return table, n
+}
+
+// filenameColumn возвращает номер столбца с именем файла или -1.
+func (t rdsTable) filenameColumn() int {
+	for i, column := range t.columns {
+		if strings.EqualFold(column, "Filename") || strings.EqualFold(column, "File") {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseRDSTable добавляет строки таблицы в records и возвращает число строк
+// таблицы.
+func parseRDSTable(lines []string, records map[string]map[string]string) int {
+	table, n := readRDSTable(lines)
+	nameColumn := table.filenameColumn()
+	if nameColumn < 0 {
+		return n
+	}
+	for _, row := range table.rows {
+		if nameColumn >= len(row) || row[nameColumn] == "" {
+			continue
+		}
+		name := row[nameColumn]
+		record := map[string]string{"Filename": name}
+		for i, value := range row {
+			if i < len(table.columns) && i != nameColumn && value != "" {
+				record[table.columns[i]] = value
+			}
+		}
+		records[name] = record
+	}
+	return n
+}
+
+// splitTableRow разбивает строку таблицы Markdown на ячейки без обратных
+// кавычек и экранирования "\|".
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.Trim(strings.TrimSpace(cell.String()), "`"))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.Trim(strings.TrimSpace(cell.String()), "`"))
+}
+
+func isTableSeparator(cells []string) bool {
+	for _, cell := range cells {
+		if strings.Trim(cell, ":- ") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// set заменяет или добавляет строку файла name. Новые поля добавляются
+// столбцами в конец таблицы в порядке keys.
+func (t rdsTable) set(name string, fields map[string]string, keys []string) rdsTable {
+	nameColumn := t.filenameColumn()
+	var added []string
+	for _, key := range keys {
+		if !containsString(t.columns, key) {
+			added = append(added, key)
+		}
+	}
+	t.columns = append(t.columns, added...)
+	if len(added) > 0 {
+		t.raw = nil // Все строки таблицы получают новые столбцы
+	}
+
+	row := make([]string, len(t.columns))
+	row[nameColumn] = name
+	for i, column := range t.columns {
+		if value, ok := fields[column]; ok {
+			row[i] = value
+		}
+	}
+	rows := append([][]string{}, t.rows...)
+	t.rows = rows
+	for i, existing := range t.rows {
+		if nameColumn < len(existing) && existing[nameColumn] == name {
+			t.rows[i] = row
+			if t.raw != nil {
+				t.raw = append([]string{}, t.raw...)
+				t.raw[len(t.raw)-len(t.rows)+i] = ""
+			}
+			return t
+		}
+	}
+	t.rows = append(t.rows, row)
+	if t.raw != nil {
+		t.raw = append(append([]string{}, t.raw...), "")
+	}
+	return t
+}
+
+// lines возвращает таблицу в формате Markdown. Неизменённые строки
+// остаются в исходном виде.
+func (t rdsTable) lines(eol string) []string {
+	format := func(cells []string) string {
+		escaped := make([]string, len(t.columns))
+		for i := range t.columns {
+			if i < len(cells) {
+				escaped[i] = strings.ReplaceAll(cells[i], "|", `\|`)
+			}
+		}
+		return "| " + strings.Join(escaped, " | ") + " |" + eol
+	}
+	separator := make([]string, len(t.columns))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	if len(t.raw) != len(t.rows)+2 {
+		t.raw = nil // Таблица без разделителя
+	}
+	lines := []string{format(t.columns), format(separator)}
+	if t.raw != nil {
+		lines = t.raw[:2]
+	}
+	for i, row := range t.rows {
+		if t.raw != nil && t.raw[i+2] != "" {
+			lines = append(lines, t.raw[i+2])
+		} else {
+			lines = append(lines, format(row))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestReadme записывает README.md во временную папку и возвращает путь.
+func writeTestReadme(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "README.md")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readTestReadme(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Запись одного поля меняет только его строку, остальной текст, включая
+// переводы строк CRLF, пробелы в конце строк и блоки кода, сохраняется
+// побайтно.
+func TestWriteRDSRecordPreservesBytes(t *testing.T) {
+	source := strings.Join([]string{
+		"# Project  ",
+		"",
+		"```",
+		"## RDS",
+		"### fake.bin",
+		"```",
+		"",
+		"## RDS",
+		"Intro text\t",
+		"",
+		"### app.bin",
+		"- **RDS**: `100`",
+		"- **SHA256**: `aaaa`",
+		"",
+		"### lib.so",
+		"- **RDS**: `200`",
+		"- **SHA256**: `bbbb`",
+		"",
+		"## Changelog",
+		"* fixed  things",
+		"",
+	}, "\r\n")
+	path := writeTestReadme(t, source)
+
+	fields := map[string]string{"Filename": "app.bin", "RDS": "100", "SHA256": "cccc"}
+	if err := writeRDSRecord(path, "app.bin", fields, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(source, "- **SHA256**: `aaaa`", "- **SHA256**: `cccc`", 1)
+	if got := readTestReadme(t, path); got != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+// Запись тех же значений не меняет файл.
+func TestWriteRDSRecordUnchanged(t *testing.T) {
+	source := "## RDS\n### app.bin\n- **RDS**: `100`\n- **SHA256**: `aaaa`\n"
+	path := writeTestReadme(t, source)
+	info, _ := os.Stat(path)
+
+	fields := map[string]string{"RDS": "100", "SHA256": "aaaa"}
+	if err := writeRDSRecord(path, "app.bin", fields, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestReadme(t, path); got != source {
+		t.Errorf("got %q, want %q", got, source)
+	}
+	if after, _ := os.Stat(path); !after.ModTime().Equal(info.ModTime()) {
+		t.Error("README.md must not be rewritten")
+	}
+}
+
+// Новый подраздел добавляется в конец раздела RDS, а не документа.
+func TestWriteRDSRecordNewRecord(t *testing.T) {
+	source := "Intro\n\n## RDS\n### app.bin\n- **RDS**: `100`\n\n## Notes\ntext\n"
+	path := writeTestReadme(t, source)
+
+	if err := writeRDSRecord(path, "lib.so", map[string]string{"RDS": "200"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "Intro\n\n## RDS\n### app.bin\n- **RDS**: `100`\n\n### lib.so\n- **RDS**: `200`\n\n## Notes\ntext\n"
+	if got := readTestReadme(t, path); got != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+// Без раздела RDS он дописывается в конец документа.
+func TestWriteRDSRecordNewSection(t *testing.T) {
+	path := writeTestReadme(t, "# Title\ntext")
+
+	if err := writeRDSRecord(path, "app.bin", map[string]string{"RDS": "100"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Title\ntext\n\n## RDS\n### app.bin\n- **RDS**: `100`\n"
+	if got := readTestReadme(t, path); got != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+// Строки, похожие на заголовки, внутри блоков кода не считаются записями.
+func TestReadmeRecordsIgnoreCodeBlocks(t *testing.T) {
+	path := writeTestReadme(t, strings.Join([]string{
+		"## RDS",
+		"### app.bin",
+		"- **RDS**: `100`",
+		"```",
+		"### fake.bin",
+		"```",
+		"- **SHA256**: `aaaa`",
+		"",
+		"## Other",
+		"### other.bin",
+		"- **RDS**: `300`",
+		"",
+	}, "\n"))
+
+	records, err := NewFileService(filepath.Dir(path), nil).ExtractRDSRecords(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records["app.bin"]["SHA256"] != "aaaa" || records["app.bin"]["Filename"] != "app.bin" {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+// Таблица обновляется в своей строке, остальные строки таблицы не меняются.
+func TestWriteRDSRecordTable(t *testing.T) {
+	source := "## RDS\n| Filename | RDS | SHA256 |\n|---|---|---|\n| app.bin | 100 | aaaa |\n| lib.so | 200 | bbbb |\n"
+	path := writeTestReadme(t, source)
+
+	fields := map[string]string{"RDS": "100", "SHA256": "cccc"}
+	if err := writeRDSRecord(path, "app.bin", fields, nil); err != nil {
+		t.Fatal(err)
+	}
+	got := readTestReadme(t, path)
+	if !strings.Contains(got, "| lib.so | 200 | bbbb |\n") {
+		t.Errorf("other row changed:\n%s", got)
+	}
+
+	records := parseReadme([]byte(got)).records()
+	if records["app.bin"]["SHA256"] != "cccc" || records["lib.so"]["SHA256"] != "bbbb" {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+// Запись в прежнем формате без подраздела переносится в подраздел.
+func TestWriteRDSRecordLegacy(t *testing.T) {
+	path := writeTestReadme(t, "## RDS\n- **Filename**: `app.bin`\n- **RDS**: `100`\n")
+
+	if err := writeRDSRecord(path, "lib.so", map[string]string{"RDS": "200"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	records := parseReadme([]byte(readTestReadme(t, path))).records()
+	if records["app.bin"]["RDS"] != "100" || records["lib.so"]["RDS"] != "200" {
+		t.Errorf("unexpected records %v", records)
+	}
+	if got := readTestReadme(t, path); strings.Contains(got, "**Filename**") {
+		t.Errorf("legacy Filename field must be removed:\n%s", got)
+	}
+}
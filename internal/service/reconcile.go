@@ -0,0 +1,386 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fileStation/pkg/logger"
+)
+
+// Размер и время изменения файла в момент записи хеш-сумм. По ним
+// определяется, описывают ли суммы текущее содержимое файла.
+const (
+	MetadataHashedSize    = "Hashed Size"
+	MetadataHashedModTime = "Hashed Mod Time" // RFC 3339 с наносекундами, UTC
+)
+
+// JobKindReconcile - задача сверки метаданных с файлами.
+const JobKindReconcile = "reconcile"
+
+// ReconcileOptions - параметры сверки метаданных с файлами.
+type ReconcileOptions struct {
+	Prefix        string // Папка сверки
+	DeleteOrphans bool   // Удалить метаданные файлов, которых больше нет
+	HashMissing   bool   // Рассчитать хеш-суммы файлов без метаданных
+	RefreshStale  bool   // Пересчитать хеш-суммы изменённых файлов
+	SignMissing   bool   // Подписать файлы без метаданных после расчёта хеш-сумм
+	MigrateHashes bool   // Заменить устаревшие значения BLAKE2sp, не совпавшие с RDS
+}
+
+// ReconcileStale - файл, изменённый после расчёта хеш-сумм.
+type ReconcileStale struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	HashedSize int64     `json:"hashed_size,omitempty"` // 0 - размер при расчёте неизвестен
+	ModTime    time.Time `json:"mod_time"`
+	// Время изменения файла при расчёте хеш-сумм, а для метаданных прежних
+	// версий - время их последней записи
+	HashedModTime time.Time `json:"hashed_mod_time"`
+}
+
+// ReconcileResult - итог сверки метаданных с файлами.
+type ReconcileResult struct {
+	Path      string           `json:"path"`
+	Scanned   int              `json:"scanned"`
+	Orphans   []string         `json:"orphans"` // Файлы, метаданные которых остались без них
+	Missing   []string         `json:"missing"` // Файлы без хеш-сумм в метаданных
+	Stale     []ReconcileStale `json:"stale"`
+	Deleted   int              `json:"deleted"`
+	Hashed    int              `json:"hashed"`
+	Refreshed int              `json:"refreshed"`
+	Migrated  int              `json:"migrated"` // Устаревшие BLAKE2sp, заменённые настоящими
+	Failed    []string         `json:"failed"`
+}
+
+// reconcileSkipped сообщает, что файл не требует собственных хеш-сумм:
+// скрытые файлы, отсоединённые подписи и подписанный файл контрольных сумм
+// папки.
+func (fs *FileService) reconcileSkipped(name string) bool {
+	if _, ok := SignatureTarget(name); ok || strings.HasPrefix(name, ".") {
+		return true
+	}
+	return fs.signer != nil && name == fs.signer.manifest
+}
+
+// Reconcile сверяет метаданные папки с файлами на диске: находит метаданные
+// удалённых или переименованных в обход сервиса файлов, файлы без хеш-сумм и
+// файлы, изменённые после их расчёта (размер отличается от сохранённого или
+// файл изменён позже последней записи метаданных). По opts удаляет лишние
+// метаданные и рассчитывает хеш-суммы. Изменённые файлы не подписываются:
+// их содержимое мог подменить кто угодно, и подтвердить его ключом сервера
+// можно только явным пересчётом после входа. Прогресс - в байтах хешируемых
+// файлов.
+func (fs *FileService) Reconcile(ctx context.Context, opts ReconcileOptions, user string, progress ProgressFunc) (ReconcileResult, error) {
+	prefix := path.Clean("/" + opts.Prefix)
+	result := ReconcileResult{Path: prefix, Orphans: []string{}, Missing: []string{}, Stale: []ReconcileStale{}, Failed: []string{}}
+	root := fs.GetFullPath(prefix)
+	baseDir := filepath.Clean(fs.baseDir)
+
+	// Файлы, для которых рассчитываются хеш-суммы; true - пересчёт изменённого
+	hashQueue := make(map[string]bool)
+	var migrateQueue []string
+	var total int64
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == baseDir {
+			return nil
+		}
+		dir, name := filepath.Split(p)
+		if name, ok := fs.store.owner(d); ok {
+			filePath := filepath.Join(dir, name)
+			if _, err := os.Lstat(filePath); os.IsNotExist(err) {
+				result.Orphans = append(result.Orphans, fs.relativePath(filePath))
+			}
+		}
+		if d.IsDir() {
+			if p != root && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || IsMetaFile(name) || fs.reconcileSkipped(name) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		result.Scanned++
+
+		metadata, err := fs.loadMetadata(p)
+		if err != nil || !hasStoredHashes(metadata) {
+			result.Missing = append(result.Missing, fs.relativePath(p))
+			if opts.HashMissing {
+				hashQueue[p] = false
+				total += info.Size()
+			}
+			return nil
+		}
+		written, err := fs.store.modTime(p)
+		if err != nil {
+			return nil
+		}
+		if !hashesStale(info, metadata, written) {
+			if opts.MigrateHashes && legacyBLAKE2spCandidate(metadata) {
+				migrateQueue = append(migrateQueue, p)
+				total += info.Size()
+			}
+			return nil
+		}
+		hashedSize, _ := strconv.ParseInt(metadata[MetadataHashedSize], 10, 64)
+		hashedModTime, err := time.Parse(time.RFC3339Nano, metadata[MetadataHashedModTime])
+		if err != nil {
+			hashedModTime = written
+		}
+		result.Stale = append(result.Stale, ReconcileStale{
+			Path:          fs.relativePath(p),
+			Size:          info.Size(),
+			HashedSize:    hashedSize,
+			ModTime:       info.ModTime().UTC(),
+			HashedModTime: hashedModTime.UTC(),
+		})
+		if opts.RefreshStale {
+			hashQueue[p] = true
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if opts.DeleteOrphans {
+		for _, p := range result.Orphans {
+			deleted, err := fs.deleteOrphanMetadata(fs.GetFullPath(p), user)
+			if err != nil {
+				logger.Warningf("Error deleting orphaned metadata of %s: %v", p, err)
+				result.Failed = append(result.Failed, p)
+				continue
+			}
+			if deleted {
+				result.Deleted++
+			}
+		}
+	}
+
+	var done int64
+	for _, p := range migrateQueue {
+		base := done
+		fileProgress := func(n, _ int64) {
+			if progress != nil {
+				progress(base+n, total)
+			}
+		}
+		if info, err := os.Stat(p); err == nil {
+			done += info.Size()
+		}
+		migrated, err := fs.migrateLegacyBLAKE2sp(ctx, p, user, fileProgress)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return result, ctxErr
+			}
+			logger.Warningf("Error checking BLAKE2sp of %s: %v", p, err)
+			result.Failed = append(result.Failed, fs.relativePath(p))
+			continue
+		}
+		if migrated {
+			result.Migrated++
+		}
+	}
+
+	queue := make([]string, 0, len(hashQueue))
+	for p := range hashQueue {
+		queue = append(queue, p)
+	}
+	sort.Strings(queue)
+	signed := make(map[string][]string)
+	for _, p := range queue {
+		base := done
+		fileProgress := func(n, _ int64) {
+			if progress != nil {
+				progress(base+n, total)
+			}
+		}
+		if info, err := os.Stat(p); err == nil {
+			done += info.Size()
+		}
+		if err := fs.reconcileHashes(ctx, p, user, fileProgress); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return result, ctxErr
+			}
+			logger.Warningf("Error calculating hashes of %s: %v", p, err)
+			result.Failed = append(result.Failed, fs.relativePath(p))
+			continue
+		}
+		if hashQueue[p] {
+			result.Refreshed++
+			continue
+		}
+		result.Hashed++
+		if opts.SignMissing {
+			signed[filepath.Dir(p)] = append(signed[filepath.Dir(p)], filepath.Base(p))
+		}
+	}
+	for dir, names := range signed {
+		if err := fs.PublishSignatures(dir, names); err != nil {
+			logger.Warningf("Error signing files in %s: %v", dir, err)
+		}
+	}
+	return result, nil
+}
+
+// reconcileHashes рассчитывает хеш-суммы файла и заново извлекает метаданные
+// его содержимого.
+func (fs *FileService) reconcileHashes(ctx context.Context, fullPath, user string, progress ProgressFunc) error {
+	hashes, err := fs.RecalculateHashesContext(ctx, fullPath, progress)
+	if err != nil {
+		return err
+	}
+	if err := fs.StoreHashes(fullPath, hashes, MetadataActor{User: user, Source: MetadataSourceReconcile}); err != nil {
+		return err
+	}
+	if _, err := fs.ExtractContentMetadata(fullPath, user); err != nil {
+		logger.Warningf("Error extracting content metadata of %s: %v", fullPath, err)
+	}
+	return nil
+}
+
+// legacyBLAKE2spCandidate сообщает, что сохранённый BLAKE2sp не совпадает с
+// RDS и может быть значением BLAKE2s, которое сохранялось под этим именем
+// раньше.
+func legacyBLAKE2spCandidate(metadata map[string]string) bool {
+	stored, expected := metadata["BLAKE2sp"], metadata["RDS BLAKE2sp"]
+	return stored != "" && expected != "" && !SameHash("BLAKE2sp", stored, expected)
+}
+
+// migrateLegacyBLAKE2sp заменяет сохранённый BLAKE2sp файла настоящим, если
+// сохранено значение BLAKE2s того же содержимого. Подпись и результат
+// проверки целостности при этом сохраняются.
+func (fs *FileService) migrateLegacyBLAKE2sp(ctx context.Context, fullPath, user string, progress ProgressFunc) (bool, error) {
+	stored, err := fs.loadMetadata(fullPath)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return false, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("error reading file info: %w", err)
+	}
+
+	algorithm, _ := LookupHashAlgorithm("BLAKE2sp")
+	hasher := &FileHasher{}
+	hasher.add(algorithm)
+	hasher.add(legacyBLAKE2sp)
+	counter := &progressCounter{total: info.Size(), report: progress}
+	if _, err := io.Copy(hasher, newProgressReader(ctx, file, counter)); err != nil {
+		return false, fmt.Errorf("error calculating hashes: %w", err)
+	}
+	sums := hasher.Sums()
+	if !SameHash("BLAKE2sp", stored["BLAKE2sp"], sums[legacyBLAKE2sp.Name]) {
+		return false, nil
+	}
+	_, err = fs.updateMetadata(fullPath, metadataUpdate{
+		set:     map[string]string{"BLAKE2sp": sums["BLAKE2sp"]},
+		relabel: true,
+		actor:   MetadataActor{User: user, Source: MetadataSourceReconcile},
+	})
+	return err == nil, err
+}
+
+// deleteOrphanMetadata удаляет метаданные отсутствующего файла filePath.
+// Если файл успел появиться снова, метаданные сохраняются.
+func (fs *FileService) deleteOrphanMetadata(filePath, user string) (bool, error) {
+	unlock := fs.locks.lock(filePath)
+	defer unlock()
+
+	if _, err := os.Lstat(filePath); !os.IsNotExist(err) {
+		return false, nil
+	}
+	// Повреждённые метаданные удаляются так же, как и прочитанные
+	before, _ := fs.loadMetadata(filePath)
+	if err := fs.store.remove(filePath); err != nil {
+		return false, err
+	}
+	fs.unindexMetadata(filePath)
+	fs.recordHistory(filePath, metadataUpdate{actor: MetadataActor{User: user, Source: MetadataSourceReconcile}},
+		&metadataResult{before: before, after: map[string]string{}, revision: noMetadataRevision})
+	return true, nil
+}
+
+// ScheduleReconcile ставит задачу сверки метаданных с файлами каждые
+// interval, пока не отменён ctx. Новая задача не ставится, пока не завершена
+// предыдущая.
+func (fs *FileService) ScheduleReconcile(ctx context.Context, jobs *JobService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if jobRunning(jobs, JobKindReconcile) {
+			continue
+		}
+		if _, err := jobs.Submit(JobKindReconcile, "", nil); err != nil {
+			logger.Errorf("Error scheduling metadata reconciliation: %v", err)
+		}
+	}
+}
+
+// reconcileJob сверяет метаданные с файлами; параметры path, delete_orphans,
+// hash_missing и refresh_stale (true/false) переопределяют настройки из
+// конфигурации. Подпись файлов задаётся только конфигурацией; устаревшие
+// значения BLAKE2sp заменяются всегда.
+func (fs *FileService) reconcileJob(ctx context.Context, jc *JobControl) error {
+	opts := fs.reconcileOptions
+	opts.Prefix = jc.Param("path")
+	opts.MigrateHashes = true
+	for param, option := range map[string]*bool{
+		"delete_orphans": &opts.DeleteOrphans,
+		"hash_missing":   &opts.HashMissing,
+		"refresh_stale":  &opts.RefreshStale,
+	} {
+		if value := jc.Param(param); value != "" {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New("invalid " + param)
+			}
+			*option = enabled
+		}
+	}
+
+	result, err := fs.Reconcile(ctx, opts, jc.User(), jc.Progress)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Metadata reconciliation finished: %d scanned, %d orphaned, %d missing, %d stale, %d deleted, %d hashed, %d refreshed",
+		result.Scanned, len(result.Orphans), len(result.Missing), len(result.Stale), result.Deleted, result.Hashed, result.Refreshed)
+	return jc.SetResult(result)
+}
+
+// SetReconcileOptions задаёт действия сверки метаданных по умолчанию.
+func (fs *FileService) SetReconcileOptions(opts ReconcileOptions) {
+	fs.reconcileOptions = opts
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Изменённый вне сервера файл остаётся устаревшим после правки его
+// метаданных, а обновление сумм снимает отметку.
+func TestReconcileStaleAfterMetadataEdit(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	path := newHashedTestFile(t, fs, "a.txt", "hello")
+	newHashedTestFile(t, fs, "b.txt", "world")
+
+	writeTestFile(t, path, []byte("HELLO"))
+	changed := time.Now().Add(-time.Hour)
+	os.Chtimes(path, changed, changed)
+	if _, err := fs.EditMetadata(path, map[string]string{"Notes": "edited"}, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := fs.Reconcile(context.Background(), ReconcileOptions{}, "tester", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Scanned != 2 || len(result.Stale) != 1 || result.Stale[0].Path != "/a.txt" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if stale := result.Stale[0]; stale.Size != 5 || stale.HashedSize != 5 || stale.HashedModTime.Equal(stale.ModTime) {
+		t.Errorf("unexpected stale entry %+v", stale)
+	}
+
+	result, err = fs.Reconcile(context.Background(), ReconcileOptions{RefreshStale: true}, "tester", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Refreshed != 1 {
+		t.Errorf("refreshed %d files, want 1", result.Refreshed)
+	}
+	if result, _ = fs.Reconcile(context.Background(), ReconcileOptions{}, "tester", nil); len(result.Stale) != 0 {
+		t.Errorf("still stale after refresh: %+v", result.Stale)
+	}
+	if fs.StoredHashes(path) == nil {
+		t.Error("refreshed hashes must be trusted")
+	}
+}
+
+func TestReconcileOrphansAndMissing(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	path := newHashedTestFile(t, fs, "gone.txt", "data")
+	os.Remove(path)
+	writeTestFile(t, filepath.Join(fs.baseDir, "dir", "new.txt"), []byte("new"))
+	writeTestFile(t, filepath.Join(fs.baseDir, "dir", "new.txt.sig"), []byte("sig"))
+
+	result, err := fs.Reconcile(context.Background(), ReconcileOptions{}, "tester", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Orphans) != 1 || result.Orphans[0] != "/gone.txt" {
+		t.Errorf("orphans %v, want [/gone.txt]", result.Orphans)
+	}
+	if len(result.Missing) != 1 || result.Missing[0] != "/dir/new.txt" {
+		t.Errorf("missing %v, want [/dir/new.txt]", result.Missing)
+	}
+	if _, err := os.Stat(MetaFilePath(path)); err != nil {
+		t.Error("report-only run must not delete metadata")
+	}
+
+	result, err = fs.Reconcile(context.Background(), ReconcileOptions{DeleteOrphans: true, HashMissing: true}, "tester", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Deleted != 1 || result.Hashed != 1 {
+		t.Errorf("deleted %d, hashed %d, want 1 and 1", result.Deleted, result.Hashed)
+	}
+	if _, err := os.Stat(MetaFilePath(path)); !os.IsNotExist(err) {
+		t.Error("orphaned metadata must be deleted")
+	}
+	if fs.StoredHashes(filepath.Join(fs.baseDir, "dir", "new.txt")) == nil {
+		t.Error("missing file must be hashed")
+	}
+}
@@ -0,0 +1,893 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html"
+)
+
+// Форматы отчётов производителей.
+const (
+	ReportHTML = "html"
+	ReportJSON = "json"
+	ReportXML  = "xml"
+	ReportPDF  = "pdf"  // Текстовый слой PDF
+	ReportText = "text" // Текст, например вывод pdftotext
+)
+
+// maxReportSize - отчёты больше этого размера не разбираются.
+const maxReportSize = 32 << 20
+
+// defaultReportMatch - имена файлов, которые разбираются парсером формата,
+// если в конфигурации не задано match.
+var defaultReportMatch = map[string][]string{
+	ReportHTML: {"*.html", "*.htm"},
+	ReportJSON: {"*.json"},
+	ReportXML:  {"*.xml"},
+	ReportPDF:  {"*.pdf"},
+	ReportText: {"*.txt"},
+}
+
+// ReportParser описывает разбор отчёта производителя в поля раздела RDS
+// файла README.md.
+type ReportParser struct {
+	Name   string
+	Format string        // html, json, xml, pdf или text
+	Match  []string      // Шаблоны имён файлов отчёта
+	Detect string        // Строка, которая должна встречаться в отчёте
+	Fields []ReportField // Поля отчёта
+	Hashes string        // Селектор блока с хеш-суммами; пустой - весь отчёт
+}
+
+// ReportField - правило извлечения одного поля из отчёта.
+//
+// Selector выбирает элемент отчёта: CSS-селектор вида "div#artifacts p.date"
+// для HTML, путь "report.rds.number" для JSON, путь "report/rds/@number" для
+// XML. В PDF и текстовых отчётах селекторы не поддерживаются. Label - подпись
+// в тексте элемента: значением поля становится остаток строки после неё.
+// Pattern - регулярное выражение, первая группа которого (или всё совпадение)
+// становится значением.
+type ReportField struct {
+	Field    string
+	Selector string
+	Label    string
+	Pattern  string
+	Required bool
+}
+
+// DefaultReportParser - отчёт о проверке в формате HTML, который разбирался
+// до появления настраиваемых парсеров.
+var DefaultReportParser = ReportParser{
+	Name:   "rds-html",
+	Format: ReportHTML,
+	Match:  []string{"*.html"},
+	Fields: []ReportField{
+		{Field: "Дата проверки", Selector: "p.report-date", Label: "Дата проверки:", Required: true},
+		{Field: "RDS", Selector: "p.report-rds_number", Label: "Основание:", Required: true},
+		{Field: "Ссылка на RDS", Selector: "p.report-rds_link", Label: "Ссылка на RDS:", Required: true},
+		{Field: "Filename", Selector: "div#artifacts", Label: "Имя:", Required: true},
+	},
+	Hashes: "div#artifacts",
+}
+
+// ReportResult - результат разбора отчёта для загрузившего его пользователя.
+type ReportResult struct {
+	File     string   `json:"file"`
+	Parser   string   `json:"parser"`
+	Applied  bool     `json:"applied"`          // Данные записаны в README.md
+	Target   string   `json:"target,omitempty"` // Файл, описанный отчётом
+	Fields   []string `json:"fields,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	dir      string
+}
+
+// reportParser - проверенный парсер с откомпилированными выражениями.
+type reportParser struct {
+	ReportParser
+	patterns  []*regexp.Regexp
+	selectors [][]htmlSelector
+	hashes    []htmlSelector
+}
+
+// reportDocument - разобранный отчёт.
+type reportDocument interface {
+	// Select возвращает текст первого элемента, подходящего под селектор.
+	Select(selector string) (string, bool)
+	// Text возвращает текст всего отчёта.
+	Text() string
+}
+
+// SetReportParsers задаёт парсеры отчётов. Они проверяются по порядку, и
+// отчёт разбирает первый подходящий по имени файла и Detect. Без
+// disableBuiltin после них проверяется DefaultReportParser.
+func (fs *FileService) SetReportParsers(parsers []ReportParser, disableBuiltin bool) error {
+	if !disableBuiltin {
+		parsers = append(parsers, DefaultReportParser)
+	}
+	compiled := make([]*reportParser, 0, len(parsers))
+	for i, parser := range parsers {
+		if parser.Name == "" {
+			parser.Name = fmt.Sprintf("parser %d", i+1)
+		}
+		parser.Format = strings.ToLower(parser.Format)
+		if _, ok := defaultReportMatch[parser.Format]; !ok {
+			return fmt.Errorf("report parser %q: unknown format %q", parser.Name, parser.Format)
+		}
+		if len(parser.Match) == 0 {
+			parser.Match = defaultReportMatch[parser.Format]
+		}
+		for _, match := range parser.Match {
+			if _, err := filepath.Match(match, ""); err != nil {
+				return fmt.Errorf("report parser %q: invalid match %q: %w", parser.Name, match, err)
+			}
+		}
+		p := &reportParser{ReportParser: parser}
+		for _, field := range parser.Fields {
+			if field.Field == "" {
+				return fmt.Errorf("report parser %q: field name is required", parser.Name)
+			}
+			if field.Selector != "" && (parser.Format == ReportPDF || parser.Format == ReportText) {
+				return fmt.Errorf("report parser %q: field %q: selectors are not supported for %s reports", parser.Name, field.Field, parser.Format)
+			}
+			var pattern *regexp.Regexp
+			if field.Pattern != "" {
+				var err error
+				if pattern, err = regexp.Compile(field.Pattern); err != nil {
+					return fmt.Errorf("report parser %q: field %q: invalid pattern: %w", parser.Name, field.Field, err)
+				}
+			}
+			p.patterns = append(p.patterns, pattern)
+			var selector []htmlSelector
+			if parser.Format == ReportHTML && field.Selector != "" {
+				var err error
+				if selector, err = parseHTMLSelector(field.Selector); err != nil {
+					return fmt.Errorf("report parser %q: field %q: %w", parser.Name, field.Field, err)
+				}
+			}
+			p.selectors = append(p.selectors, selector)
+		}
+		if parser.Hashes != "" {
+			if parser.Format == ReportPDF || parser.Format == ReportText {
+				return fmt.Errorf("report parser %q: selectors are not supported for %s reports", parser.Name, parser.Format)
+			}
+			if parser.Format == ReportHTML {
+				var err error
+				if p.hashes, err = parseHTMLSelector(parser.Hashes); err != nil {
+					return fmt.Errorf("report parser %q: hashes: %w", parser.Name, err)
+				}
+			}
+		}
+		compiled = append(compiled, p)
+	}
+	fs.reportParsers = compiled
+	return nil
+}
+
+// matchesName сообщает, подходит ли имя файла под шаблоны парсера.
+func (p *reportParser) matchesName(name string) bool {
+	for _, match := range p.Match {
+		if ok, _ := filepath.Match(strings.ToLower(match), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractReportMetadata разбирает отчёт производителя fullPath подходящим
+// парсером и записывает найденные поля в раздел RDS файла README.md папки.
+// Если парсер не подошёл, возвращает nil. Ошибки разбора и отсутствующие
+// поля возвращаются в Warnings, чтобы их увидел загрузивший отчёт.
+func (fs *FileService) ExtractReportMetadata(fullPath string) (*ReportResult, error) {
+	name := filepath.Base(fullPath)
+	var candidates []*reportParser
+	for _, parser := range fs.reportParsers {
+		if parser.matchesName(name) {
+			candidates = append(candidates, parser)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report: %w", err)
+	}
+	if info.Size() > maxReportSize {
+		return nil, nil
+	}
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report: %w", err)
+	}
+
+	var parser *reportParser
+	for _, p := range candidates {
+		if p.Detect == "" || bytes.Contains(data, []byte(p.Detect)) {
+			parser = p
+			break
+		}
+	}
+	if parser == nil {
+		return nil, nil
+	}
+
+	result := &ReportResult{File: name, Parser: parser.Name, dir: filepath.Dir(fullPath)}
+	doc, err := parseReport(parser.Format, data)
+	if err != nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("error parsing %s report: %v", parser.Format, err))
+		return result, nil
+	}
+	metadata, warnings, found := parser.extract(doc)
+	if found == 0 && parser.Detect == "" {
+		return nil, nil // Файл подошёл по имени, но это не отчёт
+	}
+	result.Warnings = append(result.Warnings, warnings...)
+	if metadata == nil {
+		return result, nil
+	}
+
+	result.Target = metadata["Filename"]
+	if result.Target == "" {
+		result.Warnings = append(result.Warnings, "report has no Filename, it was not added to README.md")
+		return result, nil
+	}
+	for key := range metadata {
+		result.Fields = append(result.Fields, key)
+	}
+	sort.Strings(result.Fields)
+
+	// README.md общий для всех файлов папки: отчёты о разных файлах
+	// записываются в него по очереди
+	readmePath := filepath.Join(filepath.Dir(fullPath), "README.md")
+	unlock := fs.locks.lock(readmePath)
+	defer unlock()
+	if err := writeRDSRecord(readmePath, result.Target, metadata, parser.order()); err != nil {
+		return result, err
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// LinkReport переносит поля RDS из README.md в метаданные файла, описанного
+// отчётом. Вызывается после сохранения всех загруженных файлов, поэтому
+// отчёт можно загрузить как вместе с файлом, так и отдельно. user -
+// загрузивший отчёт пользователь.
+func (fs *FileService) LinkReport(report *ReportResult, user string) error {
+	if !report.Applied || report.Target == "" {
+		return nil
+	}
+	if strings.ContainsAny(report.Target, `/\`) || IsMetaFile(report.Target) {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("invalid file name %q in the report", report.Target))
+		return nil
+	}
+	target := filepath.Join(report.dir, report.Target)
+	if info, err := os.Stat(target); os.IsNotExist(err) {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("file %s from the report is not in this folder", report.Target))
+		return nil
+	} else if err != nil {
+		return err
+	} else if info.IsDir() {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%s from the report is a folder", report.Target))
+		return nil
+	}
+	return fs.AddMetadata(target, map[string]string{}, MetadataActor{User: user, Source: MetadataSourceReport})
+}
+
+// order возвращает поля отчёта в порядке конфигурации для записи в
+// README.md.
+func (p *reportParser) order() []string {
+	order := make([]string, 0, len(p.Fields))
+	for _, field := range p.Fields {
+		name := field.Field
+		if algorithm, ok := LookupHashAlgorithm(name); ok {
+			name = algorithm.Name
+		}
+		order = append(order, name)
+	}
+	return order
+}
+
+// extract извлекает поля из отчёта и возвращает число найденных полей и
+// хеш-сумм. Если не найдено обязательное поле или ни одной хеш-суммы,
+// возвращает nil и причину в предупреждениях.
+func (p *reportParser) extract(doc reportDocument) (map[string]string, []string, int) {
+	metadata := make(map[string]string)
+	var warnings []string
+	missing := false
+	for i, field := range p.Fields {
+		value, problem := p.extractField(doc, i)
+		if value == "" {
+			if field.Required {
+				missing = true
+				warnings = append(warnings, fmt.Sprintf("required field %q: %s", field.Field, problem))
+			} else if problem != "not found" {
+				warnings = append(warnings, fmt.Sprintf("field %q: %s", field.Field, problem))
+			}
+			continue
+		}
+		name := field.Field
+		if algorithm, ok := LookupHashAlgorithm(name); ok {
+			name = algorithm.Name
+		}
+		metadata[name] = value
+	}
+
+	var hashText string
+	switch {
+	case p.Hashes == "":
+		hashText = doc.Text()
+	case p.Format == ReportHTML:
+		hashText, _ = doc.(*htmlReport).selectParsed(p.hashes)
+	default:
+		hashText, _ = doc.Select(p.Hashes)
+	}
+	for name, value := range extractHashFields(hashText) {
+		if _, ok := metadata[name]; !ok {
+			metadata[name] = value
+		}
+	}
+	if !hasStoredHashes(metadata) {
+		missing = true
+		warnings = append(warnings, "no hashes found in the report")
+	}
+	if missing {
+		return nil, append(warnings, "report was not added to README.md"), len(metadata)
+	}
+	return metadata, warnings, len(metadata)
+}
+
+// extractField возвращает значение i-го поля или описание, почему его нет.
+func (p *reportParser) extractField(doc reportDocument, i int) (string, string) {
+	field := p.Fields[i]
+	text := ""
+	switch {
+	case field.Selector == "":
+		text = doc.Text()
+	case p.Format == ReportHTML:
+		var ok bool
+		if text, ok = doc.(*htmlReport).selectParsed(p.selectors[i]); !ok {
+			return "", "not found"
+		}
+	default:
+		var ok bool
+		if text, ok = doc.Select(field.Selector); !ok {
+			return "", "not found"
+		}
+	}
+
+	if field.Label != "" {
+		value, ok := labelValue(text, field.Label)
+		if !ok {
+			if field.Selector == "" {
+				return "", "not found"
+			}
+			return "", fmt.Sprintf("label %q not found", field.Label)
+		}
+		text = value
+	}
+	if pattern := p.patterns[i]; pattern != nil {
+		match := pattern.FindStringSubmatch(text)
+		if match == nil {
+			if field.Selector == "" && field.Label == "" {
+				return "", "not found"
+			}
+			return "", fmt.Sprintf("value does not match %q", field.Pattern)
+		}
+		text = match[0]
+		if len(match) > 1 {
+			text = match[1]
+		}
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", "empty value"
+	}
+	return text, ""
+}
+
+// labelValue возвращает остаток первой строки text после подписи label.
+func labelValue(text, label string) (string, bool) {
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, label); i >= 0 {
+			return strings.TrimSpace(line[i+len(label):]), true
+		}
+	}
+	return "", false
+}
+
+// parseReport разбирает отчёт в формате format.
+func parseReport(format string, data []byte) (reportDocument, error) {
+	switch format {
+	case ReportHTML:
+		doc, err := html.Parse(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return &htmlReport{doc: doc}, nil
+	case ReportJSON:
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		return &jsonReport{value: value}, nil
+	case ReportXML:
+		root, err := parseXMLReport(data)
+		if err != nil {
+			return nil, err
+		}
+		return root, nil
+	case ReportPDF:
+		text, err := pdfText(data)
+		if err != nil {
+			return nil, err
+		}
+		return textReport(text), nil
+	default:
+		return textReport(string(data)), nil
+	}
+}
+
+// textReport - отчёт из строк текста.
+type textReport string
+
+func (r textReport) Select(string) (string, bool) { return "", false }
+func (r textReport) Text() string                 { return string(r) }
+
+// pdfText извлекает текстовый слой PDF. Текстовые объекты страницы
+// начинаются с новой строки.
+func pdfText(data []byte) (text string, err error) {
+	// Библиотека сообщает о повреждённых файлах паникой
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid PDF: %v", r)
+		}
+	}()
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() || page.V.Key("Contents").IsNull() {
+			continue
+		}
+		pdfPageText(&sb, page)
+		sb.WriteByte('\n')
+	}
+	return trimLines(sb.String()), nil
+}
+
+// pdfPageText записывает текст страницы в sb. Page.GetPlainText не
+// разделяет текстовые объекты, и метки отчёта сливались бы со значениями
+// соседних строк.
+func pdfPageText(sb *strings.Builder, page pdf.Page) {
+	fonts := make(map[string]pdf.Font)
+	for _, name := range page.Fonts() {
+		fonts[name] = page.Font(name)
+	}
+	var enc pdf.TextEncoding
+	show := func(raw string) {
+		if enc == nil {
+			sb.WriteString(raw)
+		} else {
+			sb.WriteString(enc.Decode(raw))
+		}
+	}
+	pdf.Interpret(page.V.Key("Contents"), func(stk *pdf.Stack, op string) {
+		args := make([]pdf.Value, stk.Len())
+		for i := len(args) - 1; i >= 0; i-- {
+			args[i] = stk.Pop()
+		}
+		switch op {
+		case "BT", "T*":
+			// Новый текстовый объект или строка
+			sb.WriteByte('\n')
+		case "Td", "TD":
+			// Сдвиг по вертикали начинает новую строку
+			if len(args) == 2 && args[1].Float64() != 0 {
+				sb.WriteByte('\n')
+			}
+		case "Tf":
+			enc = nil
+			if len(args) == 2 {
+				if font, ok := fonts[args[0].Name()]; ok {
+					enc = font.Encoder()
+				}
+			}
+		case "Tj", "'", "\"":
+			if len(args) > 0 {
+				if op == "'" || op == "\"" {
+					sb.WriteByte('\n')
+				}
+				show(args[len(args)-1].RawString())
+			}
+		case "TJ":
+			if len(args) == 1 {
+				for j := 0; j < args[0].Len(); j++ {
+					if part := args[0].Index(j); part.Kind() == pdf.String {
+						show(part.RawString())
+					}
+				}
+			}
+		}
+	})
+}
+
+// htmlSelector - простой CSS-селектор: тег, #id и классы.
+type htmlSelector struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+var htmlSelectorPart = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*)?((?:[#.][a-zA-Z0-9_-]+)*)$`)
+
+// parseHTMLSelector разбирает селектор из элементов вида tag#id.class,
+// разделённых пробелами (потомки).
+func parseHTMLSelector(selector string) ([]htmlSelector, error) {
+	var parts []htmlSelector
+	for _, part := range strings.Fields(selector) {
+		match := htmlSelectorPart.FindStringSubmatch(part)
+		if match == nil || part == "" {
+			return nil, fmt.Errorf("unsupported selector %q", selector)
+		}
+		s := htmlSelector{tag: strings.ToLower(match[1])}
+		rest := match[2]
+		for rest != "" {
+			next := strings.IndexAny(rest[1:], "#.")
+			token := rest
+			if next >= 0 {
+				token = rest[:next+1]
+			}
+			if token[0] == '#' {
+				s.id = token[1:]
+			} else {
+				s.classes = append(s.classes, token[1:])
+			}
+			rest = rest[len(token):]
+		}
+		parts = append(parts, s)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return parts, nil
+}
+
+func (s htmlSelector) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode || (s.tag != "" && n.Data != s.tag) {
+		return false
+	}
+	var id, class string
+	for _, a := range n.Attr {
+		switch a.Key {
+		case "id":
+			id = a.Val
+		case "class":
+			class = a.Val
+		}
+	}
+	if s.id != "" && id != s.id {
+		return false
+	}
+	classes := strings.Fields(class)
+	for _, c := range s.classes {
+		if !containsString(classes, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// htmlReport - разобранный HTML-отчёт.
+type htmlReport struct {
+	doc *html.Node
+}
+
+func (r *htmlReport) Select(selector string) (string, bool) {
+	parts, err := parseHTMLSelector(selector)
+	if err != nil {
+		return "", false
+	}
+	return r.selectParsed(parts)
+}
+
+func (r *htmlReport) selectParsed(parts []htmlSelector) (string, bool) {
+	if n := findHTML(r.doc, parts); n != nil {
+		return htmlText(n), true
+	}
+	return "", false
+}
+
+func (r *htmlReport) Text() string {
+	return htmlText(r.doc)
+}
+
+// findHTML возвращает первый в порядке документа элемент, подходящий под
+// последнюю часть селектора, предки которого подходят под предыдущие.
+func findHTML(n *html.Node, parts []htmlSelector) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if parts[0].matches(c) {
+			if len(parts) == 1 {
+				return c
+			}
+			if found := findHTML(c, parts[1:]); found != nil {
+				return found
+			}
+		}
+		if found := findHTML(c, parts); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// htmlBlocks - элементы, после которых в тексте начинается новая строка.
+var htmlBlocks = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "table": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"section": true, "article": true, "dt": true, "dd": true, "pre": true,
+}
+
+// htmlText возвращает текст узла, разбитый на строки по <br> и блочным
+// элементам.
+func htmlText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if n.Data == "script" || n.Data == "style" {
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && htmlBlocks[n.Data] {
+			sb.WriteByte('\n')
+		}
+	}
+	walk(n)
+	return trimLines(sb.String())
+}
+
+// trimLines убирает пробелы по краям строк и пустые строки.
+func trimLines(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// jsonReport - разобранный JSON-отчёт.
+type jsonReport struct {
+	value interface{}
+}
+
+// Select возвращает значение по пути из ключей и индексов массивов через
+// точку, например "artifacts.0.sha256".
+func (r *jsonReport) Select(selector string) (string, bool) {
+	value := r.value
+	for _, key := range strings.Split(selector, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			value = v[i]
+		default:
+			return "", false
+		}
+	}
+	if value == nil {
+		return "", false
+	}
+	return jsonText(value, ""), true
+}
+
+// Text возвращает значения отчёта строками "<ключ>: <значение>".
+func (r *jsonReport) Text() string {
+	return jsonText(r.value, "")
+}
+
+func jsonText(value interface{}, key string) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var lines []string
+		for _, k := range keys {
+			if text := jsonText(v[k], k); text != "" {
+				lines = append(lines, text)
+			}
+		}
+		return strings.Join(lines, "\n")
+	case []interface{}:
+		var lines []string
+		for _, item := range v {
+			if text := jsonText(item, key); text != "" {
+				lines = append(lines, text)
+			}
+		}
+		return strings.Join(lines, "\n")
+	case nil:
+		return ""
+	default:
+		text := fmt.Sprint(v)
+		if f, ok := v.(float64); ok {
+			text = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		if key == "" {
+			return text
+		}
+		return labeledLine(key, text)
+	}
+}
+
+// labeledLine возвращает строку "<имя>: <значение>". Если значение уже
+// содержит подпись ("SHA256: ..."), оно добавляется и отдельной строкой.
+func labeledLine(name, value string) string {
+	line := name + ": " + value
+	if strings.Contains(value, ":") {
+		line += "\n" + value
+	}
+	return line
+}
+
+// xmlReport - элемент разобранного XML-отчёта.
+type xmlReport struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xmlReport
+	text     string
+}
+
+func parseXMLReport(data []byte) (*xmlReport, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	root := &xmlReport{}
+	stack := []*xmlReport{root}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		current := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := &xmlReport{name: t.Name.Local, attrs: t.Attr}
+			current.children = append(current.children, element)
+			stack = append(stack, element)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			current.text += string(t)
+		}
+	}
+	if len(root.children) == 0 {
+		return nil, fmt.Errorf("no root element")
+	}
+	return root, nil
+}
+
+// Select возвращает текст по пути из имён элементов через "/", например
+// "artifact/hashes". Путь может начинаться с любого элемента документа;
+// последний шаг "@имя" выбирает атрибут.
+func (r *xmlReport) Select(selector string) (string, bool) {
+	steps := strings.Split(strings.Trim(selector, "/"), "/")
+	var found *xmlReport
+	var attr string
+	var walk func(*xmlReport) bool
+	walk = func(n *xmlReport) bool {
+		for _, c := range n.children {
+			if c.name == steps[0] {
+				if element, value, ok := c.descend(steps[1:]); ok {
+					found, attr = element, value
+					return true
+				}
+			}
+			if walk(c) {
+				return true
+			}
+		}
+		return false
+	}
+	if !walk(r) {
+		return "", false
+	}
+	if found == nil {
+		return attr, true
+	}
+	if len(found.children) == 0 {
+		return strings.TrimSpace(found.text), true
+	}
+	return found.Text(), true
+}
+
+// descend проходит оставшиеся шаги пути от элемента. Для атрибута
+// возвращает nil и его значение.
+func (r *xmlReport) descend(steps []string) (*xmlReport, string, bool) {
+	if len(steps) == 0 {
+		return r, "", true
+	}
+	if strings.HasPrefix(steps[0], "@") && len(steps) == 1 {
+		for _, a := range r.attrs {
+			if a.Name.Local == steps[0][1:] {
+				return nil, a.Value, true
+			}
+		}
+		return nil, "", false
+	}
+	for _, c := range r.children {
+		if c.name == steps[0] || steps[0] == "*" {
+			if element, value, ok := c.descend(steps[1:]); ok {
+				return element, value, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// Text возвращает атрибуты и значения элементов строками "<имя>: <значение>".
+// У элемента без вложенных элементов это его текст.
+func (r *xmlReport) Text() string {
+	var lines []string
+	for _, a := range r.attrs {
+		lines = append(lines, a.Name.Local+": "+strings.TrimSpace(a.Value))
+	}
+	if len(r.children) == 0 {
+		text := strings.TrimSpace(r.text)
+		if len(lines) == 0 || text == "" {
+			if text != "" {
+				lines = append(lines, text)
+			}
+			return strings.Join(lines, "\n")
+		}
+		return strings.Join(append(lines, labeledLine(r.name, text)), "\n")
+	}
+	for _, c := range r.children {
+		if len(c.children) == 0 && len(c.attrs) == 0 {
+			if text := strings.TrimSpace(c.text); text != "" {
+				lines = append(lines, labeledLine(c.name, text))
+			}
+			continue
+		}
+		if text := c.Text(); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
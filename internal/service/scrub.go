@@ -0,0 +1,396 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fileStation/pkg/logger"
+)
+
+// Поля метаданных с результатом последней проверки целостности.
+const (
+	MetadataVerifiedAt   = "Verified At"
+	MetadataVerifyResult = "Verify Result"
+)
+
+// Результаты проверки целостности.
+const (
+	VerifyOK       = "ok"
+	VerifyMismatch = "mismatch" // Содержимое не совпадает с хеш-суммами
+	VerifyModified = "modified" // Файл изменён после расчёта хеш-сумм
+)
+
+// JobKindScrub - задача проверки целостности файлов.
+const JobKindScrub = "scrub"
+
+// ScrubOptions - параметры проверки целостности.
+type ScrubOptions struct {
+	Prefix      string        // Папка проверки
+	RateLimit   int64         // Ограничение скорости чтения, байт/с; 0 - без ограничения
+	VerifyAfter time.Duration // Файлы, проверенные раньше этого срока, пропускаются
+}
+
+// ScrubFailure - файл, не прошедший проверку.
+type ScrubFailure struct {
+	Path       string    `json:"path"`
+	Result     string    `json:"result"`
+	Fields     []string  `json:"fields,omitempty"` // Несовпавшие хеш-суммы
+	VerifiedAt time.Time `json:"verified_at"`
+}
+
+// ScrubResult - итог одного прохода проверки.
+type ScrubResult struct {
+	Checked  int            `json:"checked"`
+	Skipped  int            `json:"skipped"` // Недавно проверенные файлы
+	Bytes    int64          `json:"bytes"`
+	Failures []ScrubFailure `json:"failures"`
+}
+
+// IntegrityReport - сводка результатов проверки целостности из метаданных.
+type IntegrityReport struct {
+	Path       string         `json:"path"`
+	Files      int            `json:"files"` // Файлы с хеш-суммами
+	Verified   int            `json:"verified"`
+	Unverified int            `json:"unverified"`
+	OK         int            `json:"ok"`
+	Oldest     *time.Time     `json:"oldest_verification,omitempty"`
+	Failures   []ScrubFailure `json:"failures"`
+}
+
+// VerifyFailed сообщает, что последняя проверка файла обнаружила расхождение.
+func VerifyFailed(metadata map[string]string) bool {
+	result := metadata[MetadataVerifyResult]
+	return result != "" && result != VerifyOK
+}
+
+// IntegrityStatus возвращает описание неудачной проверки целостности или
+// пустую строку.
+func IntegrityStatus(metadata map[string]string) string {
+	if !VerifyFailed(metadata) {
+		return ""
+	}
+	return fmt.Sprintf("Integrity check failed (%s) at %s", metadata[MetadataVerifyResult], metadata[MetadataVerifiedAt])
+}
+
+// VerifyFile пересчитывает хеш-суммы файла, сравнивает их с сохранёнными и
+// записывает результат в метаданные. Сохранённые хеш-суммы не изменяются,
+// кроме замены устаревшего значения BLAKE2sp при совпадении содержимого.
+func (fs *FileService) VerifyFile(ctx context.Context, fullPath string, rateLimit int64, progress func(n int64)) (ScrubFailure, error) {
+	failure := ScrubFailure{Result: VerifyOK}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return failure, err
+	}
+	written, err := fs.store.modTime(fullPath)
+	if err != nil {
+		return failure, err
+	}
+	stored, err := fs.loadMetadata(fullPath)
+	if err != nil {
+		return failure, err
+	}
+
+	// Проверяются все сохранённые хеш-суммы, а не только настроенные сейчас
+	hasher := &FileHasher{}
+	for _, name := range HashFields {
+		if stored[name] != "" {
+			algorithm, _ := LookupHashAlgorithm(name)
+			hasher.add(algorithm)
+		}
+	}
+	if stored["BLAKE2sp"] != "" {
+		hasher.add(legacyBLAKE2sp)
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return failure, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+	reader := newRateLimitedReader(ctx, file, rateLimit, progress)
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return failure, fmt.Errorf("error calculating hashes: %w", err)
+	}
+
+	sums := hasher.Sums()
+	update := make(map[string]string)
+	for _, name := range HashFields {
+		if expected := stored[name]; expected != "" && !SameHash(name, expected, sums[name]) {
+			failure.Fields = append(failure.Fields, name)
+		}
+	}
+	// Ранее под именем BLAKE2sp сохранялся BLAKE2s: если совпадает он,
+	// содержимое не изменилось и значение заменяется на настоящий BLAKE2sp
+	if SameHash("BLAKE2sp", stored["BLAKE2sp"], sums[legacyBLAKE2sp.Name]) {
+		fields := failure.Fields[:0]
+		for _, name := range failure.Fields {
+			if name != "BLAKE2sp" {
+				fields = append(fields, name)
+			}
+		}
+		failure.Fields = fields
+		update["BLAKE2sp"] = sums["BLAKE2sp"]
+	}
+	sort.Strings(failure.Fields)
+	// Изменение файла определяется по размеру и времени изменения при
+	// расчёте хеш-сумм: время записи метаданных меняет и сама проверка
+	if len(failure.Fields) > 0 {
+		failure.Result = VerifyMismatch
+		if hashesStale(info, stored, written) {
+			failure.Result = VerifyModified
+		}
+	}
+
+	failure.VerifiedAt = time.Now().UTC().Truncate(time.Second)
+	result := failure.Result
+	if len(failure.Fields) > 0 {
+		result += ": " + strings.Join(failure.Fields, ", ")
+	}
+	update[MetadataVerifiedAt] = failure.VerifiedAt.Format(time.RFC3339)
+	update[MetadataVerifyResult] = result
+	// Метаданным прежних версий без времени изменения при расчёте сумм оно
+	// задаётся так, чтобы следующие проверки пришли к тому же результату
+	if stored[MetadataHashedModTime] == "" {
+		switch failure.Result {
+		case VerifyOK:
+			update[MetadataHashedSize] = strconv.FormatInt(info.Size(), 10)
+			update[MetadataHashedModTime] = info.ModTime().UTC().Format(time.RFC3339Nano)
+		case VerifyModified:
+			update[MetadataHashedModTime] = written.UTC().Format(time.RFC3339Nano)
+		default:
+			update[MetadataHashedModTime] = info.ModTime().UTC().Format(time.RFC3339Nano)
+		}
+	}
+	// Замена BLAKE2s на BLAKE2sp выполняется только при совпадении
+	// содержимого и не отменяет подпись файла
+	_, err = fs.updateMetadata(fullPath, metadataUpdate{set: update, relabel: true, actor: SystemActor(MetadataSourceScrub)})
+	if err != nil {
+		return failure, fmt.Errorf("error updating metadata: %w", err)
+	}
+	return failure, nil
+}
+
+// Scrub проверяет целостность всех файлов с хеш-суммами в метаданных.
+func (fs *FileService) Scrub(ctx context.Context, opts ScrubOptions, progress ProgressFunc) (ScrubResult, error) {
+	prefix := path.Clean("/" + opts.Prefix)
+	result := ScrubResult{Failures: []ScrubFailure{}}
+
+	// Сначала собираем список, чтобы знать общий объём работы
+	var queue []string
+	var total int64
+	err := fs.WalkMetadata(ctx, func(file IndexedFile) error {
+		if prefix != "/" && file.Path != prefix && !strings.HasPrefix(file.Path, prefix+"/") {
+			return nil
+		}
+		if !hasStoredHashes(file.Metadata) {
+			return nil
+		}
+		if opts.VerifyAfter > 0 {
+			if verified, err := time.Parse(time.RFC3339, file.Metadata[MetadataVerifiedAt]); err == nil && time.Since(verified) < opts.VerifyAfter {
+				result.Skipped++
+				return nil
+			}
+		}
+		info, err := os.Stat(fs.GetFullPath(file.Path))
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		queue = append(queue, file.Path)
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	sort.Strings(queue)
+
+	var done int64
+	var counter *progressCounter
+	if progress != nil {
+		counter = &progressCounter{total: total, report: progress}
+	}
+	for _, p := range queue {
+		failure, err := fs.VerifyFile(ctx, fs.GetFullPath(p), opts.RateLimit, func(n int64) {
+			done += n
+			if counter != nil {
+				counter.add(n)
+			}
+		})
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return result, ctxErr
+			}
+			logger.Warningf("Error verifying %s: %v", p, err)
+			continue
+		}
+		result.Checked++
+		if failure.Result != VerifyOK {
+			failure.Path = p
+			logger.Warningf("Integrity check of %s failed: %s %s", p, failure.Result, strings.Join(failure.Fields, ", "))
+			result.Failures = append(result.Failures, failure)
+		}
+	}
+	result.Bytes = done
+	return result, nil
+}
+
+// IntegrityReport собирает результаты последних проверок файлов папки dirPath.
+func (fs *FileService) IntegrityReport(ctx context.Context, dirPath string) (IntegrityReport, error) {
+	prefix := path.Clean("/" + dirPath)
+	report := IntegrityReport{Path: prefix, Failures: []ScrubFailure{}}
+	err := fs.WalkMetadata(ctx, func(file IndexedFile) error {
+		if prefix != "/" && file.Path != prefix && !strings.HasPrefix(file.Path, prefix+"/") {
+			return nil
+		}
+		if !hasStoredHashes(file.Metadata) {
+			return nil
+		}
+		report.Files++
+		verified, err := time.Parse(time.RFC3339, file.Metadata[MetadataVerifiedAt])
+		if err != nil {
+			report.Unverified++
+			return nil
+		}
+		report.Verified++
+		if report.Oldest == nil || verified.Before(*report.Oldest) {
+			report.Oldest = &verified
+		}
+		if !VerifyFailed(file.Metadata) {
+			report.OK++
+			return nil
+		}
+		result, fields, _ := strings.Cut(file.Metadata[MetadataVerifyResult], ": ")
+		failure := ScrubFailure{Path: file.Path, Result: result, VerifiedAt: verified}
+		if fields != "" {
+			failure.Fields = strings.Split(fields, ", ")
+		}
+		report.Failures = append(report.Failures, failure)
+		return nil
+	})
+	sort.Slice(report.Failures, func(i, j int) bool { return report.Failures[i].Path < report.Failures[j].Path })
+	return report, err
+}
+
+// ScheduleScrub ставит задачу проверки целостности каждые interval, пока не
+// отменён ctx. Новая задача не ставится, пока не завершена предыдущая.
+func (fs *FileService) ScheduleScrub(ctx context.Context, jobs *JobService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if jobRunning(jobs, JobKindScrub) {
+			continue
+		}
+		if _, err := jobs.Submit(JobKindScrub, "", nil); err != nil {
+			logger.Errorf("Error scheduling integrity scrub: %v", err)
+		}
+	}
+}
+
+// jobRunning сообщает, что задача вида kind поставлена или выполняется.
+func jobRunning(jobs *JobService, kind string) bool {
+	for _, job := range jobs.List() {
+		if job.Kind == kind && !job.Finished() {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubJob проверяет целостность файлов; параметры path, rate (байт/с) и
+// verify_after (например, 24h) переопределяют настройки из конфигурации.
+func (fs *FileService) scrubJob(ctx context.Context, jc *JobControl) error {
+	opts := fs.scrubOptions
+	opts.Prefix = jc.Param("path")
+	if value := jc.Param("rate"); value != "" {
+		var rate int64
+		if _, err := fmt.Sscan(value, &rate); err != nil || rate < 0 {
+			return errors.New("invalid rate")
+		}
+		opts.RateLimit = rate
+	}
+	if value := jc.Param("verify_after"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid verify_after: %w", err)
+		}
+		opts.VerifyAfter = d
+	}
+
+	result, err := fs.Scrub(ctx, opts, jc.Progress)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Integrity scrub finished: %d checked, %d skipped, %d failed", result.Checked, result.Skipped, len(result.Failures))
+	return jc.SetResult(result)
+}
+
+// SetScrubOptions задаёт параметры проверки целостности по умолчанию.
+func (fs *FileService) SetScrubOptions(opts ScrubOptions) {
+	fs.scrubOptions = opts
+}
+
+func hasStoredHashes(metadata map[string]string) bool {
+	for _, key := range HashFields {
+		if metadata[key] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitedReader ограничивает скорость чтения и прерывает его при отмене ctx.
+type rateLimitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	rate     int64
+	progress func(n int64)
+	start    time.Time
+	read     int64
+}
+
+func newRateLimitedReader(ctx context.Context, r io.Reader, rate int64, progress func(n int64)) io.Reader {
+	return &rateLimitedReader{ctx: ctx, r: r, rate: rate, progress: progress, start: time.Now()}
+}
+
+func (l *rateLimitedReader) Read(buf []byte) (int, error) {
+	if err := l.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if l.rate > 0 && int64(len(buf)) > l.rate {
+		buf = buf[:l.rate]
+	}
+	n, err := l.r.Read(buf)
+	if n <= 0 {
+		return n, err
+	}
+	l.read += int64(n)
+	if l.progress != nil {
+		l.progress(int64(n))
+	}
+	if l.rate > 0 {
+		expected := time.Duration(float64(l.read) / float64(l.rate) * float64(time.Second))
+		if wait := expected - time.Since(l.start); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-l.ctx.Done():
+				timer.Stop()
+				return n, l.ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	return n, err
+}
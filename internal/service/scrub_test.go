@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyTwice проверяет файл дважды и возвращает результаты проверок.
+func verifyTwice(t *testing.T, fs *FileService, path string) (string, string) {
+	t.Helper()
+	var results [2]string
+	for i := range results {
+		failure, err := fs.VerifyFile(context.Background(), path, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results[i] = failure.Result
+	}
+	return results[0], results[1]
+}
+
+func TestVerifyFileOK(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	path := newHashedTestFile(t, fs, "a.txt", "hello")
+	if first, second := verifyTwice(t, fs, path); first != VerifyOK || second != VerifyOK {
+		t.Errorf("got %s, %s, want ok", first, second)
+	}
+}
+
+// Файл, изменённый после расчёта сумм, остаётся modified и после правки
+// метаданных, и при повторной проверке.
+func TestVerifyFileModified(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	path := newHashedTestFile(t, fs, "a.txt", "hello")
+	writeTestFile(t, path, []byte("HELLO"))
+	changed := time.Now().Add(-time.Minute)
+	os.Chtimes(path, changed, changed)
+	if _, err := fs.EditMetadata(path, map[string]string{"Notes": "edited"}, "tester", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if first, second := verifyTwice(t, fs, path); first != VerifyModified || second != VerifyModified {
+		t.Errorf("got %s, %s, want modified", first, second)
+	}
+	if metadata, _ := fs.loadMetadata(path); !strings.HasPrefix(metadata[MetadataVerifyResult], VerifyModified+": ") {
+		t.Errorf("Verify Result %q", metadata[MetadataVerifyResult])
+	}
+}
+
+// Содержимое изменилось при том же размере и времени изменения.
+func TestVerifyFileMismatch(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	path := newHashedTestFile(t, fs, "a.txt", "hello")
+	info, _ := os.Stat(path)
+	writeTestFile(t, path, []byte("HELLO"))
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+
+	if first, second := verifyTwice(t, fs, path); first != VerifyMismatch || second != VerifyMismatch {
+		t.Errorf("got %s, %s, want mismatch", first, second)
+	}
+	if fs.StoredHashes(path) != nil {
+		t.Error("hashes of a corrupted file must not be trusted")
+	}
+}
+
+// Метаданные прежних версий без размера и времени изменения при расчёте.
+func TestVerifyFileLegacyMetadata(t *testing.T) {
+	fs := NewFileService(t.TempDir(), nil)
+	path := newHashedTestFile(t, fs, "a.txt", "hello")
+	if _, err := fs.updateMetadata(path, metadataUpdate{unset: []string{MetadataHashedSize, MetadataHashedModTime}}); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, []byte("HELLO"))
+	changed := time.Now().Add(time.Hour)
+	os.Chtimes(path, changed, changed)
+
+	if first, second := verifyTwice(t, fs, path); first != VerifyModified || second != VerifyModified {
+		t.Errorf("got %s, %s, want modified", first, second)
+	}
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errSearchLimit останавливает обход после набора limit результатов.
+var errSearchLimit = errors.New("search limit reached")
+
+// snippetRadius - число символов вокруг найденного текста во фрагменте README.md.
+const snippetRadius = 60
+
+// SearchQuery - условия поиска. Все заданные условия должны выполняться
+// одновременно.
+type SearchQuery struct {
+	Name    string            // Шаблон имени (glob с * ? [..]) или подстрока, без учёта регистра
+	Filters map[string]string // Поля метаданных: точное значение или glob-шаблон
+	Hash    string            // Контрольная сумма файла или из README.md (RDS)
+	MinSize int64             // Минимальный размер, -1 - без ограничения
+	MaxSize int64             // Максимальный размер, -1 - без ограничения
+	After   time.Time         // Изменён не раньше
+	Before  time.Time         // Изменён не позже
+	Text    string            // Текст в README.md папки, без учёта регистра
+	Type    string            // "file", "dir" или пусто
+	Prefix  string            // Папка поиска
+	Limit   int
+}
+
+// SearchResult - найденный файл или папка.
+type SearchResult struct {
+	Path     string            `json:"path"`
+	Name     string            `json:"name"`
+	IsDir    bool              `json:"is_dir"`
+	Size     int64             `json:"size"`
+	ModTime  time.Time         `json:"mod_time"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Snippet  string            `json:"snippet,omitempty"` // Фрагмент README.md с найденным текстом
+}
+
+// Search ищет файлы и папки по имени, метаданным, контрольным суммам,
+// размеру, дате изменения и тексту README.md. Использует индекс метаданных,
+// а без него обходит дерево файлов. Возвращает результаты и признак того,
+// что их больше, чем Limit.
+func (fs *FileService) Search(ctx context.Context, q SearchQuery) ([]SearchResult, bool, error) {
+	q.Prefix = path.Clean("/" + q.Prefix)
+	q.Name = strings.ToLower(q.Name)
+	q.Text = strings.ToLower(q.Text)
+
+	var snippets map[string]string
+	if q.Text != "" {
+		var err error
+		if snippets, err = fs.searchReadmes(ctx, q.Text); err != nil {
+			return nil, false, err
+		}
+	}
+
+	results := []SearchResult{}
+	truncated := false
+	err := fs.walkEntries(ctx, q.Prefix, func(entry IndexedEntry) error {
+		if entry.Path == q.Prefix || !q.matches(entry) {
+			return nil
+		}
+		result := SearchResult{
+			Path:     entry.Path,
+			Name:     path.Base(entry.Path),
+			IsDir:    entry.IsDir,
+			Size:     entry.Size,
+			ModTime:  entry.ModTime,
+			Metadata: entry.Metadata,
+		}
+		if q.Text != "" {
+			snippet, ok := snippets[path.Dir(entry.Path)]
+			if !ok {
+				return nil
+			}
+			result.Snippet = snippet
+		}
+		if len(results) >= q.Limit {
+			truncated = true
+			return errSearchLimit
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSearchLimit) {
+		return nil, false, err
+	}
+	return results, truncated, nil
+}
+
+func (q *SearchQuery) matches(entry IndexedEntry) bool {
+	switch q.Type {
+	case "file":
+		if entry.IsDir {
+			return false
+		}
+	case "dir":
+		if !entry.IsDir {
+			return false
+		}
+	}
+	if q.Name != "" && !matchPattern(q.Name, strings.ToLower(path.Base(entry.Path))) {
+		return false
+	}
+	if q.MinSize >= 0 && (entry.IsDir || entry.Size < q.MinSize) {
+		return false
+	}
+	if q.MaxSize >= 0 && (entry.IsDir || entry.Size > q.MaxSize) {
+		return false
+	}
+	if !q.After.IsZero() && entry.ModTime.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && entry.ModTime.After(q.Before) {
+		return false
+	}
+	for key, value := range q.Filters {
+		actual, ok := entry.Metadata[key]
+		if !ok || (actual != value && !(isPattern(value) && matchPattern(value, actual))) {
+			return false
+		}
+	}
+	if q.Hash != "" && !hasHash(entry.Metadata, q.Hash) {
+		return false
+	}
+	return true
+}
+
+// hasHash проверяет, совпадает ли одна из контрольных сумм с hash.
+func hasHash(metadata map[string]string, hash string) bool {
+	for _, key := range HashFields {
+		for _, field := range []string{key, "RDS " + key, ManifestFieldPrefix + key} {
+			if SameHash(key, metadata[field], hash) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// matchPattern сравнивает строку с glob-шаблоном, а строку без спецсимволов
+// ищет как подстроку.
+func matchPattern(pattern, s string) bool {
+	if !isPattern(pattern) {
+		return strings.Contains(s, pattern)
+	}
+	ok, err := path.Match(pattern, s)
+	return err == nil && ok
+}
+
+// walkEntries перебирает файлы и папки внутри prefix вместе с метаданными.
+func (fs *FileService) walkEntries(ctx context.Context, prefix string, fn func(entry IndexedEntry) error) error {
+	inPrefix := func(p string) bool {
+		return prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+	if fs.index != nil {
+		return fs.index.WalkEntries(func(entry IndexedEntry) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !inPrefix(entry.Path) {
+				return nil
+			}
+			return fn(entry)
+		})
+	}
+
+	root := fs.GetFullPath(prefix)
+	return filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if IsMetaFile(d.Name()) && !d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		entry := IndexedEntry{
+			Path:    path.Join(prefix, filepath.ToSlash(rel)),
+			IsDir:   d.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if metadata, err := fs.loadMetadata(p); err == nil {
+			entry.Metadata = metadata
+		}
+		return fn(entry)
+	})
+}
+
+// searchReadmes возвращает фрагменты README.md, содержащих text, по путям папок.
+func (fs *FileService) searchReadmes(ctx context.Context, text string) (map[string]string, error) {
+	snippets := make(map[string]string)
+	add := func(dir, content string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if snippet, ok := findSnippet(content, text); ok {
+			snippets[dir] = snippet
+		}
+		return nil
+	}
+	if fs.index != nil {
+		return snippets, fs.index.WalkReadmes(add)
+	}
+
+	baseDir := filepath.Clean(fs.baseDir)
+	err := filepath.WalkDir(baseDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != readmeName {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rec, err := readReadmeRecord(p, info)
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(baseDir, filepath.Dir(p))
+		if err != nil {
+			return nil
+		}
+		return add(path.Join("/", filepath.ToSlash(rel)), rec.Text)
+	})
+	return snippets, err
+}
+
+// findSnippet ищет text (в нижнем регистре) в content и возвращает фрагмент
+// вокруг первого вхождения.
+func findSnippet(content, text string) (string, bool) {
+	i := strings.Index(strings.ToLower(content), text)
+	if i < 0 {
+		return "", false
+	}
+	start, end := i-snippetRadius, i+len(text)+snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	if end > len(content) {
+		end = len(content)
+	}
+	// Границы фрагмента не должны разрывать символы UTF-8
+	for start > 0 && !isRuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !isRuneStart(content[end]) {
+		end++
+	}
+	snippet := strings.Join(strings.Fields(content[start:end]), " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(content) {
+		snippet += "…"
+	}
+	return snippet, true
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
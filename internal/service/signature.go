@@ -0,0 +1,467 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"aead.dev/minisign"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// Поля метаданных с результатом проверки отсоединённой подписи файла.
+const (
+	MetadataSignatureStatus = "Signature Status"
+	MetadataSignatureSigner = "Signature Signer"
+	MetadataSignatureFile   = "Signature File"
+)
+
+// Результаты проверки подписи.
+const (
+	SignatureVerified   = "verified"   // Подпись верна и сделана ключом из связки
+	SignatureUnverified = "unverified" // Ключ подписи неизвестен или формат не поддерживается
+	SignatureInvalid    = "invalid"    // Подпись не соответствует содержимому файла
+)
+
+// DefaultMaxBufferedSize - размер файла по умолчанию, до которого проверяются
+// подписи, требующие чтения файла в память целиком.
+const DefaultMaxBufferedSize = 512 << 20
+
+// maxSignatureSize - наибольший размер файла отсоединённой подписи.
+const maxSignatureSize = 1 << 20
+
+// ErrSignedFileTooLarge возвращается, если подпись нельзя проверить потоком,
+// а файл больше допустимого для чтения в память.
+var ErrSignedFileTooLarge = errors.New("file too large to verify this signature")
+
+// signatureExtensions - расширения файлов отсоединённых подписей в порядке
+// поиска: .sig (OpenPGP или cosign), .asc (OpenPGP), .minisig (minisign).
+var signatureExtensions = []string{".sig", ".asc", ".minisig"}
+
+// SignatureResult - результат проверки подписи файла.
+type SignatureResult struct {
+	File   string `json:"file"`             // Имя подписанного файла
+	Status string `json:"status"`           // verified, unverified или invalid
+	Signer string `json:"signer,omitempty"` // Владелец или идентификатор ключа
+	Source string `json:"signature"`        // Имя файла подписи
+	Error  string `json:"error,omitempty"`
+}
+
+// SignatureKeyring - доверенные ключи для проверки подписей.
+type SignatureKeyring struct {
+	openpgp     openpgp.EntityList
+	cosign      []cosignKey
+	minisign    []minisignKey
+	maxBuffered int64 // См. SetMaxBufferedSize
+}
+
+type cosignKey struct {
+	name string
+	key  crypto.PublicKey
+}
+
+type minisignKey struct {
+	name string
+	key  minisign.PublicKey
+}
+
+// LoadSignatureKeyring загружает связки ключей OpenPGP (двоичные или
+// armored), открытые ключи cosign (PEM) и minisign.
+func LoadSignatureKeyring(openpgpKeyrings, cosignKeys, minisignKeys []string) (*SignatureKeyring, error) {
+	keyring := &SignatureKeyring{}
+	for _, p := range openpgpKeyrings {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error reading OpenPGP keyring: %w", err)
+		}
+		var entities openpgp.EntityList
+		if isArmored(data) {
+			entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		} else {
+			entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing OpenPGP keyring %s: %w", p, err)
+		}
+		keyring.openpgp = append(keyring.openpgp, entities...)
+	}
+	for _, p := range cosignKeys {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error reading cosign key: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("error parsing cosign key %s: no PEM block", p)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing cosign key %s: %w", p, err)
+		}
+		keyring.cosign = append(keyring.cosign, cosignKey{name: filepath.Base(p), key: key})
+	}
+	for _, p := range minisignKeys {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error reading minisign key: %w", err)
+		}
+		key := minisignKey{name: filepath.Base(p)}
+		if err := key.key.UnmarshalText(data); err != nil {
+			return nil, fmt.Errorf("error parsing minisign key %s: %w", p, err)
+		}
+		keyring.minisign = append(keyring.minisign, key)
+	}
+	return keyring, nil
+}
+
+// SetMaxBufferedSize задаёт наибольший размер файла, который читается в
+// память для подписей без предварительного хеширования: cosign с ключом
+// Ed25519 и minisign старого формата. Подписи больших файлов остаются
+// непроверенными. При size <= 0 используется DefaultMaxBufferedSize.
+func (k *SignatureKeyring) SetMaxBufferedSize(size int64) {
+	k.maxBuffered = size
+}
+
+// SetSignatureKeyring задаёт ключи для проверки подписей загруженных файлов.
+func (fs *FileService) SetSignatureKeyring(keyring *SignatureKeyring) {
+	fs.keyring = keyring
+}
+
+// SignatureTarget возвращает имя подписанного файла для файла подписи.
+func SignatureTarget(name string) (string, bool) {
+	for _, ext := range signatureExtensions {
+		if strings.HasSuffix(strings.ToLower(name), ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)], true
+		}
+	}
+	return "", false
+}
+
+// findSignature возвращает путь к файлу подписи fullPath или пустую строку.
+func findSignature(fullPath string) string {
+	for _, ext := range signatureExtensions {
+		if info, err := os.Stat(fullPath + ext); err == nil && info.Mode().IsRegular() {
+			return fullPath + ext
+		}
+	}
+	return ""
+}
+
+// VerifySignature проверяет отсоединённую подпись файла fullPath, лежащую
+// рядом с ним, и сохраняет результат в метаданных. Возвращает false, если
+// подписи нет.
+func (fs *FileService) VerifySignature(fullPath string) (SignatureResult, bool, error) {
+	sigPath := findSignature(fullPath)
+	if sigPath == "" {
+		return SignatureResult{}, false, nil
+	}
+	result := SignatureResult{File: filepath.Base(fullPath), Source: filepath.Base(sigPath)}
+	result.Status, result.Signer, result.Error = fs.keyring.verify(fullPath, sigPath)
+
+	err := fs.AddMetadata(fullPath, map[string]string{
+		MetadataSignatureStatus: result.Status,
+		MetadataSignatureSigner: result.Signer,
+		MetadataSignatureFile:   result.Source,
+	}, SystemActor(MetadataSourceSignature))
+	if err != nil {
+		return result, true, fmt.Errorf("error saving signature status: %w", err)
+	}
+	return result, true, nil
+}
+
+// VerifyUploadedSignatures проверяет подписи после загрузки файлов uploaded
+// в папку dirPath: и подписанных файлов, и файлов, к которым загружена
+// подпись.
+func (fs *FileService) VerifyUploadedSignatures(dirPath string, uploaded []string) ([]SignatureResult, error) {
+	results := []SignatureResult{}
+	var targets []string
+	for _, name := range uploaded {
+		if target, ok := SignatureTarget(name); ok {
+			name = target
+		}
+		if !containsString(targets, name) {
+			targets = append(targets, name)
+		}
+	}
+	for _, name := range targets {
+		fullPath := filepath.Join(dirPath, name)
+		if info, err := os.Stat(fullPath); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		result, ok, err := fs.VerifySignature(fullPath)
+		if err != nil {
+			return results, err
+		}
+		if ok {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// VerifySignatures повторно проверяет подпись файла fullPath или подписи
+// всех файлов папки, например после изменения связки ключей.
+func (fs *FileService) VerifySignatures(fullPath string) ([]SignatureResult, error) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return fs.VerifyUploadedSignatures(filepath.Dir(fullPath), []string{filepath.Base(fullPath)})
+	}
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !IsMetaFile(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return fs.VerifyUploadedSignatures(fullPath, names)
+}
+
+// hashesChanged сообщает, отличаются ли новые хеш-суммы от сохранённых.
+func hashesChanged(existing, updated map[string]string) bool {
+	for _, key := range HashFields {
+		if existing[key] != "" && updated[key] != "" && !SameHash(key, existing[key], updated[key]) {
+			return true
+		}
+	}
+	return false
+}
+
+// verify проверяет подпись sigPath файла fullPath и возвращает статус,
+// подписавшего и описание ошибки.
+func (k *SignatureKeyring) verify(fullPath, sigPath string) (status, signer, reason string) {
+	if k == nil {
+		k = &SignatureKeyring{}
+	}
+	sig, err := readLimited(sigPath, maxSignatureSize)
+	if err != nil {
+		return SignatureUnverified, "", err.Error()
+	}
+
+	switch {
+	case strings.HasSuffix(strings.ToLower(sigPath), ".minisig"):
+		return k.verifyMinisign(fullPath, sig)
+	case isArmored(sig) || len(sig) > 0 && sig[0]&0x80 != 0:
+		return k.verifyOpenPGP(fullPath, sig)
+	default:
+		return k.verifyCosign(fullPath, sig)
+	}
+}
+
+func (k *SignatureKeyring) verifyOpenPGP(fullPath string, sig []byte) (string, string, string) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return SignatureUnverified, "", err.Error()
+	}
+	defer file.Close()
+
+	var signer *openpgp.Entity
+	if isArmored(sig) {
+		signer, err = openpgp.CheckArmoredDetachedSignature(k.openpgp, file, bytes.NewReader(sig), nil)
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(k.openpgp, file, bytes.NewReader(sig), nil)
+	}
+	if errors.Is(err, pgperrors.ErrUnknownIssuer) {
+		return SignatureUnverified, openpgpIssuer(sig), "unknown key"
+	}
+	if err != nil {
+		return SignatureInvalid, openpgpIssuer(sig), err.Error()
+	}
+
+	name := fmt.Sprintf("%X", signer.PrimaryKey.KeyId)
+	if identity := signer.PrimaryIdentity(); identity != nil {
+		name = fmt.Sprintf("%s (%X)", identity.Name, signer.PrimaryKey.KeyId)
+	}
+	return SignatureVerified, name, ""
+}
+
+// openpgpIssuer возвращает идентификатор ключа из подписи OpenPGP.
+func openpgpIssuer(sig []byte) string {
+	var r io.Reader = bytes.NewReader(sig)
+	if isArmored(sig) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return ""
+		}
+		r = block.Body
+	}
+	p, err := packet.Read(r)
+	if err != nil {
+		return ""
+	}
+	if s, ok := p.(*packet.Signature); ok && s.IssuerKeyId != nil {
+		return fmt.Sprintf("%X", *s.IssuerKeyId)
+	}
+	return ""
+}
+
+// verifyCosign проверяет подпись cosign sign-blob (base64) ключами cosign.
+// Подпись не содержит идентификатора ключа, поэтому при настроенных ключах
+// несовпадение считается недействительной подписью.
+func (k *SignatureKeyring) verifyCosign(fullPath string, sig []byte) (string, string, string) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return SignatureUnverified, "", "unsupported signature format"
+	}
+	if len(k.cosign) == 0 {
+		return SignatureUnverified, "", "no cosign keys configured"
+	}
+
+	digest, err := fileDigest(fullPath, sha256.New())
+	if err != nil {
+		return SignatureUnverified, "", err.Error()
+	}
+	// Ed25519 подписывает само содержимое, а не его хеш, поэтому файл
+	// читается в память один раз и только для ключей Ed25519
+	var data []byte
+	var readErr error
+	for _, key := range k.cosign {
+		var ok bool
+		switch pub := key.key.(type) {
+		case *ecdsa.PublicKey:
+			ok = ecdsa.VerifyASN1(pub, digest, raw)
+		case *rsa.PublicKey:
+			ok = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, raw) == nil
+		case ed25519.PublicKey:
+			if data == nil && readErr == nil {
+				data, readErr = k.readSignedFile(fullPath)
+			}
+			ok = readErr == nil && ed25519.Verify(pub, data, raw)
+		}
+		if ok {
+			return SignatureVerified, key.name, ""
+		}
+	}
+	if readErr != nil {
+		return SignatureUnverified, "", readErr.Error()
+	}
+	return SignatureInvalid, "", "signature does not match any cosign key"
+}
+
+// verifyMinisign проверяет подпись minisign, включая доверенный комментарий.
+func (k *SignatureKeyring) verifyMinisign(fullPath string, sig []byte) (string, string, string) {
+	var signature minisign.Signature
+	if err := signature.UnmarshalText(sig); err != nil {
+		return SignatureUnverified, "", "unsupported signature format"
+	}
+	keyName := minisignKeyID(signature.KeyID)
+
+	var key *minisignKey
+	for i := range k.minisign {
+		if k.minisign[i].key.ID() == signature.KeyID {
+			key = &k.minisign[i]
+		}
+	}
+	if key == nil {
+		return SignatureUnverified, keyName, "unknown key"
+	}
+
+	var ok bool
+	if signature.Algorithm == minisign.HashEdDSA {
+		file, err := os.Open(fullPath)
+		if err != nil {
+			return SignatureUnverified, keyName, err.Error()
+		}
+		defer file.Close()
+		reader := minisign.NewReader(file)
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			return SignatureUnverified, keyName, err.Error()
+		}
+		ok = reader.Verify(key.key, sig)
+	} else {
+		// Подпись старого формата вычисляется от всего содержимого файла
+		data, err := k.readSignedFile(fullPath)
+		if err != nil {
+			return SignatureUnverified, keyName, err.Error()
+		}
+		ok = minisign.Verify(key.key, data, sig)
+	}
+	signer := fmt.Sprintf("%s (%s)", key.name, keyName)
+	if !ok {
+		return SignatureInvalid, signer, "signature does not match"
+	}
+	return SignatureVerified, signer, ""
+}
+
+// minisignKeyID форматирует идентификатор ключа так же, как minisign.
+func minisignKeyID(id uint64) string {
+	return fmt.Sprintf("%016X", id)
+}
+
+// readSignedFile читает подписанный файл целиком, если он не больше
+// допустимого размера (см. SetMaxBufferedSize).
+func (k *SignatureKeyring) readSignedFile(fullPath string) ([]byte, error) {
+	limit := k.maxBuffered
+	if limit <= 0 {
+		limit = DefaultMaxBufferedSize
+	}
+	data, err := readLimited(fullPath, limit)
+	if errors.Is(err, errFileTooLarge) {
+		return nil, fmt.Errorf("%w (limit %d MB)", ErrSignedFileTooLarge, limit>>20)
+	}
+	return data, err
+}
+
+var errFileTooLarge = errors.New("file too large")
+
+// readLimited читает файл, если он не больше limit байт. Размер проверяется
+// до чтения и ещё раз при чтении, если файл растёт.
+func readLimited(path string, limit int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > limit {
+		return nil, errFileTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errFileTooLarge
+	}
+	return data, nil
+}
+
+func fileDigest(fullPath string, h hash.Hash) ([]byte, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP"))
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"aead.dev/minisign"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+const signedTestContent = "signed content"
+
+// signatureCase - подпись файла с содержимым signedTestContent и ожидаемые
+// результаты проверки.
+type signatureCase struct {
+	name    string
+	ext     string
+	sig     []byte
+	trusted *SignatureKeyring
+	other   *SignatureKeyring // Ключи без ключа подписи
+	unknown string            // Статус подписи неизвестным ключом
+}
+
+// checkSignatureCase проверяет подпись доверенным ключом, неизвестным ключом
+// и после изменения файла.
+func checkSignatureCase(t *testing.T, tc signatureCase) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.bin")
+	writeTestFile(t, path, []byte(signedTestContent))
+	sigPath := path + tc.ext
+	writeTestFile(t, sigPath, tc.sig)
+
+	if status, signer, reason := tc.trusted.verify(path, sigPath); status != SignatureVerified || signer == "" {
+		t.Errorf("%s: got %s (%s, %s), want verified", tc.name, status, signer, reason)
+	}
+	if status, _, reason := tc.other.verify(path, sigPath); status != tc.unknown {
+		t.Errorf("%s untrusted: got %s (%s), want %s", tc.name, status, reason, tc.unknown)
+	}
+	writeTestFile(t, path, []byte(strings.ToUpper(signedTestContent)))
+	if status, _, reason := tc.trusted.verify(path, sigPath); status != SignatureInvalid {
+		t.Errorf("%s tampered: got %s (%s), want invalid", tc.name, status, reason)
+	}
+}
+
+func newTestEntity(t *testing.T, name string) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+func TestVerifyOpenPGP(t *testing.T) {
+	signer, other := newTestEntity(t, "signer"), newTestEntity(t, "other")
+	trusted := &SignatureKeyring{openpgp: openpgp.EntityList{other, signer}}
+	untrusted := &SignatureKeyring{openpgp: openpgp.EntityList{other}}
+
+	var armored, binary bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&armored, signer, strings.NewReader(signedTestContent), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := openpgp.DetachSign(&binary, signer, strings.NewReader(signedTestContent), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []signatureCase{
+		{name: "armored", ext: ".asc", sig: armored.Bytes()},
+		{name: "armored .sig", ext: ".sig", sig: armored.Bytes()},
+		{name: "binary", ext: ".sig", sig: binary.Bytes()},
+	} {
+		tc.trusted, tc.other, tc.unknown = trusted, untrusted, SignatureUnverified
+		checkSignatureCase(t, tc)
+	}
+
+	path := filepath.Join(t.TempDir(), "app.bin")
+	writeTestFile(t, path, []byte(signedTestContent))
+	writeTestFile(t, path+".sig", binary.Bytes())
+	if _, signer, _ := untrusted.verify(path, path+".sig"); signer == "" {
+		t.Error("unknown key must be reported by its key ID")
+	}
+}
+
+func TestVerifyCosign(t *testing.T) {
+	digest := sha256.Sum256([]byte(signedTestContent))
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaSig, err := ecdsa.SignASN1(rand.Reader, ecdsaKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSig := ed25519.Sign(edKey, []byte(signedTestContent))
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	other := cosignKey{name: "other.pub", key: otherPub}
+	for _, c := range []struct {
+		name string
+		key  crypto.PublicKey
+		sig  []byte
+	}{
+		{"ECDSA", &ecdsaKey.PublicKey, ecdsaSig},
+		{"RSA", &rsaKey.PublicKey, rsaSig},
+		{"Ed25519", edPub, edSig},
+	} {
+		checkSignatureCase(t, signatureCase{
+			name:    c.name,
+			ext:     ".sig",
+			sig:     []byte(base64.StdEncoding.EncodeToString(c.sig) + "\n"),
+			trusted: &SignatureKeyring{cosign: []cosignKey{other, {name: "cosign.pub", key: c.key}}},
+			other:   &SignatureKeyring{cosign: []cosignKey{other}},
+			// Подпись cosign не указывает ключ, поэтому чужой ключ не отличить
+			// от изменённого файла
+			unknown: SignatureInvalid,
+		})
+	}
+
+	// Без ключей cosign подпись не проверяется
+	path := filepath.Join(t.TempDir(), "app.bin")
+	writeTestFile(t, path, []byte(signedTestContent))
+	writeTestFile(t, path+".sig", []byte(base64.StdEncoding.EncodeToString(edSig)))
+	if status, _, _ := (&SignatureKeyring{}).verify(path, path+".sig"); status != SignatureUnverified {
+		t.Errorf("no keys: got %s, want unverified", status)
+	}
+	// Ed25519 требует чтения файла в память и подчиняется лимиту
+	keyring := &SignatureKeyring{cosign: []cosignKey{{name: "cosign.pub", key: edPub}}}
+	keyring.SetMaxBufferedSize(int64(len(signedTestContent) - 1))
+	if status, _, reason := keyring.verify(path, path+".sig"); status != SignatureUnverified || !strings.Contains(reason, ErrSignedFileTooLarge.Error()) {
+		t.Errorf("over limit: got %s (%s), want unverified", status, reason)
+	}
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	pub, key, err := minisign.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := minisign.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := &SignatureKeyring{minisign: []minisignKey{{name: "other.pub", key: otherPub}, {name: "minisign.pub", key: pub}}}
+	untrusted := &SignatureKeyring{minisign: []minisignKey{{name: "other.pub", key: otherPub}}}
+
+	reader := minisign.NewReader(strings.NewReader(signedTestContent))
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Fatal(err)
+	}
+	hashed := reader.Sign(key)
+	legacy := minisign.Sign(key, []byte(signedTestContent))
+	for _, tc := range []signatureCase{
+		{name: "hashed", ext: ".minisig", sig: hashed},
+		{name: "legacy", ext: ".minisig", sig: legacy},
+	} {
+		tc.trusted, tc.other, tc.unknown = trusted, untrusted, SignatureUnverified
+		checkSignatureCase(t, tc)
+	}
+
+	// Подпись старого формата требует чтения файла в память, хешированная - нет
+	path := filepath.Join(t.TempDir(), "app.bin")
+	writeTestFile(t, path, []byte(signedTestContent))
+	limited := &SignatureKeyring{minisign: trusted.minisign}
+	limited.SetMaxBufferedSize(int64(len(signedTestContent) - 1))
+	writeTestFile(t, path+".minisig", legacy)
+	if status, _, reason := limited.verify(path, path+".minisig"); status != SignatureUnverified || !strings.Contains(reason, ErrSignedFileTooLarge.Error()) {
+		t.Errorf("legacy over limit: got %s (%s), want unverified", status, reason)
+	}
+	writeTestFile(t, path+".minisig", hashed)
+	if status, _, reason := limited.verify(path, path+".minisig"); status != SignatureVerified {
+		t.Errorf("hashed over limit: got %s (%s), want verified", status, reason)
+	}
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"aead.dev/minisign"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// DefaultSignedManifest - имя подписанного файла контрольных сумм папки.
+// Отличается от SHA256SUMS, чтобы не перезаписывать файлы производителей.
+const DefaultSignedManifest = "fileStation-SHA256SUMS"
+
+// MetadataServerSignature - имя файла подписи, созданного сервером. Подписи,
+// загруженные пользователями, сервер не перезаписывает.
+const MetadataServerSignature = "Server Signature"
+
+// FileSigner подписывает опубликованные файлы ключом сервера.
+type FileSigner struct {
+	minisign *minisign.PrivateKey
+	openpgp  *openpgp.Entity
+	manifest string
+}
+
+// LoadFileSigner загружает ключ подписи сервера: секретный ключ minisign или
+// закрытый ключ OpenPGP (armored). passphraseFile - файл с паролем ключа,
+// если он зашифрован. Без ключей возвращает nil.
+func LoadFileSigner(minisignKey, openpgpKey, passphraseFile, manifest string) (*FileSigner, error) {
+	if minisignKey == "" && openpgpKey == "" {
+		return nil, nil
+	}
+	if minisignKey != "" && openpgpKey != "" {
+		return nil, fmt.Errorf("only one signing key can be configured")
+	}
+	var passphrase string
+	if passphraseFile != "" {
+		data, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading passphrase file: %w", err)
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	}
+	if manifest == "" {
+		manifest = DefaultSignedManifest
+	}
+	signer := &FileSigner{manifest: manifest}
+
+	if minisignKey != "" {
+		data, err := os.ReadFile(minisignKey)
+		if err != nil {
+			return nil, fmt.Errorf("error reading minisign key: %w", err)
+		}
+		var key minisign.PrivateKey
+		if minisign.IsEncrypted(data) {
+			key, err = minisign.DecryptKey(passphrase, data)
+		} else {
+			err = key.UnmarshalText(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error loading minisign key: %w", err)
+		}
+		signer.minisign = &key
+		return signer, nil
+	}
+
+	data, err := os.ReadFile(openpgpKey)
+	if err != nil {
+		return nil, fmt.Errorf("error reading OpenPGP key: %w", err)
+	}
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing OpenPGP key: %w", err)
+	}
+	if len(entities) != 1 || entities[0].PrivateKey == nil {
+		return nil, fmt.Errorf("OpenPGP key file must contain exactly one private key")
+	}
+	entity := entities[0]
+	if entity.PrivateKey.Encrypted {
+		if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("error decrypting OpenPGP key: %w", err)
+		}
+	}
+	signer.openpgp = entity
+	return signer, nil
+}
+
+// Extension возвращает расширение файлов подписи: .minisig или .asc.
+func (s *FileSigner) Extension() string {
+	if s.minisign != nil {
+		return ".minisig"
+	}
+	return ".asc"
+}
+
+// Manifest возвращает имя подписанного файла контрольных сумм папки.
+func (s *FileSigner) Manifest() string {
+	return s.manifest
+}
+
+// Sign записывает в w отсоединённую подпись данных r. name попадает в
+// доверенный комментарий подписи minisign.
+func (s *FileSigner) Sign(w io.Writer, r io.Reader, name string) error {
+	if s.minisign != nil {
+		reader := minisign.NewReader(r)
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			return err
+		}
+		trusted := "timestamp:" + strconv.FormatInt(time.Now().Unix(), 10) + "\tfile:" + name + "\thashed"
+		untrusted := "signature from fileStation key " + minisignKeyID(s.minisign.ID())
+		_, err := w.Write(reader.SignWithComments(*s.minisign, trusted, untrusted))
+		return err
+	}
+	return openpgp.ArmoredDetachSign(w, s.openpgp, r, nil)
+}
+
+// PublicKey возвращает открытый ключ сервера для проверки подписей клиентами:
+// ключ minisign или armored ключ OpenPGP.
+func (s *FileSigner) PublicKey() ([]byte, error) {
+	if s.minisign != nil {
+		public, ok := s.minisign.Public().(minisign.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid minisign key")
+		}
+		return public.MarshalText()
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.openpgp.Serialize(w); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// trust добавляет открытый ключ сервера в связку ключей для проверки подписей.
+func (s *FileSigner) trust(keyring *SignatureKeyring) {
+	if s.minisign != nil {
+		public, ok := s.minisign.Public().(minisign.PublicKey)
+		if ok {
+			keyring.minisign = append(keyring.minisign, minisignKey{name: "fileStation", key: public})
+		}
+		return
+	}
+	keyring.openpgp = append(keyring.openpgp, s.openpgp)
+}
+
+// SetFileSigner задаёт ключ подписи сервера. Его открытый ключ считается
+// доверенным при проверке подписей.
+func (fs *FileService) SetFileSigner(signer *FileSigner) {
+	fs.signer = signer
+	if signer == nil {
+		return
+	}
+	if fs.keyring == nil {
+		fs.keyring = &SignatureKeyring{}
+	}
+	signer.trust(fs.keyring)
+}
+
+// FileSigner возвращает ключ подписи сервера или nil.
+func (fs *FileService) FileSigner() *FileSigner {
+	return fs.signer
+}
+
+// PublishSignatures подписывает ключом сервера файлы names папки dirPath с
+// актуальными хеш-суммами и заново создаёт подписанный файл контрольных сумм
+// папки. Без ключа подписи ничего не делает.
+func (fs *FileService) PublishSignatures(dirPath string, names []string) error {
+	if fs.signer == nil {
+		return nil
+	}
+	for _, name := range names {
+		if _, ok := SignatureTarget(name); ok || IsMetaFile(name) || name == fs.signer.manifest {
+			continue
+		}
+		if err := fs.signFile(filepath.Join(dirPath, name)); err != nil {
+			return err
+		}
+	}
+	return fs.writeSignedManifest(dirPath)
+}
+
+// signFile создаёт подпись файла рядом с ним и обновляет статус подписи.
+func (fs *FileService) signFile(fullPath string) error {
+	metadata := fs.StoredHashes(fullPath)
+	if metadata == nil {
+		return nil
+	}
+	sigName := filepath.Base(fullPath) + fs.signer.Extension()
+	if _, err := os.Stat(fullPath + fs.signer.Extension()); err == nil && metadata[MetadataServerSignature] != sigName {
+		return nil // Подпись загружена пользователем
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+	var sig bytes.Buffer
+	if err := fs.signer.Sign(&sig, file, filepath.Base(fullPath)); err != nil {
+		return fmt.Errorf("error signing %s: %w", filepath.Base(fullPath), err)
+	}
+	if err := writeFileAtomic(fullPath+fs.signer.Extension(), sig.Bytes()); err != nil {
+		return err
+	}
+	if err := fs.AddMetadata(fullPath, map[string]string{MetadataServerSignature: sigName}, SystemActor(MetadataSourceSigning)); err != nil {
+		return fmt.Errorf("error saving server signature: %w", err)
+	}
+	fs.indexPath(fullPath + fs.signer.Extension())
+	_, _, err = fs.VerifySignature(fullPath)
+	return err
+}
+
+// writeSignedManifest создаёт файл контрольных сумм SHA256 папки по
+// сохранённым метаданным и его подпись.
+func (fs *FileService) writeSignedManifest(dirPath string) error {
+	var manifest bytes.Buffer
+	if _, _, err := fs.WriteManifest(&manifest, dirPath, "SHA256", false, false); err != nil {
+		return err
+	}
+	manifestPath := filepath.Join(dirPath, fs.signer.manifest)
+	var sig bytes.Buffer
+	if err := fs.signer.Sign(&sig, bytes.NewReader(manifest.Bytes()), fs.signer.manifest); err != nil {
+		return fmt.Errorf("error signing %s: %w", fs.signer.manifest, err)
+	}
+	if err := writeFileAtomic(manifestPath, manifest.Bytes()); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(manifestPath+fs.signer.Extension(), sig.Bytes()); err != nil {
+		return err
+	}
+	fs.indexPath(manifestPath)
+	fs.indexPath(manifestPath + fs.signer.Extension())
+	return nil
+}
+
+// writeFileAtomic записывает файл через временный файл и переименование,
+// чтобы клиенты не получили его частично записанным.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing %s: %w", filepath.Base(path), err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error replacing %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
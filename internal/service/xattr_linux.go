@@ -0,0 +1,73 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"time"
+)
+
+// listXattr возвращает имена расширенных атрибутов файла.
+func listXattr(path string) ([]string, error) {
+	for {
+		size, err := syscall.Listxattr(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		n, err := syscall.Listxattr(path, buf)
+		if err == syscall.ERANGE {
+			// Атрибуты добавились между вызовами
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, name := range bytes.Split(buf[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+// getXattr возвращает значение расширенного атрибута.
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		if size == 0 {
+			return buf, nil
+		}
+		n, err := syscall.Getxattr(path, name, buf)
+		if err == syscall.ERANGE {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+func setXattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
+
+func removeXattr(path, name string) error {
+	return syscall.Removexattr(path, name)
+}
+
+// changeTime возвращает время изменения индексного дескриптора файла.
+func changeTime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Ctim.Unix())
+	}
+	return info.ModTime()
+}
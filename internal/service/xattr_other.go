@@ -0,0 +1,30 @@
+//go:build !linux
+
+package service
+
+import (
+	"os"
+	"time"
+)
+
+// Расширенные атрибуты поддерживаются только в Linux.
+
+func listXattr(path string) ([]string, error) {
+	return nil, ErrXattrUnsupported
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	return nil, ErrXattrUnsupported
+}
+
+func setXattr(path, name string, value []byte) error {
+	return ErrXattrUnsupported
+}
+
+func removeXattr(path, name string) error {
+	return ErrXattrUnsupported
+}
+
+func changeTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
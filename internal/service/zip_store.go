@@ -0,0 +1,456 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrArchiveChanged возвращается при чтении архива, если исходный файл
+// изменился после расчёта его раскладки.
+var ErrArchiveChanged = errors.New("archived file changed during download")
+
+const (
+	zipLocalHeaderLen   = 30
+	zipCentralHeaderLen = 46
+	zipEndLen           = 22
+	zip64EndLen         = 56
+	zip64LocatorLen     = 20
+	zipMax32            = 0xFFFFFFFF
+	zipMax16            = 0xFFFF
+)
+
+// StoreZip - ZIP-архив без сжатия, раскладка которого рассчитана заранее.
+// Размер и ETag известны до отправки, а содержимое можно читать с любой
+// позиции, поэтому архив отдаётся через http.ServeContent с поддержкой Range.
+// CRC32 файлов берутся из метаданных, если они актуальны.
+type StoreZip struct {
+	segments []zipSegment
+	size     int64
+	etag     string
+	modTime  time.Time
+
+	pos     int64
+	current int // Индекс сегмента с открытым файлом
+	file    *os.File
+}
+
+// zipSegment - часть архива: служебные данные либо содержимое файла.
+type zipSegment struct {
+	offset   int64
+	data     []byte
+	fullPath string
+	size     int64
+	modTime  time.Time
+}
+
+type zipStoreEntry struct {
+	name     string
+	fullPath string
+	size     int64
+	modTime  time.Time
+	mode     os.FileMode
+	crc      uint32
+	isDir    bool
+	offset   int64
+}
+
+// NewStoreZip рассчитывает store-mode ZIP-архив из списка файлов и папок.
+// Файлы без актуального CRC32 в метаданных читаются для его вычисления.
+func (fs *FileService) NewStoreZip(ctx context.Context, files []string, opts ArchiveOptions) (*StoreZip, error) {
+	var entries []*zipStoreEntry
+	for _, file := range files {
+		fullPath := fs.GetFullPath(file)
+		relPath := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(file)), "/")
+		if err := fs.collectZipEntries(ctx, &entries, fullPath, relPath, opts); err != nil {
+			return nil, fmt.Errorf("error adding file to archive: %w", err)
+		}
+	}
+	return buildStoreZip(entries), nil
+}
+
+func (fs *FileService) collectZipEntries(ctx context.Context, entries *[]*zipStoreEntry, fullPath, relPath string, opts ArchiveOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// ZIP не хранит ссылки, поэтому добавляем их цель
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	if opts.ExcludeMeta && IsMetaFile(filepath.Base(fullPath)) {
+		return nil
+	}
+
+	switch {
+	case info.IsDir():
+		*entries = append(*entries, &zipStoreEntry{
+			name:    relPath + "/",
+			modTime: info.ModTime(),
+			mode:    info.Mode(),
+			isDir:   true,
+		})
+		dirEntries, err := os.ReadDir(fullPath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range dirEntries {
+			entryFullPath := filepath.Join(fullPath, entry.Name())
+			entryRelPath := path.Join(relPath, entry.Name())
+			if err := fs.collectZipEntries(ctx, entries, entryFullPath, entryRelPath, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case info.Mode().IsRegular():
+		crc, err := fs.fileCRC32(ctx, fullPath)
+		if err != nil {
+			return err
+		}
+		*entries = append(*entries, &zipStoreEntry{
+			name:     relPath,
+			fullPath: fullPath,
+			size:     info.Size(),
+			modTime:  info.ModTime(),
+			mode:     info.Mode(),
+			crc:      crc,
+		})
+		return nil
+
+	default:
+		// Сокеты, устройства и каналы в архив не попадают
+		return nil
+	}
+}
+
+// fileCRC32 берёт CRC32 из актуальных метаданных или вычисляет его.
+func (fs *FileService) fileCRC32(ctx context.Context, fullPath string) (uint32, error) {
+	if stored := fs.StoredHashes(fullPath)["CRC32"]; stored != "" {
+		if crc, err := strconv.ParseUint(stored, 16, 32); err == nil {
+			return uint32(crc), nil
+		}
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, newProgressReader(ctx, file, nil)); err != nil {
+		return 0, err
+	}
+	return hash.Sum32(), nil
+}
+
+func buildStoreZip(entries []*zipStoreEntry) *StoreZip {
+	z := &StoreZip{current: -1}
+	etag := sha256.New()
+
+	var offset int64
+	for _, e := range entries {
+		e.offset = offset
+		header := zipLocalHeader(e)
+		z.segments = append(z.segments, zipSegment{offset: offset, data: header})
+		offset += int64(len(header))
+		if e.size > 0 {
+			z.segments = append(z.segments, zipSegment{offset: offset, fullPath: e.fullPath, size: e.size, modTime: e.modTime})
+			offset += e.size
+		}
+		if e.modTime.After(z.modTime) {
+			z.modTime = e.modTime
+		}
+		fmt.Fprintf(etag, "%s\x00%d\x00%d\x00%o\x00%08x\n", e.name, e.size, e.modTime.UnixNano(), e.mode, e.crc)
+	}
+
+	var central []byte
+	for _, e := range entries {
+		central = append(central, zipCentralHeader(e)...)
+	}
+	central = append(central, zipEnd(len(entries), offset, int64(len(central)))...)
+	z.segments = append(z.segments, zipSegment{offset: offset, data: central})
+	z.size = offset + int64(len(central))
+	z.etag = `"zip-` + hex.EncodeToString(etag.Sum(nil))[:32] + `"`
+	return z
+}
+
+// Size возвращает размер архива в байтах.
+func (z *StoreZip) Size() int64 {
+	return z.size
+}
+
+// ETag возвращает сильный ETag архива, зависящий от имён, размеров, времени
+// изменения и CRC32 файлов.
+func (z *StoreZip) ETag() string {
+	return z.etag
+}
+
+// ModTime возвращает время последнего изменения среди файлов архива.
+func (z *StoreZip) ModTime() time.Time {
+	return z.modTime
+}
+
+// Read читает содержимое архива с текущей позиции.
+func (z *StoreZip) Read(p []byte) (int, error) {
+	if z.pos >= z.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(z.segments), func(i int) bool {
+		return z.segments[i].offset > z.pos
+	}) - 1
+	seg := z.segments[i]
+	within := z.pos - seg.offset
+
+	if seg.data != nil {
+		n := copy(p, seg.data[within:])
+		z.pos += int64(n)
+		return n, nil
+	}
+
+	if z.current != i {
+		if err := z.openSegment(i); err != nil {
+			return 0, err
+		}
+	}
+	if remaining := seg.size - within; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := z.file.ReadAt(p, within)
+	z.pos += int64(n)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	} else if err == io.EOF {
+		err = ErrArchiveChanged
+	}
+	return n, err
+}
+
+func (z *StoreZip) openSegment(i int) error {
+	if z.file != nil {
+		z.file.Close()
+		z.file = nil
+	}
+	seg := z.segments[i]
+	file, err := os.Open(seg.fullPath)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() != seg.size || !info.ModTime().Equal(seg.modTime) {
+		file.Close()
+		return ErrArchiveChanged
+	}
+	z.file, z.current = file, i
+	return nil
+}
+
+// Seek изменяет позицию чтения.
+func (z *StoreZip) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += z.pos
+	case io.SeekEnd:
+		offset += z.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	z.pos = offset
+	return offset, nil
+}
+
+// Close закрывает открытый файл.
+func (z *StoreZip) Close() error {
+	if z.file == nil {
+		return nil
+	}
+	err := z.file.Close()
+	z.file, z.current = nil, -1
+	return err
+}
+
+func zipFlags(name string) uint16 {
+	for i := 0; i < len(name); i++ {
+		if name[i] >= utf8.RuneSelf {
+			return 0x800 // Имя в UTF-8
+		}
+	}
+	return 0
+}
+
+func zipDosTime(t time.Time) (dosTime, dosDate uint16) {
+	if t.Year() < 1980 {
+		return 0, 1<<5 | 1
+	}
+	dosDate = uint16(t.Year()-1980)<<9 | uint16(t.Month())<<5 | uint16(t.Day())
+	dosTime = uint16(t.Hour())<<11 | uint16(t.Minute())<<5 | uint16(t.Second()/2)
+	return dosTime, dosDate
+}
+
+// zipTimeExtra - расширенная метка времени (0x5455) с mtime в UTC.
+func zipTimeExtra(t time.Time) []byte {
+	unix := t.Unix()
+	if unix < 0 || unix > zipMax32 {
+		return nil
+	}
+	b := make([]byte, 9)
+	binary.LittleEndian.PutUint16(b[0:], 0x5455)
+	binary.LittleEndian.PutUint16(b[2:], 5)
+	b[4] = 1
+	binary.LittleEndian.PutUint32(b[5:], uint32(unix))
+	return b
+}
+
+func zip64Extra(values ...uint64) []byte {
+	b := make([]byte, 4+8*len(values))
+	binary.LittleEndian.PutUint16(b[0:], 0x0001)
+	binary.LittleEndian.PutUint16(b[2:], uint16(8*len(values)))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(b[4+8*i:], v)
+	}
+	return b
+}
+
+func zipVersion(zip64 bool) uint16 {
+	if zip64 {
+		return 45
+	}
+	return 20
+}
+
+func zipLocalHeader(e *zipStoreEntry) []byte {
+	zip64 := e.size >= zipMax32
+	extra := zipTimeExtra(e.modTime)
+	size32 := uint32(e.size)
+	if zip64 {
+		extra = append(extra, zip64Extra(uint64(e.size), uint64(e.size))...)
+		size32 = zipMax32
+	}
+
+	b := make([]byte, zipLocalHeaderLen, zipLocalHeaderLen+len(e.name)+len(extra))
+	dosTime, dosDate := zipDosTime(e.modTime)
+	le := binary.LittleEndian
+	le.PutUint32(b[0:], 0x04034b50)
+	le.PutUint16(b[4:], zipVersion(zip64))
+	le.PutUint16(b[6:], zipFlags(e.name))
+	le.PutUint16(b[8:], 0) // Store
+	le.PutUint16(b[10:], dosTime)
+	le.PutUint16(b[12:], dosDate)
+	le.PutUint32(b[14:], e.crc)
+	le.PutUint32(b[18:], size32)
+	le.PutUint32(b[22:], size32)
+	le.PutUint16(b[26:], uint16(len(e.name)))
+	le.PutUint16(b[28:], uint16(len(extra)))
+	b = append(b, e.name...)
+	return append(b, extra...)
+}
+
+func zipCentralHeader(e *zipStoreEntry) []byte {
+	extra := zipTimeExtra(e.modTime)
+	size32, offset32 := uint32(e.size), uint32(e.offset)
+	var zip64Values []uint64
+	if e.size >= zipMax32 {
+		zip64Values = append(zip64Values, uint64(e.size), uint64(e.size))
+		size32 = zipMax32
+	}
+	if e.offset >= zipMax32 {
+		zip64Values = append(zip64Values, uint64(e.offset))
+		offset32 = zipMax32
+	}
+	zip64 := len(zip64Values) > 0
+	if zip64 {
+		extra = append(extra, zip64Extra(zip64Values...)...)
+	}
+
+	// Права хранятся в старших битах внешних атрибутов, как в Info-ZIP
+	attrs := uint32(e.mode.Perm())
+	if e.isDir {
+		attrs |= 0o040000
+	} else {
+		attrs |= 0o100000
+	}
+	attrs <<= 16
+	if e.isDir {
+		attrs |= 0x10 // MS-DOS directory
+	}
+
+	b := make([]byte, zipCentralHeaderLen, zipCentralHeaderLen+len(e.name)+len(extra))
+	dosTime, dosDate := zipDosTime(e.modTime)
+	le := binary.LittleEndian
+	le.PutUint32(b[0:], 0x02014b50)
+	le.PutUint16(b[4:], 3<<8|zipVersion(zip64)) // Создан в Unix
+	le.PutUint16(b[6:], zipVersion(zip64))
+	le.PutUint16(b[8:], zipFlags(e.name))
+	le.PutUint16(b[10:], 0) // Store
+	le.PutUint16(b[12:], dosTime)
+	le.PutUint16(b[14:], dosDate)
+	le.PutUint32(b[16:], e.crc)
+	le.PutUint32(b[20:], size32)
+	le.PutUint32(b[24:], size32)
+	le.PutUint16(b[28:], uint16(len(e.name)))
+	le.PutUint16(b[30:], uint16(len(extra)))
+	le.PutUint16(b[32:], 0) // Комментарий
+	le.PutUint16(b[34:], 0) // Номер диска
+	le.PutUint16(b[36:], 0) // Внутренние атрибуты
+	le.PutUint32(b[38:], attrs)
+	le.PutUint32(b[42:], offset32)
+	b = append(b, e.name...)
+	return append(b, extra...)
+}
+
+// zipEnd формирует конец центрального каталога, при необходимости в формате ZIP64.
+func zipEnd(count int, centralOffset, centralSize int64) []byte {
+	le := binary.LittleEndian
+	var b []byte
+	count16, size32, offset32 := uint16(count), uint32(centralSize), uint32(centralOffset)
+
+	if count >= zipMax16 || centralSize >= zipMax32 || centralOffset >= zipMax32 {
+		count16, size32, offset32 = zipMax16, zipMax32, zipMax32
+
+		record := make([]byte, zip64EndLen+zip64LocatorLen)
+		le.PutUint32(record[0:], 0x06064b50)
+		le.PutUint64(record[4:], zip64EndLen-12)
+		le.PutUint16(record[12:], 3<<8|45)
+		le.PutUint16(record[14:], 45)
+		le.PutUint64(record[24:], uint64(count))
+		le.PutUint64(record[32:], uint64(count))
+		le.PutUint64(record[40:], uint64(centralSize))
+		le.PutUint64(record[48:], uint64(centralOffset))
+
+		locator := record[zip64EndLen:]
+		le.PutUint32(locator[0:], 0x07064b50)
+		le.PutUint64(locator[8:], uint64(centralOffset+centralSize))
+		le.PutUint32(locator[16:], 1)
+		b = record
+	}
+
+	end := make([]byte, zipEndLen)
+	le.PutUint32(end[0:], 0x06054b50)
+	le.PutUint16(end[8:], count16)
+	le.PutUint16(end[10:], count16)
+	le.PutUint32(end[12:], size32)
+	le.PutUint32(end[16:], offset32)
+	return append(b, end...)
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestStoreZip(t *testing.T) (*StoreZip, map[string][]byte) {
+	t.Helper()
+	base := t.TempDir()
+	files := map[string][]byte{
+		"dir/a.txt":     []byte("hello"),
+		"dir/empty.txt": nil,
+		"dir/sub/b.bin": bytes.Repeat([]byte{0, 1, 2, 3, 0xff}, 20000),
+		"отчёт.txt":     []byte("utf-8 name"),
+	}
+	for name, data := range files {
+		writeTestFile(t, filepath.Join(base, name), data)
+	}
+	writeTestFile(t, filepath.Join(base, "dir", ".a.txt.meta"), []byte("{}"))
+
+	fs := NewFileService(base, nil)
+	z, err := fs.NewStoreZip(context.Background(), []string{"dir", "отчёт.txt"}, ArchiveOptions{ExcludeMeta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { z.Close() })
+	return z, files
+}
+
+func readStoreZip(t *testing.T, z *StoreZip) []byte {
+	t.Helper()
+	if _, err := z.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != z.Size() {
+		t.Fatalf("read %d bytes, Size() = %d", len(data), z.Size())
+	}
+	return data
+}
+
+// Архив должен читаться archive/zip, а CRC32 и содержимое - совпадать.
+func TestStoreZipRoundTrip(t *testing.T) {
+	z, files := newTestStoreZip(t)
+	data := readStoreZip(t, z)
+
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, f := range r.File {
+		seen[f.Name] = true
+		if f.Method != zip.Store {
+			t.Errorf("%s: method %d, want store", f.Name, f.Method)
+		}
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		// archive/zip проверяет CRC32 при чтении до конца
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if !bytes.Equal(content, files[f.Name]) {
+			t.Errorf("%s: content differs", f.Name)
+		}
+	}
+	for _, name := range []string{"dir/", "dir/sub/", "dir/a.txt", "dir/empty.txt", "dir/sub/b.bin", "отчёт.txt"} {
+		if !seen[name] {
+			t.Errorf("missing entry %s", name)
+		}
+	}
+	if seen["dir/.a.txt.meta"] {
+		t.Error("metadata file must be excluded")
+	}
+}
+
+// Чтение с произвольной позиции должно давать те же байты, что и
+// последовательное: на этом держатся Range-запросы.
+func TestStoreZipSeek(t *testing.T) {
+	z, _ := newTestStoreZip(t)
+	full := readStoreZip(t, z)
+
+	for _, offset := range []int64{0, 1, 29, 30, 31, z.Size() / 2, z.Size() - 23, z.Size() - 1} {
+		if _, err := z.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		part, err := io.ReadAll(io.LimitReader(z, 1000))
+		if err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+		end := min(offset+1000, z.Size())
+		if !bytes.Equal(part, full[offset:end]) {
+			t.Errorf("offset %d: range differs", offset)
+		}
+	}
+
+	if pos, err := z.Seek(-10, io.SeekEnd); err != nil || pos != z.Size()-10 {
+		t.Errorf("SeekEnd: %d, %v", pos, err)
+	}
+	if pos, err := z.Seek(4, io.SeekCurrent); err != nil || pos != z.Size()-6 {
+		t.Errorf("SeekCurrent: %d, %v", pos, err)
+	}
+	if _, err := z.Seek(-1, io.SeekStart); err == nil {
+		t.Error("negative position must fail")
+	}
+	if _, err := z.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := z.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("read at end: %d, %v", n, err)
+	}
+}
+
+// Изменение файла после расчёта раскладки не должно отдавать клиенту
+// архив с неверным содержимым.
+func TestStoreZipFileChanged(t *testing.T) {
+	base := t.TempDir()
+	path := filepath.Join(base, "a.txt")
+	writeTestFile(t, path, []byte("hello"))
+
+	z, err := NewFileService(base, nil).NewStoreZip(context.Background(), []string{"a.txt"}, ArchiveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	writeTestFile(t, path, []byte("hello, world"))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	if _, err := io.ReadAll(z); !errors.Is(err, ErrArchiveChanged) {
+		t.Errorf("got %v, want ErrArchiveChanged", err)
+	}
+}
+
+func TestStoreZipETag(t *testing.T) {
+	base := t.TempDir()
+	path := filepath.Join(base, "a.txt")
+	writeTestFile(t, path, []byte("hello"))
+	fs := NewFileService(base, nil)
+	etag := func() string {
+		z, err := fs.NewStoreZip(context.Background(), []string{"a.txt"}, ArchiveOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return z.ETag()
+	}
+
+	first := etag()
+	if !strings.HasPrefix(first, `"zip-`) {
+		t.Errorf("bad ETag %s", first)
+	}
+	if second := etag(); second != first {
+		t.Errorf("ETag changed without file changes: %s != %s", second, first)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if third := etag(); third == first {
+		t.Error("ETag must depend on modification time")
+	}
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"flag"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"fileStation/internal/config"
 	"fileStation/internal/handler"
@@ -65,6 +67,7 @@ func loadTemplates() error {
                 return "insert_drive_file"
             }
         },
+        "isBrowsableArchive": service.IsBrowsableArchive,
         "getFileInfo": func(fullPath, name string) os.FileInfo {
             info, err := os.Stat(filepath.Join(fullPath, name))
             if err != nil {
@@ -153,11 +156,128 @@ func setup( cfg *config.Config, mux *http.ServeMux) {
 	// Сервисы
 	authService := service.NewAuthService()
 	fileService := service.NewFileService(cfg.WebServer.BaseDir, authService)
+	fileService.SetExtractLimits(service.ExtractLimits{
+		MaxTotalSize: cfg.Archives.MaxExtractSizeMB << 20,
+		MaxEntries:   cfg.Archives.MaxExtractEntries,
+	})
+	schemaFields := make([]service.MetadataField, 0, len(cfg.MetadataSchema.Fields))
+	for _, field := range cfg.MetadataSchema.Fields {
+		schemaFields = append(schemaFields, service.MetadataField{
+			Name:     field.Name,
+			Type:     field.Type,
+			Required: field.Required,
+			Pattern:  field.Pattern,
+			Values:   field.Values,
+		})
+	}
+	metadataSchema, err := service.NewMetadataSchema(schemaFields, cfg.MetadataSchema.ReservedFields)
+	if err != nil {
+		logger.Fatalf("Invalid metadata schema: %v", err)
+	}
+	fileService.SetMetadataSchema(metadataSchema)
+	if err := fileService.SetMetadataStorage(cfg.MetadataStorage.Backend); err != nil {
+		logger.Fatalf("Invalid metadata storage: %v", err)
+	}
+	fileService.SetUploadDuplicateWarning(cfg.Duplicates.WarnOnUpload)
+	if err := fileService.SetHashAlgorithms(cfg.Hashes.Algorithms); err != nil {
+		logger.Fatalf("Invalid hash configuration: %v", err)
+	}
+	keyring, err := service.LoadSignatureKeyring(cfg.Signatures.OpenPGPKeyrings, cfg.Signatures.CosignKeys, cfg.Signatures.MinisignKeys)
+	if err != nil {
+		logger.Fatalf("Failed to load signature keys: %v", err)
+	}
+	keyring.SetMaxBufferedSize(cfg.Signatures.MaxBufferedSizeMB << 20)
+	fileService.SetSignatureKeyring(keyring)
+	signer, err := service.LoadFileSigner(cfg.Signing.MinisignKey, cfg.Signing.OpenPGPKey, cfg.Signing.PassphraseFile, cfg.Signing.Manifest)
+	if err != nil {
+		logger.Fatalf("Failed to load signing key: %v", err)
+	}
+	fileService.SetFileSigner(signer)
+	reportParsers := make([]service.ReportParser, 0, len(cfg.Reports.Parsers))
+	for _, parser := range cfg.Reports.Parsers {
+		fields := make([]service.ReportField, 0, len(parser.Fields))
+		for _, field := range parser.Fields {
+			fields = append(fields, service.ReportField{
+				Field:    field.Field,
+				Selector: field.Selector,
+				Label:    field.Label,
+				Pattern:  field.Pattern,
+				Required: field.Required,
+			})
+		}
+		reportParsers = append(reportParsers, service.ReportParser{
+			Name:   parser.Name,
+			Format: parser.Format,
+			Match:  parser.Match,
+			Detect: parser.Detect,
+			Fields: fields,
+			Hashes: parser.Hashes,
+		})
+	}
+	if err := fileService.SetReportParsers(reportParsers, cfg.Reports.DisableBuiltin); err != nil {
+		logger.Fatalf("Invalid report parser configuration: %v", err)
+	}
+	if err := fileService.SetContentExtractors(cfg.ContentMetadata.Extractors, cfg.ContentMetadata.Disabled); err != nil {
+		logger.Fatalf("Invalid content metadata configuration: %v", err)
+	}
+	if !cfg.MetadataIndex.Disabled {
+		index, err := service.OpenMetadataIndex(cfg.MetadataIndex.Path, cfg.WebServer.BaseDir)
+		if err != nil {
+			logger.Fatalf("Failed to open metadata index: %v", err)
+		}
+		fileService.SetMetadataIndex(index)
+		// Файлы метаданных могли измениться, пока сервер был остановлен
+		go func() {
+			stats, err := fileService.SyncMetadataIndex(context.Background(), false)
+			if err != nil {
+				logger.Errorf("Metadata index sync failed: %v", err)
+				return
+			}
+			logger.Infof("Metadata index synced: %d scanned, %d updated, %d removed", stats.Scanned, stats.Updated, stats.Removed)
+		}()
+	}
+	if !cfg.MetadataHistory.Disabled {
+		history, err := service.OpenMetadataHistory(cfg.MetadataHistory.Path, cfg.WebServer.BaseDir, cfg.MetadataHistory.MaxEntries)
+		if err != nil {
+			logger.Fatalf("Failed to open metadata history: %v", err)
+		}
+		fileService.SetMetadataHistory(history)
+	}
+	jobService, err := service.NewJobService(cfg.Jobs.StateDir, cfg.Jobs.Workers, time.Duration(cfg.Jobs.ResultTTLHours)*time.Hour)
+	if err != nil {
+		logger.Fatalf("Failed to initialize job service: %v", err)
+	}
+	fileService.SetScrubOptions(service.ScrubOptions{
+		RateLimit:   int64(cfg.Scrub.RateMBPerSec) << 20,
+		VerifyAfter: time.Duration(cfg.Scrub.VerifyAfterHours) * time.Hour,
+	})
+	fileService.SetReconcileOptions(service.ReconcileOptions{
+		DeleteOrphans: cfg.Reconcile.DeleteOrphans,
+		HashMissing:   cfg.Reconcile.HashMissing,
+		RefreshStale:  cfg.Reconcile.RefreshStale,
+		SignMissing:   cfg.Reconcile.SignMissing,
+	})
+	fileService.RegisterJobs(jobService)
+	if err := jobService.Start(); err != nil {
+		logger.Fatalf("Failed to start job service: %v", err)
+	}
+	if cfg.Scrub.IntervalHours > 0 {
+		go fileService.ScheduleScrub(context.Background(), jobService, time.Duration(cfg.Scrub.IntervalHours)*time.Hour)
+	}
+	if cfg.Reconcile.OnStartup {
+		if _, err := jobService.Submit(service.JobKindReconcile, "", nil); err != nil {
+			logger.Errorf("Error starting metadata reconciliation: %v", err)
+		}
+	}
+	if cfg.Reconcile.IntervalHours > 0 {
+		go fileService.ScheduleReconcile(context.Background(), jobService, time.Duration(cfg.Reconcile.IntervalHours)*time.Hour)
+	}
 
 	// Хендлеры
 	authHandler := handler.NewAuthHandler(authService, loginTemplate, appVersion)
 	fileHandler := handler.NewFileHandler(fileService, indexTemplate, authService, appVersion)
 	helperHandler := handler.NewHelperHandler(fileService)
+	jobHandler := handler.NewJobHandler(jobService, fileService, authService)
 
 	// Статические файлы
 	mux.Handle("/static/", http.StripPrefix("/static/", staticFileServer()))
@@ -171,9 +291,25 @@ func setup( cfg *config.Config, mux *http.ServeMux) {
 	mux.HandleFunc("/dir-tree", helperHandler.DirTreeHandler)
 	mux.HandleFunc("/list-folders", helperHandler.ListFoldersHandler)
 	mux.HandleFunc("/file-metadata", fileHandler.FileMetadataHandler)
+	mux.HandleFunc("/folder-metadata", fileHandler.FolderMetadataHandler)
+	mux.HandleFunc("/metadata/query", fileHandler.MetadataQueryHandler)
+	mux.HandleFunc("/metadata/export", fileHandler.MetadataExportHandler)
+	mux.HandleFunc("/metadata/schema", fileHandler.MetadataSchemaHandler)
+	mux.HandleFunc("/metadata/history", fileHandler.MetadataHistoryHandler)
+	mux.HandleFunc("/search", fileHandler.SearchHandler)
+	mux.HandleFunc("/files/by-hash", fileHandler.FindByHashHandler)
+	mux.HandleFunc("/duplicates", fileHandler.DuplicatesHandler)
+	mux.HandleFunc("/integrity/report", fileHandler.IntegrityReportHandler)
+	mux.HandleFunc("/checksums", fileHandler.ManifestHandler)
+	mux.HandleFunc("/signing-key", fileHandler.SigningKeyHandler)
+	mux.HandleFunc("/archive/list", fileHandler.ArchiveListHandler)
+	mux.HandleFunc("/archive/member", fileHandler.ArchiveMemberHandler)
 	mux.HandleFunc("/recalculate-hashes", fileHandler.RecalculateHashesHandler)
 	mux.HandleFunc("/preview-markdown", fileHandler.PreviewMarkdownHandler)
 	mux.HandleFunc("/save-readme", fileHandler.SaveReadmeHandler)
+	mux.HandleFunc("/jobs", jobHandler.JobsHandler)
+	mux.HandleFunc("/jobs/status", jobHandler.JobStatusHandler)
+	mux.HandleFunc("/jobs/result", jobHandler.JobResultHandler)
 
 	// Защищённые маршруты
 	mux.Handle("/upload", authHandler.Middleware(http.HandlerFunc(fileHandler.UploadHandler)))
@@ -182,6 +318,13 @@ func setup( cfg *config.Config, mux *http.ServeMux) {
 	mux.Handle("/rename", authHandler.Middleware(http.HandlerFunc(fileHandler.RenameHandler)))
 	mux.Handle("/move", authHandler.Middleware(http.HandlerFunc(fileHandler.MoveHandler)))
 	mux.Handle("/save-metadata", authHandler.Middleware(http.HandlerFunc(fileHandler.SaveMetadataHandler)))
+	mux.Handle("/metadata/bulk", authHandler.Middleware(http.HandlerFunc(fileHandler.MetadataBulkHandler)))
+	mux.Handle("/metadata/import", authHandler.Middleware(http.HandlerFunc(fileHandler.MetadataImportHandler)))
+	mux.Handle("/metadata/revert", authHandler.Middleware(http.HandlerFunc(fileHandler.MetadataRevertHandler)))
+	mux.Handle("/metadata/reconcile", authHandler.Middleware(http.HandlerFunc(fileHandler.ReconcileReportHandler)))
+	mux.Handle("/save-folder-metadata", authHandler.Middleware(http.HandlerFunc(fileHandler.SaveFolderMetadataHandler)))
+	mux.Handle("/jobs/cancel", authHandler.Middleware(http.HandlerFunc(jobHandler.JobCancelHandler)))
+	mux.Handle("/signatures/verify", authHandler.Middleware(http.HandlerFunc(fileHandler.VerifySignaturesHandler)))
 
 	
 
@@ -190,6 +333,7 @@ func setup( cfg *config.Config, mux *http.ServeMux) {
 func main() {
 	versionFlag := flag.Bool("version", false, "Display application version")
 	configPath := flag.String("config", "config.yaml", "Path to the configuration file")
+	migrateMetadata := flag.String("migrate-metadata", "", "Move stored metadata to the given storage (sidecar or xattr) and exit")
 	flag.Parse()
 
 	if *versionFlag {
@@ -204,6 +348,10 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *migrateMetadata != "" {
+		os.Exit(runMetadataMigration(&cfg, *migrateMetadata))
+	}
+
 	// Create a new ServeMux
     mux := http.NewServeMux()
 
@@ -225,3 +373,29 @@ func main() {
 		logger.Fatal(http.ListenAndServe(addr, mux))
 	}
 }
+
+// runMetadataMigration переносит метаданные в хранилище target из другого
+// хранилища и возвращает код завершения.
+func runMetadataMigration(cfg *config.Config, target string) int {
+	source := service.MetadataStorageSidecar
+	if strings.EqualFold(target, service.MetadataStorageSidecar) {
+		source = service.MetadataStorageXattr
+	}
+	fileService := service.NewFileService(cfg.WebServer.BaseDir, nil)
+	result, err := fileService.MigrateMetadataStorage(context.Background(), source, target)
+	for _, failure := range result.Failed {
+		fmt.Printf("Not migrated: %s\n", failure)
+	}
+	fmt.Printf("Migrated metadata of %d files and folders from %s to %s\n", result.Migrated, source, target)
+	if err != nil {
+		fmt.Printf("Metadata migration failed: %v\n", err)
+		return 1
+	}
+	if !strings.EqualFold(cfg.MetadataStorage.Backend, target) {
+		fmt.Printf("Set metadata_storage.backend to %q in the configuration before starting the server\n", target)
+	}
+	if len(result.Failed) > 0 {
+		return 1
+	}
+	return 0
+}
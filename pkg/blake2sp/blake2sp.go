@@ -0,0 +1,206 @@
+// Пакет blake2sp реализует хеш-функцию BLAKE2sp - параллельный вариант BLAKE2s
+// (RFC 7693, https://blake2.net), который используют 7-Zip и WinRAR.
+//
+// Данные делятся на блоки по 64 байта, которые по кругу распределяются между
+// восемью листьями BLAKE2s (fanout 8, depth 2, inner length 32). Итоговая
+// сумма - BLAKE2s корневого узла от восьми 32-байтных сумм листьев.
+//
+// Пример использования:
+//
+//	h := blake2sp.New()
+//	io.Copy(h, file)
+//	fmt.Printf("%x\n", h.Sum(nil))
+package blake2sp
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+const (
+	// Size - размер суммы BLAKE2sp в байтах.
+	Size = 32
+	// BlockSize - размер блока BLAKE2s в байтах.
+	BlockSize = 64
+
+	parallelism = 8
+)
+
+var iv = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+var sigma = [10][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// node - узел дерева BLAKE2s с заданным блоком параметров.
+type node struct {
+	h        [8]uint32
+	t        uint64
+	buf      [BlockSize]byte
+	n        int
+	lastNode bool
+}
+
+// newNode инициализирует узел BLAKE2sp: nodeOffset - номер листа,
+// nodeDepth - 0 для листьев и 1 для корня.
+func newNode(nodeOffset uint32, nodeDepth byte, lastNode bool) node {
+	var p [32]byte
+	p[0] = Size        // digest length
+	p[2] = parallelism // fanout
+	p[3] = 2           // depth
+	binary.LittleEndian.PutUint32(p[8:], nodeOffset)
+	p[14] = nodeDepth
+	p[15] = Size // inner length
+
+	n := node{lastNode: lastNode}
+	for i := range n.h {
+		n.h[i] = iv[i] ^ binary.LittleEndian.Uint32(p[4*i:])
+	}
+	return n
+}
+
+// write добавляет данные; последний блок сжимается только в sum.
+func (n *node) write(p []byte) {
+	for len(p) > 0 {
+		if n.n == BlockSize {
+			n.t += BlockSize
+			n.compress(n.buf[:], false)
+			n.n = 0
+		}
+		c := copy(n.buf[n.n:], p)
+		n.n += c
+		p = p[c:]
+	}
+}
+
+// sum возвращает сумму узла, не изменяя его состояние.
+func (n node) sum() [Size]byte {
+	n.t += uint64(n.n)
+	for i := n.n; i < BlockSize; i++ {
+		n.buf[i] = 0
+	}
+	n.compress(n.buf[:], true)
+
+	var out [Size]byte
+	for i, v := range n.h {
+		binary.LittleEndian.PutUint32(out[4*i:], v)
+	}
+	return out
+}
+
+func (n *node) compress(block []byte, final bool) {
+	var m [16]uint32
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint32(block[4*i:])
+	}
+	var v [16]uint32
+	copy(v[:8], n.h[:])
+	copy(v[8:], iv[:])
+	v[12] ^= uint32(n.t)
+	v[13] ^= uint32(n.t >> 32)
+	if final {
+		v[14] = ^v[14]
+		if n.lastNode {
+			v[15] = ^v[15]
+		}
+	}
+
+	g := func(a, b, c, d int, x, y uint32) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft32(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft32(v[b]^v[c], -12)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft32(v[d]^v[a], -8)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft32(v[b]^v[c], -7)
+	}
+	for _, s := range sigma {
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+	for i := range n.h {
+		n.h[i] ^= v[i] ^ v[i+8]
+	}
+}
+
+// digest реализует hash.Hash для BLAKE2sp.
+type digest struct {
+	leaves  [parallelism]node
+	written uint64
+}
+
+// New создает новый экземпляр hash.Hash, вычисляющий BLAKE2sp.
+func New() hash.Hash {
+	d := &digest{}
+	d.Reset()
+	return d
+}
+
+// Sum256 возвращает сумму BLAKE2sp данных.
+func Sum256(data []byte) [Size]byte {
+	d := &digest{}
+	d.Reset()
+	d.Write(data)
+	return d.sum()
+}
+
+func (d *digest) Reset() {
+	for i := range d.leaves {
+		d.leaves[i] = newNode(uint32(i), 0, i == parallelism-1)
+	}
+	d.written = 0
+}
+
+func (d *digest) Size() int { return Size }
+
+func (d *digest) BlockSize() int { return BlockSize }
+
+func (d *digest) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		// Блок номер k достаётся листу k mod 8
+		leaf := (d.written / BlockSize) % parallelism
+		c := BlockSize - int(d.written%BlockSize)
+		if c > len(p) {
+			c = len(p)
+		}
+		d.leaves[leaf].write(p[:c])
+		d.written += uint64(c)
+		p = p[c:]
+	}
+	return written, nil
+}
+
+func (d *digest) Sum(b []byte) []byte {
+	sum := d.sum()
+	return append(b, sum[:]...)
+}
+
+func (d *digest) sum() [Size]byte {
+	root := newNode(0, 1, true)
+	for _, leaf := range d.leaves {
+		s := leaf.sum()
+		root.write(s[:])
+	}
+	return root.sum()
+}
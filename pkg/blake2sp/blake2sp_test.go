@@ -0,0 +1,73 @@
+package blake2sp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Эталонные суммы посчитаны hashlib.blake2s с параметрами дерева BLAKE2sp;
+// сумма пустой строки совпадает со значением 7-Zip.
+var knownAnswers = []struct {
+	name string
+	data []byte
+	sum  string
+}{
+	{"empty", nil, "dd0e891776933f43c7d032b08a917e25741f8aa9a12c12e1cac8801500f2ca4f"},
+	{"abc", []byte("abc"), "70f75b58f1fecab821db43c88ad84edde5a52600616cd22517b7bb14d440a7d5"},
+	{"1025 bytes", append(bytes.Repeat(sequence(256), 4), 'x'), "40aa4715e128840f34ad00fb28bb11f1a25c42d7af956e6ccda7f7ebc0088a98"},
+	{"million a", []byte(strings.Repeat("a", 1000000)), "106cd96590d84eede13f09f3940b8e1a7c728988f9b771f811a2f21fd768cc92"},
+}
+
+func sequence(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func TestSum256(t *testing.T) {
+	for _, tc := range knownAnswers {
+		sum := Sum256(tc.data)
+		if got := hex.EncodeToString(sum[:]); got != tc.sum {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.sum)
+		}
+	}
+}
+
+// Результат не должен зависеть от того, как данные порезаны на вызовы Write.
+func TestWriteChunks(t *testing.T) {
+	for _, tc := range knownAnswers {
+		for _, chunk := range []int{1, 7, 63, 64, 65, 511, 512, 4096} {
+			h := New()
+			for data := tc.data; len(data) > 0; {
+				n := min(chunk, len(data))
+				h.Write(data[:n])
+				data = data[n:]
+			}
+			if got := hex.EncodeToString(h.Sum(nil)); got != tc.sum {
+				t.Errorf("%s by %d: got %s, want %s", tc.name, chunk, got, tc.sum)
+			}
+		}
+	}
+}
+
+func TestSumDoesNotChangeState(t *testing.T) {
+	h := New()
+	h.Write([]byte("ab"))
+	h.Sum(nil)
+	h.Write([]byte("c"))
+	if got := hex.EncodeToString(h.Sum([]byte{})); got != knownAnswers[1].sum {
+		t.Errorf("got %s, want %s", got, knownAnswers[1].sum)
+	}
+
+	h.Reset()
+	if got := hex.EncodeToString(h.Sum(nil)); got != knownAnswers[0].sum {
+		t.Errorf("after Reset: got %s, want %s", got, knownAnswers[0].sum)
+	}
+	if h.Size() != Size || h.BlockSize() != BlockSize {
+		t.Errorf("Size/BlockSize = %d/%d", h.Size(), h.BlockSize())
+	}
+}
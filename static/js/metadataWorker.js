@@ -1,13 +1,46 @@
 self.onmessage = function(event) {
     const { filePath } = event.data;
 
-    fetch('/recalculate-hashes?path=' + encodeURIComponent(filePath))
+    // Hash recalculation runs as a background job on the server, so a closed tab
+    // no longer aborts it; the worker just polls until the job finishes.
+    const body = new URLSearchParams({ kind: 'recalculate-hashes', path: filePath });
+
+    fetch('/jobs', { method: 'POST', body: body })
+        .then(response => {
+            // Recalculation signs the file with the server key, so it needs a login
+            if (response.status === 401) {
+                throw new Error('Log in to recalculate hashes');
+            }
+            return response.json();
+        })
+        .then(job => waitForJob(job.id))
+        .then(job => fetch('/jobs/result?id=' + encodeURIComponent(job.id)))
         .then(response => response.json())
         .then(hashes => {
             self.postMessage({ hashes });
         })
         .catch(error => {
             console.error('Error recalculating hashes:', error);
-            self.postMessage({ error: 'Error recalculating hashes' });
+            self.postMessage({ error: error.message === 'Log in to recalculate hashes' ? error.message : 'Error recalculating hashes' });
         });
 };
+
+function waitForJob(id) {
+    return new Promise((resolve, reject) => {
+        function poll() {
+            fetch('/jobs/status?id=' + encodeURIComponent(id))
+                .then(response => response.json())
+                .then(job => {
+                    if (job.status === 'completed') {
+                        resolve(job);
+                    } else if (job.status === 'failed' || job.status === 'canceled') {
+                        reject(new Error(job.error || 'Job ' + job.status));
+                    } else {
+                        setTimeout(poll, 1000);
+                    }
+                })
+                .catch(reject);
+        }
+        poll();
+    });
+}
@@ -2,6 +2,7 @@ document.addEventListener('DOMContentLoaded', function() {
     var body = document.body;
     var editMode = false;
     var originalMetadata = {};
+    var metadataRevision = null; // Revision of the loaded metadata, sent back as If-Match
     var currentFilePath = ''; // Initialize as an empty string
 
     // Function to set theme
@@ -141,6 +142,27 @@ document.addEventListener('DOMContentLoaded', function() {
         return false;
     }
 
+    // Function to poll a background job until it finishes
+    function waitForJob(id) {
+        return new Promise(function(resolve, reject) {
+            function poll() {
+                fetch('/jobs/status?id=' + encodeURIComponent(id))
+                    .then(response => response.json())
+                    .then(job => {
+                        if (job.status === 'completed') {
+                            resolve(job);
+                        } else if (job.status === 'failed' || job.status === 'canceled') {
+                            reject(new Error(job.error || 'Job ' + job.status));
+                        } else {
+                            setTimeout(poll, 1000);
+                        }
+                    })
+                    .catch(reject);
+            }
+            poll();
+        });
+    }
+
     // Function to check login status before performing an action
     function checkLoginAndPerformAction(action) {
         fetch('/check-session', {
@@ -188,13 +210,43 @@ document.addEventListener('DOMContentLoaded', function() {
                 fetch(uploadForm.action, {
                     method: 'POST',
                     body: formData,
+                    headers: { 'Accept': 'application/json' },
                 }).then(response => {
                     if (handleUnauthorizedResponse(response)) return;
                     if (response.ok) {
-                        window.location.reload();
+                        response.json().then(data => {
+                            var messages = [];
+                            if (data.duplicates && data.duplicates.length > 0) {
+                                messages.push('Duplicate content uploaded:<br>' + data.duplicates.map(function(duplicate) {
+                                    return escapeHtml(duplicate.file) + ' already exists as ' + duplicate.paths.map(escapeHtml).join(', ');
+                                }).join('<br>'));
+                            }
+                            if (data.checksum_mismatches && data.checksum_mismatches.length > 0) {
+                                messages.push('Checksum mismatch:<br>' + data.checksum_mismatches.map(function(mismatch) {
+                                    return escapeHtml(mismatch.file) + ': ' + escapeHtml(mismatch.algorithm) + ' differs from ' + escapeHtml(mismatch.manifest);
+                                }).join('<br>'));
+                            }
+                            var reportWarnings = (data.reports || []).filter(function(report) {
+                                return report.warnings && report.warnings.length > 0;
+                            });
+                            if (reportWarnings.length > 0) {
+                                messages.push('Report warnings:<br>' + reportWarnings.map(function(report) {
+                                    return escapeHtml(report.file) + ' (' + escapeHtml(report.parser) + '): ' + report.warnings.map(escapeHtml).join('; ');
+                                }).join('<br>'));
+                            }
+                            if (messages.length === 0) {
+                                window.location.reload();
+                                return;
+                            }
+                            M.toast({
+                                html: messages.join('<br>'),
+                                displayLength: 8000,
+                                completeCallback: function() { window.location.reload(); }
+                            });
+                        }).catch(() => window.location.reload());
                     } else {
                         response.text().then(text => {
-                            M.toast({ html: 'Error uploading files: ' + text });
+                            M.toast({ html: 'Error uploading files: ' + formatMetadataErrors(text) });
                         });
                     }
                 }).catch(error => {
@@ -293,6 +345,8 @@ document.addEventListener('DOMContentLoaded', function() {
     var deleteButton = document.getElementById('deleteButton');
     var renameButton = document.getElementById('renameButton');
     var moveButton = document.getElementById('moveButton');
+    var extractButton = document.getElementById('extractButton');
+    var bulkMetadataButton = document.getElementById('bulkMetadataButton');
     var fileForm = document.getElementById('fileForm');
 
     // Proceed only if file management elements are present
@@ -352,6 +406,25 @@ document.addEventListener('DOMContentLoaded', function() {
                     renameButton.classList.add('disabled');
                 }
             }
+
+            // Manage bulk metadata button (at least one file)
+            if (bulkMetadataButton) {
+                if (Array.from(checkedItems).some(function(item) { return item.getAttribute('data-type') === 'file'; })) {
+                    bulkMetadataButton.classList.remove('disabled');
+                } else {
+                    bulkMetadataButton.classList.add('disabled');
+                }
+            }
+
+            // Manage extract button (single archive file only)
+            if (extractButton) {
+                if (checkedItems.length === 1 && checkedItems[0].getAttribute('data-type') === 'file' &&
+                    /\.(zip|tar|tar\.gz|tgz|tar\.zst|tzst)$/i.test(checkedItems[0].value)) {
+                    extractButton.classList.remove('disabled');
+                } else {
+                    extractButton.classList.add('disabled');
+                }
+            }
         }
 
         if (selectAllCheckbox) {
@@ -384,7 +457,9 @@ document.addEventListener('DOMContentLoaded', function() {
                 }
                 // Form submission handled by form's submit event
                 fileForm.action = '/download';
-                fileForm.method = 'post';
+                // Store-mode zip can be resumed, which browsers only do for GET requests
+                var formatSelect = fileForm.querySelector('select[name="format"]');
+                fileForm.method = formatSelect && formatSelect.value === 'zip-store' ? 'get' : 'post';
                 fileForm.submit();
             });
         }
@@ -513,6 +588,344 @@ document.addEventListener('DOMContentLoaded', function() {
             });
         }
 
+        // Folder defaults: metadata inherited by all files in the folder
+        var folderDefaultsButton = document.getElementById('folderDefaultsButton');
+        var folderDefaults = {};
+        var folderDefaultsRevision = null;
+
+        // Adds an editable field/value row; removing a row clears its value
+        function addMetadataRow(container, key, value) {
+            var row = document.createElement('div');
+            row.classList.add('metadata-field', 'metadata-row');
+            var keyInput = document.createElement('input');
+            keyInput.type = 'text';
+            keyInput.placeholder = 'Field';
+            keyInput.value = key || '';
+            keyInput.classList.add('metadata-input', 'metadata-row-key');
+            var valueInput = document.createElement('input');
+            valueInput.type = 'text';
+            valueInput.placeholder = 'Value';
+            valueInput.value = value || '';
+            valueInput.classList.add('metadata-input', 'metadata-row-value');
+            var removeIcon = document.createElement('i');
+            removeIcon.className = 'material-icons red-text metadata-row-remove';
+            removeIcon.textContent = 'delete';
+            removeIcon.title = 'Remove field';
+            removeIcon.addEventListener('click', function() {
+                valueInput.value = '';
+                row.style.display = 'none';
+            });
+            row.appendChild(keyInput);
+            row.appendChild(valueInput);
+            row.appendChild(removeIcon);
+            container.appendChild(row);
+        }
+
+        function openFolderDefaults() {
+            var folderPath = document.querySelector('input[name="currentPath"]').value;
+            fetch('/folder-metadata?path=' + encodeURIComponent(folderPath))
+                .then(response => response.json())
+                .then(data => {
+                    folderDefaults = data.defaults || {};
+                    folderDefaultsRevision = data.revision || null;
+                    var inherited = document.getElementById('folderInheritedFields');
+                    inherited.innerHTML = '';
+                    Object.keys(data.inherited || {}).sort().forEach(function(key) {
+                        var fieldDiv = document.createElement('div');
+                        fieldDiv.classList.add('metadata-field');
+                        var label = document.createElement('label');
+                        label.textContent = key + ' (from ' + data.sources[key] + '):';
+                        var value = document.createElement('input');
+                        value.type = 'text';
+                        value.value = data.inherited[key];
+                        value.readOnly = true;
+                        value.classList.add('metadata-input');
+                        fieldDiv.appendChild(label);
+                        fieldDiv.appendChild(value);
+                        inherited.appendChild(fieldDiv);
+                    });
+                    document.getElementById('folderDefaultsFields').innerHTML = '';
+                    Object.keys(folderDefaults).sort().forEach(function(key) {
+                        addMetadataRow(document.getElementById('folderDefaultsFields'), key, folderDefaults[key]);
+                    });
+                    if (Object.keys(folderDefaults).length === 0) {
+                        addMetadataRow(document.getElementById('folderDefaultsFields'), '', '');
+                    }
+                    M.Modal.getInstance(document.getElementById('folderDefaultsModal')).open();
+                })
+                .catch(error => {
+                    console.error('Error loading folder defaults:', error);
+                    M.toast({ html: 'Error loading folder defaults' });
+                });
+        }
+
+        if (folderDefaultsButton) {
+            folderDefaultsButton.addEventListener('click', function(event) {
+                event.preventDefault();
+                checkLoginAndPerformAction(openFolderDefaults);
+            });
+            document.getElementById('addFolderDefaultButton').addEventListener('click', function() {
+                addMetadataRow(document.getElementById('folderDefaultsFields'), '', '');
+            });
+            document.getElementById('saveFolderDefaultsButton').addEventListener('click', function() {
+                var changes = {};
+                var kept = {};
+                document.querySelectorAll('#folderDefaultsFields .metadata-row').forEach(function(row) {
+                    var key = row.querySelector('.metadata-row-key').value.trim();
+                    var value = row.querySelector('.metadata-row-value').value;
+                    if (!key) {
+                        return;
+                    }
+                    if (value !== '') {
+                        kept[key] = true;
+                    }
+                    if (folderDefaults[key] !== value) {
+                        changes[key] = value;
+                    }
+                });
+                // Renamed or removed fields
+                Object.keys(folderDefaults).forEach(function(key) {
+                    if (!kept[key]) {
+                        changes[key] = '';
+                    }
+                });
+                if (Object.keys(changes).length === 0) {
+                    M.toast({ html: 'No changes to save' });
+                    return;
+                }
+                changes['FilePath'] = document.querySelector('input[name="currentPath"]').value;
+                var headers = { 'Content-Type': 'application/json' };
+                if (folderDefaultsRevision) {
+                    headers['If-Match'] = '"' + folderDefaultsRevision + '"';
+                }
+                fetch('/save-folder-metadata', {
+                    method: 'POST',
+                    headers: headers,
+                    body: JSON.stringify(changes),
+                })
+                    .then(response => {
+                        if (response.ok) {
+                            M.toast({ html: 'Folder defaults saved successfully' });
+                            M.Modal.getInstance(document.getElementById('folderDefaultsModal')).close();
+                        } else if (response.status === 409) {
+                            M.toast({ html: 'Folder defaults were changed by someone else, reopen them and try again', displayLength: 6000 });
+                        } else {
+                            response.text().then(text => {
+                                M.toast({ html: 'Error saving folder defaults: ' + formatMetadataErrors(text), displayLength: 6000 });
+                            });
+                        }
+                    })
+                    .catch(error => {
+                        console.error('Error saving folder defaults:', error);
+                        M.toast({ html: 'Error saving folder defaults' });
+                    });
+            });
+        }
+
+        // Renders the per-file result of a metadata bulk edit or import
+        function renderMetadataDiff(container, data) {
+            container.innerHTML = '';
+            var results = (data.results || []).filter(function(result) {
+                return result.changes.length > 0 || result.error || result.errors;
+            });
+            var summary = document.createElement('p');
+            if (results.length === 0) {
+                summary.textContent = 'No changes.';
+                container.appendChild(summary);
+                return;
+            }
+            summary.textContent = data.applied ? 'Applied:' : 'Changes:';
+            container.appendChild(summary);
+            var table = document.createElement('table');
+            table.classList.add('striped');
+            results.forEach(function(result) {
+                var rows = [];
+                if (result.error) {
+                    rows.push(['', result.error, '']);
+                }
+                (result.errors || []).forEach(function(e) {
+                    rows.push([e.field, e.message, '']);
+                });
+                result.changes.forEach(function(change) {
+                    rows.push([change.field, change.old || '', change.new || '']);
+                });
+                rows.forEach(function(cells, index) {
+                    var tr = document.createElement('tr');
+                    var pathCell = document.createElement('td');
+                    pathCell.textContent = index === 0 ? result.path : '';
+                    tr.appendChild(pathCell);
+                    cells.forEach(function(text, i) {
+                        var td = document.createElement('td');
+                        td.textContent = text;
+                        if (result.error || result.errors) {
+                            td.classList.add('red-text');
+                        } else if (i === 1) {
+                            td.classList.add('diff-old');
+                        }
+                        tr.appendChild(td);
+                    });
+                    table.appendChild(tr);
+                });
+            });
+            container.appendChild(table);
+        }
+
+        // Revisions of the previewed files, so applying fails if they change in between
+        var previewRevisions = {};
+
+        // Sends a metadata change and shows the result; dry runs enable the apply button
+        function submitMetadataChange(url, options, preview, applyButton, dryRun) {
+            fetch(url, options)
+                .then(response => {
+                    if (response.status === 200 || response.status === 422 || response.status === 409) {
+                        return response.json().then(data => {
+                            renderMetadataDiff(preview, data);
+                            var failed = response.status !== 200;
+                            if (dryRun && !failed) {
+                                previewRevisions = {};
+                                (data.results || []).forEach(function(result) {
+                                    if (result.revision) {
+                                        previewRevisions[result.path] = result.revision;
+                                    }
+                                });
+                                applyButton.classList.remove('disabled');
+                            } else {
+                                applyButton.classList.add('disabled');
+                            }
+                            if (data.applied) {
+                                M.toast({ html: 'Metadata updated successfully' });
+                            } else if (response.status === 409) {
+                                M.toast({ html: 'Some files were changed by someone else, preview the changes again' });
+                            } else if (failed) {
+                                M.toast({ html: 'Some files cannot be updated, nothing was changed' });
+                            }
+                        });
+                    }
+                    return response.text().then(text => {
+                        M.toast({ html: 'Error updating metadata: ' + escapeHtml(text) });
+                    });
+                })
+                .catch(error => {
+                    console.error('Error updating metadata:', error);
+                    M.toast({ html: 'Error updating metadata' });
+                });
+        }
+
+        // Bulk metadata editing of the selected files
+        var bulkMetadataPaths = [];
+
+        function bulkMetadataRequest(dryRun) {
+            var request = { paths: bulkMetadataPaths, set: {}, unset: [], dry_run: dryRun };
+            if (!dryRun) {
+                request.revisions = previewRevisions;
+            }
+            document.querySelectorAll('#bulkMetadataFields .metadata-row').forEach(function(row) {
+                var key = row.querySelector('.metadata-row-key').value.trim();
+                var value = row.querySelector('.metadata-row-value').value;
+                if (!key) {
+                    return;
+                }
+                if (value === '') {
+                    request.unset.push(key);
+                } else {
+                    request.set[key] = value;
+                }
+            });
+            return request;
+        }
+
+        function sendBulkMetadata(dryRun) {
+            var request = bulkMetadataRequest(dryRun);
+            if (Object.keys(request.set).length === 0 && request.unset.length === 0) {
+                M.toast({ html: 'No changes to save' });
+                return;
+            }
+            submitMetadataChange('/metadata/bulk', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(request),
+            }, document.getElementById('bulkMetadataPreview'), document.getElementById('applyBulkMetadataButton'), dryRun);
+        }
+
+        if (bulkMetadataButton) {
+            bulkMetadataButton.addEventListener('click', function(event) {
+                event.preventDefault();
+                if (bulkMetadataButton.classList.contains('disabled')) {
+                    return;
+                }
+                checkLoginAndPerformAction(function() {
+                    bulkMetadataPaths = Array.from(document.querySelectorAll('.item-checkbox:checked'))
+                        .filter(function(item) { return item.getAttribute('data-type') === 'file'; })
+                        .map(function(item) { return item.value; });
+                    if (bulkMetadataPaths.length === 0) {
+                        M.toast({ html: 'Please select files to edit.' });
+                        return;
+                    }
+                    document.getElementById('bulkMetadataTargets').textContent = bulkMetadataPaths.length + ' file(s) selected';
+                    document.getElementById('bulkMetadataFields').innerHTML = '';
+                    document.getElementById('bulkMetadataPreview').innerHTML = '';
+                    document.getElementById('applyBulkMetadataButton').classList.add('disabled');
+                    addMetadataRow(document.getElementById('bulkMetadataFields'), '', '');
+                    M.Modal.getInstance(document.getElementById('bulkMetadataModal')).open();
+                });
+            });
+            document.getElementById('addBulkMetadataButton').addEventListener('click', function() {
+                addMetadataRow(document.getElementById('bulkMetadataFields'), '', '');
+            });
+            document.getElementById('bulkMetadataFields').addEventListener('input', function() {
+                document.getElementById('applyBulkMetadataButton').classList.add('disabled');
+            });
+            document.getElementById('previewBulkMetadataButton').addEventListener('click', function() {
+                sendBulkMetadata(true);
+            });
+            document.getElementById('applyBulkMetadataButton').addEventListener('click', function() {
+                if (!this.classList.contains('disabled')) {
+                    sendBulkMetadata(false);
+                }
+            });
+        }
+
+        // Import of an edited metadata export
+        var importMetadataButton = document.getElementById('importMetadataButton');
+
+        function sendMetadataImport(dryRun) {
+            var input = document.getElementById('importMetadataFile');
+            if (!input.files.length) {
+                M.toast({ html: 'Please select a file to import.' });
+                return;
+            }
+            var formData = new FormData();
+            formData.append('file', input.files[0]);
+            formData.append('dry_run', dryRun ? 'true' : 'false');
+            if (!dryRun) {
+                formData.append('revisions', JSON.stringify(previewRevisions));
+            }
+            submitMetadataChange('/metadata/import', { method: 'POST', body: formData },
+                document.getElementById('importMetadataPreview'), document.getElementById('applyImportMetadataButton'), dryRun);
+        }
+
+        if (importMetadataButton) {
+            importMetadataButton.addEventListener('click', function(event) {
+                event.preventDefault();
+                checkLoginAndPerformAction(function() {
+                    document.getElementById('importMetadataPreview').innerHTML = '';
+                    document.getElementById('applyImportMetadataButton').classList.add('disabled');
+                    M.Modal.getInstance(document.getElementById('importMetadataModal')).open();
+                });
+            });
+            document.getElementById('importMetadataFile').addEventListener('change', function() {
+                document.getElementById('applyImportMetadataButton').classList.add('disabled');
+            });
+            document.getElementById('previewImportMetadataButton').addEventListener('click', function() {
+                sendMetadataImport(true);
+            });
+            document.getElementById('applyImportMetadataButton').addEventListener('click', function() {
+                if (!this.classList.contains('disabled')) {
+                    sendMetadataImport(false);
+                }
+            });
+        }
+
         // Add authorization check before showing rename modal
         if (renameButton) {
             renameButton.addEventListener('click', function(event) {
@@ -674,6 +1087,63 @@ document.addEventListener('DOMContentLoaded', function() {
                 modal.close();
             });
         } // End of moveButton check
+
+        // Handler for the "Extract" button
+        if (extractButton) {
+            extractButton.addEventListener('click', function(event) {
+                event.preventDefault();
+                if (extractButton.classList.contains('disabled')) {
+                    return;
+                }
+                checkLoginAndPerformAction(function() {
+                    var checkedItems = document.querySelectorAll('.item-checkbox:checked');
+                    if (checkedItems.length !== 1) {
+                        M.toast({html: 'Please select exactly one archive to extract.'});
+                        return;
+                    }
+                    var archivePath = checkedItems[0].value;
+                    var destination = archivePath.replace(/\.(zip|tar|tar\.gz|tgz|tar\.zst|tzst)$/i, '');
+                    document.getElementById('extractArchivePath').value = archivePath;
+                    document.getElementById('extractDestination').value = destination;
+                    document.getElementById('extractDestinationLabel').classList.add('active');
+                    var modal = M.Modal.getInstance(document.getElementById('extractModal'));
+                    modal.open();
+                });
+            });
+
+            document.getElementById('confirmExtractButton').addEventListener('click', function() {
+                var body = new URLSearchParams({
+                    kind: 'extract',
+                    path: document.getElementById('extractArchivePath').value,
+                    destination: document.getElementById('extractDestination').value,
+                    overwrite: document.getElementById('extractOverwrite').checked ? 'true' : 'false'
+                });
+                M.Modal.getInstance(document.getElementById('extractModal')).close();
+
+                fetch('/jobs', { method: 'POST', body: body })
+                    .then(response => {
+                        if (handleUnauthorizedResponse(response)) {
+                            throw new Error('Unauthorized');
+                        }
+                        if (!response.ok) {
+                            return response.text().then(text => { throw new Error(text); });
+                        }
+                        return response.json();
+                    })
+                    .then(job => {
+                        M.toast({html: 'Extraction started'});
+                        return waitForJob(job.id);
+                    })
+                    .then(job => {
+                        M.toast({html: 'Archive extracted to ' + job.result.destination});
+                        setTimeout(function() { location.reload(); }, 1000);
+                    })
+                    .catch(error => {
+                        console.error('Error extracting archive:', error);
+                        M.toast({html: 'Error extracting archive: ' + error.message, displayLength: 6000});
+                    });
+            });
+        }
     } // End of fileForm check
 
     var elems = document.querySelectorAll('.modal');
@@ -803,7 +1273,6 @@ document.addEventListener('DOMContentLoaded', function() {
             const rdsSHA256 = document.getElementById('rdsSHA256').value;
             const rdsCRC64 = document.getElementById('rdsCRC64').value.toUpperCase();
             const rdsBLAKE2sp = document.getElementById('rdsBLAKE2sp').value;
-            const version = document.getElementById('version').value;
 
             // Use the currentFilePath variable
             const filePath = currentFilePath;
@@ -816,7 +1285,10 @@ document.addEventListener('DOMContentLoaded', function() {
             if (originalMetadata['RDS BLAKE2sp'] !== rdsBLAKE2sp) updatedMetadata['RDS BLAKE2sp'] = rdsBLAKE2sp;
             if (originalMetadata['RDS SHA1'] !== rdsSHA1) updatedMetadata['RDS SHA1'] = rdsSHA1;
             if (originalMetadata['RDS SHA256'] !== rdsSHA256) updatedMetadata['RDS SHA256'] = rdsSHA256;
-            if (originalMetadata['Version'] !== version) updatedMetadata['Version'] = version;
+            document.querySelectorAll('.schema-field-input').forEach(function(input) {
+                var name = input.getAttribute('data-field');
+                if ((originalMetadata[name] || '') !== input.value) updatedMetadata[name] = input.value;
+            });
 
             if (Object.keys(updatedMetadata).length === 0) {
                 M.toast({ html: 'No changes to save' });
@@ -825,9 +1297,13 @@ document.addEventListener('DOMContentLoaded', function() {
 
             updatedMetadata['FilePath'] = filePath;
 
+            var headers = { 'Content-Type': 'application/json' };
+            if (metadataRevision) {
+                headers['If-Match'] = '"' + metadataRevision + '"';
+            }
             fetch('/save-metadata', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: headers,
                 body: JSON.stringify(updatedMetadata),
             })
                 .then(response => {
@@ -838,8 +1314,12 @@ document.addEventListener('DOMContentLoaded', function() {
                         }
                         closeDrawer(); // Close the drawer after saving
                         return; // Add return statement to prevent further execution
+                    } else if (response.status === 409) {
+                        M.toast({ html: 'Metadata was changed by someone else, reopen the file info and try again', displayLength: 6000 });
                     } else {
-                        M.toast({ html: 'Error saving metadata' });
+                        response.text().then(text => {
+                            M.toast({ html: 'Error saving metadata: ' + formatMetadataErrors(text), displayLength: 6000 });
+                        });
                     }
                 })
                 .catch(error => {
@@ -882,6 +1362,116 @@ document.addEventListener('DOMContentLoaded', function() {
         copyHashesButton.addEventListener('click', copyHashes);
     }
 
+    // Metadata schema: typed fields editable in the drawer
+    var metadataSchema = { fields: [], reserved: [], hashes: ['CRC32', 'CRC64', 'SHA1', 'SHA256', 'BLAKE2sp'], hash_algorithms: [] };
+    fetch('/metadata/schema')
+        .then(response => response.ok ? response.json() : metadataSchema)
+        .then(data => { metadataSchema = data; })
+        .catch(error => console.error('Error loading metadata schema:', error));
+
+    // Formats a JSON validation error response ({errors: [{field, message}]}) for a toast
+    function formatMetadataErrors(text) {
+        try {
+            var data = JSON.parse(text);
+            if (data.errors) {
+                return data.errors.map(e => e.field + ': ' + e.message).join('<br>');
+            }
+        } catch (e) {
+            // Not a JSON response
+        }
+        return text;
+    }
+
+    // Hash fields to display: configured algorithms and any stored values
+    function hashFieldNames(values) {
+        return metadataSchema.hashes.filter(function(hash) {
+            return values[hash] || metadataSchema.hash_algorithms.indexOf(hash) >= 0;
+        });
+    }
+
+    function escapeHtml(text) {
+        var div = document.createElement('div');
+        div.textContent = text;
+        return div.innerHTML;
+    }
+
+    function renderSchemaFields(data) {
+        var container = document.getElementById('schemaFieldsEdit');
+        if (!container) {
+            return;
+        }
+        container.innerHTML = '';
+        metadataSchema.fields.forEach(function(field) {
+            var fieldDiv = document.createElement('div');
+            fieldDiv.classList.add('metadata-field');
+            var label = document.createElement('label');
+            label.textContent = field.name + (field.required ? ' *:' : ':');
+            var input;
+            if (field.type === 'enum') {
+                input = document.createElement('select');
+                input.classList.add('browser-default');
+                var values = field.required ? field.values : [''].concat(field.values);
+                values.forEach(function(value) {
+                    var option = document.createElement('option');
+                    option.value = value;
+                    option.textContent = value;
+                    input.appendChild(option);
+                });
+            } else {
+                input = document.createElement('input');
+                input.type = field.type === 'url' ? 'url' : 'text';
+                input.placeholder = field.type === 'date' ? 'YYYY-MM-DD' : (field.type === 'semver' ? '1.2.3' : '');
+                if (field.pattern) {
+                    input.title = 'Pattern: ' + field.pattern;
+                }
+            }
+            input.value = data[field.name] || '';
+            input.classList.add('metadata-input', 'schema-field-input');
+            input.setAttribute('data-field', field.name);
+            fieldDiv.appendChild(label);
+            fieldDiv.appendChild(input);
+            container.appendChild(fieldDiv);
+        });
+    }
+
+    // Label suffix for a value inherited from a folder
+    function inheritedSuffix(sources, key) {
+        var source = sources && sources[key];
+        return source && source !== 'file' ? ' (from ' + source + ')' : '';
+    }
+
+    // Adds a group with inherited values that are not schema fields
+    function appendInheritedFields(container, data, sources) {
+        var keys = Object.keys(sources || {}).filter(function(key) {
+            return sources[key] !== 'file' && !metadataSchema.fields.some(function(field) {
+                return field.name === key;
+            });
+        }).sort();
+        if (keys.length === 0) {
+            return;
+        }
+        var group = document.createElement('div');
+        group.classList.add('metadata-group');
+        var header = document.createElement('h5');
+        header.textContent = 'Inherited';
+        group.appendChild(header);
+        keys.forEach(function(key) {
+            var fieldDiv = document.createElement('div');
+            fieldDiv.classList.add('metadata-field');
+            var label = document.createElement('label');
+            label.textContent = key + inheritedSuffix(sources, key) + ':';
+            var value = document.createElement('input');
+            value.type = 'text';
+            value.value = data[key];
+            value.readOnly = true;
+            value.classList.add('metadata-input');
+            fieldDiv.appendChild(label);
+            fieldDiv.appendChild(value);
+            group.appendChild(fieldDiv);
+        });
+        container.appendChild(group);
+    }
+
     // Event listener for file info icons
     var fileInfoIcons = document.querySelectorAll('.file-info-icon');
     fileInfoIcons.forEach(function(icon) {
@@ -889,11 +1479,14 @@ document.addEventListener('DOMContentLoaded', function() {
             event.preventDefault();
             var filePath = this.getAttribute('data-file');
             currentFilePath = filePath; // Set the current file path
+            document.getElementById('metadataHistory').style.display = 'none';
             
-            fetch('/file-metadata?path=' + encodeURIComponent(filePath))
+            fetch('/file-metadata?path=' + encodeURIComponent(filePath) + '&rds=true')
                 .then(response => response.json())
-                .then(data => {
+                .then(result => {
+                    var data = result.metadata;
                     originalMetadata = data;
+                    metadataRevision = result.revision || null;
                     var metadataContent = document.getElementById('fileMetadataContent');
                     metadataContent.innerHTML = ''; // Clear previous content
 
@@ -943,7 +1536,7 @@ document.addEventListener('DOMContentLoaded', function() {
                     hashesGroup.appendChild(hashesHeader);
 
                     // Hash fields
-                    var hashes = ['CRC32', 'SHA1', 'SHA256', 'CRC64', 'BLAKE2sp'];
+                    var hashes = hashFieldNames(data);
                     hashes.forEach(function(hash) {
                         var hashDiv = document.createElement('div');
                         hashDiv.classList.add('metadata-field');
@@ -951,7 +1544,7 @@ document.addEventListener('DOMContentLoaded', function() {
                         hashLabel.textContent = hash + ':';
                         var hashValue = document.createElement('input');
                         hashValue.type = 'text';
-                        hashValue.value = data[hash];
+                        hashValue.value = data[hash] || '';
                         hashValue.readOnly = true;
                         hashValue.classList.add('metadata-input');
                         var copyIcon = document.createElement('i');
@@ -993,57 +1586,37 @@ document.addEventListener('DOMContentLoaded', function() {
                     rdsNumberDiv.appendChild(rdsNumberValue);
                     rdsGroup.appendChild(rdsNumberDiv);
 
-                    // Other RDS fields with comparison status
-                    var rdsFields = [
-                        { field: 'RDS CRC32', compareWith: 'CRC32' },
-                        { field: 'RDS CRC64', compareWith: 'CRC64' },
-                        { field: 'RDS BLAKE2sp', compareWith: 'BLAKE2sp' },
-                        { field: 'RDS SHA1', compareWith: 'SHA1' },
-                        { field: 'RDS SHA256', compareWith: 'SHA256' }
-                    ];
-
-                    rdsFields.forEach(function(item) {
-                        var rdsValue = data[item.field] || '';
-                        if (rdsValue) {
-                            var rdsDiv = document.createElement('div');
-                            rdsDiv.classList.add('metadata-field');
-                            var rdsLabel = document.createElement('label');
-
-                            // Create status icon if compareWith is specified
-                            if (item.compareWith) {
-                                var statusIcon = document.createElement('i');
-                                var originalValue = data[item.compareWith];
-
-                                if (!originalValue || !rdsValue) {
-                                    // Either value is empty or undefined; display grey icon
-                                    statusIcon.className = 'material-icons status-icon grey-text';
-                                    statusIcon.textContent = 'help_outline';
-                                } else if (originalValue === rdsValue) {
-                                    // Values match; display green checkmark
-                                    statusIcon.className = 'material-icons status-icon green-text';
-                                    statusIcon.textContent = 'check_circle';
-                                } else {
-                                    // Values do not match; display red cross
-                                    statusIcon.className = 'material-icons status-icon red-text';
-                                    statusIcon.textContent = 'cancel';
-                                }
-                                rdsLabel.appendChild(statusIcon);
-                            }
+                    // RDS hash fields with per-algorithm verification status
+                    appendRDSHashFields(rdsGroup, result.rds);
 
-                            rdsLabel.appendChild(document.createTextNode(' ' + item.field + ':'));
+                    metadataContent.appendChild(rdsGroup);
 
-                            var rdsValueInput = document.createElement('input');
-                            rdsValueInput.type = 'text';
-                            rdsValueInput.value = rdsValue;
-                            rdsValueInput.readOnly = true;
-                            rdsValueInput.classList.add('metadata-input');
-                            rdsDiv.appendChild(rdsLabel);
-                            rdsDiv.appendChild(rdsValueInput);
-                            rdsGroup.appendChild(rdsDiv);
-                        }
-                    });
+                    // Schema fields group
+                    if (metadataSchema.fields.length > 0) {
+                        var schemaGroup = document.createElement('div');
+                        schemaGroup.classList.add('metadata-group');
+                        var schemaHeader = document.createElement('h5');
+                        schemaHeader.textContent = 'Attributes';
+                        schemaGroup.appendChild(schemaHeader);
+                        metadataSchema.fields.forEach(function(field) {
+                            var fieldDiv = document.createElement('div');
+                            fieldDiv.classList.add('metadata-field');
+                            var fieldLabel = document.createElement('label');
+                            fieldLabel.textContent = field.name + inheritedSuffix(result.sources, field.name) + ':';
+                            var fieldValue = document.createElement('input');
+                            fieldValue.type = 'text';
+                            fieldValue.value = data[field.name] || '';
+                            fieldValue.readOnly = true;
+                            fieldValue.classList.add('metadata-input');
+                            fieldDiv.appendChild(fieldLabel);
+                            fieldDiv.appendChild(fieldValue);
+                            schemaGroup.appendChild(fieldDiv);
+                        });
+                        metadataContent.appendChild(schemaGroup);
+                    }
 
-                    metadataContent.appendChild(rdsGroup);
+                    // Other values inherited from folder defaults
+                    appendInheritedFields(metadataContent, data, result.sources);
 
                     // Populate edit form
                     document.getElementById('rdsNumber').value = data['RDS RDS'] || '';
@@ -1053,6 +1626,7 @@ document.addEventListener('DOMContentLoaded', function() {
                     document.getElementById('rdsSHA1').value = data['RDS SHA1'] || '';
                     document.getElementById('rdsSHA256').value = data['RDS SHA256'] || '';
                     document.getElementById('version').value = data['Version'] || '';
+                    renderSchemaFields(data);
 
                     openDrawer();
                 })
@@ -1062,6 +1636,181 @@ document.addEventListener('DOMContentLoaded', function() {
         });
     });
 
+    // Event listener for archive browse icons
+    function formatSize(size) {
+        var unit = 1024;
+        if (size < unit) {
+            return size + ' B';
+        }
+        var exp = Math.floor(Math.log(size) / Math.log(unit));
+        exp = Math.min(exp, 6);
+        return (size / Math.pow(unit, exp)).toFixed(1) + ' ' + 'KMGTPE'.charAt(exp - 1) + 'B';
+    }
+
+    var archiveBrowseIcons = document.querySelectorAll('.archive-browse-icon');
+    archiveBrowseIcons.forEach(function(icon) {
+        icon.addEventListener('click', function(event) {
+            event.preventDefault();
+            var archivePath = this.getAttribute('data-file');
+            var body = document.getElementById('archiveContentsBody');
+            var summary = document.getElementById('archiveContentsSummary');
+            document.getElementById('archiveContentsTitle').textContent = archivePath.split('/').pop();
+            body.innerHTML = '';
+            summary.textContent = 'Loading...';
+            M.Modal.getInstance(document.getElementById('archiveContentsModal')).open();
+
+            fetch('/archive/list?path=' + encodeURIComponent(archivePath))
+                .then(response => {
+                    if (!response.ok) {
+                        return response.text().then(text => { throw new Error(text.trim()); });
+                    }
+                    return response.json();
+                })
+                .then(data => {
+                    var files = 0;
+                    var total = 0;
+                    data.entries.forEach(function(entry) {
+                        var row = document.createElement('tr');
+
+                        var nameCell = document.createElement('td');
+                        if (entry.is_dir || entry.link) {
+                            nameCell.textContent = entry.link ? entry.name + ' -> ' + entry.link : entry.name;
+                        } else {
+                            var link = document.createElement('a');
+                            link.href = '/archive/member?path=' + encodeURIComponent(archivePath) + '&name=' + encodeURIComponent(entry.name);
+                            link.textContent = entry.name;
+                            link.setAttribute('download', '');
+                            nameCell.appendChild(link);
+                            files++;
+                            total += entry.size;
+                        }
+                        row.appendChild(nameCell);
+
+                        var sizeCell = document.createElement('td');
+                        sizeCell.textContent = entry.is_dir ? '' : formatSize(entry.size);
+                        row.appendChild(sizeCell);
+
+                        var compressedCell = document.createElement('td');
+                        compressedCell.textContent = entry.compressed_size && !entry.is_dir ? formatSize(entry.compressed_size) : '';
+                        row.appendChild(compressedCell);
+
+                        var modifiedCell = document.createElement('td');
+                        var modified = new Date(entry.mod_time);
+                        modifiedCell.textContent = isNaN(modified) || modified.getFullYear() < 1980 ? '' : modified.toLocaleString();
+                        row.appendChild(modifiedCell);
+
+                        var crcCell = document.createElement('td');
+                        crcCell.textContent = entry.crc32 || '';
+                        row.appendChild(crcCell);
+
+                        body.appendChild(row);
+                    });
+                    summary.textContent = files + ' files, ' + formatSize(total) + ' uncompressed (' + data.format + ')' +
+                        (data.truncated ? ' - list truncated' : '');
+                })
+                .catch(error => {
+                    console.error('Error listing archive:', error);
+                    summary.textContent = 'Error reading archive: ' + error.message;
+                });
+        });
+    });
+
+    // Разбор строки поиска: name Key=value hash:... size>10M size<1G
+    // after:YYYY-MM-DD before:YYYY-MM-DD text:"..." type:file|dir
+    function parseSearchQuery(input) {
+        var params = new URLSearchParams();
+        var names = [];
+        var tokens = input.match(/(?:[^\s"]+|"[^"]*")+/g) || [];
+        tokens.forEach(function(token) {
+            var unquoted = token.replace(/"/g, '');
+            var match;
+            if ((match = unquoted.match(/^size([<>])(.+)$/i))) {
+                params.set(match[1] === '>' ? 'min_size' : 'max_size', match[2]);
+            } else if ((match = unquoted.match(/^(hash|after|before|text|type):(.*)$/i))) {
+                params.set(match[1].toLowerCase(), match[2]);
+            } else if (unquoted.indexOf('=') > 0) {
+                params.append('filter', unquoted);
+            } else {
+                names.push(unquoted);
+            }
+        });
+        if (names.length > 0) {
+            params.set('q', names.join(' '));
+        }
+        return params;
+    }
+
+    var searchForm = document.getElementById('searchForm');
+    if (searchForm) {
+        searchForm.addEventListener('submit', function(event) {
+            event.preventDefault();
+            var input = document.getElementById('searchInput').value.trim();
+            if (!input) {
+                return;
+            }
+            var body = document.getElementById('searchResultsBody');
+            var summary = document.getElementById('searchResultsSummary');
+            body.innerHTML = '';
+            summary.textContent = 'Searching...';
+            M.Modal.getInstance(document.getElementById('searchResultsModal')).open();
+
+            fetch('/search?' + parseSearchQuery(input).toString())
+                .then(response => {
+                    if (!response.ok) {
+                        return response.text().then(text => { throw new Error(text.trim()); });
+                    }
+                    return response.json();
+                })
+                .then(data => {
+                    data.results.forEach(function(result) {
+                        var row = document.createElement('tr');
+                        var metadata = result.metadata || {};
+
+                        var pathCell = document.createElement('td');
+                        var link = document.createElement('a');
+                        if (result.is_dir) {
+                            link.href = result.path + '/';
+                        } else {
+                            var dir = result.path.substring(0, result.path.lastIndexOf('/') + 1);
+                            link.href = dir;
+                        }
+                        link.textContent = result.path + (result.is_dir ? '/' : '');
+                        pathCell.appendChild(link);
+                        if (result.snippet) {
+                            var snippet = document.createElement('span');
+                            snippet.className = 'search-snippet grey-text';
+                            snippet.textContent = result.snippet;
+                            pathCell.appendChild(snippet);
+                        }
+                        row.appendChild(pathCell);
+
+                        var sizeCell = document.createElement('td');
+                        sizeCell.textContent = result.is_dir ? '' : formatSize(result.size);
+                        row.appendChild(sizeCell);
+
+                        var modifiedCell = document.createElement('td');
+                        modifiedCell.textContent = new Date(result.mod_time).toLocaleString();
+                        row.appendChild(modifiedCell);
+
+                        var versionCell = document.createElement('td');
+                        versionCell.textContent = metadata['Version'] || '';
+                        row.appendChild(versionCell);
+
+                        var uploaderCell = document.createElement('td');
+                        uploaderCell.textContent = metadata['Uploader'] || '';
+                        row.appendChild(uploaderCell);
+
+                        body.appendChild(row);
+                    });
+                    summary.textContent = data.results.length + ' results' + (data.truncated ? ' (truncated)' : '');
+                })
+                .catch(error => {
+                    console.error('Error searching:', error);
+                    summary.textContent = 'Search error: ' + error.message;
+                });
+        });
+    }
+
     // Event listener for confirm close drawer button
     var confirmCloseDrawerButton = document.getElementById('confirmCloseDrawerButton');
     if (confirmCloseDrawerButton) {
@@ -1101,23 +1850,29 @@ document.addEventListener('DOMContentLoaded', function() {
             worker.postMessage({ filePath });
 
             worker.onmessage = function(event) {
-                const { hashes, metadata } = event.data;
+                const { hashes, metadata, error } = event.data;
+                if (error) {
+                    M.toast({ html: error });
+                    return;
+                }
                 // Update metadata fields with new hashes
-                document.getElementById('rdsCRC32').value = hashes['CRC32'];
-                document.getElementById('rdsSHA1').value = hashes['SHA1'];
-                document.getElementById('rdsSHA256').value = hashes['SHA256'];
-                document.getElementById('rdsCRC64').value = hashes['CRC64'].toUpperCase();
-                document.getElementById('rdsBLAKE2sp').value = hashes['BLAKE2sp'];
+                document.getElementById('rdsCRC32').value = hashes['CRC32'] || '';
+                document.getElementById('rdsSHA1').value = hashes['SHA1'] || '';
+                document.getElementById('rdsSHA256').value = hashes['SHA256'] || '';
+                document.getElementById('rdsCRC64').value = (hashes['CRC64'] || '').toUpperCase();
+                document.getElementById('rdsBLAKE2sp').value = hashes['BLAKE2sp'] || '';
 
                 // Fetch updated metadata from README.md
-                fetch('/file-metadata?path=' + encodeURIComponent(filePath))
+                fetch('/file-metadata?path=' + encodeURIComponent(filePath) + '&rds=true')
                     .then(response => response.json())
-                    .then(metadata => {
+                    .then(result => {
+                        var metadata = result.metadata;
+                        metadataRevision = result.revision || null;
                         document.getElementById('rdsNumber').value = metadata['RDS RDS'] || '';
                         M.toast({ html: 'Metadata refreshed successfully' });
 
                         // Update the displayed metadata content
-                        updateMetadataContent(metadata, hashes);
+                        updateMetadataContent(metadata, hashes, result.rds);
                     })
                     .catch(error => {
                         console.error('Error fetching metadata:', error);
@@ -1135,21 +1890,23 @@ document.addEventListener('DOMContentLoaded', function() {
                 .then(response => response.json())
                 .then(hashes => {
                     // Update metadata fields with new hashes
-                    document.getElementById('rdsCRC32').value = hashes['CRC32'];
-                    document.getElementById('rdsSHA1').value = hashes['SHA1'];
-                    document.getElementById('rdsSHA256').value = hashes['SHA256'];
-                    document.getElementById('rdsCRC64').value = hashes['CRC64'].toUpperCase();
-                    document.getElementById('rdsBLAKE2sp').value = hashes['BLAKE2sp'];
+                    document.getElementById('rdsCRC32').value = hashes['CRC32'] || '';
+                    document.getElementById('rdsSHA1').value = hashes['SHA1'] || '';
+                    document.getElementById('rdsSHA256').value = hashes['SHA256'] || '';
+                    document.getElementById('rdsCRC64').value = (hashes['CRC64'] || '').toUpperCase();
+                    document.getElementById('rdsBLAKE2sp').value = hashes['BLAKE2sp'] || '';
 
                     // Fetch updated metadata from README.md
-                    fetch('/file-metadata?path=' + encodeURIComponent(filePath))
+                    fetch('/file-metadata?path=' + encodeURIComponent(filePath) + '&rds=true')
                         .then(response => response.json())
-                        .then(metadata => {
+                        .then(result => {
+                            var metadata = result.metadata;
+                            metadataRevision = result.revision || null;
                             document.getElementById('rdsNumber').value = metadata['RDS RDS'] || '';
                             M.toast({ html: 'Metadata refreshed successfully' });
 
                             // Update the displayed metadata content
-                            updateMetadataContent(metadata, hashes);
+                            updateMetadataContent(metadata, hashes, result.rds);
                         })
                         .catch(error => {
                             console.error('Error fetching metadata:', error);
@@ -1164,7 +1921,7 @@ document.addEventListener('DOMContentLoaded', function() {
     }
 
     // Function to update metadata content
-    function updateMetadataContent(metadata, hashes) {
+    function updateMetadataContent(metadata, hashes, rds) {
         var metadataContent = document.getElementById('fileMetadataContent');
         metadataContent.innerHTML = ''; // Clear previous content
 
@@ -1214,7 +1971,7 @@ document.addEventListener('DOMContentLoaded', function() {
         hashesGroup.appendChild(hashesHeader);
 
         // Hash fields
-        var hashFields = ['CRC32', 'SHA1', 'SHA256', 'CRC64', 'BLAKE2sp'];
+        var hashFields = hashFieldNames(hashes);
         hashFields.forEach(function(hash) {
             var hashDiv = document.createElement('div');
             hashDiv.classList.add('metadata-field');
@@ -1222,7 +1979,7 @@ document.addEventListener('DOMContentLoaded', function() {
             hashLabel.textContent = hash + ':';
             var hashValue = document.createElement('input');
             hashValue.type = 'text';
-            hashValue.value = hashes[hash];
+            hashValue.value = hashes[hash] || '';
             hashValue.readOnly = true;
             hashValue.classList.add('metadata-input');
             var copyIcon = document.createElement('i');
@@ -1264,57 +2021,122 @@ document.addEventListener('DOMContentLoaded', function() {
         rdsNumberDiv.appendChild(rdsNumberValue);
         rdsGroup.appendChild(rdsNumberDiv);
 
-        // Other RDS fields with comparison status
-        var rdsFields = [
-            { field: 'RDS CRC32', compareWith: 'CRC32' },
-            { field: 'RDS CRC64', compareWith: 'CRC64' },
-            { field: 'RDS BLAKE2sp', compareWith: 'BLAKE2sp' },
-            { field: 'RDS SHA1', compareWith: 'SHA1' },
-            { field: 'RDS SHA256', compareWith: 'SHA256' }
-        ];
-
-        rdsFields.forEach(function(item) {
-            var rdsValue = metadata[item.field] || '';
-            if (rdsValue) {
-                var rdsDiv = document.createElement('div');
-                rdsDiv.classList.add('metadata-field');
-                var rdsLabel = document.createElement('label');
-
-                // Create status icon if compareWith is specified
-                if (item.compareWith) {
-                    var statusIcon = document.createElement('i');
-                    var originalValue = hashes[item.compareWith];
-
-                    if (!originalValue || !rdsValue) {
-                        // Either value is empty or undefined; display grey icon
-                        statusIcon.className = 'material-icons status-icon grey-text';
-                        statusIcon.textContent = 'help_outline';
-                    } else if (originalValue === rdsValue) {
-                        // Values match; display green checkmark
-                        statusIcon.className = 'material-icons status-icon green-text';
-                        statusIcon.textContent = 'check_circle';
-                    } else {
-                        // Values do not match; display red cross
-                        statusIcon.className = 'material-icons status-icon red-text';
-                        statusIcon.textContent = 'cancel';
-                    }
-                    rdsLabel.appendChild(statusIcon);
+        // RDS hash fields with per-algorithm verification status
+        appendRDSHashFields(rdsGroup, rds);
+
+        metadataContent.appendChild(rdsGroup);
+    }
+
+    // Shows who changed the metadata of the open file and lets a state be restored
+    function loadMetadataHistory(filePath) {
+        var container = document.getElementById('metadataHistory');
+        container.innerHTML = '';
+        fetch('/metadata/history?path=' + encodeURIComponent(filePath))
+            .then(response => {
+                if (!response.ok) {
+                    return response.text().then(text => { throw new Error(text); });
+                }
+                return response.json();
+            })
+            .then(result => {
+                metadataRevision = result.revision || null;
+                var header = document.createElement('h5');
+                header.textContent = 'History';
+                container.appendChild(header);
+                if (result.changes.length === 0) {
+                    var empty = document.createElement('p');
+                    empty.textContent = 'No recorded changes.';
+                    container.appendChild(empty);
+                    return;
                 }
+                result.changes.forEach(function(change, index) {
+                    var entry = document.createElement('div');
+                    entry.classList.add('metadata-history-entry');
+                    var title = document.createElement('div');
+                    var source = change.source + (change.revert_to ? ' to #' + change.revert_to : '');
+                    title.innerHTML = '<strong>#' + change.id + ' ' + escapeHtml(source) + '</strong> by ' +
+                        escapeHtml(change.user || 'server') + ', ' + escapeHtml(new Date(change.time).toLocaleString());
+                    entry.appendChild(title);
+
+                    var table = document.createElement('table');
+                    change.changes.forEach(function(field) {
+                        var tr = document.createElement('tr');
+                        [field.field, field.old || '', field.new || ''].forEach(function(text, i) {
+                            var td = document.createElement('td');
+                            td.textContent = text;
+                            if (i === 1) {
+                                td.classList.add('diff-old');
+                            }
+                            tr.appendChild(td);
+                        });
+                        table.appendChild(tr);
+                    });
+                    entry.appendChild(table);
+
+                    // The latest entry is the current state
+                    if (index > 0) {
+                        var restore = document.createElement('a');
+                        restore.href = '#';
+                        restore.className = 'btn-flat blue-text';
+                        restore.textContent = 'Restore this state';
+                        restore.title = 'Undo the later changes of editable fields';
+                        restore.addEventListener('click', function(event) {
+                            event.preventDefault();
+                            checkLoginAndPerformAction(function() {
+                                revertMetadata(filePath, change.id);
+                            });
+                        });
+                        entry.appendChild(restore);
+                    }
+                    container.appendChild(entry);
+                });
+            })
+            .catch(error => {
+                console.error('Error loading metadata history:', error);
+                container.textContent = 'History is not available: ' + error.message;
+            });
+    }
 
-                rdsLabel.appendChild(document.createTextNode(' ' + item.field + ':'));
+    function revertMetadata(filePath, id) {
+        var headers = {};
+        if (metadataRevision) {
+            headers['If-Match'] = '"' + metadataRevision + '"';
+        }
+        fetch('/metadata/revert', {
+            method: 'POST',
+            headers: headers,
+            body: new URLSearchParams({ path: filePath, id: id })
+        })
+            .then(response => {
+                if (response.ok) {
+                    M.toast({ html: 'Metadata restored' });
+                    loadMetadataHistory(filePath);
+                } else if (response.status === 409) {
+                    M.toast({ html: 'Metadata was changed by someone else, reload the history and try again', displayLength: 6000 });
+                    loadMetadataHistory(filePath);
+                } else {
+                    response.text().then(text => {
+                        M.toast({ html: 'Error restoring metadata: ' + formatMetadataErrors(text), displayLength: 6000 });
+                    });
+                }
+            })
+            .catch(error => {
+                console.error('Error restoring metadata:', error);
+                M.toast({ html: 'Error restoring metadata' });
+            });
+    }
 
-                var rdsValueInput = document.createElement('input');
-                rdsValueInput.type = 'text';
-                rdsValueInput.value = rdsValue;
-                rdsValueInput.readOnly = true;
-                rdsValueInput.classList.add('metadata-input');
-                rdsDiv.appendChild(rdsLabel);
-                rdsDiv.appendChild(rdsValueInput);
-                rdsGroup.appendChild(rdsDiv);
+    var metadataHistoryButton = document.getElementById('metadataHistoryButton');
+    if (metadataHistoryButton) {
+        metadataHistoryButton.addEventListener('click', function() {
+            var container = document.getElementById('metadataHistory');
+            if (container.style.display === 'none') {
+                container.style.display = 'block';
+                loadMetadataHistory(currentFilePath);
+            } else {
+                container.style.display = 'none';
             }
         });
-
-        metadataContent.appendChild(rdsGroup);
     }
 
     // Event listener for refresh metadata button
@@ -1325,33 +2147,93 @@ document.addEventListener('DOMContentLoaded', function() {
         });
     }
 
+    // RDS verification badges, same as the file listing
+    var rdsBadges = {
+        match: { icon: 'check_circle', color: 'green-text', title: 'RDS match' },
+        weak: { icon: 'check_circle', color: 'orange-text', title: 'RDS weak match (CRC only)' },
+        mismatch: { icon: 'cancel', color: 'red-text', title: 'RDS mismatch' },
+        missing: { icon: 'help_outline', color: 'grey-text', title: 'Hash not calculated' },
+        stale: { icon: 'update', color: 'grey-text', title: 'Stored hash is outdated, recalculate hashes' },
+        unknown: { icon: 'help_outline', color: 'grey-text', title: 'RDS unknown' }
+    };
+
+    function rdsSummary(rds) {
+        var hashes = (rds && rds.hashes || []).filter(function(hash) {
+            return hash.expected;
+        });
+        if (hashes.length === 0) {
+            return 'No RDS hashes';
+        }
+        return hashes.map(function(hash) {
+            var status = hash.status === 'missing' ? 'not calculated'
+                : hash.status === 'stale' ? 'outdated, recalculate hashes' : hash.status;
+            return hash.algorithm + ': ' + status;
+        }).join(', ');
+    }
+
+    function createRDSIcon(status, title) {
+        var badge = rdsBadges[status] || rdsBadges.unknown;
+        var icon = document.createElement('i');
+        icon.className = 'material-icons ' + badge.color;
+        icon.textContent = badge.icon;
+        icon.title = title || badge.title;
+        return icon;
+    }
+
+    // Adds RDS hash fields with the server-side verification status of each algorithm
+    function appendRDSHashFields(rdsGroup, rds) {
+        if (!rds || !rds.hashes) {
+            return;
+        }
+        rds.hashes.forEach(function(hash) {
+            if (!hash.expected) {
+                return;
+            }
+            var rdsDiv = document.createElement('div');
+            rdsDiv.classList.add('metadata-field');
+            var rdsLabel = document.createElement('label');
+            var status = hash.status === 'match' && hash.weak ? 'weak' : hash.status;
+            var statusIcon = createRDSIcon(status);
+            statusIcon.classList.add('status-icon');
+            rdsLabel.appendChild(statusIcon);
+            rdsLabel.appendChild(document.createTextNode(' RDS ' + hash.algorithm + ':'));
+
+            var rdsValueInput = document.createElement('input');
+            rdsValueInput.type = 'text';
+            rdsValueInput.value = hash.expected;
+            rdsValueInput.readOnly = true;
+            rdsValueInput.classList.add('metadata-input');
+            rdsDiv.appendChild(rdsLabel);
+            rdsDiv.appendChild(rdsValueInput);
+            rdsGroup.appendChild(rdsDiv);
+        });
+    }
+
     function updateRDSStatus() {
         var rows = document.querySelectorAll('#fileTable tbody tr');
         rows.forEach(function(row) {
             var fileName = row.querySelector('td a.file-link')?.getAttribute('data-file');
-            if (fileName) {
-                fetch('/file-metadata?path=' + encodeURIComponent(fileName))
-                    .then(response => response.json())
-                    .then(metadata => {
-                        var rdsStatusCell = row.querySelector('.rds-status');
-                        if (metadata['RDS CRC32'] === metadata['CRC32'] ||
-                            metadata['RDS CRC64'] === metadata['CRC64'] ||
-                            metadata['RDS SHA1'] === metadata['SHA1'] ||
-                            metadata['RDS SHA256'] === metadata['SHA256'] ||
-                            metadata['RDS BLAKE2sp'] === metadata['BLAKE2sp']) {
-                            rdsStatusCell.innerHTML = '<i class="material-icons green-text">check_circle</i>';
-                        } else if (metadata['RDS CRC32'] || metadata['RDS CRC64'] || metadata['RDS SHA1'] || metadata['RDS SHA256'] || metadata['RDS BLAKE2sp']) {
-                            rdsStatusCell.innerHTML = '<i class="material-icons red-text">cancel</i>';
-                        } else if (!fileName.endsWith('.md') && !fileName.endsWith('.html') && !fileName.endsWith('.txt')) {
-                            rdsStatusCell.innerHTML = '<i class="material-icons grey-text">help_outline</i>';
-                        } else {
-                            rdsStatusCell.innerHTML = '<i class="material-icons" style="visibility: hidden;">help_outline</i>';
-                        }
-                    })
-                    .catch(error => {
-                        console.error('Error fetching metadata:', error);
-                    });
+            var rdsStatusCell = row.querySelector('.rds-status');
+            if (!fileName || !rdsStatusCell || /\.(md|html|txt)$/i.test(fileName)) {
+                return;
             }
+            fetch('/file-metadata?path=' + encodeURIComponent(fileName) + '&rds=true')
+                .then(response => response.json())
+                .then(result => {
+                    var rds = result.rds || { status: 'unknown', hashes: [] };
+                    var badge = rdsBadges[rds.status] || rdsBadges.unknown;
+                    var icon = createRDSIcon(rds.status, badge.title + ': ' + rdsSummary(rds));
+                    icon.classList.add('rds-icon');
+                    var current = rdsStatusCell.querySelector('.rds-icon');
+                    if (current) {
+                        current.replaceWith(icon);
+                    } else {
+                        rdsStatusCell.prepend(icon);
+                    }
+                })
+                .catch(error => {
+                    console.error('Error fetching metadata:', error);
+                });
         });
     }
 